@@ -4,7 +4,11 @@ go 1.24
 
 require (
 	agones.dev/agones v1.50.0
+	github.com/BurntSushi/toml v1.5.0
+	github.com/fsnotify/fsnotify v1.7.0
 	github.com/hpcloud/tail v1.0.0
+	github.com/klauspost/compress v1.18.0
+	gopkg.in/yaml.v3 v3.0.1
 )
 
 require (
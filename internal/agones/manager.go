@@ -8,13 +8,28 @@ import (
 	"strings"
 	"time"
 
+	sdkpb "agones.dev/agones/pkg/sdk"
 	"agones.dev/agones/sdks/go"
+	"github.com/pegnia/sidecar/internal/audit"
 	"github.com/pegnia/sidecar/internal/config"
+	"github.com/pegnia/sidecar/internal/metrics"
 )
 
 // RunManager connects to the Agones SDK and manages the game server lifecycle.
-func RunManager(ctx context.Context, cfg config.AgonesConfig, agonesSDK *sdk.SDK) {
+// onState, if set, is called with the GameServer state on every change Agones reports.
+func RunManager(ctx context.Context, cfg config.AgonesConfig, agonesSDK *sdk.SDK, auditLog *audit.Logger, onState func(state string)) {
 	slog.Info("Starting Agones manager...")
+	start := time.Now()
+
+	watch := func(gs *sdkpb.GameServer) {
+		recordGameServer(gs)
+		if state := gs.GetStatus().GetState(); state != "" && onState != nil {
+			onState(state)
+		}
+	}
+	if err := agonesSDK.WatchGameServer(watch); err != nil {
+		slog.Warn("Could not watch GameServer, state metrics and running-only path rules will be unavailable", "error", err)
+	}
 
 	slog.Info("Waiting for initial delay before probing", "duration", cfg.InitialDelay)
 	time.Sleep(cfg.InitialDelay)
@@ -27,10 +42,13 @@ func RunManager(ctx context.Context, cfg config.AgonesConfig, agonesSDK *sdk.SDK
 		return
 	}
 
-	if err := agonesSDK.Ready(); err != nil {
+	err := agonesSDK.Ready()
+	recordAgonesCall(auditLog, "agones.ready", err)
+	if err != nil {
 		slog.Error("Failed to send Ready signal to Agones", "error", err)
 		return
 	}
+	metrics.TimeToReady.Set(time.Since(start).Seconds())
 	slog.Info(">>> Server is Ready! Starting health checks. <<<")
 
 	ticker := time.NewTicker(cfg.HealthInterval)
@@ -39,8 +57,10 @@ func RunManager(ctx context.Context, cfg config.AgonesConfig, agonesSDK *sdk.SDK
 		select {
 		case <-ticker.C:
 			if err := agonesSDK.Health(); err != nil {
+				metrics.HealthPings.Inc("failure")
 				slog.Warn("Failed to send health ping", "error", err)
 			} else {
+				metrics.HealthPings.Inc("success")
 				slog.Debug("Health ping sent successfully")
 			}
 		case <-ctx.Done():
@@ -62,7 +82,14 @@ func probeGameServer(ctx context.Context, cfg config.AgonesConfig) error {
 		case <-ctx.Done():
 			return ctx.Err()
 		case <-ticker.C:
+			attemptStart := time.Now()
 			conn, err := net.DialTimeout(protocol, address, cfg.PingTimeout)
+			result := "success"
+			if err != nil {
+				result = "failure"
+			}
+			metrics.ProbeAttempts.Inc(result)
+			metrics.ProbeDuration.Observe(time.Since(attemptStart).Seconds(), result)
 			if err == nil {
 				conn.Close()
 				slog.Info("Readiness probe successful!")
@@ -72,3 +99,26 @@ func probeGameServer(ctx context.Context, cfg config.AgonesConfig) error {
 		}
 	}
 }
+
+// recordGameServer updates the GameServer state and player metrics on every change Agones reports.
+// Player counts are only present when the PlayerTracking feature gate is enabled.
+func recordGameServer(gs *sdkpb.GameServer) {
+	status := gs.GetStatus()
+	if state := status.GetState(); state != "" {
+		metrics.GameServerState.SetOnly(1, state)
+	}
+	if players := status.GetPlayers(); players != nil {
+		metrics.GameServerPlayers.Set(float64(players.GetCount()))
+		metrics.GameServerPlayerCapacity.Set(float64(players.GetCapacity()))
+	}
+}
+
+// recordAgonesCall audits a lifecycle call the sidecar made on its own behalf.
+func recordAgonesCall(auditLog *audit.Logger, action string, err error) {
+	event := audit.Event{Action: action, Actor: "sidecar", Result: audit.ResultSuccess}
+	if err != nil {
+		event.Result = audit.ResultFailure
+		event.Error = err.Error()
+	}
+	auditLog.Record(event)
+}
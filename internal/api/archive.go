@@ -0,0 +1,70 @@
+package api
+
+import (
+	"io/fs"
+	"net/http"
+	"path/filepath"
+
+	"github.com/pegnia/sidecar/internal/archive"
+	"github.com/pegnia/sidecar/internal/glob"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// downloadArchive streams the directory name as an archive. The format is
+// taken from the "format" query parameter (zip by default), and the
+// repeatable "include" and "exclude" parameters filter entries by glob.
+func (s *Server) downloadArchive(w http.ResponseWriter, r *http.Request, name string) {
+	query := r.URL.Query()
+
+	format := archive.Zip
+	if v := query.Get("format"); v != "" {
+		f, err := archive.ParseFormat(v)
+		if err != nil {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Unsupported archive format",
+				map[string]any{"format": v, "supported": []archive.Format{archive.Zip, archive.Tar, archive.TarGz, archive.TarZst}})
+			return
+		}
+		format = f
+	}
+
+	includes, excludes := query["include"], query["exclude"]
+	for _, p := range append(append([]string{}, includes...), excludes...) {
+		if !glob.Valid(p) {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid glob pattern", map[string]any{"pattern": p})
+			return
+		}
+	}
+	filter := func(rel string, d fs.DirEntry) bool {
+		if s.hiddenPath(filepath.Join(name, rel)) || glob.MatchAny(excludes, rel) {
+			return false
+		}
+		// Directories are always descended into so includes can match files deep in the tree.
+		return d.IsDir() || len(includes) == 0 || glob.MatchAny(includes, rel)
+	}
+
+	base := filepath.Base(name)
+	if name == "." {
+		base = filepath.Base(s.dataRoot)
+	}
+	w.Header().Set("Content-Type", format.ContentType())
+	w.Header().Set("Content-Disposition", attachment(base+format.Extension()))
+	// The archive is built as it is sent, so it cannot be resumed part-way.
+	w.Header().Set("Accept-Ranges", "none")
+
+	rw := newResponseWriter(w)
+	err := archive.Write(rw, s.root, name, format, filter)
+	metrics.BytesDownloaded.Add(float64(rw.bytes))
+	if err != nil {
+		s.log(r).Error("Failed to stream archive", "path", name, "format", format, "error", err)
+		if rw.bytes == 0 {
+			w.Header().Del("Content-Disposition")
+			w.Header().Del("Accept-Ranges")
+			s.writeFSError(w, r, err, "Could not create archive")
+			return
+		}
+		// The archive is already partially sent; abort the connection so the
+		// client sees a failed download instead of a truncated archive.
+		panic(http.ErrAbortHandler)
+	}
+}
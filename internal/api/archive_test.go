@@ -0,0 +1,135 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// archiveFiles returns the names of the regular files in a zip or tar.gz
+// archive, sorted.
+func archiveFiles(t *testing.T, format string, data []byte) []string {
+	t.Helper()
+	var names []string
+	switch format {
+	case "zip":
+		zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, f := range zr.File {
+			if f.Mode().IsRegular() {
+				names = append(names, f.Name)
+			}
+		}
+	case "tar.gz":
+		gr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tr := tar.NewReader(gr)
+		for {
+			hdr, err := tr.Next()
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if hdr.Typeflag == tar.TypeReg {
+				names = append(names, hdr.Name)
+			}
+		}
+	default:
+		t.Fatalf("unknown format %q", format)
+	}
+	slices.Sort(names)
+	return names
+}
+
+// TestDownloadArchive checks that a directory downloads as an archive in the
+// requested format, filtered by the include and exclude patterns.
+func TestDownloadArchive(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		query    string
+		format   string
+		filename string
+		want     []string
+	}{
+		{"zip by default", "path=sub", "zip", "sub.zip", []string{"nested/a.log", "nested/b.txt", "ok.txt"}},
+		{"tar.gz", "path=sub&format=tar.gz", "tar.gz", "sub.tar.gz", []string{"nested/a.log", "nested/b.txt", "ok.txt"}},
+		{"exclude", "path=sub&format=tar.gz&exclude=*.log", "tar.gz", "sub.tar.gz", []string{"nested/b.txt", "ok.txt"}},
+		{"include", "path=sub&include=**/*.txt&exclude=ok.txt", "zip", "sub.zip", []string{"nested/b.txt"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			nested := filepath.Join(base, "data", "sub", "nested")
+			if err := os.Mkdir(nested, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			for _, name := range []string{"a.log", "b.txt"} {
+				if err := os.WriteFile(filepath.Join(nested, name), []byte(name), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files/download?"+tt.query, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+			}
+			if cd := rr.Header().Get("Content-Disposition"); !strings.Contains(cd, tt.filename) {
+				t.Errorf("got Content-Disposition %q, want the file name %s", cd, tt.filename)
+			}
+			if got := rr.Header().Get("Accept-Ranges"); got != "none" {
+				t.Errorf("got Accept-Ranges %q for a streamed archive, want none", got)
+			}
+			if got := archiveFiles(t, tt.format, rr.Body.Bytes()); !slices.Equal(got, tt.want) {
+				t.Errorf("got files %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	for _, tt := range []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"unsupported format", "path=sub&format=rar", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"invalid pattern", "path=sub&include=[", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"escape", "path=outdir", http.StatusBadRequest, apitypes.CodeInvalidPath},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files/download?"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+			if cd := rr.Header().Get("Content-Disposition"); cd != "" {
+				t.Errorf("a refused download has Content-Disposition %q", cd)
+			}
+		})
+	}
+}
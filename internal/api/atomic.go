@@ -0,0 +1,131 @@
+package api
+
+import (
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// checksums are the digests of a file computed while it is written.
+type checksums struct {
+	SHA256 string
+	MD5    string
+}
+
+// checksumError reports that written data does not match a client-supplied digest.
+type checksumError struct {
+	Algorithm string
+	Expected  string
+	Actual    string
+}
+
+func (e *checksumError) Error() string {
+	return fmt.Sprintf("%s checksum mismatch: expected %s, got %s", e.Algorithm, e.Expected, e.Actual)
+}
+
+// expectedChecksums holds optional hex digests the written data must match.
+type expectedChecksums checksums
+
+// verify compares sums against the expected digests that are set.
+func (e expectedChecksums) verify(sums checksums) error {
+	if e.SHA256 != "" && e.SHA256 != sums.SHA256 {
+		return &checksumError{Algorithm: "sha256", Expected: e.SHA256, Actual: sums.SHA256}
+	}
+	if e.MD5 != "" && e.MD5 != sums.MD5 {
+		return &checksumError{Algorithm: "md5", Expected: e.MD5, Actual: sums.MD5}
+	}
+	return nil
+}
+
+// parseHexDigest validates a hex digest of size bytes. The empty string is accepted as "not given".
+func parseHexDigest(v string, size int) (string, bool) {
+	v = strings.ToLower(strings.TrimSpace(v))
+	if v == "" {
+		return "", true
+	}
+	if len(v) != size*2 {
+		return "", false
+	}
+	_, err := hex.DecodeString(v)
+	return v, err == nil
+}
+
+// siblingName returns a hidden, randomly suffixed name next to name for a
+// temporary entry, such as ".level.dat.tmp-1a2b3c4d" for the tag "tmp".
+func siblingName(name, tag string) string {
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	return filepath.Join(filepath.Dir(name), "."+filepath.Base(name)+"."+tag+"-"+hex.EncodeToString(suffix[:]))
+}
+
+// writeFileAtomic streams src into a temporary file next to name, syncs it,
+// checks it against expected and, if set, check, and renames it over name.
+// Readers, including the game server, see either the old content or the new,
+// never a partial file. The file's mode and owner are set by setStagedMode.
+func (s *Server) writeFileAtomic(name string, src io.Reader, expected expectedChecksums, check func(staged string) error) (int64, checksums, error) {
+	tmpName := siblingName(name, "tmp")
+	tmp, err := s.root.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return 0, checksums{}, err
+	}
+	committed := false
+	defer func() {
+		if !committed {
+			tmp.Close()
+			s.root.Remove(tmpName)
+		}
+	}()
+
+	sha, md := sha256.New(), md5.New()
+	written, err := io.Copy(io.MultiWriter(tmp, sha, md), src)
+	if err != nil {
+		return written, checksums{}, err
+	}
+	sums := checksums{SHA256: hexSum(sha), MD5: hexSum(md)}
+	if err := expected.verify(sums); err != nil {
+		return written, sums, err
+	}
+	if err := tmp.Sync(); err != nil {
+		return written, sums, err
+	}
+	if err := tmp.Close(); err != nil {
+		return written, sums, err
+	}
+	if err := s.setStagedMode(tmpName, name); err != nil {
+		return written, sums, err
+	}
+	if check != nil {
+		if err := check(tmpName); err != nil {
+			s.root.Remove(tmpName)
+			committed = true
+			return written, sums, err
+		}
+	}
+	committed = true
+	if err := s.root.Rename(tmpName, name); err != nil {
+		s.root.Remove(tmpName)
+		return written, sums, err
+	}
+	s.syncDir(filepath.Dir(name))
+	return written, sums, nil
+}
+
+// syncDir flushes a directory so a rename inside it survives a crash. It is
+// best effort: not every filesystem supports syncing directories.
+func (s *Server) syncDir(name string) {
+	if d, err := s.root.Open(name); err == nil {
+		d.Sync()
+		d.Close()
+	}
+}
+
+func hexSum(h hash.Hash) string {
+	return hex.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,144 @@
+package api
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/audit"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// clientIP returns the address of the caller. X-Forwarded-For is only
+// believed for requests from a trusted proxy, and then the caller is the last
+// hop that is not one of the trusted proxies itself, as earlier hops are
+// whatever the client chose to send.
+func (s *Server) clientIP(r *http.Request) string {
+	ip := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(ip); err == nil {
+		ip = host
+	}
+	if !s.trustedProxy(ip) {
+		return ip
+	}
+	hops := strings.Split(r.Header.Get("X-Forwarded-For"), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if hop == "" {
+			continue
+		}
+		ip = hop
+		if !s.trustedProxy(hop) {
+			break
+		}
+	}
+	return ip
+}
+
+// trustedProxy reports whether ip is in SIDECAR_TRUSTED_PROXIES.
+func (s *Server) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	return slices.ContainsFunc(s.trustedProxies, func(p netip.Prefix) bool {
+		return p.Contains(addr.Unmap())
+	})
+}
+
+// parseTrustedProxies parses a comma-separated list of CIDR ranges. A plain
+// address stands for itself.
+func parseTrustedProxies(list string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(v)
+		if err != nil {
+			addr, addrErr := netip.ParseAddr(v)
+			if addrErr != nil {
+				return nil, fmt.Errorf("invalid CIDR %q", v)
+			}
+			prefix = netip.PrefixFrom(addr, addr.BitLen())
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// recordAudit writes an audit event for a mutating operation on name.
+// A nil err records a success.
+func (s *Server) recordAudit(r *http.Request, action, name string, bytes int64, err error) {
+	s.recordAuditTransfer(r, action, name, "", bytes, err)
+}
+
+// recordAuditTransfer writes an audit event for an operation with both a
+// source and a target path, such as a move or copy.
+func (s *Server) recordAuditTransfer(r *http.Request, action, name, target string, bytes int64, err error) {
+	event := audit.Event{
+		Action:     action,
+		Actor:      s.clientIP(r),
+		RemoteAddr: r.RemoteAddr,
+		Path:       name,
+		Target:     target,
+		Bytes:      bytes,
+		Result:     audit.ResultSuccess,
+		RequestID:  requestID(r),
+	}
+	if err != nil {
+		event.Result = audit.ResultFailure
+		event.Error = err.Error()
+	}
+	s.audit.Record(event)
+}
+
+// auditLogHandler returns audit events filtered by time range, actor and action.
+func (s *Server) auditLogHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	filter := audit.Filter{
+		Actor:  query.Get("actor"),
+		Action: query.Get("action"),
+		Limit:  1000,
+	}
+
+	var err error
+	if v := query.Get("since"); v != "" {
+		if filter.Since, err = time.Parse(time.RFC3339, v); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'since' timestamp, expected RFC 3339", nil)
+			return
+		}
+	}
+	if v := query.Get("until"); v != "" {
+		if filter.Until, err = time.Parse(time.RFC3339, v); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'until' timestamp, expected RFC 3339", nil)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		limit, err := strconv.Atoi(v)
+		if err != nil || limit <= 0 {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'limit', expected a positive integer", nil)
+			return
+		}
+		filter.Limit = limit
+	}
+
+	events, err := s.audit.Query(filter)
+	if err != nil {
+		if errors.Is(err, audit.ErrNoFile) {
+			s.writeError(w, r, http.StatusNotFound, apitypes.CodeNotFound, "Audit log file not configured", nil)
+			return
+		}
+		s.writeFSError(w, r, err, "Could not read audit log")
+		return
+	}
+
+	s.writeJSON(w, r, http.StatusOK, events)
+}
@@ -0,0 +1,42 @@
+package api
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	t.Setenv("SIDECAR_TRUSTED_PROXIES", "10.0.0.0/8, 192.0.2.1")
+	s, _ := newTestServer(t)
+	for _, tt := range []struct {
+		remoteAddr   string
+		forwardedFor string
+		want         string
+	}{
+		{"203.0.113.5:4000", "", "203.0.113.5"},
+		{"203.0.113.5:4000", "198.51.100.7", "203.0.113.5"},
+		{"10.1.2.3:4000", "", "10.1.2.3"},
+		{"10.1.2.3:4000", "198.51.100.7", "198.51.100.7"},
+		{"192.0.2.1:4000", "198.51.100.7", "198.51.100.7"},
+		{"192.0.2.2:4000", "198.51.100.7", "192.0.2.2"},
+		// Hops the client sent itself are skipped over.
+		{"10.1.2.3:4000", "1.1.1.1, 198.51.100.7", "198.51.100.7"},
+		{"10.1.2.3:4000", "1.1.1.1, 198.51.100.7, 10.9.9.9", "198.51.100.7"},
+		{"10.1.2.3:4000", "10.4.4.4", "10.4.4.4"},
+		{"[::ffff:10.1.2.3]:4000", "198.51.100.7", "198.51.100.7"},
+	} {
+		r := httptest.NewRequest("GET", "/", nil)
+		r.RemoteAddr = tt.remoteAddr
+		if tt.forwardedFor != "" {
+			r.Header.Set("X-Forwarded-For", tt.forwardedFor)
+		}
+		if got := s.clientIP(r); got != tt.want {
+			t.Errorf("clientIP from %s with X-Forwarded-For %q = %q, want %q", tt.remoteAddr, tt.forwardedFor, got, tt.want)
+		}
+	}
+
+	t.Setenv("SIDECAR_TRUSTED_PROXIES", "10.0.0.0/33")
+	if _, err := NewServer(":0", t.TempDir(), "logs/stdout.log", nil); err == nil {
+		t.Error("NewServer accepted an invalid SIDECAR_TRUSTED_PROXIES")
+	}
+}
@@ -0,0 +1,373 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/archive"
+	"github.com/pegnia/sidecar/internal/backup"
+	"github.com/pegnia/sidecar/internal/glob"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// maxBackupLabel limits the length of a snapshot's label.
+const maxBackupLabel = 200
+
+// parseBackupPaths parses the comma-separated list of root-relative paths
+// that snapshots are limited to. Paths inside another listed one are dropped,
+// so a restore can replace each path as a whole.
+func parseBackupPaths(list string) ([]string, error) {
+	var paths []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.Trim(strings.TrimSpace(p), "/"); p == "" {
+			continue
+		}
+		name := filepath.Clean(filepath.FromSlash(p))
+		if !filepath.IsLocal(name) || isInternalPath(name) {
+			return nil, fmt.Errorf("invalid path %q, expected a path inside the data root", p)
+		}
+		paths = append(paths, filepath.ToSlash(name))
+	}
+	return slices.DeleteFunc(paths, func(p string) bool {
+		return slices.ContainsFunc(paths, func(q string) bool { return p != q && strings.HasPrefix(p, q+"/") })
+	}), nil
+}
+
+// parseBackupExclude parses the comma-separated glob patterns of entries
+// left out of snapshots.
+func parseBackupExclude(list string) ([]string, error) {
+	var patterns []string
+	for _, p := range strings.Split(list, ",") {
+		if p = strings.TrimSpace(p); p == "" {
+			continue
+		}
+		if !glob.Valid(p) {
+			return nil, fmt.Errorf("invalid glob pattern %q", p)
+		}
+		patterns = append(patterns, p)
+	}
+	return patterns, nil
+}
+
+// openBackupDir opens the snapshot directory, which must be outside the data
+// root: inside it, every snapshot would contain the ones before it.
+func openBackupDir(dir, dataRoot string) (*backup.Dir, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	if rel, err := filepath.Rel(dataRoot, abs); err == nil && (rel == "." || filepath.IsLocal(rel)) {
+		return nil, fmt.Errorf("%s is inside the data root", dir)
+	}
+	return backup.OpenDir(abs)
+}
+
+// backupSkipped reports whether snapshots leave out the root-relative name:
+// the sidecar's own directory, hidden paths and excluded ones.
+func (s *Server) backupSkipped(name string) bool {
+	return s.hiddenPath(name) || glob.MatchAny(s.backupExclude, filepath.ToSlash(name))
+}
+
+// backupFilter selects the entries that go into a snapshot: the configured
+// paths, or the whole data root, minus those backupSkipped leaves out.
+func (s *Server) backupFilter() archive.Filter {
+	return func(rel string, d fs.DirEntry) bool {
+		if s.backupSkipped(filepath.FromSlash(rel)) {
+			return false
+		}
+		if len(s.backupPaths) == 0 {
+			return true
+		}
+		for _, p := range s.backupPaths {
+			if rel == p || strings.HasPrefix(rel, p+"/") {
+				return true
+			}
+			// Parents of a configured path are kept so it is restored in place.
+			if d.IsDir() && strings.HasPrefix(p, rel+"/") {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// measureBackup records the size and number of files a snapshot will hold.
+func (s *Server) measureBackup(filter archive.Filter, progress *transferProgress) error {
+	return fs.WalkDir(s.root.FS(), ".", func(rel string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if rel == "." {
+			return nil
+		}
+		if !filter(rel, d) {
+			return skipEntry(d)
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			progress.BytesTotal += info.Size()
+			progress.FilesTotal++
+		}
+		return nil
+	})
+}
+
+// snapshot takes a new snapshot, running the pre-snapshot hook before and
+// the post-snapshot hook after it. The post-snapshot hook runs whenever the
+// pre-snapshot one was started, so a failure never leaves the game server
+// with saving turned off. The snapshot is then uploaded to remote storage,
+// if configured. The caller must hold backupMu.
+func (s *Server) snapshot(ctx context.Context, createdBy, label string, progress *transferProgress) (*backup.Snapshot, error) {
+	for _, p := range s.backupPaths {
+		if _, err := s.root.Lstat(filepath.FromSlash(p)); err != nil {
+			return nil, err
+		}
+	}
+	filter := s.backupFilter()
+	if progress.streaming() {
+		if err := s.measureBackup(filter, progress); err != nil {
+			return nil, err
+		}
+	}
+
+	now := time.Now().UTC()
+	snap := &backup.Snapshot{
+		ID:        backup.NewID(now),
+		CreatedAt: now,
+		CreatedBy: createdBy,
+		Label:     label,
+		Format:    s.backupFormat,
+		Paths:     s.backupPaths,
+	}
+	start := time.Now()
+	err := backup.RunHook(ctx, "pre-snapshot", s.backupPreHook, s.backupHookTimeout, snap.ID)
+	if err == nil {
+		err = s.backups.Create(snap, func(w io.Writer) error {
+			return archive.Write(w, s.root, ".", s.backupFormat, func(rel string, d fs.DirEntry) bool {
+				if !filter(rel, d) {
+					return false
+				}
+				if d.Type().IsRegular() {
+					var size int64
+					if info, err := d.Info(); err == nil {
+						size = info.Size()
+					}
+					snap.Files++
+					progress.add(size, 1)
+				}
+				return true
+			})
+		})
+	}
+	// The game server must resume saving even if the request was cancelled.
+	// A snapshot that was written is kept regardless.
+	if hookErr := backup.RunHook(context.WithoutCancel(ctx), "post-snapshot", s.backupPostHook, s.backupHookTimeout, snap.ID); hookErr != nil {
+		var output string
+		var he *backup.HookError
+		if errors.As(hookErr, &he) {
+			output = he.Output
+		}
+		s.logger.Error("Post-snapshot command failed", "backup_id", snap.ID, "error", hookErr, "output", output)
+	}
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.Backups.Inc(result)
+	s.updateBackupMetrics()
+	if err != nil {
+		return nil, err
+	}
+	metrics.BackupDuration.Set(time.Since(start).Seconds())
+	s.shipBackup(ctx, snap, progress)
+	return snap, nil
+}
+
+// updateBackupMetrics refreshes the gauges describing the stored snapshots.
+func (s *Server) updateBackupMetrics() {
+	snaps, err := s.backups.List()
+	if err != nil {
+		s.logger.Warn("Could not list snapshots", "error", err)
+		return
+	}
+	var total int64
+	for _, snap := range snaps {
+		total += snap.Size
+	}
+	metrics.BackupSnapshots.Set(float64(len(snaps)))
+	metrics.BackupBytes.Set(float64(total))
+	if len(snaps) > 0 {
+		metrics.BackupLastTimestamp.Set(float64(snaps[0].CreatedAt.Unix()))
+	}
+}
+
+// backupInfo converts stored snapshot metadata for the API.
+func backupInfo(snap *backup.Snapshot) apitypes.Backup {
+	return apitypes.Backup{
+		ID:        snap.ID,
+		CreatedAt: snap.CreatedAt,
+		CreatedBy: snap.CreatedBy,
+		Label:     snap.Label,
+		Format:    string(snap.Format),
+		Paths:     snap.Paths,
+		Size:      snap.Size,
+		Files:     snap.Files,
+		SHA256:    snap.SHA256,
+		RemoteKey: snap.RemoteKey,
+	}
+}
+
+// backupsEnabled writes an error response if no backup directory is configured.
+func (s *Server) backupsEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if s.backups == nil {
+		s.writeError(w, r, http.StatusServiceUnavailable, apitypes.CodeNotConfigured, "Backups are not configured, set SIDECAR_BACKUP_DIR", nil)
+		return false
+	}
+	return true
+}
+
+// createBackupHandler takes a snapshot. Clients accepting text/event-stream
+// get progress events while it is written, as for copies.
+func (s *Server) createBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.backupsEnabled(w, r) {
+		return
+	}
+	var payload apitypes.BackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+	if len(payload.Label) > maxBackupLabel {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, fmt.Sprintf("Label is longer than %d bytes", maxBackupLabel), nil)
+		return
+	}
+	if !s.backupMu.TryLock() {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "A backup operation is already running", nil)
+		return
+	}
+	defer s.backupMu.Unlock()
+
+	progress := newTransferProgress(w, r)
+	snap, err := s.snapshot(r.Context(), s.clientIP(r), payload.Label, progress)
+	var id string
+	var size int64
+	if snap != nil {
+		id, size = snap.ID, snap.Size
+	}
+	s.recordAudit(r, "backup.create", id, size, err)
+
+	if !progress.streaming() {
+		if err != nil {
+			s.writeFSError(w, r, err, "Could not create snapshot")
+			return
+		}
+		s.writeJSON(w, r, http.StatusCreated, backupInfo(snap))
+		return
+	}
+
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if err != nil {
+		s.log(r).Error("Snapshot failed", "error", err)
+		_, code := fsErrorStatus(err)
+		progress.event("error", apitypes.ErrorResponse{
+			Code:      code,
+			Message:   "Could not create snapshot",
+			Details:   errorDetails(err),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	progress.event("progress", progress.TransferProgress)
+	progress.event("result", backupInfo(snap))
+}
+
+// listBackupsHandler returns the stored snapshots, newest first.
+func (s *Server) listBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.backupsEnabled(w, r) {
+		return
+	}
+	snaps, err := s.backups.List()
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not list snapshots")
+		return
+	}
+	backups := make([]apitypes.Backup, len(snaps))
+	for i := range snaps {
+		backups[i] = backupInfo(&snaps[i])
+	}
+	s.writeJSON(w, r, http.StatusOK, backups)
+}
+
+// downloadBackupHandler serves the archive of a snapshot. Snapshots never
+// change, so their checksum is a strong ETag and downloads can be resumed.
+func (s *Server) downloadBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.backupsEnabled(w, r) {
+		return
+	}
+	f, snap, err := s.backups.Open(r.PathValue("id"))
+	if err != nil {
+		s.writeBackupError(w, r, err)
+		return
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not read snapshot")
+		return
+	}
+	w.Header().Set("ETag", `"`+snap.SHA256+`"`)
+	w.Header().Set("Content-Type", snap.Format.ContentType())
+	w.Header().Set("Content-Disposition", attachment(snap.ID+snap.Format.Extension()))
+	http.ServeContent(w, r, "", info.ModTime(), f)
+}
+
+// deleteBackupHandler removes a snapshot.
+func (s *Server) deleteBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.backupsEnabled(w, r) {
+		return
+	}
+	id := r.PathValue("id")
+	snap, err := s.backups.Delete(id)
+	s.recordAudit(r, "backup.delete", id, snap.Size, err)
+	if err != nil {
+		s.writeBackupError(w, r, err)
+		return
+	}
+	s.updateBackupMetrics()
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Snapshot deleted", Bytes: snap.Size, Files: snap.Files})
+}
+
+// writeBackupError reports a failure to find or read snapshot metadata.
+func (s *Server) writeBackupError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, fs.ErrNotExist) {
+		s.writeError(w, r, http.StatusNotFound, apitypes.CodeNotFound, "Snapshot not found", nil)
+		return
+	}
+	s.writeFSError(w, r, err, "Could not read snapshot")
+}
+
+// parseBackupFormat validates SIDECAR_BACKUP_FORMAT.
+func parseBackupFormat(v string) (archive.Format, error) {
+	if v == "" {
+		return archive.TarGz, nil
+	}
+	f, err := archive.ParseFormat(v)
+	if err != nil || f != archive.TarGz && f != archive.TarZst {
+		return "", fmt.Errorf("unsupported snapshot format %q, expected %s or %s", v, archive.TarGz, archive.TarZst)
+	}
+	return f, nil
+}
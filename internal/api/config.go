@@ -0,0 +1,185 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/pegnia/sidecar/internal/configfile"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/textenc"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// configFormat returns the format requested with ?format=, or the one implied
+// by the file extension.
+func configFormat(r *http.Request, name string) (configfile.Format, bool) {
+	if f := r.URL.Query().Get("format"); f != "" {
+		format, err := configfile.ParseFormat(f)
+		return format, err == nil
+	}
+	return configfile.FormatFromName(name)
+}
+
+// configFile builds the response for a config file's text.
+func configFile(name string, format configfile.Format, text, etag string) (apitypes.ConfigFile, error) {
+	settings, err := configfile.Get(format, text)
+	if err != nil {
+		return apitypes.ConfigFile{}, err
+	}
+	raw, err := json.Marshal(settings)
+	if err != nil {
+		return apitypes.ConfigFile{}, err
+	}
+	return apitypes.ConfigFile{Path: name, Format: string(format), Settings: raw, ETag: etag}, nil
+}
+
+// getConfigHandler parses a config file and returns its settings as JSON.
+func (s *Server) getConfigHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := s.sanitizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	format, ok := configFormat(r, name)
+	if !ok {
+		s.writeUnknownFormat(w, r)
+		return
+	}
+	raw, _, err := s.readText(name)
+	if err != nil {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	etag := contentETag(raw)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	text, _, err := textenc.Decode(raw)
+	if err != nil {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	cfg, err := configFile(name, format, text, etag)
+	if err != nil {
+		s.writeConfigError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, cfg)
+}
+
+// patchConfigHandler applies a JSON merge patch to a config file. Only the
+// changed keys are rewritten, so comments and ordering stay as they were,
+// and the file keeps its encoding. If-Match works as for the content
+// endpoints.
+func (s *Server) patchConfigHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := s.sanitizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if !s.authorize(w, r, name, policy.Write) {
+		return
+	}
+	if err := s.checkUploadName(name); err != nil {
+		s.writeFSError(w, r, err, "File type not allowed for security reasons")
+		return
+	}
+	format, ok := configFormat(r, name)
+	if !ok {
+		s.writeUnknownFormat(w, r)
+		return
+	}
+	patch, err := configfile.DecodeObject(http.MaxBytesReader(w, r.Body, s.textMaxBytes))
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.writeContentError(w, r, errTextTooLarge, "Patch too large")
+			return
+		}
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Request body must be a JSON object", map[string]any{"error": err.Error()})
+		return
+	}
+
+	s.contentMu.Lock()
+	defer s.contentMu.Unlock()
+
+	current, _, err := s.readText(name)
+	if err != nil {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	currentETag := contentETag(current)
+	if im := r.Header.Get("If-Match"); im != "" && !etagMatches(im, currentETag) {
+		s.writeError(w, r, http.StatusPreconditionFailed, apitypes.CodePreconditionFailed, "File was changed by someone else",
+			map[string]any{"etag": currentETag})
+		return
+	}
+	text, enc, err := textenc.Decode(current)
+	if err != nil {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	patched, err := configfile.Patch(format, text, patch)
+	if err != nil {
+		s.writeConfigError(w, r, err)
+		return
+	}
+	raw, err := textenc.Encode(patched, enc)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Settings cannot be encoded", map[string]any{"error": err.Error()})
+		return
+	}
+	if int64(len(raw)) > s.textMaxBytes {
+		s.writeContentError(w, r, errTextTooLarge, "Content too large")
+		return
+	}
+
+	if err := s.checkUploadSize(name, int64(len(raw))); err != nil {
+		s.writeFSError(w, r, err, "File exceeds the size limit for its path")
+		return
+	}
+
+	written, _, err := s.writeFileAtomic(name, bytes.NewReader(raw), expectedChecksums{}, func(staged string) error {
+		return s.checkStagedUpload(r.Context(), name, staged)
+	})
+	metrics.BytesUploaded.Add(float64(written))
+	s.recordAudit(r, "config.update", name, written, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not save file")
+		return
+	}
+
+	etag := contentETag(raw)
+	w.Header().Set("ETag", etag)
+	cfg, err := configFile(name, format, patched, etag)
+	if err != nil {
+		s.writeConfigError(w, r, err)
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, cfg)
+}
+
+func (s *Server) writeUnknownFormat(w http.ResponseWriter, r *http.Request) {
+	s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Unknown config format; pass format explicitly",
+		map[string]any{"formats": configfile.Formats})
+}
+
+// writeConfigError maps parse and patch failures; anything else is a file error.
+func (s *Server) writeConfigError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, configfile.ErrSyntax):
+		s.writeError(w, r, http.StatusUnprocessableEntity, apitypes.CodeInvalidConfig, "File cannot be parsed in this format",
+			map[string]any{"error": err.Error()})
+	case errors.Is(err, configfile.ErrPatch):
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Patch does not fit the file",
+			map[string]any{"error": err.Error()})
+	default:
+		s.writeFSError(w, r, err, "Could not process config file")
+	}
+}
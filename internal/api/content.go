@@ -0,0 +1,217 @@
+package api
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"strings"
+	"syscall"
+
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/textenc"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// errTextTooLarge is returned for files above the text editing size limit.
+var errTextTooLarge = errors.New("file exceeds the text size limit")
+
+// contentETag returns the strong entity tag of a file's raw bytes.
+func contentETag(b []byte) string {
+	sum := sha256.Sum256(b)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// etagMatches evaluates an If-Match or If-None-Match header value against the
+// current entity tag, which is empty if the file does not exist. Weak tags
+// never match, as required for If-Match's strong comparison.
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if etag != "" && (candidate == "*" || candidate == etag) {
+			return true
+		}
+	}
+	return false
+}
+
+// readText reads a regular file of at most s.textMaxBytes, returning its raw bytes.
+func (s *Server) readText(name string) ([]byte, fs.FileInfo, error) {
+	f, err := s.root.Open(name)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	info, err := f.Stat()
+	if err != nil {
+		return nil, nil, err
+	}
+	if info.IsDir() {
+		return nil, nil, &fs.PathError{Op: "read", Path: name, Err: syscall.EISDIR}
+	}
+	if info.Size() > s.textMaxBytes {
+		return nil, info, errTextTooLarge
+	}
+	b, err := io.ReadAll(io.LimitReader(f, s.textMaxBytes+1))
+	if err == nil && int64(len(b)) > s.textMaxBytes {
+		err = errTextTooLarge
+	}
+	return b, info, err
+}
+
+// getContentHandler returns a text file decoded to UTF-8 along with its ETag.
+func (s *Server) getContentHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := s.sanitizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	raw, info, err := s.readText(name)
+	if err != nil {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	etag := contentETag(raw)
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	text, enc, err := textenc.Decode(raw)
+	if err != nil {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, apitypes.FileContent{
+		Path:     name,
+		Content:  text,
+		Encoding: string(enc),
+		Size:     info.Size(),
+		Modified: info.ModTime(),
+		ETag:     etag,
+	})
+}
+
+// putContentHandler replaces a text file atomically. With If-Match the write
+// only happens if the file still has the given ETag, so two admins editing
+// the same config cannot silently overwrite each other; If-None-Match: *
+// only creates new files.
+func (s *Server) putContentHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := s.sanitizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if name == "." {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeIsDirectory, "Path must name a file", nil)
+		return
+	}
+	if !s.authorize(w, r, name, policy.Write) {
+		return
+	}
+	if err := s.checkUploadName(name); err != nil {
+		s.writeFSError(w, r, err, "File type not allowed for security reasons")
+		return
+	}
+
+	// JSON escaping can grow text considerably, so the body gets headroom
+	// beyond the text limit; the encoded content is checked exactly below.
+	var payload apitypes.ContentRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 6*s.textMaxBytes+4096)).Decode(&payload); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.writeContentError(w, r, errTextTooLarge, "Content too large")
+			return
+		}
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+
+	// Serialize writers so the ETag check and the rename act as one step.
+	s.contentMu.Lock()
+	defer s.contentMu.Unlock()
+
+	current, _, err := s.readText(name)
+	exists := err == nil
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		s.writeContentError(w, r, err, "Could not read file")
+		return
+	}
+	currentETag := ""
+	if exists {
+		currentETag = contentETag(current)
+	}
+	if im := r.Header.Get("If-Match"); im != "" && !etagMatches(im, currentETag) {
+		s.writeError(w, r, http.StatusPreconditionFailed, apitypes.CodePreconditionFailed, "File was changed by someone else",
+			map[string]any{"etag": currentETag})
+		return
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" && etagMatches(inm, currentETag) {
+		s.writeError(w, r, http.StatusPreconditionFailed, apitypes.CodePreconditionFailed, "File already exists",
+			map[string]any{"etag": currentETag})
+		return
+	}
+
+	enc := textenc.UTF8
+	if payload.Encoding != "" {
+		if enc, err = textenc.Parse(payload.Encoding); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Unsupported encoding", map[string]any{"encoding": payload.Encoding})
+			return
+		}
+	} else if exists {
+		if _, existing, err := textenc.Decode(current); err == nil {
+			enc = existing
+		}
+	}
+	raw, err := textenc.Encode(payload.Content, enc)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Content cannot be encoded", map[string]any{"error": err.Error()})
+		return
+	}
+	if int64(len(raw)) > s.textMaxBytes {
+		s.writeContentError(w, r, errTextTooLarge, "Content too large")
+		return
+	}
+
+	if err := s.checkUploadSize(name, int64(len(raw))); err != nil {
+		s.writeFSError(w, r, err, "File exceeds the size limit for its path")
+		return
+	}
+
+	written, sums, err := s.writeFileAtomic(name, bytes.NewReader(raw), expectedChecksums{}, func(staged string) error {
+		return s.checkStagedUpload(r.Context(), name, staged)
+	})
+	metrics.BytesUploaded.Add(float64(written))
+	s.recordAudit(r, "file.write", name, written, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not save file")
+		return
+	}
+
+	etag := contentETag(raw)
+	w.Header().Set("ETag", etag)
+	status := http.StatusOK
+	if !exists {
+		status = http.StatusCreated
+	}
+	s.writeResult(w, r, status, apitypes.Result{Message: "File saved successfully", Path: name, Bytes: written, SHA256: sums.SHA256, MD5: sums.MD5, ETag: etag})
+}
+
+// writeContentError handles the text-specific failures before falling back to writeFSError.
+func (s *Server) writeContentError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, errTextTooLarge):
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge, "File exceeds the text editing size limit",
+			map[string]any{"limit": s.textMaxBytes})
+	case errors.Is(err, textenc.ErrBinary):
+		s.writeError(w, r, http.StatusUnsupportedMediaType, apitypes.CodeNotText, "File is not a text file", nil)
+	default:
+		s.writeFSError(w, r, err, message)
+	}
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestContentPolicy checks that the text content and config endpoints refuse
+// what an upload of the same file would be refused for.
+func TestContentPolicy(t *testing.T) {
+	t.Setenv("SIDECAR_UPLOAD_MAX_SIZES", "*.cfg=8")
+	t.Setenv("SIDECAR_UPLOAD_SCAN_COMMAND", "grep -qv EICAR")
+	for _, tt := range []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"content allowed", "PUT", "/api/files/content?path=sub/new.txt", `{"content":"hello"}`, http.StatusCreated, ""},
+		{"content extension", "PUT", "/api/files/content?path=sub/run.exe", `{"content":"hello"}`, http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"content size", "PUT", "/api/files/content?path=sub/a.cfg", `{"content":"123456789"}`, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge},
+		{"content scan", "PUT", "/api/files/content?path=sub/new.txt", `{"content":"EICAR"}`, http.StatusUnprocessableEntity, apitypes.CodeContentRejected},
+		{"config allowed", "PATCH", "/api/config?path=sub/server.properties", `{"motd":"hi"}`, http.StatusOK, ""},
+		{"config scan", "PATCH", "/api/config?path=sub/server.properties", `{"motd":"EICAR"}`, http.StatusUnprocessableEntity, apitypes.CodeContentRejected},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			props := filepath.Join(base, "data", "sub", "server.properties")
+			if err := os.WriteFile(props, []byte("motd=old\n"), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+			entries, _ := os.ReadDir(filepath.Join(base, "data", "sub"))
+			if len(entries) != 2 {
+				t.Errorf("a refused write left %d entries in the directory, want 2", len(entries))
+			}
+			if data, _ := os.ReadFile(props); string(data) != "motd=old\n" {
+				t.Errorf("a refused write changed server.properties to %q", data)
+			}
+		})
+	}
+}
+
+// TestContentETag checks that reads and writes of text content honour the
+// ETag preconditions, so concurrent editors cannot overwrite each other.
+func TestContentETag(t *testing.T) {
+	s, base := newTestServer(t)
+	h := testHandler(s)
+	do := func(method, target, body string, header ...string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		for i := 0; i < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+		return rr
+	}
+	checkError := func(rr *httptest.ResponseRecorder, status int, code string) {
+		t.Helper()
+		if rr.Code != status {
+			t.Fatalf("got status %d, want %d: %s", rr.Code, status, rr.Body)
+		}
+		var resp apitypes.ErrorResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Code != code {
+			t.Errorf("got code %q, want %q", resp.Code, code)
+		}
+	}
+	okFile := filepath.Join(base, "data", "sub", "ok.txt")
+
+	rr := do("GET", "/api/files/content?path=sub/ok.txt", "")
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+	}
+	var file apitypes.FileContent
+	if err := json.Unmarshal(rr.Body.Bytes(), &file); err != nil {
+		t.Fatal(err)
+	}
+	etag := rr.Header().Get("ETag")
+	if file.Content != "ok" || etag == "" || file.ETag != etag {
+		t.Fatalf("got content %q with ETag %q, header %q", file.Content, file.ETag, etag)
+	}
+	if rr := do("GET", "/api/files/content?path=sub/ok.txt", "", "If-None-Match", etag); rr.Code != http.StatusNotModified {
+		t.Errorf("GET with the current ETag: got status %d, want %d", rr.Code, http.StatusNotModified)
+	}
+
+	rr = do("PUT", "/api/files/content?path=sub/ok.txt", `{"content":"first"}`, "If-Match", etag)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("PUT with the current ETag: got status %d: %s", rr.Code, rr.Body)
+	}
+	newETag := rr.Header().Get("ETag")
+	if newETag == "" || newETag == etag {
+		t.Errorf("got ETag %q after the write, want a new one", newETag)
+	}
+
+	// A second editor still holding the old ETag is refused.
+	rr = do("PUT", "/api/files/content?path=sub/ok.txt", `{"content":"second"}`, "If-Match", etag)
+	checkError(rr, http.StatusPreconditionFailed, apitypes.CodePreconditionFailed)
+	if data, _ := os.ReadFile(okFile); string(data) != "first" {
+		t.Errorf("a refused write changed the file to %q", data)
+	}
+
+	checkError(do("PUT", "/api/files/content?path=sub/ok.txt", `{"content":"x"}`, "If-None-Match", "*"),
+		http.StatusPreconditionFailed, apitypes.CodePreconditionFailed)
+	if rr := do("PUT", "/api/files/content?path=sub/new.txt", `{"content":"x"}`, "If-None-Match", "*"); rr.Code != http.StatusCreated {
+		t.Errorf("creating a new file with If-None-Match: *: got status %d: %s", rr.Code, rr.Body)
+	}
+	if rr := do("PUT", "/api/files/content?path=sub/other.txt", `{"content":"x"}`, "If-Match", etag); rr.Code != http.StatusPreconditionFailed {
+		t.Errorf("If-Match for a missing file: got status %d, want %d", rr.Code, http.StatusPreconditionFailed)
+	}
+}
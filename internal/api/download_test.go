@@ -0,0 +1,107 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestDownloadConditional checks that file downloads honour Range and the
+// conditional headers against the ETag and Last-Modified they send.
+func TestDownloadConditional(t *testing.T) {
+	s, base := newTestServer(t)
+	if err := os.WriteFile(filepath.Join(base, "data", "sub", "big.txt"), []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	h := testHandler(s)
+	const target = "/api/files/download?path=sub/big.txt"
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+	if rr.Code != http.StatusOK || rr.Body.String() != "0123456789" {
+		t.Fatalf("got status %d, body %q", rr.Code, rr.Body)
+	}
+	etag, modified := rr.Header().Get("ETag"), rr.Header().Get("Last-Modified")
+	if etag == "" || modified == "" {
+		t.Fatalf("got ETag %q and Last-Modified %q, want both", etag, modified)
+	}
+
+	for _, tt := range []struct {
+		name       string
+		headers    map[string]string
+		wantStatus int
+		wantBody   string
+	}{
+		{"range", map[string]string{"Range": "bytes=2-5"}, http.StatusPartialContent, "2345"},
+		{"suffix range", map[string]string{"Range": "bytes=-3"}, http.StatusPartialContent, "789"},
+		{"if-range current", map[string]string{"Range": "bytes=8-", "If-Range": etag}, http.StatusPartialContent, "89"},
+		{"if-range stale", map[string]string{"Range": "bytes=8-", "If-Range": `"stale"`}, http.StatusOK, "0123456789"},
+		{"if-none-match", map[string]string{"If-None-Match": etag}, http.StatusNotModified, ""},
+		{"if-modified-since", map[string]string{"If-Modified-Since": modified}, http.StatusNotModified, ""},
+		{"if-match stale", map[string]string{"If-Match": `"stale"`}, http.StatusPreconditionFailed, ""},
+		{"unsatisfiable range", map[string]string{"Range": "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", target, nil)
+			for k, v := range tt.headers {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantBody != "" && rr.Body.String() != tt.wantBody {
+				t.Errorf("got body %q, want %q", rr.Body, tt.wantBody)
+			}
+		})
+	}
+
+	t.Run("content etag", func(t *testing.T) {
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("GET", target+"&etag=content", nil))
+		f, err := os.Open(filepath.Join(base, "data", "sub", "big.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		want, err := hashETag(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rr.Header().Get("ETag"); rr.Code != http.StatusOK || got != want {
+			t.Errorf("got status %d, ETag %q, want ETag %q", rr.Code, got, want)
+		}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid etag mode", "path=sub/big.txt&etag=weak", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"missing", "path=sub/missing.txt", http.StatusNotFound, apitypes.CodeNotFound},
+		{"escape", "path=out", http.StatusBadRequest, apitypes.CodeInvalidPath},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("GET", "/api/files/download?"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
@@ -0,0 +1,184 @@
+package api
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"path/filepath"
+	"slices"
+
+	"github.com/pegnia/sidecar/internal/archive"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/uploadpolicy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// extractDir holds the files an extraction with overwrite replaces until it
+// has finished, one directory per extraction. Like trashDir it lives inside
+// the data root, so moving them aside and back are renames.
+var extractDir = filepath.Join(internalDir, "extract")
+
+// countingReader counts the bytes read through it.
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// extractArchiveHandler unpacks an uploaded zip or tar archive into an
+// existing directory. The archive is read straight from the request body
+// rather than buffered by the multipart parser.
+func (s *Server) extractArchiveHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	dirName, err := s.sanitizePath(query.Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if !s.authorize(w, r, dirName, policy.Write) {
+		return
+	}
+	dirInfo, err := s.root.Stat(dirName)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not access destination directory")
+		return
+	}
+	if !dirInfo.IsDir() {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeNotDirectory, "Destination path is not a directory", nil)
+		return
+	}
+
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	mr, err := r.MultipartReader()
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Expected a multipart/form-data body", nil)
+		return
+	}
+	part, err := mr.NextPart()
+	for err == nil && part.FormName() != "file" {
+		part, err = mr.NextPart()
+	}
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Missing archive in form field \"file\"", nil)
+		return
+	}
+	defer part.Close()
+
+	format, ok := archive.FormatFromName(part.FileName())
+	if v := query.Get("format"); v != "" {
+		format, err = archive.ParseFormat(v)
+		ok = err == nil
+	}
+	if !ok {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Unsupported or unknown archive format",
+			map[string]any{"filename": part.FileName(), "supported": []archive.Format{archive.Zip, archive.Tar, archive.TarGz, archive.TarZst}})
+		return
+	}
+
+	s.log(r).Info("Archive extraction in progress", "filename", part.FileName(), "format", format, "destination", dirName)
+
+	// The quota, if tighter, lowers the uncompressed size limit.
+	maxBytes := s.extractMaxBytes
+	remaining, limited, err := s.quotaRemaining(r.Context())
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not extract archive")
+		return
+	}
+	quotaBound := limited && remaining < maxBytes
+	if quotaBound {
+		maxBytes = remaining
+	}
+
+	body := &countingReader{r: part}
+	stats, err := archive.Extract(body, s.root, dirName, format, archive.ExtractOptions{
+		MaxBytes:   maxBytes,
+		MaxFiles:   s.extractMaxFiles,
+		Allow:      func(name string) bool { return s.checkUploadName(name) == nil },
+		Check:      s.checkExtractEntry,
+		Overwrite:  query.Get("overwrite") == "true",
+		StagingDir: filepath.Join(extractDir, newRequestID()),
+		FileMode:   s.fileMode,
+		DirMode:    s.dirMode,
+		Created:    s.ownNew,
+		Verify: func(dst, staged string) error {
+			return s.checkExtractedFile(r.Context(), dst, staged)
+		},
+	})
+	metrics.BytesUploaded.Add(float64(body.n))
+	if quotaBound && errors.Is(err, archive.ErrTooLarge) {
+		used, _ := s.usage.Used(r.Context())
+		err = &quotaError{Limit: s.quotaBytes, Used: used, Requested: maxBytes + 1}
+	}
+	if err == nil {
+		s.usage.Add(stats.Bytes)
+	}
+	s.recordAudit(r, "file.extract", dirName, stats.Bytes, err)
+	if err != nil {
+		s.writeExtractError(w, r, err)
+		return
+	}
+
+	s.writeResult(w, r, http.StatusCreated, apitypes.Result{
+		Message: "Archive extracted successfully", Path: dirName, Bytes: stats.Bytes, Files: stats.Files,
+	})
+	s.log(r).Info("Archive extraction completed successfully", "path", dirName, "files", stats.Files, "size", stats.Bytes)
+}
+
+// checkExtractEntry vets the destination of every archive entry like any
+// other write, both as named and with the links along it followed, since a
+// link the archive created earlier may lead elsewhere. An archive extracted
+// into the data root could otherwise also reach the internal directory.
+func (s *Server) checkExtractEntry(dst string) error {
+	names, err := s.policyNames(dst, policy.Write)
+	if err != nil {
+		return err
+	}
+	if slices.ContainsFunc(names, isInternalPath) {
+		return errInternalPath
+	}
+	return s.checkPolicy(dst, policy.Write)
+}
+
+// writeExtractError maps archive policy violations to API errors and falls
+// back to the filesystem mapping for everything else.
+func (s *Server) writeExtractError(w http.ResponseWriter, r *http.Request, err error) {
+	var entry *archive.EntryError
+	var maxBytesErr *http.MaxBytesError
+	var rejection *uploadpolicy.Rejection
+	switch {
+	case errors.As(err, &maxBytesErr):
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge, "Upload exceeds the maximum request size",
+			map[string]any{"limit": maxBytesErr.Limit})
+	case errors.As(err, &entry) && errors.Is(err, archive.ErrUnsafePath):
+		s.log(r).Warn("Rejected archive with unsafe entry path", "entry", entry.Name)
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Archive entry escapes the destination directory",
+			map[string]any{"entry": entry.Name})
+	case errors.As(err, &entry) && errors.As(err, &rejection):
+		s.log(r).Warn("Rejected archive containing a file refused by the upload policy", "entry", entry.Name, "reason", rejection.Reason)
+		status, code := fsErrorStatus(err)
+		details := errorDetails(err)
+		details["entry"] = entry.Name
+		s.writeError(w, r, status, code, "Archive contains a file refused by the upload policy", details)
+	case errors.As(err, &entry) && errors.Is(err, archive.ErrForbidden):
+		s.log(r).Warn("Rejected archive containing forbidden file type", "entry", entry.Name)
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Archive contains a file type not allowed for security reasons",
+			map[string]any{"entry": entry.Name})
+	case errors.Is(err, archive.ErrCorrupt):
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Archive is corrupt or truncated",
+			map[string]any{"error": errorReason(err)})
+	case errors.Is(err, archive.ErrTooLarge):
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge, "Archive exceeds the maximum uncompressed size",
+			map[string]any{"limit": s.extractMaxBytes})
+	case errors.Is(err, archive.ErrTooManyFiles):
+		s.writeError(w, r, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge, "Archive contains too many entries",
+			map[string]any{"limit": s.extractMaxFiles})
+	default:
+		s.writeFSError(w, r, err, "Could not extract archive")
+	}
+}
@@ -0,0 +1,158 @@
+package api
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// extractRequest builds an extraction request into dir for a tar archive
+// holding files, given as name and content pairs.
+func extractRequest(t *testing.T, dir string, files ...string) *http.Request {
+	t.Helper()
+	var archive bytes.Buffer
+	tw := tar.NewWriter(&archive)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &tar.Header{Name: files[i], Mode: 0o644, Size: int64(len(files[i+1])), Typeflag: tar.TypeReg}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archiveRequest(t, dir, "archive.tar", archive.Bytes())
+}
+
+// extractZipRequest is extractRequest for a zip archive.
+func extractZipRequest(t *testing.T, dir string, files ...string) *http.Request {
+	t.Helper()
+	var archive bytes.Buffer
+	zw := zip.NewWriter(&archive)
+	for i := 0; i < len(files); i += 2 {
+		w, err := zw.Create(files[i])
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(files[i+1])); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return archiveRequest(t, dir, "archive.zip", archive.Bytes())
+}
+
+// archiveRequest builds an extraction request into dir uploading data as
+// the file name.
+func archiveRequest(t *testing.T, dir, name string, data []byte) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write(data)
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/files/extract?path="+dir, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// TestExtractContentPolicy checks that every extracted file goes through the
+// upload content policy, and that a refused archive leaves nothing behind.
+func TestExtractContentPolicy(t *testing.T) {
+	t.Setenv("SIDECAR_UPLOAD_DENY_TYPES", "application/x-elf")
+	t.Setenv("SIDECAR_UPLOAD_MAX_SIZES", "*.cfg=4")
+	t.Setenv("SIDECAR_UPLOAD_SCAN_COMMAND", "grep -qv EICAR")
+	for _, tt := range []struct {
+		name       string
+		files      []string
+		wantStatus int
+		wantCode   string
+	}{
+		{"allowed", []string{"run.txt", "#!/bin/sh\n", "mod.json", "x", "a.cfg", "1234"}, http.StatusCreated, ""},
+		{"extension", []string{"first.txt", "x", "tool.exe", "x"}, http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"size", []string{"first.txt", "x", "a.cfg", "12345"}, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge},
+		{"type", []string{"first.txt", "x", "plugin", "\x7fELF\x02\x01\x01"}, http.StatusUnprocessableEntity, apitypes.CodeContentRejected},
+		{"scan", []string{"first.txt", "x", "virus.txt", "EICAR"}, http.StatusUnprocessableEntity, apitypes.CodeContentRejected},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, extractRequest(t, "sub", tt.files...))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			entries, err := os.ReadDir(filepath.Join(base, "data", "sub"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var names []string
+			for _, e := range entries {
+				names = append(names, e.Name())
+			}
+			if tt.wantCode == "" {
+				if len(names) != 1+len(tt.files)/2 {
+					t.Errorf("got entries %v after extraction", names)
+				}
+				return
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode || resp.Details["entry"] != tt.files[2] {
+				t.Errorf("got code %q for entry %v, want %q for %q", resp.Code, resp.Details["entry"], tt.wantCode, tt.files[2])
+			}
+			if len(names) != 1 || names[0] != "ok.txt" {
+				t.Errorf("got entries %v after a refused extraction, want only the original ok.txt", names)
+			}
+		})
+	}
+}
+
+// TestExtractZipSpool checks that a zip is buffered inside the data root,
+// not in the system's temporary directory, and that nothing of it is left.
+func TestExtractZipSpool(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		files      []string
+		wantStatus int
+	}{
+		{"extracted", []string{"a.txt", "a", "b/c.txt", "c"}, http.StatusCreated},
+		{"refused", []string{"a.txt", "a", "tool.exe", "x"}, http.StatusBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			t.Setenv("TMPDIR", filepath.Join(base, "missing"))
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, extractZipRequest(t, "sub", tt.files...))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			_, err := os.Stat(filepath.Join(base, "data", "sub", "a.txt"))
+			if (err == nil) != (tt.wantStatus == http.StatusCreated) {
+				t.Errorf("got %v for the extracted a.txt", err)
+			}
+			entries, _ := os.ReadDir(filepath.Join(base, "data", extractDir))
+			if len(entries) != 0 {
+				t.Errorf("%d entries left in %s", len(entries), extractDir)
+			}
+		})
+	}
+}
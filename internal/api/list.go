@@ -0,0 +1,247 @@
+package api
+
+import (
+	"cmp"
+	"encoding/base64"
+	"encoding/json"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+)
+
+// fileInfo describes the entry at the slash-separated, root-relative path p.
+func (s *Server) fileInfo(p string, info fs.FileInfo) FileInfo {
+	fi := FileInfo{
+		Name:     info.Name(),
+		Path:     p,
+		Size:     info.Size(),
+		IsDir:    info.IsDir(),
+		Modified: info.ModTime(),
+		Mode:     info.Mode().String(),
+	}
+	if info.Mode()&fs.ModeSymlink != 0 {
+		if target, err := s.root.Readlink(filepath.FromSlash(p)); err == nil {
+			fi.SymlinkTarget = target
+		}
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		fi.UID, fi.GID = &uid, &gid
+	}
+	if !info.IsDir() {
+		// Only the extension is consulted; sniffing would mean opening every file.
+		if t, _, err := mime.ParseMediaType(mime.TypeByExtension(filepath.Ext(info.Name()))); err == nil {
+			fi.MIMEType = t
+		}
+	}
+	return fi
+}
+
+// listCursor marks the last entry of a page. It carries the entry's sort
+// keys rather than an offset, so files created or deleted between requests
+// do not make pages skip or repeat entries.
+type listCursor struct {
+	Sort     string `json:"s"`
+	Order    string `json:"o"`
+	Name     string `json:"n"`
+	Path     string `json:"p"`
+	Size     int64  `json:"z"`
+	Modified int64  `json:"m"`
+}
+
+func encodeListCursor(sortBy, order string, fi FileInfo) string {
+	b, _ := json.Marshal(listCursor{Sort: sortBy, Order: order, Name: fi.Name, Path: fi.Path, Size: fi.Size, Modified: fi.Modified.UnixNano()})
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeListCursor(v string) (*listCursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return nil, err
+	}
+	var c listCursor
+	if err := json.Unmarshal(b, &c); err != nil {
+		return nil, err
+	}
+	return &c, nil
+}
+
+// compareFiles orders entries by the sort key, then by path so the order is total.
+func compareFiles(sortBy string, a, b *FileInfo) int {
+	var c int
+	switch sortBy {
+	case "size":
+		c = cmp.Compare(a.Size, b.Size)
+	case "modified":
+		c = a.Modified.Compare(b.Modified)
+	default:
+		c = strings.Compare(a.Name, b.Name)
+	}
+	if c == 0 {
+		c = strings.Compare(a.Path, b.Path)
+	}
+	return c
+}
+
+// listFilesHandler lists a directory, optionally recursively. Results are
+// sorted and paginated: when more entries remain, the X-Next-Cursor header
+// holds the cursor for the next page, and X-Total-Count gives the number of
+// entries across all pages. Each page carries an ETag of its content and a
+// Last-Modified of the newest entry walked, and honours If-None-Match and
+// If-Modified-Since.
+func (s *Server) listFilesHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name, err := s.sanitizePath(query.Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+
+	depth, limit, hidden := 1, defaultListLimit, true
+	sortBy, order := cmp.Or(query.Get("sort"), "name"), cmp.Or(query.Get("order"), "asc")
+	if v := query.Get("depth"); v != "" {
+		if depth, err = strconv.Atoi(v); err != nil || depth < 0 {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'depth', expected 0 (unlimited) or a positive integer", nil)
+			return
+		}
+	}
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxListLimit {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'limit', expected 1 to "+strconv.Itoa(maxListLimit), nil)
+			return
+		}
+	}
+	if v := query.Get("hidden"); v != "" {
+		if hidden, err = strconv.ParseBool(v); err != nil {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'hidden', expected a boolean", nil)
+			return
+		}
+	}
+	if sortBy != "name" && sortBy != "size" && sortBy != "modified" {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'sort', expected name, size or modified", nil)
+		return
+	}
+	if order != "asc" && order != "desc" {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'order', expected asc or desc", nil)
+		return
+	}
+	var cursor *listCursor
+	if v := query.Get("cursor"); v != "" {
+		if cursor, err = decodeListCursor(v); err != nil || cursor.Sort != sortBy || cursor.Order != order {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'cursor' for this sort order", nil)
+			return
+		}
+	}
+
+	info, err := s.root.Stat(name)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not read directory")
+		return
+	}
+	if !info.IsDir() {
+		s.writeFSError(w, r, &fs.PathError{Op: "readdir", Path: name, Err: syscall.ENOTDIR}, "Could not read directory")
+		return
+	}
+
+	ctx := r.Context()
+	files := []FileInfo{}
+	// The directory's own modification time covers entries removed from it.
+	modified := info.ModTime()
+	base := filepath.ToSlash(name)
+	err = fs.WalkDir(s.root.FS(), base, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			if p == base {
+				return err
+			}
+			s.log(r).Warn("Could not read entry, skipping", "entry", p, "error", err)
+			return nil
+		}
+		if p == base {
+			return nil
+		}
+		if s.hiddenPath(filepath.FromSlash(p)) || !hidden && strings.HasPrefix(d.Name(), ".") {
+			return skipEntry(d)
+		}
+		info, err := d.Info()
+		if err != nil {
+			s.log(r).Warn("Could not get file info for entry, skipping", "entry", p, "error", err)
+			return nil
+		}
+		files = append(files, s.fileInfo(p, info))
+		if info.ModTime().After(modified) {
+			modified = info.ModTime()
+		}
+
+		rel := strings.TrimPrefix(p, base+"/")
+		if base == "." {
+			rel = p
+		}
+		if d.IsDir() && depth > 0 && strings.Count(rel, "/")+1 >= depth {
+			return fs.SkipDir
+		}
+		return nil
+	})
+	if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		s.writeFSError(w, r, err, "Could not read directory")
+		return
+	}
+
+	sign := 1
+	if order == "desc" {
+		sign = -1
+	}
+	slices.SortFunc(files, func(a, b FileInfo) int { return sign * compareFiles(sortBy, &a, &b) })
+	total := len(files)
+	if cursor != nil {
+		last := FileInfo{Name: cursor.Name, Path: cursor.Path, Size: cursor.Size, Modified: time.Unix(0, cursor.Modified)}
+		start := len(files)
+		for i := range files {
+			if sign*compareFiles(sortBy, &files[i], &last) > 0 {
+				start = i
+				break
+			}
+		}
+		files = files[start:]
+	}
+	if len(files) > limit {
+		files = files[:limit]
+		w.Header().Set("X-Next-Cursor", encodeListCursor(sortBy, order, files[limit-1]))
+	}
+	w.Header().Set("X-Total-Count", strconv.Itoa(total))
+	body, err := json.Marshal(files)
+	if err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, apitypes.CodeInternal, "Could not encode listing", nil)
+		return
+	}
+	if notModified(w, r, contentETag(strconv.AppendInt(body, int64(total), 10)), modified) {
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, files)
+}
+
+// skipEntry leaves an entry out of a walk. fs.SkipDir may only be returned
+// for directories: for a file it would skip the rest of its parent.
+func skipEntry(d fs.DirEntry) error {
+	if d.IsDir() {
+		return fs.SkipDir
+	}
+	return nil
+}
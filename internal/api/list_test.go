@@ -0,0 +1,115 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestListPages checks that following X-Next-Cursor visits every entry once,
+// in the requested order, and that bad paging parameters are refused.
+func TestListPages(t *testing.T) {
+	newListServer := func(t *testing.T) *Server {
+		t.Helper()
+		s, base := newTestServer(t)
+		for i, name := range []string{"c.txt", "a.txt", "b.txt"} {
+			content := make([]byte, 10*(i+1))
+			if err := os.WriteFile(filepath.Join(base, "data", "sub", name), content, 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s
+	}
+
+	for _, tt := range []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"name", "path=sub", []string{"sub/a.txt", "sub/b.txt", "sub/c.txt", "sub/ok.txt"}},
+		{"name desc", "path=sub&order=desc", []string{"sub/ok.txt", "sub/c.txt", "sub/b.txt", "sub/a.txt"}},
+		{"size", "path=sub&sort=size", []string{"sub/ok.txt", "sub/c.txt", "sub/a.txt", "sub/b.txt"}},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			h := testHandler(newListServer(t))
+			var got []string
+			cursor := ""
+			for pages := 0; ; pages++ {
+				if pages > len(tt.want) {
+					t.Fatalf("still paging after %d pages, got %q", pages, got)
+				}
+				target := "/api/files?limit=3&" + tt.query
+				if cursor != "" {
+					target += "&cursor=" + url.QueryEscape(cursor)
+				}
+				rr := httptest.NewRecorder()
+				h.ServeHTTP(rr, httptest.NewRequest("GET", target, nil))
+				if rr.Code != http.StatusOK {
+					t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+				}
+				if total := rr.Header().Get("X-Total-Count"); total != "4" {
+					t.Errorf("got X-Total-Count %q, want 4", total)
+				}
+				var files []FileInfo
+				if err := json.Unmarshal(rr.Body.Bytes(), &files); err != nil {
+					t.Fatal(err)
+				}
+				for _, f := range files {
+					got = append(got, f.Path)
+				}
+				if cursor = rr.Header().Get("X-Next-Cursor"); cursor == "" {
+					break
+				}
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q, want %q", got, tt.want)
+			}
+		})
+	}
+
+	// A cursor is only valid for the sort order it was issued for.
+	s := newListServer(t)
+	rr := httptest.NewRecorder()
+	testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files?path=sub&limit=1", nil))
+	nameCursor := rr.Header().Get("X-Next-Cursor")
+	if nameCursor == "" {
+		t.Fatal("no X-Next-Cursor on a partial page")
+	}
+
+	for _, tt := range []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"malformed cursor", "path=sub&cursor=%21%21", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"cursor for another order", "path=sub&sort=size&cursor=" + url.QueryEscape(nameCursor), http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"limit zero", "path=sub&limit=0", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"limit too large", "path=sub&limit=10001", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"invalid sort", "path=sub&sort=owner", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"invalid order", "path=sub&order=up", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"escape", "path=outdir", http.StatusBadRequest, apitypes.CodeInvalidPath},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files?"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
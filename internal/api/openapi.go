@@ -0,0 +1,448 @@
+package api
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// schema is a JSON Schema object as embedded in the OpenAPI document.
+type schema map[string]any
+
+func ref(name string) schema {
+	return schema{"$ref": "#/components/schemas/" + name}
+}
+
+func arrayOf(items schema) schema {
+	return schema{"type": "array", "items": items}
+}
+
+// param documents a single request parameter.
+type param struct {
+	Name        string
+	In          string
+	Type        string
+	Format      string
+	Description string
+	Required    bool
+}
+
+// media is a request or response body of a given content type.
+type media struct {
+	ContentType string
+	Schema      schema
+}
+
+// operation is the documentation attached to a route.
+type operation struct {
+	ID       string
+	Summary  string
+	Params   []param
+	Body     *media
+	Status   int // success status code, defaults to 200
+	Response *media
+}
+
+// componentSchemas are the named schemas referenced by operations.
+var componentSchemas = map[string]schema{
+	"FileInfo": {
+		"type":     "object",
+		"required": []string{"name", "size", "is_dir", "modified"},
+		"properties": schema{
+			"name":           schema{"type": "string"},
+			"path":           schema{"type": "string", "description": "Path relative to the data root."},
+			"size":           schema{"type": "integer", "format": "int64"},
+			"is_dir":         schema{"type": "boolean"},
+			"modified":       schema{"type": "string", "format": "date-time"},
+			"mode":           schema{"type": "string", "description": "Permissions in ls notation, e.g. -rw-r--r--."},
+			"symlink_target": schema{"type": "string"},
+			"uid":            schema{"type": "integer", "format": "int64"},
+			"gid":            schema{"type": "integer", "format": "int64"},
+			"mime_type":      schema{"type": "string", "description": "Guessed from the file extension."},
+		},
+	},
+	"SearchResult": {
+		"allOf": []schema{
+			ref("FileInfo"),
+			{"type": "object", "properties": schema{"matches": arrayOf(ref("SearchMatch"))}},
+		},
+	},
+	"SearchMatch": {
+		"type":     "object",
+		"required": []string{"line", "text"},
+		"properties": schema{
+			"line":   schema{"type": "integer"},
+			"text":   schema{"type": "string"},
+			"before": arrayOf(schema{"type": "string"}),
+			"after":  arrayOf(schema{"type": "string"}),
+		},
+	},
+	"SearchResponse": {
+		"type":     "object",
+		"required": []string{"results", "truncated"},
+		"properties": schema{
+			"results":   arrayOf(ref("SearchResult")),
+			"truncated": schema{"type": "boolean", "description": "More entries matched than max_results allowed."},
+		},
+	},
+	"DiskUsage": {
+		"type":     "object",
+		"required": []string{"path", "size", "files", "scanned_at", "children", "truncated"},
+		"properties": schema{
+			"path":       schema{"type": "string"},
+			"size":       schema{"type": "integer", "format": "int64"},
+			"files":      schema{"type": "integer"},
+			"scanned_at": schema{"type": "string", "format": "date-time"},
+			"children":   arrayOf(ref("UsageEntry")),
+			"truncated":  schema{"type": "boolean"},
+			"filesystem": schema{
+				"type": "object",
+				"properties": schema{
+					"total":     schema{"type": "integer", "format": "int64"},
+					"free":      schema{"type": "integer", "format": "int64"},
+					"available": schema{"type": "integer", "format": "int64"},
+				},
+			},
+			"quota": schema{
+				"type": "object",
+				"properties": schema{
+					"limit":     schema{"type": "integer", "format": "int64"},
+					"used":      schema{"type": "integer", "format": "int64"},
+					"available": schema{"type": "integer", "format": "int64"},
+				},
+			},
+		},
+	},
+	"UsageEntry": {
+		"type":     "object",
+		"required": []string{"name", "path", "size", "is_dir"},
+		"properties": schema{
+			"name":   schema{"type": "string"},
+			"path":   schema{"type": "string"},
+			"size":   schema{"type": "integer", "format": "int64"},
+			"files":  schema{"type": "integer"},
+			"is_dir": schema{"type": "boolean"},
+		},
+	},
+	"PathRequest": {
+		"type":       "object",
+		"required":   []string{"path"},
+		"properties": schema{"path": schema{"type": "string"}},
+	},
+	"DeleteRequest": {
+		"type":     "object",
+		"required": []string{"path"},
+		"properties": schema{
+			"path":      schema{"type": "string"},
+			"permanent": schema{"type": "boolean", "description": "Delete immediately instead of moving to the trash."},
+		},
+	},
+	"TrashItem": {
+		"type":     "object",
+		"required": []string{"id", "path", "is_dir", "size", "deleted_by", "deleted_at", "expires_at"},
+		"properties": schema{
+			"id":         schema{"type": "string"},
+			"path":       schema{"type": "string", "description": "Where the item was deleted from."},
+			"is_dir":     schema{"type": "boolean"},
+			"size":       schema{"type": "integer", "format": "int64"},
+			"files":      schema{"type": "integer"},
+			"deleted_by": schema{"type": "string"},
+			"deleted_at": schema{"type": "string", "format": "date-time"},
+			"expires_at": schema{"type": "string", "format": "date-time"},
+		},
+	},
+	"FileEvent": {
+		"type":     "object",
+		"required": []string{"op", "is_dir", "time"},
+		"properties": schema{
+			"op":     schema{"type": "string", "enum": []string{"create", "modify", "delete", "rename", "overflow"}},
+			"path":   schema{"type": "string", "description": "Relative to the data root; for rename, the old path."},
+			"is_dir": schema{"type": "boolean"},
+			"time":   schema{"type": "string", "format": "date-time"},
+		},
+	},
+	"Backup": {
+		"type":     "object",
+		"required": []string{"id", "created_at", "created_by", "format", "size", "files", "sha256"},
+		"properties": schema{
+			"id":         schema{"type": "string"},
+			"created_at": schema{"type": "string", "format": "date-time"},
+			"created_by": schema{"type": "string"},
+			"label":      schema{"type": "string"},
+			"format":     schema{"type": "string", "enum": []string{"tar.gz", "tar.zst"}},
+			"paths":      schema{"type": "array", "items": schema{"type": "string"}, "description": "Paths the snapshot is limited to; the whole data root if absent."},
+			"size":       schema{"type": "integer", "format": "int64", "description": "Size of the archive."},
+			"files":      schema{"type": "integer"},
+			"sha256":     schema{"type": "string", "description": "Checksum of the archive."},
+			"remote_key": schema{"type": "string", "description": "Object key of the archive in remote storage, once uploaded."},
+		},
+	},
+	"BackupRequest": {
+		"type": "object",
+		"properties": schema{
+			"label": schema{"type": "string", "maxLength": maxBackupLabel},
+		},
+	},
+	"BackupSchedule": {
+		"type":     "object",
+		"required": []string{"retention"},
+		"properties": schema{
+			"schedule":       schema{"type": "string", "description": "Cron schedule of snapshots; absent if none is configured."},
+			"retention":      ref("BackupRetention"),
+			"next_run":       schema{"type": "string", "format": "date-time"},
+			"last_run":       schema{"type": "string", "format": "date-time"},
+			"last_result":    schema{"type": "string", "enum": []string{"success", "failure", "skipped"}},
+			"last_backup_id": schema{"type": "string"},
+			"last_error":     schema{"type": "string"},
+			"last_pruned":    schema{"type": "integer", "description": "Snapshots removed by retention after the last run."},
+		},
+	},
+	"BackupRetention": {
+		"type":     "object",
+		"required": []string{"keep_hourly", "keep_daily", "keep_weekly", "max_bytes"},
+		"properties": schema{
+			"keep_hourly": schema{"type": "integer"},
+			"keep_daily":  schema{"type": "integer"},
+			"keep_weekly": schema{"type": "integer"},
+			"max_bytes":   schema{"type": "integer", "format": "int64", "description": "Limit on the total size of all snapshots; 0 if none."},
+		},
+	},
+	"RestoreBackupRequest": {
+		"type": "object",
+		"properties": schema{
+			"drain":                schema{"type": "boolean", "description": "Mark the game server as not accepting players while restoring."},
+			"skip_safety_snapshot": schema{"type": "boolean", "description": "Do not snapshot the current state first."},
+		},
+	},
+	"RestoreRequest": {
+		"type": "object",
+		"properties": schema{
+			"path":      schema{"type": "string", "description": "Restore here instead of the original path."},
+			"overwrite": schema{"type": "boolean"},
+		},
+	},
+	"TransferRequest": {
+		"type":     "object",
+		"required": []string{"source", "destination"},
+		"properties": schema{
+			"source":      schema{"type": "string"},
+			"destination": schema{"type": "string"},
+			"overwrite":   schema{"type": "boolean"},
+		},
+	},
+	"ChmodRequest": {
+		"type":     "object",
+		"required": []string{"path", "mode"},
+		"properties": schema{
+			"path":      schema{"type": "string"},
+			"mode":      schema{"type": "string", "description": "Octal such as 0664, or symbolic as for chmod(1) such as g+rwX,o-rwx."},
+			"recursive": schema{"type": "boolean"},
+		},
+	},
+	"ChownRequest": {
+		"type":     "object",
+		"required": []string{"path"},
+		"properties": schema{
+			"path":      schema{"type": "string"},
+			"uid":       schema{"type": "integer", "description": "Numeric user ID from SIDECAR_CHOWN_UIDS; omit to keep."},
+			"gid":       schema{"type": "integer", "description": "Numeric group ID from SIDECAR_CHOWN_GIDS; omit to keep."},
+			"recursive": schema{"type": "boolean"},
+		},
+	},
+	"TransferProgress": {
+		"type": "object",
+		"properties": schema{
+			"phase":       schema{"type": "string", "description": "Current step of a restore: snapshot, extract or swap."},
+			"bytes_done":  schema{"type": "integer", "format": "int64"},
+			"bytes_total": schema{"type": "integer", "format": "int64"},
+			"files_done":  schema{"type": "integer"},
+			"files_total": schema{"type": "integer"},
+		},
+	},
+	"FileContent": {
+		"type":     "object",
+		"required": []string{"path", "content", "encoding", "size", "modified", "etag"},
+		"properties": schema{
+			"path":     schema{"type": "string"},
+			"content":  schema{"type": "string"},
+			"encoding": schema{"type": "string", "enum": []string{"utf-8", "utf-8-bom", "utf-16le", "utf-16be", "iso-8859-1"}},
+			"size":     schema{"type": "integer", "format": "int64"},
+			"modified": schema{"type": "string", "format": "date-time"},
+			"etag":     schema{"type": "string"},
+		},
+	},
+	"ContentRequest": {
+		"type":     "object",
+		"required": []string{"content"},
+		"properties": schema{
+			"content":  schema{"type": "string"},
+			"encoding": schema{"type": "string", "description": "Encoding to write; defaults to the existing file's encoding or utf-8."},
+		},
+	},
+	"ConfigFile": {
+		"type":     "object",
+		"required": []string{"path", "format", "settings", "etag"},
+		"properties": schema{
+			"path":     schema{"type": "string"},
+			"format":   schema{"type": "string", "enum": []string{"properties", "ini", "json", "yaml", "toml"}},
+			"settings": schema{"type": "object", "description": "Settings in file order; sections and tables are nested objects."},
+			"etag":     schema{"type": "string"},
+		},
+	},
+	"UploadRequest": {
+		"type":     "object",
+		"required": []string{"path", "size"},
+		"properties": schema{
+			"path":      schema{"type": "string"},
+			"size":      schema{"type": "integer", "format": "int64"},
+			"sha256":    schema{"type": "string", "description": "Hex-encoded SHA-256 of the complete file, verified on completion."},
+			"overwrite": schema{"type": "boolean"},
+		},
+	},
+	"UploadSession": {
+		"type":     "object",
+		"required": []string{"id", "path", "size", "offset", "expires_at"},
+		"properties": schema{
+			"id":         schema{"type": "string"},
+			"path":       schema{"type": "string"},
+			"size":       schema{"type": "integer", "format": "int64"},
+			"offset":     schema{"type": "integer", "format": "int64"},
+			"sha256":     schema{"type": "string"},
+			"overwrite":  schema{"type": "boolean"},
+			"expires_at": schema{"type": "string", "format": "date-time"},
+		},
+	},
+	"PathPolicy": {
+		"type":     "object",
+		"required": []string{"read_only", "game_server_running", "rules"},
+		"properties": schema{
+			"read_only":           schema{"type": "boolean", "description": "The whole file API refuses changes."},
+			"game_server_running": schema{"type": "boolean"},
+			"rules": arrayOf(schema{
+				"type":     "object",
+				"required": []string{"pattern", "effect", "active"},
+				"properties": schema{
+					"pattern":       schema{"type": "string"},
+					"effect":        schema{"type": "string", "enum": []string{"hidden", "read_only", "protected"}},
+					"while_running": schema{"type": "boolean", "description": "The rule only applies while the game server is running."},
+					"active":        schema{"type": "boolean"},
+				},
+			}),
+		},
+	},
+	"AuditEvent": {
+		"type":     "object",
+		"required": []string{"time", "action", "actor", "result"},
+		"properties": schema{
+			"time":        schema{"type": "string", "format": "date-time"},
+			"action":      schema{"type": "string"},
+			"actor":       schema{"type": "string"},
+			"remote_addr": schema{"type": "string"},
+			"path":        schema{"type": "string"},
+			"target":      schema{"type": "string"},
+			"bytes":       schema{"type": "integer", "format": "int64"},
+			"result":      schema{"type": "string", "enum": []string{"success", "failure"}},
+			"error":       schema{"type": "string"},
+			"request_id":  schema{"type": "string"},
+		},
+	},
+	"Result": {
+		"type":     "object",
+		"required": []string{"message"},
+		"properties": schema{
+			"message":    schema{"type": "string"},
+			"path":       schema{"type": "string"},
+			"bytes":      schema{"type": "integer", "format": "int64"},
+			"files":      schema{"type": "integer"},
+			"sha256":     schema{"type": "string", "description": "Hex-encoded SHA-256 of the written file."},
+			"md5":        schema{"type": "string", "description": "Hex-encoded MD5 of the written file."},
+			"etag":       schema{"type": "string"},
+			"trash_id":   schema{"type": "string", "description": "ID of the trash item a deleted item was moved to."},
+			"backup_id":  schema{"type": "string", "description": "ID of the safety snapshot taken before a restore."},
+			"request_id": schema{"type": "string"},
+		},
+	},
+	"ErrorResponse": {
+		"type":     "object",
+		"required": []string{"code", "message"},
+		"properties": schema{
+			"code":       schema{"type": "string", "description": "Stable machine-readable error code, e.g. not_found or no_space."},
+			"message":    schema{"type": "string"},
+			"details":    schema{"type": "object", "additionalProperties": true},
+			"request_id": schema{"type": "string"},
+		},
+	},
+}
+
+// buildOpenAPI renders the OpenAPI 3 document for the given routes.
+func buildOpenAPI(routes []route) map[string]any {
+	paths := map[string]map[string]any{}
+	for _, rt := range routes {
+		op := rt.Doc
+		item, ok := paths[rt.Path]
+		if !ok {
+			item = map[string]any{}
+			paths[rt.Path] = item
+		}
+
+		status := op.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		success := map[string]any{"description": http.StatusText(status)}
+		if op.Response != nil {
+			success["content"] = map[string]any{op.Response.ContentType: map[string]any{"schema": op.Response.Schema}}
+		}
+
+		doc := map[string]any{
+			"operationId": op.ID,
+			"summary":     op.Summary,
+			"responses": map[string]any{
+				strconv.Itoa(status): success,
+				"default": map[string]any{
+					"description": "Error",
+					"content":     map[string]any{"application/json": map[string]any{"schema": ref("ErrorResponse")}},
+				},
+			},
+		}
+		if len(op.Params) > 0 {
+			var params []map[string]any
+			for _, p := range op.Params {
+				s := schema{"type": p.Type}
+				if p.Format != "" {
+					s["format"] = p.Format
+				}
+				params = append(params, map[string]any{
+					"name":        p.Name,
+					"in":          p.In,
+					"required":    p.Required || p.In == "path",
+					"description": p.Description,
+					"schema":      s,
+				})
+			}
+			doc["parameters"] = params
+		}
+		if op.Body != nil {
+			doc["requestBody"] = map[string]any{
+				"required": true,
+				"content":  map[string]any{op.Body.ContentType: map[string]any{"schema": op.Body.Schema}},
+			}
+		}
+		item[strings.ToLower(rt.Method)] = doc
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":   "Agnostic Agones Sidecar API",
+			"version": "1.0.0",
+		},
+		"paths":      paths,
+		"components": map[string]any{"schemas": componentSchemas},
+	}
+}
+
+// openAPIHandler serves the OpenAPI document describing every registered route.
+func (s *Server) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	s.writeJSON(w, r, http.StatusOK, buildOpenAPI(s.routes()))
+}
@@ -0,0 +1,223 @@
+package api
+
+import (
+	"cmp"
+	"encoding/json"
+	"net/http/httptest"
+	"reflect"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/internal/audit"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// openAPIDocument fetches the document the server serves, decoded the way a
+// client would see it.
+func openAPIDocument(t *testing.T, s *Server) map[string]any {
+	t.Helper()
+	rr := httptest.NewRecorder()
+	testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/openapi.json", nil))
+	var doc map[string]any
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatalf("decoding OpenAPI document: %v: %s", err, rr.Body)
+	}
+	return doc
+}
+
+var pathParamPattern = regexp.MustCompile(`\{(\w+)\}`)
+
+// TestOpenAPIRoutes checks that every route is documented under its path and
+// method, and that the document lists nothing that is not served.
+func TestOpenAPIRoutes(t *testing.T) {
+	s, _ := newTestServer(t)
+	paths := openAPIDocument(t, s)["paths"].(map[string]any)
+
+	served := map[string]bool{}
+	ids := map[string]string{}
+	for _, rt := range s.routes() {
+		key := rt.Method + " " + rt.Path
+		if served[key] {
+			t.Errorf("%s: registered twice", key)
+		}
+		served[key] = true
+
+		item, _ := paths[rt.Path].(map[string]any)
+		op, ok := item[strings.ToLower(rt.Method)].(map[string]any)
+		if !ok {
+			t.Errorf("%s: missing from the OpenAPI document", key)
+			continue
+		}
+		id, _ := op["operationId"].(string)
+		if id == "" || op["summary"] == "" {
+			t.Errorf("%s: operation has no ID or summary", key)
+		}
+		if other, ok := ids[id]; ok {
+			t.Errorf("%s: operation ID %q is also used by %s", key, id, other)
+		}
+		ids[id] = key
+
+		var want, got []string
+		for _, m := range pathParamPattern.FindAllStringSubmatch(rt.Path, -1) {
+			want = append(want, m[1])
+		}
+		for _, p := range rt.Doc.Params {
+			if p.In == "path" {
+				got = append(got, p.Name)
+			}
+		}
+		slices.Sort(want)
+		slices.Sort(got)
+		if !slices.Equal(want, got) {
+			t.Errorf("%s: documents path parameters %v, want %v", key, got, want)
+		}
+	}
+
+	for p, item := range paths {
+		for method := range item.(map[string]any) {
+			if key := strings.ToUpper(method) + " " + p; !served[key] {
+				t.Errorf("%s: documented but not served", key)
+			}
+		}
+	}
+}
+
+// schemaTypes maps each component schema to the Go type encoded or decoded
+// for it. Handlers decode PathRequest into an anonymous struct.
+var schemaTypes = map[string]reflect.Type{
+	"FileInfo":       reflect.TypeFor[apitypes.FileInfo](),
+	"SearchResult":   reflect.TypeFor[apitypes.SearchResult](),
+	"SearchMatch":    reflect.TypeFor[apitypes.SearchMatch](),
+	"SearchResponse": reflect.TypeFor[apitypes.SearchResponse](),
+	"DiskUsage":      reflect.TypeFor[apitypes.DiskUsage](),
+	"UsageEntry":     reflect.TypeFor[apitypes.UsageEntry](),
+	"PathRequest": reflect.TypeFor[struct {
+		Path string `json:"path"`
+	}](),
+	"DeleteRequest":        reflect.TypeFor[apitypes.DeleteRequest](),
+	"TrashItem":            reflect.TypeFor[apitypes.TrashItem](),
+	"FileEvent":            reflect.TypeFor[apitypes.FileEvent](),
+	"Backup":               reflect.TypeFor[apitypes.Backup](),
+	"BackupRequest":        reflect.TypeFor[apitypes.BackupRequest](),
+	"BackupSchedule":       reflect.TypeFor[apitypes.BackupSchedule](),
+	"BackupRetention":      reflect.TypeFor[apitypes.BackupRetention](),
+	"RestoreBackupRequest": reflect.TypeFor[apitypes.RestoreBackupRequest](),
+	"RestoreRequest":       reflect.TypeFor[apitypes.RestoreRequest](),
+	"TransferRequest":      reflect.TypeFor[apitypes.TransferRequest](),
+	"ChmodRequest":         reflect.TypeFor[apitypes.ChmodRequest](),
+	"ChownRequest":         reflect.TypeFor[apitypes.ChownRequest](),
+	"TransferProgress":     reflect.TypeFor[apitypes.TransferProgress](),
+	"FileContent":          reflect.TypeFor[apitypes.FileContent](),
+	"ContentRequest":       reflect.TypeFor[apitypes.ContentRequest](),
+	"ConfigFile":           reflect.TypeFor[apitypes.ConfigFile](),
+	"UploadRequest":        reflect.TypeFor[apitypes.UploadRequest](),
+	"UploadSession":        reflect.TypeFor[apitypes.UploadSession](),
+	"PathPolicy":           reflect.TypeFor[apitypes.PathPolicy](),
+	"AuditEvent":           reflect.TypeFor[audit.Event](),
+	"Result":               reflect.TypeFor[apitypes.Result](),
+	"ErrorResponse":        reflect.TypeFor[apitypes.ErrorResponse](),
+}
+
+// TestOpenAPISchemas checks the properties of every component schema against
+// the JSON fields of its Go type, in both directions, including the inline
+// objects nested in them. Required properties must be fields that are always
+// encoded.
+func TestOpenAPISchemas(t *testing.T) {
+	s, _ := newTestServer(t)
+	components := openAPIDocument(t, s)["components"].(map[string]any)["schemas"].(map[string]any)
+	for name, sch := range components {
+		typ, ok := schemaTypes[name]
+		if !ok {
+			t.Errorf("schema %s: no Go type to check it against", name)
+			continue
+		}
+		checkSchema(t, name, components, sch.(map[string]any), typ)
+	}
+	for name := range schemaTypes {
+		if _, ok := components[name]; !ok {
+			t.Errorf("schema %s: missing from the OpenAPI document", name)
+		}
+	}
+}
+
+func checkSchema(t *testing.T, name string, components, sch map[string]any, typ reflect.Type) {
+	t.Helper()
+	for typ.Kind() == reflect.Pointer || typ.Kind() == reflect.Slice {
+		typ = typ.Elem()
+	}
+	props, required := schemaProperties(components, sch)
+	fields := map[string]reflect.StructField{}
+	jsonFields(typ, fields)
+
+	for prop, propSchema := range props {
+		field, ok := fields[prop]
+		if !ok {
+			t.Errorf("schema %s: property %q has no field in %s", name, prop, typ)
+			continue
+		}
+		nested := propSchema.(map[string]any)
+		if items, ok := nested["items"].(map[string]any); ok {
+			nested = items
+		}
+		if _, ok := nested["properties"]; ok {
+			checkSchema(t, name+"."+prop, components, nested, field.Type)
+		}
+	}
+	for tag, field := range fields {
+		if _, ok := props[tag]; !ok {
+			t.Errorf("schema %s: field %s.%s (%q) is not documented", name, typ, field.Name, tag)
+		}
+	}
+	for _, prop := range required {
+		if field, ok := fields[prop]; ok && strings.HasSuffix(field.Tag.Get("json"), ",omitempty") {
+			t.Errorf("schema %s: required property %q is omitted when empty", name, prop)
+		}
+	}
+}
+
+// schemaProperties returns the properties and required names of sch, merging
+// in those of the schemas it combines with allOf.
+func schemaProperties(components, sch map[string]any) (map[string]any, []string) {
+	props := map[string]any{}
+	var required []string
+	if r, ok := sch["$ref"].(string); ok {
+		sch = components[strings.TrimPrefix(r, "#/components/schemas/")].(map[string]any)
+	}
+	parts, _ := sch["allOf"].([]any)
+	for _, part := range parts {
+		p, r := schemaProperties(components, part.(map[string]any))
+		for k, v := range p {
+			props[k] = v
+		}
+		required = append(required, r...)
+	}
+	if p, ok := sch["properties"].(map[string]any); ok {
+		for k, v := range p {
+			props[k] = v
+		}
+	}
+	names, _ := sch["required"].([]any)
+	for _, r := range names {
+		required = append(required, r.(string))
+	}
+	return props, required
+}
+
+// jsonFields collects the fields of struct type typ by their JSON name,
+// flattening embedded structs the way encoding/json does.
+func jsonFields(typ reflect.Type, fields map[string]reflect.StructField) {
+	for _, f := range reflect.VisibleFields(typ) {
+		tag := f.Tag.Get("json")
+		if f.Anonymous && tag == "" {
+			jsonFields(f.Type, fields)
+			continue
+		}
+		if !f.IsExported() || len(f.Index) > 1 || tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+		fields[cmp.Or(name, f.Name)] = f
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !unix
+
+package api
+
+import "io/fs"
+
+// fileOwner reports no owner on platforms without Unix ownership.
+func fileOwner(fs.FileInfo) (uid, gid uint32, ok bool) {
+	return 0, 0, false
+}
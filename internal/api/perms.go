@@ -0,0 +1,225 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/pegnia/sidecar/internal/filemode"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// envID reads a numeric user or group ID from the environment; -1 if unset.
+func envID(key string) (int, error) {
+	v := os.Getenv(key)
+	if v == "" {
+		return -1, nil
+	}
+	id, err := strconv.Atoi(v)
+	if err != nil || id < 0 {
+		return 0, fmt.Errorf("%s: invalid ID %q, expected a non-negative integer", key, v)
+	}
+	return id, nil
+}
+
+// parseIDs parses a comma-separated list of numeric IDs.
+func parseIDs(list string) ([]int, error) {
+	var ids []int
+	for _, v := range strings.Split(list, ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		id, err := strconv.Atoi(v)
+		if err != nil || id < 0 {
+			return nil, fmt.Errorf("invalid ID %q, expected a non-negative integer", v)
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// ownNew gives a file or directory the sidecar just created the configured
+// owner, if there is one.
+func (s *Server) ownNew(name string) error {
+	if s.fileUID < 0 && s.fileGID < 0 {
+		return nil
+	}
+	return s.root.Lchown(name, s.fileUID, s.fileGID)
+}
+
+// setStagedMode prepares the staged file that is about to replace name. If
+// name is an existing file, the staged file takes over its mode and, where
+// the sidecar may set it, its owner; otherwise it gets the configured ones.
+func (s *Server) setStagedMode(staged, name string) error {
+	info, err := s.root.Lstat(name)
+	if err != nil || !info.Mode().IsRegular() {
+		if err := s.root.Chmod(staged, s.fileMode); err != nil {
+			return err
+		}
+		return s.ownNew(staged)
+	}
+	if err := s.root.Chmod(staged, info.Mode().Perm()); err != nil {
+		return err
+	}
+	if uid, gid, ok := fileOwner(info); ok {
+		// Without the right to, the file passes to the sidecar's user, as
+		// it would with any rewrite.
+		s.root.Lchown(staged, int(uid), int(gid))
+	}
+	return nil
+}
+
+// mkdirAll creates name and any missing parents, giving each directory it
+// creates the configured mode and owner.
+func (s *Server) mkdirAll(name string) error {
+	created := ""
+	for p := name; p != "." && p != string(filepath.Separator); p = filepath.Dir(p) {
+		if _, err := s.root.Lstat(p); !errors.Is(err, fs.ErrNotExist) {
+			break
+		}
+		created = p
+	}
+	if err := s.root.MkdirAll(name, s.dirMode); err != nil || created == "" {
+		return err
+	}
+	for p := name; ; p = filepath.Dir(p) {
+		if err := s.root.Chmod(p, s.dirMode); err != nil {
+			return err
+		}
+		if err := s.ownNew(p); err != nil {
+			return err
+		}
+		if p == created {
+			return nil
+		}
+	}
+}
+
+// walkPerms calls fn for name and, if recursive, every entry below it,
+// leaving out hidden paths. It returns how many entries fn was called for.
+func (s *Server) walkPerms(name string, recursive bool, fn func(name string, d fs.DirEntry) error) (int, error) {
+	if !recursive {
+		info, err := s.root.Lstat(name)
+		if err != nil {
+			return 0, err
+		}
+		return 1, fn(name, fs.FileInfoToDirEntry(info))
+	}
+	n := 0
+	err := fs.WalkDir(s.root.FS(), filepath.ToSlash(name), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel := filepath.FromSlash(p)
+		if rel != name && s.hiddenPath(rel) {
+			return skipEntry(d)
+		}
+		n++
+		return fn(rel, d)
+	})
+	return n, err
+}
+
+// chmodHandler changes permissions, given in octal or chmod(1)'s symbolic
+// notation. Recursive changes skip symlinks, whose own mode is meaningless.
+func (s *Server) chmodHandler(w http.ResponseWriter, r *http.Request) {
+	var payload apitypes.ChmodRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+	change, err := filemode.Parse(payload.Mode)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'mode'", map[string]any{"error": err.Error()})
+		return
+	}
+	name, err := s.sanitizePath(payload.Path)
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if !s.authorizePerms(w, r, name, payload.Recursive) {
+		return
+	}
+
+	n, err := s.walkPerms(name, payload.Recursive, func(name string, d fs.DirEntry) error {
+		if payload.Recursive && d.Type()&fs.ModeSymlink != 0 {
+			return nil
+		}
+		info, err := s.root.Stat(name)
+		if err != nil {
+			return err
+		}
+		return s.root.Chmod(name, change.Apply(info.Mode(), info.IsDir()))
+	})
+	s.recordAudit(r, "file.chmod", name, 0, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not change permissions")
+		return
+	}
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Permissions changed successfully", Path: name, Files: n})
+}
+
+// chownHandler changes the numeric owner and group of a file, a symlink
+// itself or a tree, to IDs from the configured allow-lists.
+func (s *Server) chownHandler(w http.ResponseWriter, r *http.Request) {
+	var payload apitypes.ChownRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+	if payload.UID == nil && payload.GID == nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Specify 'uid', 'gid' or both", nil)
+		return
+	}
+	uid, gid := -1, -1
+	if payload.UID != nil {
+		uid = *payload.UID
+		if !slices.Contains(s.chownUIDs, uid) {
+			s.writeError(w, r, http.StatusForbidden, apitypes.CodePermissionDenied, "User ID not allowed", map[string]any{"allowed_uids": s.chownUIDs})
+			return
+		}
+	}
+	if payload.GID != nil {
+		gid = *payload.GID
+		if !slices.Contains(s.chownGIDs, gid) {
+			s.writeError(w, r, http.StatusForbidden, apitypes.CodePermissionDenied, "Group ID not allowed", map[string]any{"allowed_gids": s.chownGIDs})
+			return
+		}
+	}
+	name, err := s.sanitizePath(payload.Path)
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if !s.authorizePerms(w, r, name, payload.Recursive) {
+		return
+	}
+
+	n, err := s.walkPerms(name, payload.Recursive, func(name string, _ fs.DirEntry) error {
+		return s.root.Lchown(name, uid, gid)
+	})
+	s.recordAudit(r, "file.chown", name, 0, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not change owner")
+		return
+	}
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Owner changed successfully", Path: name, Files: n})
+}
+
+// authorizePerms checks a permission or owner change against the path
+// policy, for the whole tree if it is recursive.
+func (s *Server) authorizePerms(w http.ResponseWriter, r *http.Request, name string, recursive bool) bool {
+	if !recursive {
+		return s.authorize(w, r, name, policy.Write)
+	}
+	return s.enforce(w, r, name, policy.Write, s.checkPolicyTree(name, policy.Write))
+}
@@ -0,0 +1,137 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestChmod checks octal and symbolic modes, that a recursive change does not
+// follow links out of the data root, and that bad requests are refused.
+func TestChmod(t *testing.T) {
+	for _, tt := range []struct {
+		name string
+		body string
+		file string
+		want os.FileMode
+	}{
+		{"octal", `{"path":"sub/ok.txt","mode":"600"}`, "sub/ok.txt", 0o600},
+		{"symbolic", `{"path":"sub/ok.txt","mode":"g+w,o-r"}`, "sub/ok.txt", 0o660},
+		{"recursive", `{"path":".","mode":"go-rwx","recursive":true}`, "sub/ok.txt", 0o600},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/chmod", strings.NewReader(tt.body)))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+			}
+			info, err := os.Stat(filepath.Join(base, "data", tt.file))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if info.Mode().Perm() != tt.want {
+				t.Errorf("got mode %v, want %v", info.Mode().Perm(), tt.want)
+			}
+			checkOutsideUntouched(t, base)
+		})
+	}
+
+	for _, tt := range []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid mode", `{"path":"sub/ok.txt","mode":"u+z"}`, http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"escape", `{"path":"out","mode":"600"}`, http.StatusBadRequest, apitypes.CodeInvalidPath},
+		{"escape through directory", `{"path":"outdir/outside","mode":"600"}`, http.StatusBadRequest, apitypes.CodeInvalidPath},
+		{"missing", `{"path":"sub/missing","mode":"600"}`, http.StatusNotFound, apitypes.CodeNotFound},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/chmod", strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+			checkOutsideUntouched(t, base)
+		})
+	}
+}
+
+// TestChown checks that a tree can be given an allowed owner, and that other
+// IDs and paths out of the data root are refused.
+func TestChown(t *testing.T) {
+	uid, gid := os.Getuid(), os.Getgid()
+	t.Setenv("SIDECAR_CHOWN_UIDS", strconv.Itoa(uid))
+	t.Setenv("SIDECAR_CHOWN_GIDS", strconv.Itoa(gid))
+
+	t.Run("success", func(t *testing.T) {
+		s, base := newTestServer(t)
+		body := `{"path":".","uid":` + strconv.Itoa(uid) + `,"gid":` + strconv.Itoa(gid) + `,"recursive":true}`
+		rr := httptest.NewRecorder()
+		testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/chown", strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+		}
+		var resp apitypes.Result
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if resp.Files == 0 {
+			t.Errorf("got %+v, want the number of entries changed", resp)
+		}
+		info, err := os.Lstat(filepath.Join(base, "data", "sub", "ok.txt"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if u, g, ok := fileOwner(info); !ok || int(u) != uid || int(g) != gid {
+			t.Errorf("got owner %d:%d, want %d:%d", u, g, uid, gid)
+		}
+		checkOutsideUntouched(t, base)
+	})
+
+	for _, tt := range []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{"no ids", `{"path":"sub/ok.txt"}`, http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"uid not allowed", `{"path":"sub/ok.txt","uid":` + strconv.Itoa(uid+1) + `}`, http.StatusForbidden, apitypes.CodePermissionDenied},
+		{"gid not allowed", `{"path":"sub/ok.txt","gid":` + strconv.Itoa(gid+1) + `}`, http.StatusForbidden, apitypes.CodePermissionDenied},
+		{"escape", `{"path":"outdir/outside","uid":` + strconv.Itoa(uid) + `}`, http.StatusBadRequest, apitypes.CodeInvalidPath},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/chown", strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+			checkOutsideUntouched(t, base)
+		})
+	}
+}
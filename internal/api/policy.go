@@ -0,0 +1,57 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// runningStates are the GameServer states in which the game server process
+// is up, and WhileRunning policy rules apply.
+var runningStates = map[string]bool{"Ready": true, "Allocated": true, "Reserved": true}
+
+// SetGameServerState tells the server about the current Agones GameServer
+// state, which switches rules that only apply while the server is running.
+func (s *Server) SetGameServerState(state string) {
+	running := runningStates[state]
+	if running != s.policy.Running() {
+		s.logger.Info("Game server running state changed, updating path policy", "state", state, "running", running)
+	}
+	s.policy.SetRunning(running)
+}
+
+// authorize checks op on the root-relative name against the path policy. If
+// it is forbidden, it writes a 403 naming the rule and returns false.
+func (s *Server) authorize(w http.ResponseWriter, r *http.Request, name string, op policy.Op) bool {
+	return s.enforce(w, r, name, op, s.checkPolicy(name, op))
+}
+
+// enforce is authorize for a policy check the caller made itself.
+func (s *Server) enforce(w http.ResponseWriter, r *http.Request, name string, op policy.Op, err error) bool {
+	if err == nil {
+		return true
+	}
+	s.log(r).Warn("Request denied by path policy", "path", name, "operation", op.String(), "error", err)
+	s.writeFSError(w, r, err, "Operation not allowed by path policy")
+	return false
+}
+
+// policyHandler returns the path policy, so clients can tell in advance which
+// paths they may change.
+func (s *Server) policyHandler(w http.ResponseWriter, r *http.Request) {
+	resp := apitypes.PathPolicy{
+		ReadOnly:          s.policy.ReadOnly(),
+		GameServerRunning: s.policy.Running(),
+		Rules:             []apitypes.PolicyRule{},
+	}
+	for _, rule := range s.policy.AllRules() {
+		resp.Rules = append(resp.Rules, apitypes.PolicyRule{
+			Pattern:      rule.Pattern,
+			Effect:       string(rule.Effect),
+			WhileRunning: rule.WhileRunning,
+			Active:       !rule.WhileRunning || s.policy.Running(),
+		})
+	}
+	s.writeJSON(w, r, http.StatusOK, resp)
+}
@@ -0,0 +1,301 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/backup"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/s3"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// defaultRemotePrefix keeps the snapshots of each game server apart, grouped
+// by fleet.
+const defaultRemotePrefix = "{fleet}/{gameserver}"
+
+// parseBackupRemote configures uploads of snapshots to S3-compatible storage,
+// which is enabled by naming a bucket. Credentials fall back to the standard
+// AWS variables.
+func parseBackupRemote() (*s3.Client, string, error) {
+	bucket := os.Getenv("SIDECAR_BACKUP_S3_BUCKET")
+	if bucket == "" {
+		return nil, "", nil
+	}
+	cfg := s3.Config{
+		Endpoint:        os.Getenv("SIDECAR_BACKUP_S3_ENDPOINT"),
+		Region:          cmp.Or(os.Getenv("SIDECAR_BACKUP_S3_REGION"), os.Getenv("AWS_REGION")),
+		Bucket:          bucket,
+		AccessKeyID:     cmp.Or(os.Getenv("SIDECAR_BACKUP_S3_ACCESS_KEY_ID"), os.Getenv("AWS_ACCESS_KEY_ID")),
+		SecretAccessKey: cmp.Or(os.Getenv("SIDECAR_BACKUP_S3_SECRET_ACCESS_KEY"), os.Getenv("AWS_SECRET_ACCESS_KEY")),
+		SessionToken:    cmp.Or(os.Getenv("SIDECAR_BACKUP_S3_SESSION_TOKEN"), os.Getenv("AWS_SESSION_TOKEN")),
+		SSE:             os.Getenv("SIDECAR_BACKUP_S3_SSE"),
+		KMSKeyID:        os.Getenv("SIDECAR_BACKUP_S3_SSE_KMS_KEY_ID"),
+		CustomerKey:     os.Getenv("SIDECAR_BACKUP_S3_SSE_CUSTOMER_KEY"),
+	}
+	// Services other than AWS mostly only support path-style addressing.
+	cfg.PathStyle = cfg.Endpoint != ""
+	if v := os.Getenv("SIDECAR_BACKUP_S3_PATH_STYLE"); v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, "", fmt.Errorf("SIDECAR_BACKUP_S3_PATH_STYLE: invalid boolean %q", v)
+		}
+		cfg.PathStyle = b
+	}
+	if v := os.Getenv("SIDECAR_BACKUP_S3_PART_SIZE"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return nil, "", fmt.Errorf("SIDECAR_BACKUP_S3_PART_SIZE: invalid size %q", v)
+		}
+		cfg.PartSize = n
+	}
+	client, err := s3.New(cfg)
+	if err != nil {
+		return nil, "", fmt.Errorf("SIDECAR_BACKUP_S3: %w", err)
+	}
+
+	prefix := os.Getenv("SIDECAR_BACKUP_S3_PREFIX")
+	if prefix == "" {
+		prefix = defaultRemotePrefix
+	}
+	rest := strings.NewReplacer("{fleet}", "", "{gameserver}", "").Replace(prefix)
+	if strings.ContainsAny(rest, "{}") {
+		return nil, "", fmt.Errorf("SIDECAR_BACKUP_S3_PREFIX: %q has placeholders other than {fleet} and {gameserver}", prefix)
+	}
+	return client, prefix, nil
+}
+
+// SetGameServer tells the server the name and fleet of its GameServer, which
+// the key prefix of remote snapshots may contain. It must be called before
+// Run. Without it, the host name stands in for the GameServer name, which is
+// the same for Agones pods.
+func (s *Server) SetGameServer(name, fleet string) {
+	s.gameServerName, s.fleetName = name, fleet
+}
+
+// remoteKeyPrefix returns the key prefix of the remote snapshots of the game
+// server named gameServer, ending in a slash unless empty. Segments left
+// empty, such as the fleet of a GameServer that is not in one, are dropped.
+func (s *Server) remoteKeyPrefix(gameServer string) string {
+	expanded := strings.NewReplacer("{fleet}", s.fleetName, "{gameserver}", gameServer).Replace(s.remotePrefix)
+	segments := slices.DeleteFunc(strings.Split(expanded, "/"), func(seg string) bool { return seg == "" })
+	if len(segments) == 0 {
+		return ""
+	}
+	return strings.Join(segments, "/") + "/"
+}
+
+// uploadBackup uploads the archive of snap, then its metadata, so a snapshot
+// is only listed remotely once it is complete. snap.RemoteKey is set, and
+// saved locally as well, once the upload succeeded.
+func (s *Server) uploadBackup(ctx context.Context, snap *backup.Snapshot, progress *transferProgress) error {
+	f, _, err := s.backups.Open(snap.ID)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	start := time.Now()
+	prefix := s.remoteKeyPrefix(s.gameServerName)
+	key := prefix + snap.ID + snap.Format.Extension()
+	progress.startPhase("upload")
+	progress.BytesTotal, progress.FilesTotal = snap.Size, 1
+	if err := s.remote.Upload(ctx, key, io.TeeReader(f, progress), snap.Format.ContentType()); err != nil {
+		return err
+	}
+	progress.add(0, 1)
+
+	uploaded := *snap
+	uploaded.RemoteKey = key
+	data, err := json.Marshal(uploaded)
+	if err != nil {
+		return err
+	}
+	if err := s.remote.PutObject(ctx, prefix+snap.ID+".json", data, "application/json"); err != nil {
+		return err
+	}
+	*snap = uploaded
+	if err := s.backups.Update(snap); err != nil {
+		s.logger.Warn("Could not record upload of snapshot", "backup_id", snap.ID, "error", err)
+	}
+	metrics.BackupUploadDuration.Set(time.Since(start).Seconds())
+	return nil
+}
+
+// shipBackup uploads a new snapshot if remote storage is configured. A
+// failed upload is logged and counted, but the local snapshot stands.
+func (s *Server) shipBackup(ctx context.Context, snap *backup.Snapshot, progress *transferProgress) {
+	if s.remote == nil {
+		return
+	}
+	err := s.uploadBackup(ctx, snap, progress)
+	result := "success"
+	if err != nil {
+		result = "failure"
+		s.logger.Error("Could not upload snapshot", "backup_id", snap.ID, "bucket", s.remote.String(), "error", err)
+	} else {
+		s.logger.Info("Uploaded snapshot", "backup_id", snap.ID, "key", snap.RemoteKey, "size", snap.Size)
+	}
+	metrics.BackupUploads.Inc(result)
+}
+
+// remoteBackup returns the metadata of the remote snapshot id under prefix.
+func (s *Server) remoteBackup(ctx context.Context, prefix, id string) (backup.Snapshot, error) {
+	var snap backup.Snapshot
+	if !backup.ValidID(id) {
+		return snap, fmt.Errorf("snapshot %q: %w", id, fs.ErrNotExist)
+	}
+	body, _, err := s.remote.GetObject(ctx, prefix+id+".json")
+	if err != nil {
+		return snap, err
+	}
+	defer body.Close()
+	if err := json.NewDecoder(io.LimitReader(body, 1<<20)).Decode(&snap); err != nil {
+		return snap, fmt.Errorf("reading remote snapshot %q: %w", id, err)
+	}
+	if snap.ID != id || snap.RemoteKey == "" {
+		return snap, fmt.Errorf("remote snapshot %q has invalid metadata", id)
+	}
+	return snap, nil
+}
+
+// listRemoteBackups returns the remote snapshots under prefix, newest first.
+func (s *Server) listRemoteBackups(ctx context.Context, prefix string) ([]backup.Snapshot, error) {
+	objects, err := s.remote.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	snaps := []backup.Snapshot{}
+	for _, obj := range objects {
+		id, ok := strings.CutSuffix(strings.TrimPrefix(obj.Key, prefix), ".json")
+		if !ok || !backup.ValidID(id) {
+			continue
+		}
+		snap, err := s.remoteBackup(ctx, prefix, id)
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		snaps = append(snaps, snap)
+	}
+	slices.SortFunc(snaps, func(a, b backup.Snapshot) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return snaps, nil
+}
+
+// fetchRemoteBackup downloads the archive of a remote snapshot into the
+// snapshot directory, checking it against the recorded checksum.
+func (s *Server) fetchRemoteBackup(ctx context.Context, snap backup.Snapshot, progress *transferProgress) error {
+	progress.startPhase("download")
+	progress.BytesTotal, progress.FilesTotal = snap.Size, 1
+	body, _, err := s.remote.GetObject(ctx, snap.RemoteKey)
+	if err != nil {
+		return err
+	}
+	defer body.Close()
+	if err := s.backups.Import(snap, io.TeeReader(body, progress)); err != nil {
+		return err
+	}
+	progress.add(0, 1)
+	s.updateBackupMetrics()
+	return nil
+}
+
+// remoteEnabled writes an error response if remote storage is not configured.
+func (s *Server) remoteEnabled(w http.ResponseWriter, r *http.Request) bool {
+	if !s.backupsEnabled(w, r) {
+		return false
+	}
+	if s.remote == nil {
+		s.writeError(w, r, http.StatusServiceUnavailable, apitypes.CodeNotConfigured, "Remote backups are not configured, set SIDECAR_BACKUP_S3_BUCKET", nil)
+		return false
+	}
+	return true
+}
+
+// remotePrefixParam returns the key prefix for the game server named by the
+// gameserver query parameter, which defaults to this one. Naming another
+// lets a replacement GameServer find the snapshots of the one before it.
+func (s *Server) remotePrefixParam(w http.ResponseWriter, r *http.Request) (string, bool) {
+	name := r.URL.Query().Get("gameserver")
+	if name == "" {
+		return s.remoteKeyPrefix(s.gameServerName), true
+	}
+	if strings.ContainsAny(name, "/\\{}") || path.Clean(name) != name || name == ".." {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid game server name", nil)
+		return "", false
+	}
+	return s.remoteKeyPrefix(name), true
+}
+
+// listRemoteBackupsHandler returns the snapshots in remote storage, newest first.
+func (s *Server) listRemoteBackupsHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.remoteEnabled(w, r) {
+		return
+	}
+	prefix, ok := s.remotePrefixParam(w, r)
+	if !ok {
+		return
+	}
+	snaps, err := s.listRemoteBackups(r.Context(), prefix)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not list remote snapshots")
+		return
+	}
+	infos := make([]apitypes.Backup, len(snaps))
+	for i := range snaps {
+		infos[i] = backupInfo(&snaps[i])
+	}
+	s.writeJSON(w, r, http.StatusOK, infos)
+}
+
+// restoreRemoteBackupHandler restores a snapshot from remote storage,
+// downloading it into the snapshot directory first unless it is there
+// already. Otherwise it works like restoreBackupHandler.
+func (s *Server) restoreRemoteBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.remoteEnabled(w, r) {
+		return
+	}
+	prefix, ok := s.remotePrefixParam(w, r)
+	if !ok {
+		return
+	}
+	payload, ok := s.decodeRestoreRequest(w, r)
+	if !ok {
+		return
+	}
+	if !s.backupMu.TryLock() {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "A backup operation is already running", nil)
+		return
+	}
+	defer s.backupMu.Unlock()
+
+	id := r.PathValue("id")
+	if snap, err := s.backups.Get(id); err == nil {
+		s.runRestore(w, r, snap, payload, nil)
+		return
+	}
+	snap, err := s.remoteBackup(r.Context(), prefix, id)
+	if err != nil {
+		s.writeBackupError(w, r, err)
+		return
+	}
+	s.runRestore(w, r, snap, payload, func(ctx context.Context, progress *transferProgress) error {
+		return s.fetchRemoteBackup(ctx, snap, progress)
+	})
+}
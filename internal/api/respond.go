@@ -0,0 +1,218 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pegnia/sidecar/internal/backup"
+	"github.com/pegnia/sidecar/internal/fsroot"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/s3"
+	"github.com/pegnia/sidecar/internal/uploadpolicy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// requestIDHeader carries the request ID in both directions.
+const requestIDHeader = "X-Request-ID"
+
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	loggerKey
+)
+
+// newRequestID returns a random 128-bit hex identifier.
+func newRequestID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	return hex.EncodeToString(b[:])
+}
+
+// validRequestID reports whether a client-supplied request ID is safe to echo and log.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > 128 {
+		return false
+	}
+	for _, c := range id {
+		if c < 0x21 || c > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// withRequestID stores the request ID and a logger tagged with it in the request context.
+func (s *Server) withRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDKey, id)
+	ctx = context.WithValue(ctx, loggerKey, s.logger.With("request_id", id))
+	return r.WithContext(ctx)
+}
+
+// requestID returns the ID assigned to r by loggingMiddleware.
+func requestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDKey).(string)
+	return id
+}
+
+// log returns the logger for r, tagged with its request ID.
+func (s *Server) log(r *http.Request) *slog.Logger {
+	if logger, ok := r.Context().Value(loggerKey).(*slog.Logger); ok {
+		return logger
+	}
+	return s.logger
+}
+
+// writeJSON encodes v as the response body with the given status code.
+func (s *Server) writeJSON(w http.ResponseWriter, r *http.Request, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		s.log(r).Error("Failed to encode JSON response", "error", err)
+	}
+}
+
+// writeResult replies to a successful mutation.
+func (s *Server) writeResult(w http.ResponseWriter, r *http.Request, status int, result apitypes.Result) {
+	result.RequestID = requestID(r)
+	s.writeJSON(w, r, status, result)
+}
+
+// writeError replies with the JSON error envelope.
+func (s *Server) writeError(w http.ResponseWriter, r *http.Request, status int, code, message string, details map[string]any) {
+	s.writeJSON(w, r, status, apitypes.ErrorResponse{
+		Code:      code,
+		Message:   message,
+		Details:   details,
+		RequestID: requestID(r),
+	})
+}
+
+// writeFSError maps a filesystem error to a stable error code and status.
+// message describes what failed from the client's point of view; the
+// underlying error is only included for errors the client can act on.
+func (s *Server) writeFSError(w http.ResponseWriter, r *http.Request, err error, message string) {
+	status, code := fsErrorStatus(err)
+	var details map[string]any
+	if status == http.StatusInternalServerError {
+		s.log(r).Error(message, "error", err)
+	} else {
+		details = errorDetails(err)
+	}
+	s.writeError(w, r, status, code, message, details)
+}
+
+// errorDetails returns the details of an error response for err.
+func errorDetails(err error) map[string]any {
+	var details map[string]any
+	var sumErr *checksumError
+	var quotaErr *quotaError
+	var violation *policy.Violation
+	var rejection *uploadpolicy.Rejection
+	var hookErr *backup.HookError
+	var remoteErr *s3.Error
+	switch {
+	case errors.As(err, &rejection):
+		details = map[string]any{"error": rejection.Error(), "reason": rejection.Reason}
+		switch rejection.Reason {
+		case uploadpolicy.ReasonExtension:
+			details["extension"] = rejection.Extension
+		case uploadpolicy.ReasonType:
+			details["type"] = rejection.Type
+		case uploadpolicy.ReasonSize:
+			details["pattern"], details["limit"], details["size"] = rejection.Pattern, rejection.Limit, rejection.Size
+		case uploadpolicy.ReasonScan:
+			details["output"] = rejection.Output
+		}
+	case errors.As(err, &violation):
+		details = map[string]any{"error": violation.Error(), "path": filepath.ToSlash(violation.Path), "operation": violation.Op.String()}
+		if violation.Rule != nil {
+			details["pattern"], details["effect"] = violation.Rule.Pattern, violation.Rule.Effect
+		} else {
+			details["effect"] = policy.ReadOnly
+		}
+	case errors.As(err, &sumErr):
+		details = map[string]any{"algorithm": sumErr.Algorithm, "expected": sumErr.Expected, "actual": sumErr.Actual}
+	case errors.As(err, &quotaErr):
+		details = map[string]any{"error": quotaErr.Error(), "quota": quotaErr.Limit, "used": quotaErr.Used, "requested": quotaErr.Requested}
+	case errors.As(err, &hookErr):
+		details = map[string]any{"error": hookErr.Error(), "hook": hookErr.Hook, "output": hookErr.Output}
+	case errors.As(err, &remoteErr):
+		details = map[string]any{"error": remoteErr.Error(), "operation": remoteErr.Op}
+		if remoteErr.StatusCode != 0 {
+			details["status"], details["code"], details["request_id"] = remoteErr.StatusCode, remoteErr.Code, remoteErr.RequestID
+		}
+	default:
+		details = map[string]any{"error": errorReason(err)}
+	}
+	return details
+}
+
+// fsErrorStatus returns the HTTP status and error code for a filesystem error.
+func fsErrorStatus(err error) (int, string) {
+	status, code := http.StatusInternalServerError, apitypes.CodeInternal
+	var sumErr *checksumError
+	var violation *policy.Violation
+	var rejection *uploadpolicy.Rejection
+	var hookErr *backup.HookError
+	var remoteErr *s3.Error
+	switch {
+	case errors.As(err, &hookErr):
+		status, code = http.StatusBadGateway, apitypes.CodeHookFailed
+	case errors.As(err, &remoteErr) && !errors.Is(err, fs.ErrNotExist):
+		status, code = http.StatusBadGateway, apitypes.CodeRemoteFailed
+	case errors.Is(err, backup.ErrChecksum):
+		status, code = http.StatusUnprocessableEntity, apitypes.CodeChecksumMismatch
+	case errors.As(err, &sumErr):
+		status, code = http.StatusUnprocessableEntity, apitypes.CodeChecksumMismatch
+	case errors.As(err, &rejection):
+		switch rejection.Reason {
+		case uploadpolicy.ReasonExtension:
+			status, code = http.StatusBadRequest, apitypes.CodeBadRequest
+		case uploadpolicy.ReasonSize:
+			status, code = http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge
+		default:
+			status, code = http.StatusUnprocessableEntity, apitypes.CodeContentRejected
+		}
+	case errors.As(err, &violation):
+		status, code = http.StatusForbidden, apitypes.CodePolicyDenied
+	case errors.Is(err, fsroot.ErrEscape):
+		status, code = http.StatusBadRequest, apitypes.CodeInvalidPath
+	case errors.Is(err, fs.ErrNotExist):
+		status, code = http.StatusNotFound, apitypes.CodeNotFound
+	case errors.Is(err, fs.ErrExist):
+		status, code = http.StatusConflict, apitypes.CodeAlreadyExists
+	case errors.Is(err, fs.ErrPermission):
+		status, code = http.StatusForbidden, apitypes.CodePermissionDenied
+	case errors.Is(err, syscall.ENOSPC), errors.Is(err, syscall.EDQUOT):
+		status, code = http.StatusInsufficientStorage, apitypes.CodeNoSpace
+	case errors.Is(err, syscall.ENOTDIR):
+		status, code = http.StatusBadRequest, apitypes.CodeNotDirectory
+	case errors.Is(err, syscall.EISDIR):
+		status, code = http.StatusBadRequest, apitypes.CodeIsDirectory
+	}
+	return status, code
+}
+
+// errorReason strips the operation and path from a *fs.PathError so host paths
+// never leak into responses.
+func errorReason(err error) string {
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) {
+		return pathErr.Err.Error()
+	}
+	var linkErr *os.LinkError
+	if errors.As(err, &linkErr) {
+		return linkErr.Err.Error()
+	}
+	return err.Error()
+}
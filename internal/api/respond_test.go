@@ -0,0 +1,64 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestErrorResponse checks the error envelope, and that the request ID is
+// taken from the client when it is safe to echo and is the same in the
+// response header and body.
+func TestErrorResponse(t *testing.T) {
+	for _, tt := range []struct {
+		name       string
+		target     string
+		id         string
+		wantStatus int
+		wantCode   string
+		wantID     string // empty if a new ID is expected
+	}{
+		{"success", "/api/files?path=sub", "trace-1", http.StatusOK, "", "trace-1"},
+		{"not found", "/api/files?path=missing", "trace-2", http.StatusNotFound, apitypes.CodeNotFound, "trace-2"},
+		{"escape", "/api/files?path=../outside", "", http.StatusBadRequest, apitypes.CodeInvalidPath, ""},
+		{"unsafe id", "/api/files?path=missing", "bad id\x7f", http.StatusNotFound, apitypes.CodeNotFound, ""},
+		{"long id", "/api/files?path=missing", strings.Repeat("x", 129), http.StatusNotFound, apitypes.CodeNotFound, ""},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			req := httptest.NewRequest("GET", tt.target, nil)
+			if tt.id != "" {
+				req.Header.Set(requestIDHeader, tt.id)
+			}
+			rr := httptest.NewRecorder()
+			s.loggingMiddleware(testHandler(s)).ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			id := rr.Header().Get(requestIDHeader)
+			switch {
+			case tt.wantID != "" && id != tt.wantID:
+				t.Errorf("got request ID %q, want %q", id, tt.wantID)
+			case tt.wantID == "" && (len(id) != 32 || id == tt.id):
+				t.Errorf("got request ID %q, want a new one", id)
+			}
+			if tt.wantCode == "" {
+				return
+			}
+			if ct := rr.Header().Get("Content-Type"); !strings.HasPrefix(ct, "application/json") {
+				t.Errorf("got Content-Type %q for an error", ct)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode || resp.Message == "" || resp.RequestID != id {
+				t.Errorf("got error %+v, want code %q and request ID %q", resp, tt.wantCode, id)
+			}
+		})
+	}
+}
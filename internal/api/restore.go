@@ -0,0 +1,328 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+
+	"github.com/pegnia/sidecar/internal/archive"
+	"github.com/pegnia/sidecar/internal/backup"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// restoreDir is where restores are staged: the snapshot is extracted into
+// restoreStaged, and the entries it replaces are moved into restorePrevious.
+// Like trashDir it lives inside the data root, so both moves are renames.
+var (
+	restoreDir      = filepath.Join(internalDir, "restore")
+	restoreStaged   = filepath.Join(restoreDir, "staged")
+	restorePrevious = filepath.Join(restoreDir, "previous")
+)
+
+// SetDrainer sets the function called to mark the game server as no longer
+// accepting players before a restore asking for it, and as accepting them
+// again afterwards. It must be called before Run.
+func (s *Server) SetDrainer(drain func(draining bool) error) {
+	s.drain = drain
+}
+
+// restoreTargets returns the root-relative paths a restore of snap replaces:
+// the paths it was limited to, or else every top-level entry of the data root
+// and of the staged tree that snapshots do not leave out.
+func (s *Server) restoreTargets(snap *backup.Snapshot) ([]string, error) {
+	var targets []string
+	for _, p := range snap.Paths {
+		targets = append(targets, filepath.FromSlash(p))
+	}
+	if len(targets) > 0 {
+		return targets, nil
+	}
+	seen := map[string]bool{}
+	for _, dir := range []string{restoreStaged, "."} {
+		entries, err := s.root.ReadDir(dir)
+		if err != nil {
+			return nil, err
+		}
+		for _, e := range entries {
+			name := e.Name()
+			if seen[name] || s.backupSkipped(name) {
+				continue
+			}
+			seen[name] = true
+			targets = append(targets, name)
+		}
+	}
+	return targets, nil
+}
+
+// stageRestore extracts the archive of snap into restoreStaged.
+func (s *Server) stageRestore(snap *backup.Snapshot, progress *transferProgress) (archive.Stats, error) {
+	f, _, err := s.backups.Open(snap.ID)
+	if err != nil {
+		return archive.Stats{}, err
+	}
+	defer f.Close()
+	if err := s.root.MkdirAll(restoreStaged, 0700); err != nil {
+		return archive.Stats{}, err
+	}
+	progress.BytesTotal, progress.FilesTotal = snap.Size, snap.Files
+	return archive.Extract(io.TeeReader(f, progress), s.root, restoreStaged, snap.Format, archive.ExtractOptions{
+		FileMode: s.fileMode,
+		DirMode:  s.dirMode,
+		Created: func(dst string) error {
+			if info, err := s.root.Lstat(dst); err == nil && info.Mode().IsRegular() {
+				progress.add(0, 1)
+			}
+			return s.ownNew(dst)
+		},
+	})
+}
+
+// swapRestore moves each target aside into restorePrevious and its staged
+// replacement into place. If any rename fails, the targets already swapped
+// are put back, so the data root is either fully restored or unchanged.
+func (s *Server) swapRestore(targets []string, progress *transferProgress) error {
+	type swap struct {
+		target         string
+		hadOld, hasNew bool
+	}
+	var done []swap
+	rollback := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			sw := done[i]
+			if sw.hasNew {
+				s.root.RemoveAll(sw.target)
+			}
+			if sw.hadOld {
+				s.root.Rename(filepath.Join(restorePrevious, sw.target), sw.target)
+			}
+		}
+	}
+	for _, target := range targets {
+		sw := swap{target: target}
+		if _, err := s.root.Lstat(target); err == nil {
+			prev := filepath.Join(restorePrevious, target)
+			err := s.root.MkdirAll(filepath.Dir(prev), 0700)
+			if err == nil {
+				err = s.root.Rename(target, prev)
+			}
+			if err != nil {
+				rollback()
+				return err
+			}
+			sw.hadOld = true
+		} else if !errors.Is(err, fs.ErrNotExist) {
+			rollback()
+			return err
+		}
+		staged := filepath.Join(restoreStaged, target)
+		if _, err := s.root.Lstat(staged); err == nil {
+			err := s.mkdirAll(filepath.Dir(target))
+			if err == nil {
+				err = s.root.Rename(staged, target)
+			}
+			if err != nil {
+				done = append(done, sw)
+				rollback()
+				return err
+			}
+			sw.hasNew = true
+		}
+		done = append(done, sw)
+		s.syncDir(filepath.Dir(target))
+		progress.add(0, 1)
+	}
+	return nil
+}
+
+// keepSkipped moves entries that snapshots leave out, such as hidden and
+// excluded paths, from the replaced tree target into the restored one, where
+// their parent directory still exists.
+func (s *Server) keepSkipped(target string) {
+	prev := filepath.Join(restorePrevious, target)
+	fs.WalkDir(s.root.FS(), filepath.ToSlash(prev), func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(prev, filepath.FromSlash(p))
+		name := filepath.Join(target, rel)
+		if rel == "." || !s.backupSkipped(name) {
+			return nil
+		}
+		if _, err := s.root.Lstat(name); errors.Is(err, fs.ErrNotExist) {
+			if info, err := s.root.Stat(filepath.Dir(name)); err == nil && info.IsDir() {
+				if err := s.root.Rename(filepath.FromSlash(p), name); err != nil {
+					s.logger.Warn("Could not keep entry left out of the snapshot", "path", name, "error", err)
+				}
+			}
+		}
+		return skipEntry(d)
+	})
+}
+
+// restore replaces the paths covered by snap with its contents, taking a
+// safety snapshot first unless asked not to. The archive is extracted before
+// the game server is disturbed; the pre-restore hook then runs right before
+// the swap, and the post-restore hook after it, whenever the pre-restore one
+// was started. The caller must hold backupMu.
+func (s *Server) restore(ctx context.Context, snap *backup.Snapshot, opts apitypes.RestoreBackupRequest, actor string, progress *transferProgress) (safety *backup.Snapshot, stats archive.Stats, err error) {
+	if opts.Drain {
+		if err := s.drain(true); err != nil {
+			return nil, stats, fmt.Errorf("marking the game server as draining: %w", err)
+		}
+		defer func() {
+			if err := s.drain(false); err != nil {
+				s.logger.Error("Could not mark the game server as accepting players again", "error", err)
+			}
+		}()
+	}
+	if !opts.SkipSafetySnapshot {
+		progress.startPhase("snapshot")
+		safety, err = s.snapshot(ctx, actor, "Before restoring "+snap.ID, progress)
+		if err != nil {
+			return nil, stats, fmt.Errorf("taking safety snapshot: %w", err)
+		}
+	}
+
+	// Whatever is left of an earlier restore that was interrupted is stale.
+	if _, err := s.root.Lstat(restoreDir); err == nil {
+		s.logger.Warn("Removing leftovers of an interrupted restore", "path", restoreDir)
+	}
+	if err := s.root.RemoveAll(restoreDir); err != nil {
+		return safety, stats, err
+	}
+	defer s.root.RemoveAll(restoreDir)
+
+	progress.startPhase("extract")
+	if stats, err = s.stageRestore(snap, progress); err != nil {
+		return safety, stats, err
+	}
+	targets, err := s.restoreTargets(snap)
+	if err != nil {
+		return safety, stats, err
+	}
+	for _, target := range targets {
+		if err := s.checkPolicy(target, policy.Delete); err != nil {
+			return safety, stats, err
+		}
+	}
+
+	progress.startPhase("swap")
+	progress.FilesTotal = len(targets)
+	err = backup.RunHook(ctx, "pre-restore", s.restorePreHook, s.backupHookTimeout, snap.ID)
+	if err == nil {
+		if err = s.swapRestore(targets, progress); err == nil {
+			for _, target := range targets {
+				s.keepSkipped(target)
+			}
+		}
+	}
+	if hookErr := backup.RunHook(ctx, "post-restore", s.restorePostHook, s.backupHookTimeout, snap.ID); hookErr != nil {
+		var output string
+		var he *backup.HookError
+		if errors.As(hookErr, &he) {
+			output = he.Output
+		}
+		s.logger.Error("Post-restore command failed", "backup_id", snap.ID, "error", hookErr, "output", output)
+	}
+	s.usage.Refresh()
+	return safety, stats, err
+}
+
+// restoreBackupHandler restores a snapshot over the current data. It runs
+// to completion even if the client disconnects, and streams progress events
+// for each phase to clients accepting text/event-stream.
+func (s *Server) restoreBackupHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.backupsEnabled(w, r) {
+		return
+	}
+	payload, ok := s.decodeRestoreRequest(w, r)
+	if !ok {
+		return
+	}
+	snap, err := s.backups.Get(r.PathValue("id"))
+	if err != nil {
+		s.writeBackupError(w, r, err)
+		return
+	}
+	if !s.backupMu.TryLock() {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "A backup operation is already running", nil)
+		return
+	}
+	defer s.backupMu.Unlock()
+	s.runRestore(w, r, snap, payload, nil)
+}
+
+// decodeRestoreRequest reads the optional body of a restore request.
+func (s *Server) decodeRestoreRequest(w http.ResponseWriter, r *http.Request) (apitypes.RestoreBackupRequest, bool) {
+	var payload apitypes.RestoreBackupRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return payload, false
+	}
+	if payload.Drain && s.drain == nil {
+		s.writeError(w, r, http.StatusServiceUnavailable, apitypes.CodeNotConfigured, "Draining needs the Agones SDK", nil)
+		return payload, false
+	}
+	return payload, true
+}
+
+// runRestore restores snap and writes the response, after calling fetch, if
+// set, to bring the snapshot into the snapshot directory. The caller must
+// hold backupMu.
+func (s *Server) runRestore(w http.ResponseWriter, r *http.Request, snap backup.Snapshot, payload apitypes.RestoreBackupRequest, fetch func(context.Context, *transferProgress) error) {
+	s.log(r).Info("Restoring snapshot", "backup_id", snap.ID, "drain", payload.Drain, "safety_snapshot", !payload.SkipSafetySnapshot, "remote", fetch != nil)
+	progress := newTransferProgress(w, r)
+	ctx := context.WithoutCancel(r.Context())
+	var safety *backup.Snapshot
+	var stats archive.Stats
+	var err error
+	if fetch != nil {
+		err = fetch(ctx, progress)
+	}
+	if err == nil {
+		safety, stats, err = s.restore(ctx, &snap, payload, s.clientIP(r), progress)
+	}
+	s.recordAudit(r, "backup.restore", snap.ID, stats.Bytes, err)
+	result := "success"
+	if err != nil {
+		result = "failure"
+	}
+	metrics.Restores.Inc(result)
+
+	res := apitypes.Result{Message: "Snapshot restored successfully", Bytes: stats.Bytes, Files: stats.Files, RequestID: requestID(r)}
+	if safety != nil {
+		res.BackupID = safety.ID
+	}
+	if !progress.streaming() {
+		if err != nil {
+			s.writeFSError(w, r, err, "Could not restore snapshot")
+			return
+		}
+		s.writeResult(w, r, http.StatusOK, res)
+		return
+	}
+
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if err != nil {
+		s.log(r).Error("Restore failed", "backup_id", snap.ID, "error", err)
+		_, code := fsErrorStatus(err)
+		progress.event("error", apitypes.ErrorResponse{
+			Code:      code,
+			Message:   "Could not restore snapshot",
+			Details:   errorDetails(err),
+			RequestID: requestID(r),
+		})
+		return
+	}
+	progress.event("progress", progress.TransferProgress)
+	progress.event("result", res)
+}
@@ -0,0 +1,102 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestRestore checks that a restore puts back the snapshot, drops what was
+// added since, and keeps excluded entries where their directory survives.
+func TestRestore(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("SIDECAR_BACKUP_DIR", dir)
+	t.Setenv("SIDECAR_BACKUP_PATHS", "sub")
+	t.Setenv("SIDECAR_BACKUP_EXCLUDE", "sub/*.lock,sub/*/*.lock")
+	s, base := newTestServer(t)
+	h := testHandler(s)
+	sub := filepath.Join(base, "data", "sub")
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/api/backups", nil))
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("create: got status %d: %s", rr.Code, rr.Body)
+	}
+	var snap apitypes.Backup
+	if err := json.Unmarshal(rr.Body.Bytes(), &snap); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.MkdirAll(filepath.Join(sub, "gone"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{
+		"ok.txt":      "changed",
+		"new.txt":     "new",
+		"game.lock":   "lock",
+		"gone/a.lock": "lock",
+	} {
+		if err := os.WriteFile(filepath.Join(sub, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	rr = httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/api/backups/"+snap.ID+"/restore", strings.NewReader(`{"skip_safety_snapshot":true}`)))
+	if rr.Code != http.StatusOK {
+		t.Fatalf("restore: got status %d: %s", rr.Code, rr.Body)
+	}
+	if data, _ := os.ReadFile(filepath.Join(sub, "ok.txt")); string(data) != "ok" {
+		t.Errorf("got ok.txt %q after the restore, want %q", data, "ok")
+	}
+	if data, _ := os.ReadFile(filepath.Join(sub, "game.lock")); string(data) != "lock" {
+		t.Errorf("the excluded game.lock was not kept, got %q", data)
+	}
+	for _, name := range []string{filepath.Join(sub, "new.txt"), filepath.Join(sub, "gone"), filepath.Join(base, "data", restoreDir)} {
+		if _, err := os.Lstat(name); !os.IsNotExist(err) {
+			t.Errorf("%s is left after the restore", name)
+		}
+	}
+	checkOutsideUntouched(t, base)
+}
+
+// TestSwapRestoreRollback checks that when a rename fails partway through the
+// swap, the targets already swapped are put back.
+func TestSwapRestoreRollback(t *testing.T) {
+	s, base := newTestServer(t)
+	data := filepath.Join(base, "data")
+	staged := filepath.Join(data, restoreStaged)
+	for name, content := range map[string]string{
+		filepath.Join(staged, "sub", "ok.txt"): "restored",
+		filepath.Join(staged, "conf", "a"):     "restored",
+		// A file where the second target needs a directory.
+		filepath.Join(data, "conf"): "conf",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.swapRestore([]string{"sub", filepath.Join("conf", "a")}, &transferProgress{}); err == nil {
+		t.Fatal("swap succeeded, want an error")
+	}
+	if data, _ := os.ReadFile(filepath.Join(data, "sub", "ok.txt")); string(data) != "ok" {
+		t.Errorf("got sub/ok.txt %q after the failed swap, want %q", data, "ok")
+	}
+	if data, _ := os.ReadFile(filepath.Join(data, "conf")); string(data) != "conf" {
+		t.Errorf("got conf %q after the failed swap, want %q", data, "conf")
+	}
+	entries, _ := os.ReadDir(filepath.Join(data, restorePrevious))
+	if len(entries) != 0 {
+		t.Errorf("%d entries left in %s after the rollback", len(entries), restorePrevious)
+	}
+}
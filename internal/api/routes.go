@@ -0,0 +1,463 @@
+package api
+
+import (
+	"net/http"
+
+	"github.com/pegnia/sidecar/internal/metrics"
+)
+
+// route is a single API endpoint. The route table drives both handler
+// registration in Run and the OpenAPI document, so the two always agree.
+type route struct {
+	Method  string
+	Path    string
+	Handler http.HandlerFunc
+	Doc     operation
+}
+
+// pathParam documents the "path" query parameter shared by most file endpoints.
+func pathParam(description string) param {
+	return param{Name: "path", In: "query", Type: "string", Description: description}
+}
+
+// pathBody documents the {"path": "..."} JSON body accepted by mutating file endpoints.
+var pathBody = &media{ContentType: "application/json", Schema: ref("PathRequest")}
+
+// transferBody documents the JSON body accepted by the move and copy endpoints.
+var transferBody = &media{ContentType: "application/json", Schema: ref("TransferRequest")}
+
+// uploadIDParam documents the {id} path parameter of resumable upload endpoints.
+var uploadIDParam = param{Name: "id", In: "path", Type: "string", Description: "Upload session ID."}
+
+// uploadBody documents an upload session returned by the resumable upload endpoints.
+var uploadBody = &media{ContentType: "application/json", Schema: ref("UploadSession")}
+
+// configFormatParam documents the "format" query parameter of the config endpoints.
+var configFormatParam = param{Name: "format", In: "query", Type: "string", Description: "properties, ini, json, yaml or toml; guessed from the file extension if omitted."}
+
+// configBody documents a parsed config file returned by the config endpoints.
+var configBody = &media{ContentType: "application/json", Schema: ref("ConfigFile")}
+
+// trashIDParam documents the {id} path parameter of trash item endpoints.
+var trashIDParam = param{Name: "id", In: "path", Type: "string", Description: "Trash item ID."}
+
+// backupIDParam documents the {id} path parameter of snapshot endpoints.
+var backupIDParam = param{Name: "id", In: "path", Type: "string", Description: "Snapshot ID."}
+
+// remoteGameServerParam selects the GameServer whose remote snapshots to use.
+var remoteGameServerParam = param{Name: "gameserver", In: "query", Type: "string", Description: "GameServer whose snapshots to use instead of this one's, such as the one it replaced."}
+
+// resultBody documents the JSON result returned by successful mutations.
+var resultBody = &media{ContentType: "application/json", Schema: ref("Result")}
+
+// routes returns every endpoint served by the API.
+func (s *Server) routes() []route {
+	return []route{
+		{
+			Method: "GET", Path: "/health", Handler: s.healthCheckHandler,
+			Doc: operation{
+				ID: "health", Summary: "Health check",
+				Response: &media{ContentType: "text/plain", Schema: schema{"type": "string"}},
+			},
+		},
+		{
+			Method: "GET", Path: "/metrics", Handler: metrics.Handler().ServeHTTP,
+			Doc: operation{
+				ID: "metrics", Summary: "Prometheus metrics",
+				Response: &media{ContentType: "text/plain", Schema: schema{"type": "string"}},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/openapi.json", Handler: s.openAPIHandler,
+			Doc: operation{
+				ID: "openapi", Summary: "This OpenAPI document",
+				Response: &media{ContentType: "application/json", Schema: schema{"type": "object"}},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/files", Handler: s.listFilesHandler,
+			Doc: operation{
+				ID: "listFiles", Summary: "List files in a directory",
+				Params: []param{
+					pathParam("Directory to list, relative to the data root."),
+					{Name: "depth", In: "query", Type: "integer", Description: "Levels to descend; 1 (default) lists the directory itself, 0 the whole tree."},
+					{Name: "sort", In: "query", Type: "string", Description: "Sort by name (default), size or modified."},
+					{Name: "order", In: "query", Type: "string", Description: "asc (default) or desc."},
+					{Name: "hidden", In: "query", Type: "boolean", Description: "Set to false to leave out entries whose name starts with a dot."},
+					{Name: "limit", In: "query", Type: "integer", Description: "Entries per page, 1 to 10000 (default 1000)."},
+					{Name: "cursor", In: "query", Type: "string", Description: "X-Next-Cursor header of the previous page."},
+					{Name: "If-None-Match", In: "header", Type: "string", Description: "Reply 304 if the page still has this ETag."},
+					{Name: "If-Modified-Since", In: "header", Type: "string", Description: "Reply 304 if no listed entry changed since this time."},
+				},
+				Response: &media{ContentType: "application/json", Schema: arrayOf(ref("FileInfo"))},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/files/download", Handler: s.downloadFileHandler,
+			Doc: operation{
+				ID: "downloadFile", Summary: "Download a file, or a directory as an archive",
+				Params: []param{
+					pathParam("File or directory to download, relative to the data root."),
+					{Name: "format", In: "query", Type: "string", Description: "Archive format for directories: zip (default), tar, tar.gz or tar.zst."},
+					{Name: "include", In: "query", Type: "string", Description: "Glob of entries to include in a directory archive; repeatable."},
+					{Name: "exclude", In: "query", Type: "string", Description: "Glob of entries to leave out of a directory archive; repeatable."},
+					{Name: "etag", In: "query", Type: "string", Description: "Derive a file's ETag from its size and modification time (stat, default) or from a hash of its content (content)."},
+					{Name: "Range", In: "header", Type: "string", Description: "Byte ranges of a file to send, e.g. bytes=1048576- to resume."},
+					{Name: "If-Range", In: "header", Type: "string", Description: "Only honour Range if the file still has this ETag; otherwise send it whole."},
+					{Name: "If-None-Match", In: "header", Type: "string", Description: "Reply 304 if the file still has this ETag."},
+					{Name: "If-Modified-Since", In: "header", Type: "string", Description: "Reply 304 if the file has not changed since this time."},
+				},
+				Response: &media{ContentType: "application/octet-stream", Schema: schema{"type": "string", "format": "binary"}},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/files/search", Handler: s.searchFilesHandler,
+			Doc: operation{
+				ID: "searchFiles", Summary: "Search a directory tree by name and content",
+				Params: []param{
+					pathParam("Directory to search, relative to the data root."),
+					{Name: "name", In: "query", Type: "string", Description: "Glob the entry's path below the directory must match; repeatable."},
+					{Name: "regex", In: "query", Type: "string", Description: "Regular expression the entry's path below the directory must match."},
+					{Name: "content", In: "query", Type: "string", Description: "Regular expression to search for in text files; only files with a matching line are returned."},
+					{Name: "ignore_case", In: "query", Type: "boolean", Description: "Match regex and content case-insensitively."},
+					{Name: "context", In: "query", Type: "integer", Description: "Lines of context around each content match, up to 10."},
+					{Name: "type", In: "query", Type: "string", Description: "Only return files (file) or directories (dir)."},
+					{Name: "min_size", In: "query", Type: "integer", Format: "int64", Description: "Smallest file size in bytes."},
+					{Name: "max_size", In: "query", Type: "integer", Format: "int64", Description: "Largest file size in bytes."},
+					{Name: "modified_after", In: "query", Type: "string", Description: "RFC 3339 timestamp the entry must be modified after."},
+					{Name: "modified_before", In: "query", Type: "string", Description: "RFC 3339 timestamp the entry must be modified before."},
+					{Name: "max_results", In: "query", Type: "integer", Description: "Maximum number of entries to return, 1 to 10000 (default 1000)."},
+				},
+				Response: &media{ContentType: "application/json", Schema: ref("SearchResponse")},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/files/watch", Handler: s.watchFilesHandler,
+			Doc: operation{
+				ID: "watchFiles", Summary: "Stream changes below a directory as server-sent events",
+				Params: []param{
+					pathParam("Directory to watch, relative to the data root."),
+				},
+				Response: &media{ContentType: "text/event-stream", Schema: ref("FileEvent")},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/files/usage", Handler: s.usageHandler,
+			Doc: operation{
+				ID: "getDiskUsage", Summary: "Recursive disk usage of a directory, filesystem capacity and quota",
+				Params: []param{
+					pathParam("Directory to measure, relative to the data root."),
+					{Name: "limit", In: "query", Type: "integer", Description: "Largest entries to return, 1 to 10000 (default 100)."},
+					{Name: "refresh", In: "query", Type: "boolean", Description: "Rescan the data root now instead of using the last background scan."},
+				},
+				Response: &media{ContentType: "application/json", Schema: ref("DiskUsage")},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/files/content", Handler: s.getContentHandler,
+			Doc: operation{
+				ID: "getFileContent", Summary: "Read a text file for editing",
+				Params: []param{
+					pathParam("Text file to read, relative to the data root."),
+					{Name: "If-None-Match", In: "header", Type: "string", Description: "Reply 304 if the file still has this ETag."},
+				},
+				Response: &media{ContentType: "application/json", Schema: ref("FileContent")},
+			},
+		},
+		{
+			Method: "PUT", Path: "/api/files/content", Handler: s.putContentHandler,
+			Doc: operation{
+				ID: "putFileContent", Summary: "Atomically replace or create a text file",
+				Params: []param{
+					pathParam("Text file to write, relative to the data root."),
+					{Name: "If-Match", In: "header", Type: "string", Description: "Only write if the file still has this ETag."},
+					{Name: "If-None-Match", In: "header", Type: "string", Description: "Use * to only create the file if it does not exist."},
+				},
+				Body:     &media{ContentType: "application/json", Schema: ref("ContentRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "GET", Path: "/api/config", Handler: s.getConfigHandler,
+			Doc: operation{
+				ID: "getConfig", Summary: "Read the settings of a config file",
+				Params: []param{
+					pathParam("Config file to read, relative to the data root."),
+					configFormatParam,
+					{Name: "If-None-Match", In: "header", Type: "string", Description: "Reply 304 if the file still has this ETag."},
+				},
+				Response: configBody,
+			},
+		},
+		{
+			Method: "PATCH", Path: "/api/config", Handler: s.patchConfigHandler,
+			Doc: operation{
+				ID: "patchConfig", Summary: "Change settings in a config file, keeping comments and order",
+				Params: []param{
+					pathParam("Config file to change, relative to the data root."),
+					configFormatParam,
+					{Name: "If-Match", In: "header", Type: "string", Description: "Only write if the file still has this ETag."},
+				},
+				Body:     &media{ContentType: "application/merge-patch+json", Schema: schema{"type": "object", "description": "JSON merge patch (RFC 7386); null removes a key."}},
+				Response: configBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/upload", Handler: s.uploadFileHandler,
+			Doc: operation{
+				ID: "uploadFile", Summary: "Upload a file",
+				Params: []param{
+					pathParam("Existing directory to upload into, relative to the data root."),
+					{Name: "overwrite", In: "query", Type: "boolean", Description: "Replace an existing file."},
+				},
+				Body: &media{ContentType: "multipart/form-data", Schema: schema{
+					"type":       "object",
+					"required":   []string{"file"},
+					"properties": schema{"file": schema{"type": "string", "format": "binary"}},
+				}},
+				Status:   http.StatusCreated,
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/extract", Handler: s.extractArchiveHandler,
+			Doc: operation{
+				ID: "extractArchive", Summary: "Upload an archive and extract it into a directory",
+				Params: []param{
+					pathParam("Existing directory to extract into, relative to the data root."),
+					{Name: "format", In: "query", Type: "string", Description: "Archive format: zip, tar, tar.gz or tar.zst. Guessed from the file name if omitted."},
+					{Name: "overwrite", In: "query", Type: "boolean", Description: "Replace existing files."},
+				},
+				Body: &media{ContentType: "multipart/form-data", Schema: schema{
+					"type":       "object",
+					"required":   []string{"file"},
+					"properties": schema{"file": schema{"type": "string", "format": "binary"}},
+				}},
+				Status:   http.StatusCreated,
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/uploads", Handler: s.createUploadHandler,
+			Doc: operation{
+				ID: "createUpload", Summary: "Start a resumable upload",
+				Body:     &media{ContentType: "application/json", Schema: ref("UploadRequest")},
+				Status:   http.StatusCreated,
+				Response: uploadBody,
+			},
+		},
+		{
+			Method: "GET", Path: "/api/uploads/{id}", Handler: s.getUploadHandler,
+			Doc: operation{
+				ID: "getUpload", Summary: "Get the state and offset of a resumable upload",
+				Params:   []param{uploadIDParam},
+				Response: uploadBody,
+			},
+		},
+		{
+			Method: "PATCH", Path: "/api/uploads/{id}", Handler: s.patchUploadHandler,
+			Doc: operation{
+				ID: "patchUpload", Summary: "Append a chunk to a resumable upload",
+				Params: []param{
+					uploadIDParam,
+					{Name: "Upload-Offset", In: "header", Type: "integer", Required: true, Description: "Current offset of the upload; the chunk is written there."},
+				},
+				Body:     &media{ContentType: "application/offset+octet-stream", Schema: schema{"type": "string", "format": "binary"}},
+				Response: uploadBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/uploads/{id}/complete", Handler: s.completeUploadHandler,
+			Doc: operation{
+				ID: "completeUpload", Summary: "Verify a finished upload and move it into place",
+				Params:   []param{uploadIDParam},
+				Status:   http.StatusCreated,
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "DELETE", Path: "/api/uploads/{id}", Handler: s.cancelUploadHandler,
+			Doc: operation{
+				ID: "cancelUpload", Summary: "Cancel a resumable upload and discard its data",
+				Params:   []param{uploadIDParam},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/delete", Handler: s.deleteFileHandler,
+			Doc: operation{
+				ID: "deleteFile", Summary: "Delete a file or directory recursively, moving it to the trash unless permanent is set",
+				Body:     &media{ContentType: "application/json", Schema: ref("DeleteRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "GET", Path: "/api/trash", Handler: s.listTrashHandler,
+			Doc: operation{
+				ID: "listTrash", Summary: "List deleted items in the trash, newest first",
+				Response: &media{ContentType: "application/json", Schema: arrayOf(ref("TrashItem"))},
+			},
+		},
+		{
+			Method: "POST", Path: "/api/trash/{id}/restore", Handler: s.restoreTrashHandler,
+			Doc: operation{
+				ID: "restoreTrash", Summary: "Restore an item from the trash to its original or a new path",
+				Params:   []param{trashIDParam},
+				Body:     &media{ContentType: "application/json", Schema: ref("RestoreRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "DELETE", Path: "/api/trash/{id}", Handler: s.purgeTrashHandler,
+			Doc: operation{
+				ID: "purgeTrash", Summary: "Permanently delete an item from the trash",
+				Params:   []param{trashIDParam},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "DELETE", Path: "/api/trash", Handler: s.emptyTrashHandler,
+			Doc: operation{
+				ID: "emptyTrash", Summary: "Permanently delete everything in the trash",
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/backups", Handler: s.createBackupHandler,
+			Doc: operation{
+				ID: "createBackup", Summary: "Take a snapshot of the data root, streaming progress as server-sent events if requested",
+				Body:     &media{ContentType: "application/json", Schema: ref("BackupRequest")},
+				Status:   http.StatusCreated,
+				Response: &media{ContentType: "application/json", Schema: ref("Backup")},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/backups", Handler: s.listBackupsHandler,
+			Doc: operation{
+				ID: "listBackups", Summary: "List stored snapshots, newest first",
+				Response: &media{ContentType: "application/json", Schema: arrayOf(ref("Backup"))},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/backups/schedule", Handler: s.backupScheduleHandler,
+			Doc: operation{
+				ID: "getBackupSchedule", Summary: "Get the snapshot schedule, retention policy and last scheduled run",
+				Response: &media{ContentType: "application/json", Schema: ref("BackupSchedule")},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/backups/remote", Handler: s.listRemoteBackupsHandler,
+			Doc: operation{
+				ID: "listRemoteBackups", Summary: "List snapshots in remote storage, newest first",
+				Params:   []param{remoteGameServerParam},
+				Response: &media{ContentType: "application/json", Schema: arrayOf(ref("Backup"))},
+			},
+		},
+		{
+			Method: "POST", Path: "/api/backups/remote/{id}/restore", Handler: s.restoreRemoteBackupHandler,
+			Doc: operation{
+				ID: "restoreRemoteBackup", Summary: "Download a snapshot from remote storage and restore it, streaming progress as server-sent events if requested",
+				Params:   []param{backupIDParam, remoteGameServerParam},
+				Body:     &media{ContentType: "application/json", Schema: ref("RestoreBackupRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "GET", Path: "/api/backups/{id}/download", Handler: s.downloadBackupHandler,
+			Doc: operation{
+				ID: "downloadBackup", Summary: "Download the archive of a snapshot",
+				Params: []param{
+					backupIDParam,
+					{Name: "Range", In: "header", Type: "string", Description: "Byte range to resume a download, e.g. bytes=1048576-."},
+				},
+				Response: &media{ContentType: "application/octet-stream", Schema: schema{"type": "string", "format": "binary"}},
+			},
+		},
+		{
+			Method: "POST", Path: "/api/backups/{id}/restore", Handler: s.restoreBackupHandler,
+			Doc: operation{
+				ID: "restoreBackup", Summary: "Restore a snapshot over the current data, streaming progress as server-sent events if requested",
+				Params:   []param{backupIDParam},
+				Body:     &media{ContentType: "application/json", Schema: ref("RestoreBackupRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "DELETE", Path: "/api/backups/{id}", Handler: s.deleteBackupHandler,
+			Doc: operation{
+				ID: "deleteBackup", Summary: "Delete a snapshot",
+				Params:   []param{backupIDParam},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/create-dir", Handler: s.createDirHandler,
+			Doc: operation{
+				ID: "createDir", Summary: "Create a directory and any missing parents",
+				Body:     pathBody,
+				Status:   http.StatusCreated,
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/move", Handler: s.moveFileHandler,
+			Doc: operation{
+				ID: "moveFile", Summary: "Move or rename a file or directory",
+				Body:     transferBody,
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/copy", Handler: s.copyFileHandler,
+			Doc: operation{
+				ID: "copyFile", Summary: "Copy a file or directory recursively",
+				Body:     transferBody,
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/chmod", Handler: s.chmodHandler,
+			Doc: operation{
+				ID: "chmod", Summary: "Change the permissions of a file or directory tree",
+				Body:     &media{ContentType: "application/json", Schema: ref("ChmodRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "POST", Path: "/api/files/chown", Handler: s.chownHandler,
+			Doc: operation{
+				ID: "chown", Summary: "Change the owner and group of a file or directory tree to allowed IDs",
+				Body:     &media{ContentType: "application/json", Schema: ref("ChownRequest")},
+				Response: resultBody,
+			},
+		},
+		{
+			Method: "GET", Path: "/api/logs/stream", Handler: s.streamStdoutLogHandler,
+			Doc: operation{
+				ID: "streamLogs", Summary: "Stream the game server stdout log as server-sent events",
+				Response: &media{ContentType: "text/event-stream", Schema: schema{"type": "string"}},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/policy", Handler: s.policyHandler,
+			Doc: operation{
+				ID: "getPolicy", Summary: "Read-only mode and the rules hiding or protecting paths",
+				Response: &media{ContentType: "application/json", Schema: ref("PathPolicy")},
+			},
+		},
+		{
+			Method: "GET", Path: "/api/audit", Handler: s.auditLogHandler,
+			Doc: operation{
+				ID: "queryAudit", Summary: "Query the audit log",
+				Params: []param{
+					{Name: "since", In: "query", Type: "string", Format: "date-time", Description: "Only events at or after this time."},
+					{Name: "until", In: "query", Type: "string", Format: "date-time", Description: "Only events at or before this time."},
+					{Name: "actor", In: "query", Type: "string", Description: "Only events by this actor."},
+					{Name: "action", In: "query", Type: "string", Description: "Only events with this action, e.g. file.delete."},
+					{Name: "limit", In: "query", Type: "integer", Description: "Maximum number of most recent events to return (default 1000)."},
+				},
+				Response: &media{ContentType: "application/json", Schema: arrayOf(ref("AuditEvent"))},
+			},
+		},
+	}
+}
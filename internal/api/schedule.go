@@ -0,0 +1,185 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/audit"
+	"github.com/pegnia/sidecar/internal/backup"
+	"github.com/pegnia/sidecar/internal/cron"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// scheduledBy is recorded as the creator of scheduled snapshots. Only those
+// are subject to the retention policy.
+const scheduledBy = "scheduler"
+
+// errBackupBusy is recorded for a scheduled run that found another backup
+// operation running.
+var errBackupBusy = errors.New("another backup operation was running")
+
+// parseBackupSchedule parses a cron schedule for snapshots. An empty one
+// means snapshots are only taken on request.
+func parseBackupSchedule(spec string) (*cron.Schedule, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	sched, err := cron.Parse(spec)
+	if err != nil {
+		return nil, err
+	}
+	if sched.Next(time.Now()).IsZero() {
+		return nil, fmt.Errorf("%q never fires", spec)
+	}
+	return sched, nil
+}
+
+// parseBackupRetention reads the retention policy of scheduled snapshots.
+func parseBackupRetention() (backup.Retention, error) {
+	var r backup.Retention
+	for _, opt := range []struct {
+		env string
+		dst *int
+	}{
+		{"SIDECAR_BACKUP_KEEP_HOURLY", &r.Hourly},
+		{"SIDECAR_BACKUP_KEEP_DAILY", &r.Daily},
+		{"SIDECAR_BACKUP_KEEP_WEEKLY", &r.Weekly},
+	} {
+		v := os.Getenv(opt.env)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("%s: invalid count %q", opt.env, v)
+		}
+		*opt.dst = n
+	}
+	if v := os.Getenv("SIDECAR_BACKUP_MAX_BYTES"); v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil || n < 0 {
+			return r, fmt.Errorf("SIDECAR_BACKUP_MAX_BYTES: invalid size %q", v)
+		}
+		r.MaxBytes = n
+	}
+	return r, nil
+}
+
+// runBackupSchedule takes a snapshot each time the schedule fires, then
+// prunes scheduled snapshots by the retention policy. Runs are not caught
+// up: one missed while the sidecar was down is skipped.
+func (s *Server) runBackupSchedule(ctx context.Context) {
+	s.logger.Info("Scheduling snapshots", "schedule", s.backupSchedule.String())
+	s.backupMu.Lock()
+	s.pruneBackups()
+	s.backupMu.Unlock()
+	for {
+		next := s.backupSchedule.Next(time.Now())
+		s.scheduleMu.Lock()
+		s.scheduleStatus.NextRun = &next
+		s.scheduleMu.Unlock()
+		metrics.BackupScheduleNextTimestamp.Set(float64(next.Unix()))
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		s.runScheduledBackup(ctx)
+	}
+}
+
+// runScheduledBackup takes a scheduled snapshot and records the outcome. A
+// run that finds a manual snapshot or a restore in progress is skipped.
+func (s *Server) runScheduledBackup(ctx context.Context) {
+	started := time.Now()
+	var snap *backup.Snapshot
+	var pruned int
+	err := errBackupBusy
+	if s.backupMu.TryLock() {
+		snap, err = s.snapshot(ctx, scheduledBy, "", &transferProgress{})
+		pruned = s.pruneBackups()
+		s.backupMu.Unlock()
+	}
+
+	status := apitypes.BackupSchedule{LastRun: &started, LastResult: "success", LastPruned: pruned}
+	event := audit.Event{Action: "backup.create", Actor: scheduledBy, Result: audit.ResultSuccess}
+	switch {
+	case errors.Is(err, errBackupBusy):
+		status.LastResult = "skipped"
+		s.logger.Warn("Skipped scheduled snapshot", "reason", err)
+	case err != nil:
+		status.LastResult = "failure"
+		s.logger.Error("Scheduled snapshot failed", "error", err)
+	default:
+		status.LastBackupID = snap.ID
+		event.Path, event.Bytes = snap.ID, snap.Size
+		s.logger.Info("Took scheduled snapshot", "backup_id", snap.ID, "size", snap.Size, "files", snap.Files, "pruned", pruned)
+	}
+	if err != nil {
+		status.LastError = err.Error()
+		event.Result, event.Error = audit.ResultFailure, err.Error()
+	}
+	s.audit.Record(event)
+	metrics.ScheduledBackups.Inc(status.LastResult)
+	metrics.BackupScheduleLastTimestamp.Set(float64(started.Unix()))
+
+	s.scheduleMu.Lock()
+	status.NextRun = s.scheduleStatus.NextRun
+	s.scheduleStatus = status
+	s.scheduleMu.Unlock()
+}
+
+// pruneBackups deletes the scheduled snapshots the retention policy does not
+// keep and returns how many it deleted. The caller must hold backupMu.
+func (s *Server) pruneBackups() int {
+	snaps, err := s.backups.List()
+	if err != nil {
+		s.logger.Warn("Could not list snapshots", "error", err)
+		return 0
+	}
+	var pruned int
+	for _, snap := range s.backupRetention.Expired(snaps, func(snap backup.Snapshot) bool {
+		return snap.CreatedBy == scheduledBy
+	}) {
+		if _, err := s.backups.Delete(snap.ID); err != nil {
+			s.logger.Warn("Could not prune snapshot", "backup_id", snap.ID, "error", err)
+			continue
+		}
+		pruned++
+		s.logger.Info("Pruned snapshot", "backup_id", snap.ID, "created_at", snap.CreatedAt, "size", snap.Size)
+		s.audit.Record(audit.Event{Action: "backup.prune", Actor: scheduledBy, Path: snap.ID, Bytes: snap.Size, Result: audit.ResultSuccess})
+	}
+	metrics.BackupsPruned.Add(float64(pruned))
+	s.updateBackupMetrics()
+	return pruned
+}
+
+// backupScheduleHandler returns the snapshot schedule, the retention policy
+// and the outcome of the last scheduled run.
+func (s *Server) backupScheduleHandler(w http.ResponseWriter, r *http.Request) {
+	if !s.backupsEnabled(w, r) {
+		return
+	}
+	s.scheduleMu.Lock()
+	status := s.scheduleStatus
+	s.scheduleMu.Unlock()
+	if s.backupSchedule != nil {
+		status.Schedule = s.backupSchedule.String()
+	}
+	status.Retention = apitypes.BackupRetention{
+		KeepHourly: s.backupRetention.Hourly,
+		KeepDaily:  s.backupRetention.Daily,
+		KeepWeekly: s.backupRetention.Weekly,
+		MaxBytes:   s.backupRetention.MaxBytes,
+	}
+	s.writeJSON(w, r, http.StatusOK, status)
+}
@@ -0,0 +1,310 @@
+package api
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/glob"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+const (
+	defaultSearchResults = 1000
+	maxSearchResults     = 10000
+	maxSearchContext     = 10
+	// maxFileMatches caps the content matches reported for a single file.
+	maxFileMatches = 100
+	// maxMatchLine is the longest line text returned; longer lines are cut.
+	maxMatchLine = 512
+	// maxGrepLine is how much of a single line is searched.
+	maxGrepLine = 1 << 20
+)
+
+// searchQuery holds the parsed parameters of a search request.
+type searchQuery struct {
+	names      []string
+	nameRegex  *regexp.Regexp
+	content    *regexp.Regexp
+	context    int
+	fileType   string
+	minSize    int64
+	maxSize    int64
+	after      time.Time
+	before     time.Time
+	maxResults int
+}
+
+// parseSearchQuery validates the query parameters, returning a message for
+// the first invalid one.
+func parseSearchQuery(query map[string][]string) (*searchQuery, string) {
+	get := func(key string) string {
+		if v := query[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	q := &searchQuery{names: query["name"], maxSize: -1, maxResults: defaultSearchResults}
+	for _, p := range q.names {
+		if !glob.Valid(p) {
+			return nil, "Invalid glob pattern in 'name'"
+		}
+	}
+
+	flags := ""
+	if v := get("ignore_case"); v != "" {
+		ignore, err := strconv.ParseBool(v)
+		if err != nil {
+			return nil, "Invalid 'ignore_case', expected a boolean"
+		}
+		if ignore {
+			flags = "(?i)"
+		}
+	}
+	var err error
+	if v := get("regex"); v != "" {
+		if q.nameRegex, err = regexp.Compile(flags + v); err != nil {
+			return nil, "Invalid 'regex': " + err.Error()
+		}
+	}
+	if v := get("content"); v != "" {
+		if q.content, err = regexp.Compile(flags + v); err != nil {
+			return nil, "Invalid 'content' pattern: " + err.Error()
+		}
+	}
+	if v := get("context"); v != "" {
+		if q.context, err = strconv.Atoi(v); err != nil || q.context < 0 || q.context > maxSearchContext {
+			return nil, "Invalid 'context', expected 0 to " + strconv.Itoa(maxSearchContext)
+		}
+	}
+	switch q.fileType = get("type"); q.fileType {
+	case "", "file", "dir":
+	default:
+		return nil, "Invalid 'type', expected file or dir"
+	}
+	if v := get("min_size"); v != "" {
+		if q.minSize, err = strconv.ParseInt(v, 10, 64); err != nil || q.minSize < 0 {
+			return nil, "Invalid 'min_size', expected a byte count"
+		}
+	}
+	if v := get("max_size"); v != "" {
+		if q.maxSize, err = strconv.ParseInt(v, 10, 64); err != nil || q.maxSize < 0 {
+			return nil, "Invalid 'max_size', expected a byte count"
+		}
+	}
+	if v := get("modified_after"); v != "" {
+		if q.after, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, "Invalid 'modified_after' timestamp, expected RFC 3339"
+		}
+	}
+	if v := get("modified_before"); v != "" {
+		if q.before, err = time.Parse(time.RFC3339, v); err != nil {
+			return nil, "Invalid 'modified_before' timestamp, expected RFC 3339"
+		}
+	}
+	if v := get("max_results"); v != "" {
+		if q.maxResults, err = strconv.Atoi(v); err != nil || q.maxResults <= 0 || q.maxResults > maxSearchResults {
+			return nil, "Invalid 'max_results', expected 1 to " + strconv.Itoa(maxSearchResults)
+		}
+	}
+	return q, ""
+}
+
+// matchInfo applies the name, type, size and time filters. rel is the
+// slash-separated path below the searched directory.
+func (q *searchQuery) matchInfo(rel string, info fs.FileInfo) bool {
+	switch {
+	case q.fileType == "file" && info.IsDir(), q.fileType == "dir" && !info.IsDir():
+		return false
+	case q.content != nil && !info.Mode().IsRegular():
+		return false
+	case len(q.names) > 0 && !glob.MatchAny(q.names, rel):
+		return false
+	case q.nameRegex != nil && !q.nameRegex.MatchString(rel):
+		return false
+	case !info.IsDir() && (info.Size() < q.minSize || q.maxSize >= 0 && info.Size() > q.maxSize):
+		return false
+	case !q.after.IsZero() && !info.ModTime().After(q.after):
+		return false
+	case !q.before.IsZero() && !info.ModTime().Before(q.before):
+		return false
+	}
+	return true
+}
+
+// searchFilesHandler walks a directory tree and returns the entries that
+// match every given filter. The walk stops as soon as the client goes away.
+func (s *Server) searchFilesHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := s.sanitizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	q, msg := parseSearchQuery(r.URL.Query())
+	if q == nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, msg, nil)
+		return
+	}
+	info, err := s.root.Stat(name)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not access directory")
+		return
+	}
+	if !info.IsDir() {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeNotDirectory, "Path is not a directory", nil)
+		return
+	}
+
+	ctx := r.Context()
+	resp := apitypes.SearchResponse{Results: []apitypes.SearchResult{}}
+	base := filepath.ToSlash(name)
+	err = fs.WalkDir(s.root.FS(), base, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			// Unreadable subdirectories are skipped rather than failing the search.
+			s.log(r).Warn("Skipping unreadable entry during search", "path", p, "error", err)
+			return nil
+		}
+		if p == base {
+			return nil
+		}
+		if s.hiddenPath(filepath.FromSlash(p)) {
+			return skipEntry(d)
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel := strings.TrimPrefix(p, base+"/")
+		if base == "." {
+			rel = p
+		}
+		if !q.matchInfo(rel, info) {
+			return nil
+		}
+
+		result := apitypes.SearchResult{FileInfo: s.fileInfo(p, info)}
+		if q.content != nil {
+			matches, err := s.grepFile(ctx, p, q.content, q.context)
+			if err != nil || len(matches) == 0 {
+				return ctx.Err()
+			}
+			result.Matches = matches
+		}
+		if len(resp.Results) == q.maxResults {
+			resp.Truncated = true
+			return fs.SkipAll
+		}
+		resp.Results = append(resp.Results, result)
+		return nil
+	})
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			s.log(r).Info("Search cancelled by client", "path", name)
+			return
+		}
+		s.writeFSError(w, r, err, "Search failed")
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, resp)
+}
+
+// grepFile returns the lines of a file matching re, with up to around lines
+// around each. Files that look binary yield no matches.
+func (s *Server) grepFile(ctx context.Context, name string, re *regexp.Regexp, around int) ([]apitypes.SearchMatch, error) {
+	f, err := s.root.Open(filepath.FromSlash(name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	if head, _ := br.Peek(8000); bytes.IndexByte(head, 0) >= 0 {
+		return nil, nil
+	}
+
+	var (
+		matches []apitypes.SearchMatch
+		before  []string
+		open    []int // indices of matches still collecting trailing context
+	)
+	for lineNo := 1; ; lineNo++ {
+		if lineNo%256 == 0 && ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		text, err := readLine(br)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		line := clipLine(text)
+
+		still := open[:0]
+		for _, i := range open {
+			matches[i].After = append(matches[i].After, line)
+			if len(matches[i].After) < around {
+				still = append(still, i)
+			}
+		}
+		open = still
+
+		if re.MatchString(text) {
+			if len(matches) == maxFileMatches {
+				break
+			}
+			matches = append(matches, apitypes.SearchMatch{Line: lineNo, Text: line, Before: append([]string(nil), before...)})
+			if around > 0 {
+				open = append(open, len(matches)-1)
+			}
+		}
+		if around > 0 {
+			if len(before) == around {
+				before = before[1:]
+			}
+			before = append(before, line)
+		}
+	}
+	return matches, nil
+}
+
+// readLine returns the next line without its terminator. Only the first
+// maxGrepLine bytes of very long lines are kept; the rest is skipped.
+func readLine(br *bufio.Reader) (string, error) {
+	var line []byte
+	for {
+		chunk, isPrefix, err := br.ReadLine()
+		if err != nil {
+			if len(line) > 0 {
+				return string(line), nil
+			}
+			return "", err
+		}
+		if len(line) < maxGrepLine {
+			line = append(line, chunk[:min(len(chunk), maxGrepLine-len(line))]...)
+		}
+		if !isPrefix {
+			return string(line), nil
+		}
+	}
+}
+
+// clipLine shortens a line to maxMatchLine bytes without splitting a character.
+func clipLine(line string) string {
+	if len(line) <= maxMatchLine {
+		return line
+	}
+	return strings.ToValidUTF8(line[:maxMatchLine], "")
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestSearch checks the name, type and content filters of a search, that
+// hidden paths are never found, and that bad parameters are refused.
+func TestSearch(t *testing.T) {
+	t.Setenv("SIDECAR_HIDDEN_PATHS", "sub/secret")
+	newSearchServer := func(t *testing.T) *Server {
+		t.Helper()
+		s, base := newTestServer(t)
+		sub := filepath.Join(base, "data", "sub")
+		for _, dir := range []string{"cfg", "secret"} {
+			if err := os.Mkdir(filepath.Join(sub, dir), 0o755); err != nil {
+				t.Fatal(err)
+			}
+		}
+		for name, content := range map[string]string{
+			"cfg/server.yml": "motd: hi\nspawn-protection: 16\npvp: true\n",
+			"cfg/world.dat":  "spawn-protection\x00",
+			"secret/key.yml": "spawn-protection: 0\n",
+		} {
+			if err := os.WriteFile(filepath.Join(sub, name), []byte(content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return s
+	}
+
+	for _, tt := range []struct {
+		name      string
+		query     string
+		want      []string
+		truncated bool
+	}{
+		{"name", "path=sub&name=*.yml", []string{"sub/cfg/server.yml"}, false},
+		{"names", "path=sub&name=*.yml&name=ok.*", []string{"sub/cfg/server.yml", "sub/ok.txt"}, false},
+		{"type", "path=sub&type=dir", []string{"sub/cfg"}, false},
+		{"regex", "path=sub&regex=^CFG/&ignore_case=true&type=file", []string{"sub/cfg/server.yml", "sub/cfg/world.dat"}, false},
+		{"content skips binary files", "path=sub&content=spawn-protection", []string{"sub/cfg/server.yml"}, false},
+		{"max results", "path=sub&type=file&max_results=1", []string{"sub/cfg/server.yml"}, true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSearchServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files/search?"+tt.query, nil))
+			if rr.Code != http.StatusOK {
+				t.Fatalf("got status %d: %s", rr.Code, rr.Body)
+			}
+			var resp apitypes.SearchResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			var got []string
+			for _, res := range resp.Results {
+				got = append(got, res.Path)
+			}
+			slices.Sort(got)
+			if !slices.Equal(got, tt.want) || resp.Truncated != tt.truncated {
+				t.Errorf("got %q, truncated %v, want %q, truncated %v", got, resp.Truncated, tt.want, tt.truncated)
+			}
+		})
+	}
+
+	t.Run("context", func(t *testing.T) {
+		s := newSearchServer(t)
+		rr := httptest.NewRecorder()
+		testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files/search?path=sub&name=*.yml&content=spawn&context=1", nil))
+		var resp apitypes.SearchResponse
+		if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+			t.Fatal(err)
+		}
+		if len(resp.Results) != 1 || len(resp.Results[0].Matches) != 1 {
+			t.Fatalf("got %+v, want one file with one match", resp.Results)
+		}
+		m := resp.Results[0].Matches[0]
+		if m.Line != 2 || m.Text != "spawn-protection: 16" || !slices.Equal(m.Before, []string{"motd: hi"}) || !slices.Equal(m.After, []string{"pvp: true"}) {
+			t.Errorf("got match %+v", m)
+		}
+	})
+
+	for _, tt := range []struct {
+		name       string
+		query      string
+		wantStatus int
+		wantCode   string
+	}{
+		{"invalid regex", "path=sub&regex=(", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"invalid glob", "path=sub&name=[", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"context too large", "path=sub&content=x&context=11", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"invalid type", "path=sub&type=link", http.StatusBadRequest, apitypes.CodeBadRequest},
+		{"file", "path=sub/ok.txt", http.StatusBadRequest, apitypes.CodeNotDirectory},
+		{"hidden", "path=sub/secret", http.StatusForbidden, apitypes.CodePolicyDenied},
+		{"escape", "path=outdir", http.StatusBadRequest, apitypes.CodeInvalidPath},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newSearchServer(t)
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("GET", "/api/files/search?"+tt.query, nil))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+		})
+	}
+}
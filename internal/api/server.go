@@ -3,42 +3,244 @@ package api
 import (
 	"bufio"
 	"context"
+	"crypto/md5"
+	"crypto/sha256"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
+
+	"github.com/pegnia/sidecar/internal/archive"
+	"github.com/pegnia/sidecar/internal/audit"
+	"github.com/pegnia/sidecar/internal/backup"
+	"github.com/pegnia/sidecar/internal/cron"
+	"github.com/pegnia/sidecar/internal/filemode"
+	"github.com/pegnia/sidecar/internal/fsroot"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/s3"
+	"github.com/pegnia/sidecar/internal/uploadpolicy"
+	"github.com/pegnia/sidecar/internal/usage"
+	"github.com/pegnia/sidecar/pkg/apitypes"
 )
 
 // Server holds dependencies and configuration for the internal API server.
 type Server struct {
 	listenAddr string
 	dataRoot   string
+	root       *fsroot.Root
 	logger     *slog.Logger
+	audit      *audit.Logger
+	policy     *policy.Policy
+
+	uploadPolicy *uploadpolicy.Policy
 
-	stdoutLogPath string
+	stdoutLogName string
 	requestCounts map[string]int
 	rateLimitMu   sync.Mutex
 	rateLimit     int
+
+	trustedProxies []netip.Prefix
+
+	extractMaxBytes int64
+	extractMaxFiles int
+
+	textMaxBytes int64
+	contentMu    sync.Mutex
+
+	uploadTTL     time.Duration
+	uploadsMu     sync.Mutex
+	activeUploads map[string]bool
+
+	usage         *usage.Tracker
+	usageInterval time.Duration
+	quotaBytes    int64
+
+	trashRetention time.Duration
+	trashMaxBytes  int64
+	trashMu        sync.Mutex
+
+	watchDebounce time.Duration
+	watchStreams  chan struct{}
+
+	fileMode  os.FileMode
+	dirMode   os.FileMode
+	fileUID   int
+	fileGID   int
+	chownUIDs []int
+	chownGIDs []int
+
+	backups           *backup.Dir
+	backupFormat      archive.Format
+	backupPaths       []string
+	backupExclude     []string
+	backupPreHook     []string
+	backupPostHook    []string
+	backupHookTimeout time.Duration
+	backupMu          sync.Mutex
+	restorePreHook    []string
+	restorePostHook   []string
+	backupSchedule    *cron.Schedule
+	backupRetention   backup.Retention
+	scheduleMu        sync.Mutex
+	scheduleStatus    apitypes.BackupSchedule
+	remote            *s3.Client
+	remotePrefix      string
+	gameServerName    string
+	fleetName         string
+	drain             func(draining bool) error
 }
 
+// internalDir is a hidden directory at the top of the data root where the
+// sidecar keeps its own state. It is not reachable through the file API.
+const internalDir = ".sidecar"
+
+// errInternalPath is returned by sanitizePath for paths inside internalDir.
+var errInternalPath = fmt.Errorf("%w: %s is reserved", fsroot.ErrEscape, internalDir)
+
+// maxUploadSize limits the request body of uploads and archive extraction.
+const maxUploadSize = 500 * 1024 * 1024
+
 // FileInfo represents a single file or directory, used for JSON responses.
-type FileInfo struct {
-	Name     string    `json:"name"`
-	Size     int64     `json:"size"`
-	IsDir    bool      `json:"is_dir"`
-	Modified time.Time `json:"modified"`
-}
+type FileInfo = apitypes.FileInfo
 
-// NewServer creates a new API server instance.
-func NewServer(listenAddr, dataRoot string, stdoutFile string) *Server {
+// NewServer creates a new API server instance. The data root must exist.
+func NewServer(listenAddr, dataRoot string, stdoutFile string, auditLog *audit.Logger) (*Server, error) {
+	root, err := fsroot.Open(dataRoot)
+	if err != nil {
+		return nil, fmt.Errorf("opening data root: %w", err)
+	}
+	stdoutLogName, err := root.Rel(stdoutFile)
+	if err != nil {
+		return nil, fmt.Errorf("invalid stdout log file %q: %w", stdoutFile, err)
+	}
+
+	// Path rules are fixed at startup; a malformed pattern is a configuration
+	// error rather than something to silently ignore.
+	readOnly, _ := strconv.ParseBool(os.Getenv("SIDECAR_READ_ONLY"))
+	var rules []policy.Rule
+	rules = append(rules, policy.Rules(os.Getenv("SIDECAR_HIDDEN_PATHS"), policy.Hidden, false)...)
+	rules = append(rules, policy.Rules(os.Getenv("SIDECAR_READONLY_PATHS"), policy.ReadOnly, false)...)
+	rules = append(rules, policy.Rules(os.Getenv("SIDECAR_RUNNING_READONLY_PATHS"), policy.ReadOnly, true)...)
+	rules = append(rules, policy.Rules(os.Getenv("SIDECAR_PROTECTED_PATHS"), policy.Protected, false)...)
+	pathPolicy, err := policy.New(readOnly, rules)
+	if err != nil {
+		return nil, fmt.Errorf("invalid path policy: %w", err)
+	}
+
+	// Uploads are checked by extension, sniffed type, size per path and an
+	// optional scanner. Leaving SIDECAR_UPLOAD_DENY_EXTS unset keeps the
+	// built-in blocklist; setting it empty denies no extension.
+	denyExts := uploadpolicy.DefaultDenyExts
+	if v, ok := os.LookupEnv("SIDECAR_UPLOAD_DENY_EXTS"); ok {
+		denyExts = uploadpolicy.List(v)
+	}
+	maxSizes, err := uploadpolicy.ParseSizes(os.Getenv("SIDECAR_UPLOAD_MAX_SIZES"))
+	if err != nil {
+		return nil, err
+	}
+	scanTimeout := time.Minute
+	if v, err := time.ParseDuration(os.Getenv("SIDECAR_UPLOAD_SCAN_TIMEOUT")); err == nil && v > 0 {
+		scanTimeout = v
+	}
+	uploadPolicy, err := uploadpolicy.New(uploadpolicy.Config{
+		AllowExts:   uploadpolicy.List(os.Getenv("SIDECAR_UPLOAD_ALLOW_EXTS")),
+		DenyExts:    denyExts,
+		AllowTypes:  uploadpolicy.List(os.Getenv("SIDECAR_UPLOAD_ALLOW_TYPES")),
+		DenyTypes:   uploadpolicy.List(os.Getenv("SIDECAR_UPLOAD_DENY_TYPES")),
+		MaxSizes:    maxSizes,
+		ScanCommand: strings.Fields(os.Getenv("SIDECAR_UPLOAD_SCAN_COMMAND")),
+		ScanTimeout: scanTimeout,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// New files and directories get these modes regardless of the umask and,
+	// if configured, an owner, so the game server's user can use them.
+	fileMode, dirMode := os.FileMode(0644), os.FileMode(0755)
+	if v := os.Getenv("SIDECAR_FILE_MODE"); v != "" {
+		if fileMode, err = filemode.ParseOctal(v); err != nil {
+			return nil, fmt.Errorf("SIDECAR_FILE_MODE: %w", err)
+		}
+	}
+	if v := os.Getenv("SIDECAR_DIR_MODE"); v != "" {
+		if dirMode, err = filemode.ParseOctal(v); err != nil {
+			return nil, fmt.Errorf("SIDECAR_DIR_MODE: %w", err)
+		}
+	}
+	fileUID, err := envID("SIDECAR_FILE_UID")
+	if err != nil {
+		return nil, err
+	}
+	fileGID, err := envID("SIDECAR_FILE_GID")
+	if err != nil {
+		return nil, err
+	}
+	chownUIDs, err := parseIDs(os.Getenv("SIDECAR_CHOWN_UIDS"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_CHOWN_UIDS: %w", err)
+	}
+	chownGIDs, err := parseIDs(os.Getenv("SIDECAR_CHOWN_GIDS"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_CHOWN_GIDS: %w", err)
+	}
+
+	// Snapshots are only taken once a backup directory is configured. The
+	// hooks let the game server flush and pause saving around a snapshot.
+	var backups *backup.Dir
+	if v := os.Getenv("SIDECAR_BACKUP_DIR"); v != "" {
+		if backups, err = openBackupDir(v, root.Dir()); err != nil {
+			return nil, fmt.Errorf("SIDECAR_BACKUP_DIR: %w", err)
+		}
+	}
+	backupFormat, err := parseBackupFormat(os.Getenv("SIDECAR_BACKUP_FORMAT"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_BACKUP_FORMAT: %w", err)
+	}
+	backupPaths, err := parseBackupPaths(os.Getenv("SIDECAR_BACKUP_PATHS"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_BACKUP_PATHS: %w", err)
+	}
+	backupExclude, err := parseBackupExclude(os.Getenv("SIDECAR_BACKUP_EXCLUDE"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_BACKUP_EXCLUDE: %w", err)
+	}
+	backupHookTimeout := 5 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("SIDECAR_BACKUP_HOOK_TIMEOUT")); err == nil && v > 0 {
+		backupHookTimeout = v
+	}
+	// Scheduled snapshots are pruned by the retention policy; others are
+	// kept until deleted.
+	backupSchedule, err := parseBackupSchedule(os.Getenv("SIDECAR_BACKUP_SCHEDULE"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_BACKUP_SCHEDULE: %w", err)
+	}
+	if backupSchedule != nil && backups == nil {
+		return nil, errors.New("SIDECAR_BACKUP_SCHEDULE: needs SIDECAR_BACKUP_DIR")
+	}
+	backupRetention, err := parseBackupRetention()
+	if err != nil {
+		return nil, err
+	}
+	remote, remotePrefix, err := parseBackupRemote()
+	if err != nil {
+		return nil, err
+	}
+	if remote != nil && backups == nil {
+		return nil, errors.New("SIDECAR_BACKUP_S3_BUCKET: needs SIDECAR_BACKUP_DIR")
+	}
+	hostname, _ := os.Hostname()
 
 	// Get rate limit from environment variable, default to 60 requests per minute
 	rateLimit := 60
@@ -48,25 +250,128 @@ func NewServer(listenAddr, dataRoot string, stdoutFile string) *Server {
 		}
 	}
 
-	return &Server{
-		listenAddr:    listenAddr,
-		dataRoot:      dataRoot,
-		logger:        slog.With("component", "api-server"),
-		stdoutLogPath: filepath.Join(dataRoot, stdoutFile),
-		requestCounts: make(map[string]int),
-		rateLimit:     rateLimit,
+	// X-Forwarded-For is ignored unless the request comes through one of
+	// these proxies.
+	trustedProxies, err := parseTrustedProxies(os.Getenv("SIDECAR_TRUSTED_PROXIES"))
+	if err != nil {
+		return nil, fmt.Errorf("SIDECAR_TRUSTED_PROXIES: %w", err)
+	}
+
+	// Limits for archive extraction: 2 GiB uncompressed and 10000 entries by default.
+	extractMaxBytes := int64(2 * 1024 * 1024 * 1024)
+	if v, err := strconv.ParseInt(os.Getenv("SIDECAR_EXTRACT_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		extractMaxBytes = v
+	}
+	extractMaxFiles := 10000
+	if v, err := strconv.Atoi(os.Getenv("SIDECAR_EXTRACT_MAX_FILES")); err == nil && v > 0 {
+		extractMaxFiles = v
+	}
+
+	// Text files larger than 1 MiB are not served by the content endpoints by default.
+	textMaxBytes := int64(1024 * 1024)
+	if v, err := strconv.ParseInt(os.Getenv("SIDECAR_TEXT_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		textMaxBytes = v
+	}
+
+	// Resumable upload sessions expire after 24 hours without new data by default.
+	uploadTTL := 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("SIDECAR_UPLOAD_TTL")); err == nil && v > 0 {
+		uploadTTL = v
+	}
+
+	// Disk usage is rescanned every 5 minutes by default; a quota of 0 means none.
+	usageInterval := 5 * time.Minute
+	if v, err := time.ParseDuration(os.Getenv("SIDECAR_USAGE_INTERVAL")); err == nil && v > 0 {
+		usageInterval = v
+	}
+	var quotaBytes int64
+	if v, err := strconv.ParseInt(os.Getenv("SIDECAR_QUOTA_BYTES"), 10, 64); err == nil && v > 0 {
+		quotaBytes = v
 	}
+	// Deleted items stay in the trash for 7 days by default; a retention of 0
+	// disables the trash, and a size limit of 0 means none.
+	trashRetention := 7 * 24 * time.Hour
+	if v, err := time.ParseDuration(os.Getenv("SIDECAR_TRASH_RETENTION")); err == nil && v >= 0 {
+		trashRetention = v
+	}
+	var trashMaxBytes int64
+	if v, err := strconv.ParseInt(os.Getenv("SIDECAR_TRASH_MAX_BYTES"), 10, 64); err == nil && v > 0 {
+		trashMaxBytes = v
+	}
+	// Each watch stream holds an inotify instance, of which a user gets 128 by default.
+	watchDebounce, watchMaxStreams := 250*time.Millisecond, 16
+	if v, err := time.ParseDuration(os.Getenv("SIDECAR_WATCH_DEBOUNCE")); err == nil && v > 0 {
+		watchDebounce = v
+	}
+	if v, err := strconv.Atoi(os.Getenv("SIDECAR_WATCH_MAX_STREAMS")); err == nil && v > 0 {
+		watchMaxStreams = v
+	}
+
+	tracker := usage.NewTracker(root.FS())
+	tracker.OnScan = func(snap *usage.Snapshot) {
+		metrics.DataRootBytes.Set(float64(snap.Dirs["."].Size))
+	}
+	metrics.DataRootQuotaBytes.Set(float64(quotaBytes))
+
+	return &Server{
+		listenAddr:      listenAddr,
+		dataRoot:        root.Dir(),
+		root:            root,
+		logger:          slog.With("component", "api-server"),
+		audit:           auditLog,
+		policy:          pathPolicy,
+		uploadPolicy:    uploadPolicy,
+		stdoutLogName:   stdoutLogName,
+		requestCounts:   make(map[string]int),
+		rateLimit:       rateLimit,
+		trustedProxies:  trustedProxies,
+		extractMaxBytes: extractMaxBytes,
+		extractMaxFiles: extractMaxFiles,
+		textMaxBytes:    textMaxBytes,
+		uploadTTL:       uploadTTL,
+		activeUploads:   make(map[string]bool),
+		usage:           tracker,
+		usageInterval:   usageInterval,
+		quotaBytes:      quotaBytes,
+		trashRetention:  trashRetention,
+		trashMaxBytes:   trashMaxBytes,
+		watchDebounce:   watchDebounce,
+		watchStreams:    make(chan struct{}, watchMaxStreams),
+		fileMode:        fileMode,
+		dirMode:         dirMode,
+		fileUID:         fileUID,
+		fileGID:         fileGID,
+		chownUIDs:       chownUIDs,
+		chownGIDs:       chownGIDs,
+
+		backups:           backups,
+		backupFormat:      backupFormat,
+		backupPaths:       backupPaths,
+		backupExclude:     backupExclude,
+		backupPreHook:     strings.Fields(os.Getenv("SIDECAR_BACKUP_PRE_COMMAND")),
+		backupPostHook:    strings.Fields(os.Getenv("SIDECAR_BACKUP_POST_COMMAND")),
+		backupHookTimeout: backupHookTimeout,
+		restorePreHook:    strings.Fields(os.Getenv("SIDECAR_RESTORE_PRE_COMMAND")),
+		restorePostHook:   strings.Fields(os.Getenv("SIDECAR_RESTORE_POST_COMMAND")),
+		backupSchedule:    backupSchedule,
+		backupRetention:   backupRetention,
+		remote:            remote,
+		remotePrefix:      remotePrefix,
+		gameServerName:    hostname,
+	}, nil
 }
 
 // responseWriter is a wrapper for http.ResponseWriter that captures the status code
+// and the number of body bytes written
 type responseWriter struct {
 	http.ResponseWriter
 	statusCode int
+	bytes      int64
 }
 
 func newResponseWriter(w http.ResponseWriter) *responseWriter {
 	// Default to 200 OK if WriteHeader is not called
-	return &responseWriter{w, http.StatusOK}
+	return &responseWriter{ResponseWriter: w, statusCode: http.StatusOK}
 }
 
 func (rw *responseWriter) WriteHeader(code int) {
@@ -74,21 +379,48 @@ func (rw *responseWriter) WriteHeader(code int) {
 	rw.ResponseWriter.WriteHeader(code)
 }
 
+func (rw *responseWriter) Write(b []byte) (int, error) {
+	n, err := rw.ResponseWriter.Write(b)
+	rw.bytes += int64(n)
+	return n, err
+}
+
 func (rw *responseWriter) Flush() {
 	if flusher, ok := rw.ResponseWriter.(http.Flusher); ok {
 		flusher.Flush()
 	}
 }
 
-// loggingMiddleware logs incoming HTTP requests
+// loggingMiddleware assigns a request ID, logs incoming HTTP requests and records request metrics
 func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		wrappedWriter := newResponseWriter(w)
 
+		// Reuse the caller's request ID so a single operation can be traced across services.
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(requestIDHeader, id)
+		r = s.withRequestID(r, id)
+
 		next.ServeHTTP(wrappedWriter, r) // Serve the request
 
-		s.logger.Info("HTTP Request",
+		// The mux records the matched pattern on the request; use it as the route label
+		// so metrics cardinality stays bounded regardless of query strings or 404s.
+		// The method has a label of its own, so it is cut from the pattern.
+		route := r.Pattern
+		if _, path, ok := strings.Cut(route, " "); ok {
+			route = path
+		}
+		if route == "" {
+			route = "unmatched"
+		}
+		metrics.HTTPRequests.Inc(route, r.Method, strconv.Itoa(wrappedWriter.statusCode))
+		metrics.HTTPRequestDuration.Observe(time.Since(start).Seconds(), route, r.Method)
+
+		s.log(r).Info("HTTP Request",
 			"method", r.Method,
 			"path", r.URL.Path,
 			"remote_addr", r.RemoteAddr,
@@ -103,17 +435,14 @@ func (s *Server) loggingMiddleware(next http.Handler) http.Handler {
 func (s *Server) rateLimitRequest(next http.Handler) http.Handler {
 	// FIXME: This does not work
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		// Skip rate limiting for health check endpoint
-		if r.URL.Path == "/health" {
+		// Skip rate limiting for health check and metrics endpoints
+		if r.URL.Path == "/health" || r.URL.Path == "/metrics" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Get client IP
-		ip := r.RemoteAddr
-		if forwardedFor := r.Header.Get("X-Forwarded-For"); forwardedFor != "" {
-			ip = strings.Split(forwardedFor, ",")[0]
-		}
+		ip := s.clientIP(r)
 
 		// Check rate limit
 		s.rateLimitMu.Lock()
@@ -137,7 +466,8 @@ func (s *Server) rateLimitRequest(next http.Handler) http.Handler {
 		s.rateLimitMu.Unlock()
 
 		if exceeded {
-			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
+			metrics.RateLimitRejections.Inc()
+			s.writeError(w, r, http.StatusTooManyRequests, apitypes.CodeRateLimited, "Rate limit exceeded", nil)
 			return
 		}
 
@@ -148,14 +478,9 @@ func (s *Server) rateLimitRequest(next http.Handler) http.Handler {
 // Run starts the HTTP server and handles graceful shutdown.
 func (s *Server) Run(ctx context.Context) {
 	mux := http.NewServeMux()
-	mux.HandleFunc("GET /health", s.healthCheckHandler)
-	mux.HandleFunc("GET /api/files", s.listFilesHandler)
-	mux.HandleFunc("GET /api/files/download", s.downloadFileHandler)
-	mux.HandleFunc("POST /api/files/upload", s.uploadFileHandler)
-	mux.HandleFunc("POST /api/files/delete", s.deleteFileHandler)
-	mux.HandleFunc("POST /api/files/create-dir", s.createDirHandler)
-
-	mux.HandleFunc("GET /api/logs/stream", s.streamStdoutLogHandler)
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.Method+" "+rt.Path, rt.Handler)
+	}
 
 	// Create a handler chain with our middleware. Order matters: requests flow from bottom to top.
 	var handler http.Handler = mux
@@ -174,6 +499,18 @@ func (s *Server) Run(ctx context.Context) {
 		}
 	}()
 
+	go s.cleanupUploads(ctx)
+	go s.usage.Run(ctx, s.usageInterval)
+	if s.trashEnabled() {
+		go s.cleanupTrash(ctx)
+	}
+	if s.backups != nil {
+		s.updateBackupMetrics()
+	}
+	if s.backupSchedule != nil {
+		go s.runBackupSchedule(ctx)
+	}
+
 	<-ctx.Done()
 	s.logger.Info("Shutting down API server...")
 	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
@@ -184,118 +521,182 @@ func (s *Server) Run(ctx context.Context) {
 }
 
 // sanitizePath cleans and validates a user-provided path.
-// It ensures the resulting path is a clean, absolute path still within the allowed dataRoot.
-// This is the primary security function to prevent path traversal attacks.
+// It returns the path relative to the data root, suitable for use with s.root.
+// The sidecar's own internal directory and hidden paths are refused, whether
+// named directly or reached through symlinks. Links that lead outside of the
+// data root are refused here if a parent directory is one, and otherwise by
+// the rooted filesystem when the path is actually used.
 func (s *Server) sanitizePath(userPath string) (string, error) {
-	// Join the root with the user-provided path and clean it up (resolves .., ., //, etc.)
-	fullPath := filepath.Join(s.dataRoot, userPath)
-
-	// Security check: ensure the final, cleaned path still starts with our root directory.
-	if !strings.HasPrefix(fullPath, s.dataRoot) {
-		return "", fmt.Errorf("invalid path: access denied")
+	name, err := s.root.Rel(userPath)
+	if err != nil {
+		return "", err
+	}
+	names, err := s.policyNames(name, policy.Read)
+	if err != nil {
+		return "", err
 	}
-	return fullPath, nil
+	for _, n := range names {
+		if isInternalPath(n) {
+			return "", errInternalPath
+		}
+		if err := s.policy.Check(filepath.ToSlash(n), policy.Read); err != nil {
+			return "", err
+		}
+	}
+	return name, nil
 }
 
-// listFilesHandler handles requests to list directory contents.
-func (s *Server) listFilesHandler(w http.ResponseWriter, r *http.Request) {
-	path := r.URL.Query().Get("path")
-	fullPath, err := s.sanitizePath(path)
+// policyNames returns the root-relative names op on name is checked under:
+// name itself and, where symlinks along it lead elsewhere, the path they
+// lead to. A final link is followed unless op is a delete, which removes the
+// link itself; if it leads out of the data root, using it is refused anyway.
+func (s *Server) policyNames(name string, op policy.Op) ([]string, error) {
+	names := []string{name}
+	parent, err := s.root.Resolve(filepath.Dir(name))
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
-		return
+		return nil, err
+	}
+	if resolved := filepath.Join(parent, filepath.Base(name)); resolved != name {
+		names = append(names, resolved)
 	}
+	if op != policy.Delete {
+		if target, err := s.root.Resolve(name); err == nil && !slices.Contains(names, target) {
+			names = append(names, target)
+		}
+	}
+	return names, nil
+}
 
-	dirEntries, err := os.ReadDir(fullPath)
+// isInternalPath reports whether a root-relative name is inside internalDir.
+func isInternalPath(name string) bool {
+	return name == internalDir || strings.HasPrefix(name, internalDir+string(filepath.Separator))
+}
+
+// hiddenPath reports whether a root-relative name must be left out of
+// listings, searches and archives: the internal directory and hidden paths.
+func (s *Server) hiddenPath(name string) bool {
+	return isInternalPath(name) || s.policy.Hidden(filepath.ToSlash(name))
+}
+
+// checkPolicy returns a *policy.Violation if op on the root-relative name is
+// forbidden, under its own name or the one symlinks along it lead to. Deletes
+// also check everything below name.
+func (s *Server) checkPolicy(name string, op policy.Op) error {
+	if op == policy.Delete {
+		return s.checkPolicyTree(name, op)
+	}
+	names, err := s.policyNames(name, op)
 	if err != nil {
-		s.logger.Error("Failed to read directory", "path", fullPath, "error", err)
-		http.Error(w, "Could not read directory", http.StatusInternalServerError)
-		return
+		return err
 	}
-
-	var files []FileInfo
-	for _, entry := range dirEntries {
-		info, err := entry.Info()
-		if err != nil {
-			s.logger.Warn("Could not get file info for entry, skipping", "entry", entry.Name(), "error", err)
-			continue
+	for _, n := range names {
+		if err := s.policy.Check(filepath.ToSlash(n), op); err != nil {
+			return err
 		}
-		files = append(files, FileInfo{
-			Name:     info.Name(),
-			Size:     info.Size(),
-			IsDir:    info.IsDir(),
-			Modified: info.ModTime(),
-		})
 	}
+	return nil
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(files); err != nil {
-		s.logger.Error("Failed to encode file list to JSON", "error", err)
+// checkPolicyTree is checkPolicy for name and every entry below it.
+func (s *Server) checkPolicyTree(name string, op policy.Op) error {
+	names, err := s.policyNames(name, op)
+	if err != nil {
+		return err
+	}
+	for _, n := range names {
+		if err := s.policy.CheckTree(s.root.FS(), filepath.ToSlash(n), op); err != nil {
+			return err
+		}
 	}
+	return nil
 }
 
-// downloadFileHandler serves a single file for download.
+// downloadFileHandler serves a single file for download, or a directory as a
+// streamed archive. Files carry an ETag and Last-Modified, and support Range,
+// If-Range and the other conditional headers, so interrupted downloads can be
+// resumed. The ETag is derived from size and modification time unless
+// etag=content asks for a hash of the content.
 func (s *Server) downloadFileHandler(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
-	fullPath, err := s.sanitizePath(path)
+	name, err := s.sanitizePath(path)
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	etagMode := r.URL.Query().Get("etag")
+	if etagMode != "" && etagMode != "stat" && etagMode != "content" {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'etag', expected stat or content", nil)
+		return
+	}
+
+	file, err := s.root.Open(name)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeFSError(w, r, err, "Could not access file")
 		return
 	}
+	defer file.Close()
 
-	info, err := os.Stat(fullPath)
+	info, err := file.Stat()
 	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "File not found", http.StatusNotFound)
-			return
-		}
-		http.Error(w, "Could not access file", http.StatusInternalServerError)
+		s.writeFSError(w, r, err, "Could not access file")
 		return
 	}
 
 	if info.IsDir() {
-		http.Error(w, "Cannot download a directory", http.StatusBadRequest)
+		s.downloadArchive(w, r, name)
 		return
 	}
 
-	w.Header().Set("Content-Disposition", "attachment; filename="+filepath.Base(fullPath))
-	http.ServeFile(w, r, fullPath)
+	etag := fileETag(info)
+	if etagMode == "content" {
+		if etag, err = hashETag(file); err != nil {
+			s.writeFSError(w, r, err, "Could not read file")
+			return
+		}
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Content-Disposition", attachment(filepath.Base(name)))
+	rw := newResponseWriter(w)
+	http.ServeContent(rw, r, info.Name(), info.ModTime(), file)
+	metrics.BytesDownloaded.Add(float64(rw.bytes))
 }
 
 // uploadFileHandler handles multipart file uploads.
 func (s *Server) uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	path := r.URL.Query().Get("path")
-	fullPath, err := s.sanitizePath(path)
+	dirName, err := s.sanitizePath(path)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeFSError(w, r, err, "Invalid path")
 		return
 	}
 
 	// Check if destination directory exists
-	dirInfo, err := os.Stat(fullPath)
+	dirInfo, err := s.root.Stat(dirName)
 	if err != nil {
-		if os.IsNotExist(err) {
-			http.Error(w, "Destination directory does not exist", http.StatusBadRequest)
-		} else {
-			s.logger.Error("Failed to check destination directory", "path", fullPath, "error", err)
-			http.Error(w, "Could not access destination directory", http.StatusInternalServerError)
-		}
+		s.writeFSError(w, r, err, "Could not access destination directory")
 		return
 	}
 
 	// Ensure the destination is a directory
 	if !dirInfo.IsDir() {
-		http.Error(w, "Destination path is not a directory", http.StatusBadRequest)
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeNotDirectory, "Destination path is not a directory", nil)
 		return
 	}
 
-	// Limit upload size (e.g., 500 MB) to prevent abuse.
-	r.Body = http.MaxBytesReader(w, r.Body, 500*1024*1024)
+	// Limit upload size to prevent abuse.
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
 
 	file, header, err := r.FormFile("file")
 	if err != nil {
-		s.logger.Warn("Invalid file upload attempt", "error", err)
-		http.Error(w, "Invalid file upload: "+err.Error(), http.StatusBadRequest)
+		s.log(r).Warn("Invalid file upload attempt", "error", err)
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			s.writeError(w, r, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge, "Upload exceeds the maximum request size",
+				map[string]any{"limit": maxBytesErr.Limit})
+			return
+		}
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid file upload", map[string]any{"error": err.Error()})
 		return
 	}
 	defer file.Close()
@@ -303,92 +704,136 @@ func (s *Server) uploadFileHandler(w http.ResponseWriter, r *http.Request) {
 	// Basic file type validation - check file extension
 	// This is a simple example - a production system would use more robust validation
 	filename := header.Filename
-	ext := strings.ToLower(filepath.Ext(filename))
-
-	// List of potentially dangerous extensions
-	dangerousExts := map[string]bool{
-		".exe": true, ".dll": true, ".sh": true, ".bat": true, ".cmd": true,
-		".php": true, ".phtml": true, ".js": true, ".jsp": true, ".asp": true,
+	if err := s.checkUploadName(filename); err != nil {
+		s.log(r).Warn("Rejected upload by content policy", "filename", filename, "error", err)
+		s.writeFSError(w, r, err, "File type not allowed for security reasons")
+		return
 	}
 
-	if dangerousExts[ext] {
-		s.logger.Warn("Attempted upload of potentially dangerous file type", "filename", filename, "extension", ext)
-		http.Error(w, "File type not allowed for security reasons", http.StatusBadRequest)
+	// Final security check on the combined path to ensure no funny business in the filename:
+	// the destination must be a direct child of the requested directory.
+	destName, err := s.sanitizePath(filepath.Join(dirName, filename))
+	var violation *policy.Violation
+	if errors.As(err, &violation) {
+		s.writeFSError(w, r, err, "Operation not allowed by path policy")
 		return
 	}
-
-	destPath := filepath.Join(fullPath, filename)
-
-	// Final security check on the combined path to ensure no funny business in the filename.
-	if !strings.HasPrefix(destPath, fullPath) {
-		http.Error(w, "Invalid destination filename", http.StatusBadRequest)
+	if err != nil || filepath.Dir(destName) != dirName {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Invalid destination filename", nil)
+		return
+	}
+	if !s.authorize(w, r, destName, policy.Write) {
+		return
+	}
+	if err := s.checkUploadSize(destName, header.Size); err != nil {
+		s.log(r).Warn("Rejected upload by content policy", "path", destName, "error", err)
+		s.writeFSError(w, r, err, "File exceeds the size limit for its path")
 		return
 	}
 
 	// Check if file already exists
 	overwrite := r.URL.Query().Get("overwrite") == "true"
-	if _, err := os.Stat(destPath); err == nil && !overwrite {
-		http.Error(w, "File already exists. Use overwrite=true to replace it.", http.StatusConflict)
+	if _, err := s.root.Lstat(destName); err == nil && !overwrite {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeAlreadyExists, "File already exists. Use overwrite=true to replace it.", nil)
 		return
 	}
 
-	// Create the file with appropriate permissions
-	dst, err := os.OpenFile(destPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
-	if err != nil {
-		s.logger.Error("Failed to create file for upload", "path", destPath, "error", err)
-		http.Error(w, "Could not save file", http.StatusInternalServerError)
+	// Optional digests of the file content, verified before the file replaces the destination.
+	var expected expectedChecksums
+	var ok bool
+	if expected.SHA256, ok = parseHexDigest(r.Header.Get("X-Checksum-SHA256"), sha256.Size); !ok {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "X-Checksum-SHA256 must be a hex-encoded SHA-256 digest", nil)
+		return
+	}
+	if expected.MD5, ok = parseHexDigest(r.Header.Get("X-Checksum-MD5"), md5.Size); !ok {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "X-Checksum-MD5 must be a hex-encoded MD5 digest", nil)
 		return
 	}
-	defer dst.Close()
 
 	// Log the upload attempt
-	s.logger.Info("File upload in progress",
+	s.log(r).Info("File upload in progress",
 		"filename", filename,
 		"size", header.Size,
-		"destination", destPath,
+		"destination", destName,
 		"client_ip", r.RemoteAddr)
 
-	if _, err := io.Copy(dst, file); err != nil {
-		s.logger.Error("Failed to copy uploaded file content", "path", destPath, "error", err)
-		http.Error(w, "Could not save file", http.StatusInternalServerError)
+	if err := s.reserve(r.Context(), header.Size); err != nil {
+		s.writeFSError(w, r, err, "Could not save file")
+		return
+	}
+
+	// The upload is written next to the destination and renamed over it only
+	// once complete, so a failed upload never leaves a truncated file behind.
+	written, sums, err := s.writeFileAtomic(destName, file, expected, func(staged string) error {
+		return s.checkStagedUpload(r.Context(), destName, staged)
+	})
+	metrics.BytesUploaded.Add(float64(written))
+	if err == nil {
+		s.usage.Add(written)
+	}
+	s.recordAudit(r, "file.upload", destName, written, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not save file")
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, "File uploaded successfully")
-	s.logger.Info("File upload completed successfully", "path", destPath, "size", header.Size)
+	s.writeResult(w, r, http.StatusCreated, apitypes.Result{
+		Message: "File uploaded successfully", Path: destName, Bytes: written, SHA256: sums.SHA256, MD5: sums.MD5,
+	})
+	s.log(r).Info("File upload completed successfully", "path", destName, "size", written, "sha256", sums.SHA256)
 }
 
-// deleteFileHandler deletes a file or directory recursively.
+// deleteFileHandler deletes a file or directory recursively. Unless the trash
+// is disabled or the caller asks for a permanent delete, the item is moved to
+// the trash instead, from where it can be restored.
 func (s *Server) deleteFileHandler(w http.ResponseWriter, r *http.Request) {
-	var payload struct {
-		Path string `json:"path"`
-	}
+	var payload apitypes.DeleteRequest
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
 		return
 	}
 
-	fullPath, err := s.sanitizePath(payload.Path)
+	name, err := s.sanitizePath(payload.Path)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeFSError(w, r, err, "Invalid path")
 		return
 	}
 
 	// Important safety check: Do not allow deletion of the root directory itself.
-	if fullPath == s.dataRoot {
-		http.Error(w, "Cannot delete root directory", http.StatusBadRequest)
+	if name == "." {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Cannot delete root directory", nil)
+		return
+	}
+	if !s.authorize(w, r, name, policy.Delete) {
+		return
+	}
+
+	if s.trashEnabled() && !payload.Permanent {
+		item, err := s.moveToTrash(r.Context(), name, s.clientIP(r))
+		if err != nil {
+			s.recordAudit(r, "file.delete", name, 0, err)
+			s.writeFSError(w, r, err, "Could not delete item")
+			return
+		}
+		s.recordAuditTransfer(r, "file.delete", name, trashItemName(item.ID), item.Size, nil)
+		s.trashMu.Lock()
+		s.trimTrash()
+		s.trashMu.Unlock()
+		s.writeResult(w, r, http.StatusOK, apitypes.Result{
+			Message: "Item moved to trash", Path: name, Bytes: item.Size, Files: item.Files, TrashID: item.ID,
+		})
 		return
 	}
 
-	if err := os.RemoveAll(fullPath); err != nil {
-		s.logger.Error("Failed to delete file/directory", "path", fullPath, "error", err)
-		http.Error(w, "Could not delete item", http.StatusInternalServerError)
+	err = s.root.RemoveAll(name)
+	s.usage.Refresh()
+	s.recordAudit(r, "file.delete", name, 0, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not delete item")
 		return
 	}
 
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Item deleted successfully")
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Item deleted successfully", Path: name})
 }
 
 // createDirHandler creates a new directory.
@@ -397,30 +842,34 @@ func (s *Server) createDirHandler(w http.ResponseWriter, r *http.Request) {
 		Path string `json:"path"`
 	}
 	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
 		return
 	}
 
-	fullPath, err := s.sanitizePath(payload.Path)
+	name, err := s.sanitizePath(payload.Path)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		s.writeFSError(w, r, err, "Invalid path")
 		return
 	}
 
-	if err := os.MkdirAll(fullPath, 0755); err != nil {
-		s.logger.Error("Failed to create directory", "path", fullPath, "error", err)
-		http.Error(w, "Could not create directory", http.StatusInternalServerError)
+	if !s.authorize(w, r, name, policy.Write) {
+		return
+	}
+
+	err = s.mkdirAll(name)
+	s.recordAudit(r, "file.mkdir", name, 0, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not create directory")
 		return
 	}
 
-	w.WriteHeader(http.StatusCreated)
-	fmt.Fprintln(w, "Directory created successfully")
+	s.writeResult(w, r, http.StatusCreated, apitypes.Result{Message: "Directory created successfully", Path: name})
 }
 
 func (s *Server) streamStdoutLogHandler(w http.ResponseWriter, r *http.Request) {
 	const initialLogLines = 100
 
-	log := s.logger.With("handler", "streamStdoutLog", "path", s.stdoutLogPath)
+	log := s.log(r).With("handler", "streamStdoutLog", "path", s.root.Join(s.stdoutLogName))
 	log.Info("Log stream connection initiated.")
 
 	w.Header().Set("Content-Type", "text/event-stream")
@@ -431,16 +880,16 @@ func (s *Server) streamStdoutLogHandler(w http.ResponseWriter, r *http.Request)
 	flusher, ok := w.(http.Flusher)
 	if !ok {
 		log.Error("Streaming unsupported by the connection")
-		http.Error(w, "Streaming unsupported!", http.StatusInternalServerError)
+		s.writeError(w, r, http.StatusInternalServerError, apitypes.CodeInternal, "Streaming unsupported", nil)
 		return
 	}
 
 	// For a more robust solution, consider a library like "github.com/nxadm/tail"
 	// but for simplicity, a basic tailing loop is shown here.
-	file, err := os.Open(s.stdoutLogPath)
+	file, err := s.root.Open(s.stdoutLogName)
 	if err != nil {
 		log.Error("Could not open log file for streaming", "error", err)
-		http.Error(w, "Log file not available", http.StatusNotFound)
+		s.writeFSError(w, r, err, "Log file not available")
 		return
 	}
 	defer file.Close()
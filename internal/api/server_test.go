@@ -0,0 +1,350 @@
+package api
+
+import (
+	"bytes"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/internal/fsroot"
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// newTestServer returns a server for a data root laid out like the one in
+// the fsroot tests: a sibling directory sharing the root's name as a prefix,
+// and links that lead out of the root next to ones that stay inside.
+func newTestServer(t *testing.T) (*Server, string) {
+	t.Helper()
+	base := t.TempDir()
+	data := filepath.Join(base, "data")
+	for _, dir := range []string{filepath.Join(data, "sub"), filepath.Join(base, "data-other")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(base, "outside"):              "outside",
+		filepath.Join(base, "data-other", "secret"): "secret",
+		filepath.Join(data, "sub", "ok.txt"):        "ok",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"in":     "sub/ok.txt",
+		"out":    filepath.Join(base, "outside"),
+		"outdir": base,
+		"other":  "../data-other",
+	} {
+		if err := os.Symlink(target, filepath.Join(data, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s, err := NewServer(":0", data, "logs/stdout.log", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.root.Close() })
+	return s, base
+}
+
+// testHandler routes requests the way Run does, without the middleware.
+func testHandler(s *Server) http.Handler {
+	mux := http.NewServeMux()
+	for _, rt := range s.routes() {
+		mux.HandleFunc(rt.Method+" "+rt.Path, rt.Handler)
+	}
+	return mux
+}
+
+func TestSanitizePath(t *testing.T) {
+	s, _ := newTestServer(t)
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"", "."},
+		{"/", "."},
+		{"/sub/ok.txt", "sub/ok.txt"},
+		{"sub/../in", "in"},
+		{"..", ""},
+		{"../data-other/secret", ""},
+		{"/../outside", ""},
+		{"sub/../../outside", ""},
+		{internalDir, ""},
+		{"/" + internalDir + "/uploads", ""},
+		{"sub/../" + internalDir, ""},
+	} {
+		got, err := s.sanitizePath(tt.path)
+		if tt.want == "" {
+			if !errors.Is(err, fsroot.ErrEscape) {
+				t.Errorf("sanitizePath(%q): got error %v, want ErrEscape", tt.path, err)
+			}
+			continue
+		}
+		if err != nil || got != filepath.FromSlash(tt.want) {
+			t.Errorf("sanitizePath(%q) = %q, %v, want %q", tt.path, got, err, tt.want)
+		}
+	}
+}
+
+// TestHandlersRejectEscapes sends paths that climb out of the data root, or
+// go through links that do, to the endpoints that take a path.
+func TestHandlersRejectEscapes(t *testing.T) {
+	escapes := []string{
+		"..",
+		"../data-other/secret",
+		"/../outside",
+		"outdir/outside",
+		"other/secret",
+	}
+	type request struct{ method, target, body string }
+	endpoints := map[string]func(p string) request{
+		"list": func(p string) request {
+			return request{"GET", "/api/files?path=" + p, ""}
+		},
+		"download": func(p string) request {
+			return request{"GET", "/api/files/download?path=" + p, ""}
+		},
+		"content": func(p string) request {
+			return request{"GET", "/api/files/content?path=" + p, ""}
+		},
+		"create-dir": func(p string) request {
+			return request{"POST", "/api/files/create-dir", `{"path":"` + p + `/new"}`}
+		},
+		"delete": func(p string) request {
+			return request{"POST", "/api/files/delete", `{"path":"` + p + `","permanent":true}`}
+		},
+		"move from": func(p string) request {
+			return request{"POST", "/api/files/move", `{"source":"` + p + `","destination":"moved"}`}
+		},
+		"move to": func(p string) request {
+			return request{"POST", "/api/files/move", `{"source":"sub/ok.txt","destination":"` + p + `/moved"}`}
+		},
+		"copy from": func(p string) request {
+			return request{"POST", "/api/files/copy", `{"source":"` + p + `","destination":"copied"}`}
+		},
+		"copy to": func(p string) request {
+			return request{"POST", "/api/files/copy", `{"source":"sub/ok.txt","destination":"` + p + `/copied"}`}
+		},
+		"chmod": func(p string) request {
+			return request{"POST", "/api/files/chmod", `{"path":"` + p + `","mode":"600"}`}
+		},
+	}
+	for name, endpoint := range endpoints {
+		for _, p := range escapes {
+			t.Run(name+" "+p, func(t *testing.T) {
+				s, base := newTestServer(t)
+				req := endpoint(p)
+				rr := httptest.NewRecorder()
+				testHandler(s).ServeHTTP(rr, httptest.NewRequest(req.method, req.target, strings.NewReader(req.body)))
+				if rr.Code != http.StatusBadRequest {
+					t.Fatalf("got status %d, want %d: %s", rr.Code, http.StatusBadRequest, rr.Body)
+				}
+				var resp apitypes.ErrorResponse
+				if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+					t.Fatal(err)
+				}
+				if resp.Code != apitypes.CodeInvalidPath {
+					t.Errorf("got code %q, want %q", resp.Code, apitypes.CodeInvalidPath)
+				}
+				checkOutsideUntouched(t, base)
+			})
+		}
+	}
+}
+
+// checkOutsideUntouched fails the test if anything next to the data root of
+// newTestServer was changed.
+func checkOutsideUntouched(t *testing.T, base string) {
+	t.Helper()
+	for name, content := range map[string]string{
+		"outside":                             "outside",
+		filepath.Join("data-other", "secret"): "secret",
+	} {
+		p := filepath.Join(base, name)
+		info, err := os.Stat(p)
+		if err != nil {
+			t.Errorf("%s: %v", p, err)
+			continue
+		}
+		if info.Mode().Perm() != 0o644 {
+			t.Errorf("%s: mode changed to %v", p, info.Mode().Perm())
+		}
+		if data, _ := os.ReadFile(p); string(data) != content {
+			t.Errorf("%s: content changed to %q", p, data)
+		}
+	}
+	entries, err := os.ReadDir(base)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if n := e.Name(); n != "data" && n != "data-other" && n != "outside" {
+			t.Errorf("%s was created outside of the data root", filepath.Join(base, n))
+		}
+	}
+}
+
+// TestPolicyFollowsLinks checks that path rules and the internal directory
+// cannot be got around by going through a symlink.
+func TestPolicyFollowsLinks(t *testing.T) {
+	t.Setenv("SIDECAR_HIDDEN_PATHS", "secret")
+	t.Setenv("SIDECAR_READONLY_PATHS", "ro")
+	for _, tt := range []struct {
+		name       string
+		method     string
+		target     string
+		body       string
+		wantStatus int
+	}{
+		{"read hidden through dir link", "GET", "/api/files/download?path=hid/file", "", http.StatusForbidden},
+		{"read hidden through file link", "GET", "/api/files/download?path=hidfile", "", http.StatusForbidden},
+		{"list hidden through link", "GET", "/api/files?path=hid", "", http.StatusForbidden},
+		{"write read-only through link", "PUT", "/api/files/content?path=rolink/file", `{"content":"x"}`, http.StatusForbidden},
+		{"create in read-only through link", "POST", "/api/files/create-dir", `{"path":"rolink/new"}`, http.StatusForbidden},
+		{"chmod read-only through link", "POST", "/api/files/chmod", `{"path":"rofile","mode":"600"}`, http.StatusForbidden},
+		{"chmod read-only tree through link", "POST", "/api/files/chmod", `{"path":"rolink","mode":"600","recursive":true}`, http.StatusForbidden},
+		{"read internal through link", "GET", "/api/files?path=internal", "", http.StatusBadRequest},
+		{"delete link to read-only", "POST", "/api/files/delete", `{"path":"rofile","permanent":true}`, http.StatusOK},
+		{"read through link", "GET", "/api/files/download?path=in", "", http.StatusOK},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			data := filepath.Join(base, "data")
+			for _, dir := range []string{"secret", "ro", internalDir} {
+				if err := os.MkdirAll(filepath.Join(data, dir), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(data, dir, "file"), []byte("x"), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for link, target := range map[string]string{
+				"hid":      "secret",
+				"hidfile":  "secret/file",
+				"rolink":   "ro",
+				"rofile":   "ro/file",
+				"internal": internalDir,
+			} {
+				if err := os.Symlink(target, filepath.Join(data, link)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if info, err := os.Stat(filepath.Join(data, "ro", "file")); err != nil || info.Mode().Perm() != 0o644 {
+				t.Errorf("read-only file was changed")
+			}
+		})
+	}
+}
+
+func TestRequestMetricsRoute(t *testing.T) {
+	s, _ := newTestServer(t)
+	h := s.loggingMiddleware(testHandler(s))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/api/files?path=sub", nil))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("GET", "/no/such/route", nil))
+
+	rr := httptest.NewRecorder()
+	metrics.Handler().ServeHTTP(rr, httptest.NewRequest("GET", "/metrics", nil))
+	for _, want := range []string{
+		`sidecar_http_requests_total{route="/api/files",method="GET",status="200"}`,
+		`sidecar_http_requests_total{route="unmatched",method="GET",status="404"}`,
+	} {
+		if !strings.Contains(rr.Body.String(), want) {
+			t.Errorf("metrics do not contain %s", want)
+		}
+	}
+	if strings.Contains(rr.Body.String(), `route="GET `) {
+		t.Errorf("route label contains the method:\n%s", rr.Body)
+	}
+}
+
+// uploadRequest builds a single-file upload of content as name into dir.
+func uploadRequest(t *testing.T, dir, name, content string) *http.Request {
+	t.Helper()
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	fw, err := mw.CreateFormFile("file", name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fw.Write([]byte(content))
+	mw.Close()
+	req := httptest.NewRequest("POST", "/api/files/upload?overwrite=true&path="+dir, &body)
+	req.Header.Set("Content-Type", mw.FormDataContentType())
+	return req
+}
+
+// TestUploadChecksum checks that an upload with a digest that does not match
+// its content is refused and leaves the existing file as it was.
+func TestUploadChecksum(t *testing.T) {
+	const content = "new content"
+	sha := sha256.Sum256([]byte(content))
+	md := md5.Sum([]byte(content))
+	wrong := sha256.Sum256([]byte("other"))
+	for _, tt := range []struct {
+		name       string
+		header     map[string]string
+		wantStatus int
+		wantCode   string
+	}{
+		{"no checksum", nil, http.StatusCreated, ""},
+		{"matching", map[string]string{"X-Checksum-SHA256": hex.EncodeToString(sha[:]), "X-Checksum-MD5": hex.EncodeToString(md[:])}, http.StatusCreated, ""},
+		{"sha256 mismatch", map[string]string{"X-Checksum-SHA256": hex.EncodeToString(wrong[:])}, http.StatusUnprocessableEntity, apitypes.CodeChecksumMismatch},
+		{"md5 mismatch", map[string]string{"X-Checksum-MD5": hex.EncodeToString(wrong[:md5.Size])}, http.StatusUnprocessableEntity, apitypes.CodeChecksumMismatch},
+		{"malformed", map[string]string{"X-Checksum-SHA256": "abc"}, http.StatusBadRequest, apitypes.CodeBadRequest},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			req := uploadRequest(t, "sub", "ok.txt", content)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, req)
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			data, _ := os.ReadFile(filepath.Join(base, "data", "sub", "ok.txt"))
+			if tt.wantCode == "" {
+				var result apitypes.Result
+				if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+					t.Fatal(err)
+				}
+				if string(data) != content || result.SHA256 != hex.EncodeToString(sha[:]) || result.MD5 != hex.EncodeToString(md[:]) {
+					t.Errorf("got content %q and checksums %s, %s", data, result.SHA256, result.MD5)
+				}
+				return
+			}
+			var resp apitypes.ErrorResponse
+			if err := json.Unmarshal(rr.Body.Bytes(), &resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Code != tt.wantCode {
+				t.Errorf("got code %q, want %q", resp.Code, tt.wantCode)
+			}
+			if string(data) != "ok" {
+				t.Errorf("a refused upload changed ok.txt to %q", data)
+			}
+			if entries, _ := os.ReadDir(filepath.Join(base, "data", "sub")); len(entries) != 1 {
+				t.Errorf("a refused upload left %d entries in the directory, want 1", len(entries))
+			}
+		})
+	}
+}
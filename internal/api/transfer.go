@@ -0,0 +1,405 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// progressInterval is the minimum time between progress events for streamed transfers.
+const progressInterval = 500 * time.Millisecond
+
+// transferProgress tracks a running copy and reports it as server-sent events
+// when the client asked for a progress stream.
+type transferProgress struct {
+	mu         sync.Mutex
+	w          http.ResponseWriter
+	flusher    http.Flusher
+	lastReport time.Time
+
+	apitypes.TransferProgress
+}
+
+// newTransferProgress returns a tracker that streams to w if the client
+// accepts text/event-stream, or a silent one otherwise.
+func newTransferProgress(w http.ResponseWriter, r *http.Request) *transferProgress {
+	p := &transferProgress{}
+	if !strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		return p
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return p
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	p.w, p.flusher = w, flusher
+	return p
+}
+
+func (p *transferProgress) streaming() bool {
+	return p.w != nil
+}
+
+// event writes a single server-sent event. The caller must hold p.mu.
+func (p *transferProgress) event(name string, v any) {
+	data, _ := json.Marshal(v)
+	fmt.Fprintf(p.w, "event: %s\ndata: %s\n\n", name, data)
+	p.flusher.Flush()
+}
+
+// add records copied bytes and files, emitting a progress event at most every progressInterval.
+func (p *transferProgress) add(bytes int64, files int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.BytesDone += bytes
+	p.FilesDone += files
+	if p.streaming() && time.Since(p.lastReport) >= progressInterval {
+		p.lastReport = time.Now()
+		p.event("progress", p.TransferProgress)
+	}
+}
+
+// startPhase begins the next step of an operation made of several, starting
+// the counts over.
+func (p *transferProgress) startPhase(phase string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.TransferProgress = apitypes.TransferProgress{Phase: phase}
+	if p.streaming() {
+		p.lastReport = time.Now()
+		p.event("progress", p.TransferProgress)
+	}
+}
+
+// Write lets the tracker count bytes as they are copied.
+func (p *transferProgress) Write(b []byte) (int, error) {
+	p.add(int64(len(b)), 0)
+	return len(b), nil
+}
+
+// measure walks name and records the total size and file count to be copied.
+func (p *transferProgress) measure(s *Server, name string) error {
+	return fs.WalkDir(s.root.FS(), filepath.ToSlash(name), func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			info, err := d.Info()
+			if err != nil {
+				return err
+			}
+			p.BytesTotal += info.Size()
+		}
+		if !d.IsDir() {
+			p.FilesTotal++
+		}
+		return nil
+	})
+}
+
+// moveFileHandler moves or renames a file or directory.
+func (s *Server) moveFileHandler(w http.ResponseWriter, r *http.Request) {
+	s.transfer(w, r, "file.move")
+}
+
+// copyFileHandler copies a file or directory recursively.
+func (s *Server) copyFileHandler(w http.ResponseWriter, r *http.Request) {
+	s.transfer(w, r, "file.copy")
+}
+
+// transfer implements both move and copy. Moves are a rename when source and
+// destination share a filesystem and fall back to copy-then-delete otherwise.
+func (s *Server) transfer(w http.ResponseWriter, r *http.Request, action string) {
+	var payload apitypes.TransferRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+
+	src, err := s.sanitizePath(payload.Source)
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid source path")
+		return
+	}
+	dst, err := s.sanitizePath(payload.Destination)
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid destination path")
+		return
+	}
+	if src == "." || dst == "." {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Cannot move or copy the root directory", nil)
+		return
+	}
+	if src == dst || strings.HasPrefix(dst, src+string(filepath.Separator)) {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Destination cannot be the source or inside it", nil)
+		return
+	}
+
+	srcInfo, err := s.root.Lstat(src)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not access source")
+		return
+	}
+	// A file must not get a name or a size an upload to dst could not have.
+	if srcInfo.Mode().IsRegular() {
+		err := s.checkUploadName(dst)
+		if err == nil {
+			err = s.checkUploadSize(dst, srcInfo.Size())
+		}
+		if err != nil {
+			s.writeFSError(w, r, err, "File not allowed at destination")
+			return
+		}
+	}
+	// A move takes the source away; a copy must not expose hidden files
+	// below it under a new name.
+	srcOp := policy.Read
+	if action == "file.move" {
+		srcOp = policy.Delete
+	}
+	if !s.authorize(w, r, src, srcOp) {
+		return
+	}
+	if srcOp == policy.Read {
+		if err := s.checkPolicyTree(src, policy.Read); err != nil {
+			s.writeFSError(w, r, err, "Operation not allowed by path policy")
+			return
+		}
+	}
+	if !s.authorize(w, r, dst, policy.Write) {
+		return
+	}
+	if dstInfo, err := s.root.Stat(filepath.Dir(dst)); err != nil {
+		s.writeFSError(w, r, err, "Could not access destination directory")
+		return
+	} else if !dstInfo.IsDir() {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeNotDirectory, "Destination parent is not a directory", nil)
+		return
+	}
+	var replaced *replacedEntry
+	if _, err := s.root.Lstat(dst); err == nil {
+		if !payload.Overwrite {
+			s.writeError(w, r, http.StatusConflict, apitypes.CodeAlreadyExists, "Destination already exists. Use overwrite=true to replace it.", nil)
+			return
+		}
+		if !s.authorize(w, r, dst, policy.Delete) {
+			return
+		}
+		if replaced, err = s.setAside(r.Context(), dst, s.clientIP(r)); err != nil {
+			s.writeFSError(w, r, err, "Could not replace destination")
+			return
+		}
+	}
+
+	progress := newTransferProgress(w, r)
+	err = s.runTransfer(r.Context(), action, src, dst, progress)
+	if replaced != nil {
+		s.settleReplaced(replaced, err == nil)
+	}
+	s.recordAuditTransfer(r, action, src, dst, progress.BytesDone, err)
+
+	result := apitypes.Result{Message: "Item copied successfully", Path: dst, Bytes: progress.BytesDone, RequestID: requestID(r)}
+	if action == "file.move" {
+		result.Message = "Item moved successfully"
+	}
+
+	if !progress.streaming() {
+		if err != nil {
+			s.writeFSError(w, r, err, "Could not complete transfer")
+			return
+		}
+		s.writeResult(w, r, http.StatusOK, result)
+		return
+	}
+
+	progress.mu.Lock()
+	defer progress.mu.Unlock()
+	if err != nil {
+		s.log(r).Error("Transfer failed", "source", src, "destination", dst, "error", err)
+		_, code := fsErrorStatus(err)
+		progress.event("error", apitypes.ErrorResponse{
+			Code:      code,
+			Message:   "Could not complete transfer",
+			Details:   map[string]any{"error": errorReason(err)},
+			RequestID: requestID(r),
+		})
+		return
+	}
+	progress.event("progress", progress.TransferProgress)
+	progress.event("result", result)
+}
+
+// replacedEntry is an existing destination moved out of the way of a move or
+// copy with overwrite, so it can be put back if the transfer fails. It is
+// either in the trash or, with the trash disabled, in a hidden sibling.
+type replacedEntry struct {
+	name    string
+	trashID string
+	aside   string
+}
+
+// setAside moves the entry at name out of the way of its replacement. With
+// the trash enabled it goes there and can be restored like a deleted item;
+// otherwise it is renamed to a hidden sibling until the replacement is done.
+func (s *Server) setAside(ctx context.Context, name, deletedBy string) (*replacedEntry, error) {
+	if s.trashEnabled() {
+		item, err := s.moveToTrash(ctx, name, deletedBy)
+		if err != nil {
+			return nil, err
+		}
+		return &replacedEntry{name: name, trashID: item.ID}, nil
+	}
+	aside := siblingName(name, "replaced")
+	if err := s.root.Rename(name, aside); err != nil {
+		return nil, err
+	}
+	return &replacedEntry{name: name, aside: aside}, nil
+}
+
+// settleReplaced lets a replaced entry go once its replacement is in place,
+// or puts it back where it was if the replacement failed.
+func (s *Server) settleReplaced(e *replacedEntry, done bool) {
+	var err error
+	switch {
+	case e.trashID != "":
+		s.trashMu.Lock()
+		if done {
+			s.trimTrash()
+		} else {
+			err = s.untrash(e.trashID, e.name)
+		}
+		s.trashMu.Unlock()
+	case done:
+		err = s.root.RemoveAll(e.aside)
+	default:
+		err = s.root.Rename(e.aside, e.name)
+	}
+	if err != nil {
+		s.logger.Error("Could not clean up replaced destination", "path", e.name, "trash_id", e.trashID, "aside", e.aside, "error", err)
+	}
+}
+
+// runTransfer performs the move or copy once the request has been validated.
+func (s *Server) runTransfer(ctx context.Context, action, src, dst string, progress *transferProgress) error {
+	if action == "file.move" {
+		err := s.root.Rename(src, dst)
+		if !errors.Is(err, syscall.EXDEV) {
+			return err
+		}
+		// Source and destination are on different mounts: copy, then remove the source.
+	}
+
+	if err := progress.measure(s, src); err != nil {
+		return err
+	}
+	if action == "file.copy" {
+		// A move across mounts frees as much as it writes, so only copies count.
+		if err := s.reserve(ctx, progress.BytesTotal); err != nil {
+			return err
+		}
+	}
+	if err := s.copyTree(ctx, src, dst, progress); err != nil {
+		// Do not leave a partial copy behind.
+		s.root.RemoveAll(dst)
+		return err
+	}
+	if action == "file.copy" {
+		s.usage.Add(progress.BytesDone)
+	}
+	if action == "file.move" {
+		return s.root.RemoveAll(src)
+	}
+	return nil
+}
+
+// copyTree recursively copies src to dst inside the data root, preserving
+// permissions and recreating symlinks as links. Each file must pass the
+// checks an upload to its new path would, and is written under a temporary
+// name until it has.
+func (s *Server) copyTree(ctx context.Context, src, dst string, progress *transferProgress) error {
+	info, err := s.root.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case info.Mode()&os.ModeSymlink != 0:
+		target, err := s.root.Readlink(src)
+		if err != nil {
+			return err
+		}
+		if err := s.root.Symlink(target, dst); err != nil {
+			return err
+		}
+		progress.add(0, 1)
+		return nil
+
+	case info.IsDir():
+		if err := s.root.Mkdir(dst, info.Mode().Perm()); err != nil {
+			return err
+		}
+		entries, err := s.root.ReadDir(src)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := s.copyTree(ctx, filepath.Join(src, entry.Name()), filepath.Join(dst, entry.Name()), progress); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case info.Mode().IsRegular():
+		if err := s.checkUploadName(dst); err != nil {
+			return err
+		}
+		if err := s.checkUploadSize(dst, info.Size()); err != nil {
+			return err
+		}
+		in, err := s.root.Open(src)
+		if err != nil {
+			return err
+		}
+		defer in.Close()
+		tmpName := siblingName(dst, "tmp")
+		out, err := s.root.OpenFile(tmpName, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(io.MultiWriter(out, progress), in)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err == nil {
+			err = s.checkStagedUpload(ctx, dst, tmpName)
+		}
+		if err == nil {
+			err = s.root.Rename(tmpName, dst)
+		}
+		if err != nil {
+			s.root.Remove(tmpName)
+			return err
+		}
+		progress.add(0, 1)
+		return nil
+
+	default:
+		// Sockets, devices and pipes have no business in a game data directory.
+		return &fs.PathError{Op: "copy", Path: src, Err: errors.New("unsupported file type")}
+	}
+}
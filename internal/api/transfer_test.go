@@ -0,0 +1,140 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+// TestTransferDestinationName checks that a move or copy cannot give a file
+// a name or size an upload could not have.
+func TestTransferDestinationName(t *testing.T) {
+	t.Setenv("SIDECAR_UPLOAD_MAX_SIZES", "*.cfg=1")
+	for _, tt := range []struct {
+		action, dst string
+		wantStatus  int
+	}{
+		{"move", "sub/tool.exe", http.StatusBadRequest},
+		{"copy", "sub/tool.exe", http.StatusBadRequest},
+		{"copy", "sub/big.cfg", http.StatusRequestEntityTooLarge},
+		{"copy", "sub/copy.txt", http.StatusOK},
+	} {
+		t.Run(tt.action+" "+tt.dst, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			body := `{"source":"sub/ok.txt","destination":"` + tt.dst + `"}`
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/"+tt.action, strings.NewReader(body)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+		})
+	}
+}
+
+// TestTransferOverwriteFailure checks that a move or copy that fails after
+// replacing its destination puts the destination back, and that a
+// successful one keeps the replaced destination in the trash.
+func TestTransferOverwriteFailure(t *testing.T) {
+	for _, tt := range []struct {
+		name      string
+		retention string
+		source    string
+		wantCode  int
+		wantTrash int
+	}{
+		{"failed with trash", "1h", "bad", http.StatusInternalServerError, 0},
+		{"failed without trash", "0", "bad", http.StatusInternalServerError, 0},
+		{"replaced with trash", "1h", "good", http.StatusOK, 1},
+		{"replaced without trash", "0", "good", http.StatusOK, 0},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("SIDECAR_TRASH_RETENTION", tt.retention)
+			s, base := newTestServer(t)
+			data := filepath.Join(base, "data")
+			for _, dir := range []string{"dst", "good", "bad"} {
+				if err := os.Mkdir(filepath.Join(data, dir), 0o755); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(filepath.Join(data, dir, "file"), []byte(dir), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			// Copying a named pipe fails after the destination was replaced.
+			if err := syscall.Mkfifo(filepath.Join(data, "bad", "pipe"), 0o644); err != nil {
+				t.Skip("cannot create a named pipe:", err)
+			}
+			body := `{"source":"` + tt.source + `","destination":"dst","overwrite":true}`
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/copy", strings.NewReader(body)))
+			if rr.Code != tt.wantCode {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantCode, rr.Body)
+			}
+			want := "dst"
+			if tt.wantCode == http.StatusOK {
+				want = tt.source
+			}
+			if got, err := os.ReadFile(filepath.Join(data, "dst", "file")); err != nil || string(got) != want {
+				t.Errorf("got dst/file %q, %v, want %q", got, err, want)
+			}
+			entries, _ := os.ReadDir(filepath.Join(data, trashDir))
+			if got := len(entries) / 2; got != tt.wantTrash {
+				t.Errorf("got %d trash items, want %d", got, tt.wantTrash)
+			}
+			all, _ := os.ReadDir(data)
+			for _, e := range all {
+				if strings.Contains(e.Name(), ".replaced-") {
+					t.Errorf("%s was left behind", e.Name())
+				}
+			}
+		})
+	}
+}
+
+// TestTransferContentPolicy checks that every file a directory copy creates
+// passes the checks an upload of it would, and that a refused copy leaves
+// nothing behind.
+func TestTransferContentPolicy(t *testing.T) {
+	t.Setenv("SIDECAR_UPLOAD_MAX_SIZES", "*.cfg=4")
+	t.Setenv("SIDECAR_UPLOAD_SCAN_COMMAND", "grep -qv EICAR")
+	for _, tt := range []struct {
+		name, file, content string
+		wantStatus          int
+	}{
+		{"allowed", "b.txt", "fine", http.StatusOK},
+		{"extension", "tool.exe", "fine", http.StatusBadRequest},
+		{"size", "big.cfg", "too big", http.StatusRequestEntityTooLarge},
+		{"scan", "b.txt", "EICAR", http.StatusUnprocessableEntity},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, base := newTestServer(t)
+			src := filepath.Join(base, "data", "src", "nested")
+			if err := os.MkdirAll(src, 0o755); err != nil {
+				t.Fatal(err)
+			}
+			// Files the game server writes are only checked once they are copied.
+			if err := os.WriteFile(filepath.Join(src, tt.file), []byte(tt.content), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			rr := httptest.NewRecorder()
+			testHandler(s).ServeHTTP(rr, httptest.NewRequest("POST", "/api/files/copy", strings.NewReader(`{"source":"src","destination":"dst"}`)))
+			if rr.Code != tt.wantStatus {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.wantStatus, rr.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				entries, _ := os.ReadDir(filepath.Join(base, "data", "dst", "nested"))
+				if len(entries) != 1 || entries[0].Name() != tt.file {
+					t.Errorf("got %v in the copied directory, want only %s", entries, tt.file)
+				}
+			}
+			if tt.wantStatus != http.StatusOK {
+				if _, err := os.Lstat(filepath.Join(base, "data", "dst")); !os.IsNotExist(err) {
+					t.Errorf("a refused copy left its destination behind")
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,413 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/usage"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// trashDir holds deleted items until they are restored or purged. Like
+// uploadsDir it lives inside the data root, so deleting and restoring are
+// renames rather than copies.
+var trashDir = filepath.Join(internalDir, "trash")
+
+// trashCleanupInterval is how often expired trash is looked for.
+const trashCleanupInterval = 10 * time.Minute
+
+// trashMeta is persisted next to a trashed item. The item itself keeps its
+// contents and modes; everything the API reports about it comes from here.
+type trashMeta struct {
+	Path      string    `json:"path"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+	Files     int       `json:"files,omitempty"`
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+}
+
+func trashItemName(id string) string { return filepath.Join(trashDir, id) }
+func trashMetaName(id string) string { return filepath.Join(trashDir, id+".json") }
+
+// validTrashID reports whether id has the shape generated by moveToTrash,
+// which is the same as an upload ID.
+func validTrashID(id string) bool {
+	return validUploadID(id)
+}
+
+// trashEnabled reports whether deletes go to the trash by default.
+func (s *Server) trashEnabled() bool {
+	return s.trashRetention > 0
+}
+
+// moveToTrash moves name into the trash and returns the new entry.
+func (s *Server) moveToTrash(ctx context.Context, name, deletedBy string) (*apitypes.TrashItem, error) {
+	meta, err := s.newTrashMeta(ctx, name, deletedBy)
+	if err != nil {
+		return nil, err
+	}
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	return s.addToTrash(name, meta)
+}
+
+// newTrashMeta describes name as it is about to be trashed.
+func (s *Server) newTrashMeta(ctx context.Context, name, deletedBy string) (trashMeta, error) {
+	info, err := s.root.Lstat(name)
+	if err != nil {
+		return trashMeta{}, err
+	}
+	meta := trashMeta{Path: filepath.ToSlash(name), IsDir: info.IsDir(), DeletedBy: deletedBy, DeletedAt: time.Now().UTC()}
+	if info.IsDir() {
+		snap, err := usage.Scan(ctx, s.root.FS(), meta.Path)
+		if err != nil {
+			return trashMeta{}, err
+		}
+		dir := snap.Dirs[meta.Path]
+		meta.Size, meta.Files = dir.Size, dir.Files
+	} else if info.Mode().IsRegular() {
+		meta.Size, meta.Files = info.Size(), 1
+	}
+	return meta, nil
+}
+
+// addToTrash moves name into the trash under meta. The metadata is written
+// first, so a crash before the rename leaves only a metadata file, which
+// cleanup removes. The caller must hold trashMu.
+func (s *Server) addToTrash(name string, meta trashMeta) (*apitypes.TrashItem, error) {
+	if err := s.root.MkdirAll(trashDir, 0700); err != nil {
+		return nil, err
+	}
+	id := newRequestID()
+	data, _ := json.Marshal(meta)
+	if err := s.createFile(trashMetaName(id), data, 0600); err != nil {
+		return nil, err
+	}
+	if err := s.root.Rename(name, trashItemName(id)); err != nil {
+		s.root.Remove(trashMetaName(id))
+		return nil, err
+	}
+	s.syncDir(filepath.Dir(name))
+	s.syncDir(trashDir)
+	return s.trashItem(id, meta), nil
+}
+
+// untrash moves item id back out of the trash to name, whose parent must
+// exist, and drops its metadata. The caller must hold trashMu.
+func (s *Server) untrash(id, name string) error {
+	if err := s.root.Rename(trashItemName(id), name); err != nil {
+		return err
+	}
+	s.syncDir(filepath.Dir(name))
+	s.root.Remove(trashMetaName(id))
+	return nil
+}
+
+func (s *Server) trashItem(id string, meta trashMeta) *apitypes.TrashItem {
+	return &apitypes.TrashItem{
+		ID:        id,
+		Path:      meta.Path,
+		IsDir:     meta.IsDir,
+		Size:      meta.Size,
+		Files:     meta.Files,
+		DeletedBy: meta.DeletedBy,
+		DeletedAt: meta.DeletedAt,
+		ExpiresAt: meta.DeletedAt.Add(s.trashRetention),
+	}
+}
+
+// readTrashMeta loads the metadata of a trashed item.
+func (s *Server) readTrashMeta(id string) (trashMeta, error) {
+	var meta trashMeta
+	data, err := fs.ReadFile(s.root.FS(), filepath.ToSlash(trashMetaName(id)))
+	if err != nil {
+		return meta, err
+	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		return meta, err
+	}
+	return meta, nil
+}
+
+// listTrash returns every trashed item, newest first. The caller must hold trashMu.
+func (s *Server) listTrash() ([]apitypes.TrashItem, error) {
+	entries, err := s.root.ReadDir(trashDir)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return []apitypes.TrashItem{}, nil
+		}
+		return nil, err
+	}
+	items := []apitypes.TrashItem{}
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validTrashID(id) {
+			continue
+		}
+		meta, err := s.readTrashMeta(id)
+		if err != nil {
+			continue
+		}
+		if _, err := s.root.Lstat(trashItemName(id)); err != nil {
+			// Left behind by a delete that failed to rename the item.
+			s.root.Remove(trashMetaName(id))
+			continue
+		}
+		items = append(items, *s.trashItem(id, meta))
+	}
+	slices.SortFunc(items, func(a, b apitypes.TrashItem) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return items, nil
+}
+
+// purgeTrashItem deletes a trashed item for good. The metadata goes last, so
+// an item that could only be partly removed stays listed and can be retried.
+// The caller must hold trashMu.
+func (s *Server) purgeTrashItem(id string) error {
+	if err := s.root.RemoveAll(trashItemName(id)); err != nil {
+		return err
+	}
+	err := s.root.Remove(trashMetaName(id))
+	s.usage.Refresh()
+	return err
+}
+
+// trimTrash purges items older than the retention period, then the oldest
+// items until the trash fits its size limit. The newest item is never purged
+// for size, so deleting something larger than the limit still leaves a way
+// back until the next delete. The caller must hold trashMu.
+func (s *Server) trimTrash() {
+	items, err := s.listTrash()
+	if err != nil {
+		s.logger.Warn("Could not read trash", "error", err)
+		return
+	}
+	var total int64
+	for _, item := range items {
+		total += item.Size
+	}
+	remaining := len(items)
+	for i := len(items) - 1; i >= 0; i-- {
+		item := items[i]
+		expired := time.Now().After(item.ExpiresAt)
+		oversize := s.trashMaxBytes > 0 && total > s.trashMaxBytes && i > 0
+		if !expired && !oversize {
+			continue
+		}
+		if err := s.purgeTrashItem(item.ID); err != nil {
+			s.logger.Warn("Could not purge trash item", "trash_id", item.ID, "path", item.Path, "error", err)
+			continue
+		}
+		total -= item.Size
+		remaining--
+		reason := "expired"
+		if !expired {
+			reason = "size limit"
+		}
+		s.logger.Info("Purged trash item", "trash_id", item.ID, "path", item.Path, "size", item.Size, "reason", reason)
+	}
+	metrics.TrashBytes.Set(float64(total))
+	metrics.TrashItems.Set(float64(remaining))
+}
+
+// cleanupTrash periodically purges expired trash.
+func (s *Server) cleanupTrash(ctx context.Context) {
+	ticker := time.NewTicker(trashCleanupInterval)
+	defer ticker.Stop()
+	for {
+		s.trashMu.Lock()
+		s.trimTrash()
+		s.trashMu.Unlock()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// listTrashHandler returns the items in the trash, newest first.
+func (s *Server) listTrashHandler(w http.ResponseWriter, r *http.Request) {
+	s.trashMu.Lock()
+	items, err := s.listTrash()
+	s.trashMu.Unlock()
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not read trash")
+		return
+	}
+	s.writeJSON(w, r, http.StatusOK, items)
+}
+
+// restoreTrashHandler moves an item out of the trash, back to where it was
+// deleted from or to the path given in the body. Missing parent directories
+// are recreated, since they were often deleted along with it.
+func (s *Server) restoreTrashHandler(w http.ResponseWriter, r *http.Request) {
+	var payload apitypes.RestoreRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil && err != io.EOF {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+
+	id := r.PathValue("id")
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	meta, ok := s.loadTrashMeta(w, r, id)
+	if !ok {
+		return
+	}
+
+	dst, err := s.sanitizePath(cmp.Or(payload.Path, meta.Path))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if dst == "." {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Cannot restore over the root directory", nil)
+		return
+	}
+	if !s.authorize(w, r, dst, policy.Write) {
+		return
+	}
+	_, err = s.root.Lstat(dst)
+	exists := err == nil
+	if exists {
+		if !payload.Overwrite {
+			s.writeError(w, r, http.StatusConflict, apitypes.CodeAlreadyExists, "Destination already exists. Use overwrite=true to replace it.", nil)
+			return
+		}
+		if !s.authorize(w, r, dst, policy.Delete) {
+			return
+		}
+	}
+	if err := s.mkdirAll(filepath.Dir(dst)); err != nil {
+		s.writeFSError(w, r, err, "Could not create destination directory")
+		return
+	}
+
+	// The entry being replaced goes to the trash itself, and comes back if
+	// the restore fails.
+	var replaced *apitypes.TrashItem
+	if exists {
+		replacedMeta, err := s.newTrashMeta(r.Context(), dst, s.clientIP(r))
+		if err == nil {
+			replaced, err = s.addToTrash(dst, replacedMeta)
+		}
+		if err != nil {
+			s.writeFSError(w, r, err, "Could not replace destination")
+			return
+		}
+	}
+
+	err = s.untrash(id, dst)
+	if err != nil && replaced != nil {
+		if err := s.untrash(replaced.ID, dst); err != nil {
+			s.logger.Error("Could not put back replaced destination", "path", dst, "trash_id", replaced.ID, "error", err)
+		}
+		replaced = nil
+	}
+	s.recordAuditTransfer(r, "trash.restore", filepath.FromSlash(meta.Path), dst, meta.Size, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not restore item")
+		return
+	}
+	s.trimTrash()
+
+	result := apitypes.Result{Message: "Item restored successfully", Path: dst, Bytes: meta.Size, Files: meta.Files}
+	if replaced != nil {
+		result.TrashID = replaced.ID
+	}
+	s.writeResult(w, r, http.StatusOK, result)
+}
+
+// purgeTrashHandler deletes one item from the trash permanently.
+func (s *Server) purgeTrashHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	meta, ok := s.loadTrashMeta(w, r, id)
+	if !ok {
+		return
+	}
+	// The item is no longer at its path, so only the rules are consulted.
+	if err := s.policy.Check(meta.Path, policy.Delete); err != nil {
+		s.writeFSError(w, r, err, "Operation not allowed by path policy")
+		return
+	}
+
+	err := s.purgeTrashItem(id)
+	s.recordAudit(r, "trash.purge", filepath.FromSlash(meta.Path), meta.Size, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not purge item")
+		return
+	}
+	s.trimTrash()
+
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Item purged from trash", Path: meta.Path, Bytes: meta.Size, Files: meta.Files})
+}
+
+// emptyTrashHandler deletes everything in the trash permanently.
+func (s *Server) emptyTrashHandler(w http.ResponseWriter, r *http.Request) {
+	s.trashMu.Lock()
+	defer s.trashMu.Unlock()
+	items, err := s.listTrash()
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not read trash")
+		return
+	}
+
+	var bytes int64
+	files := 0
+	for _, item := range items {
+		if err = s.policy.Check(item.Path, policy.Delete); err != nil {
+			break
+		}
+		if err = s.purgeTrashItem(item.ID); err != nil {
+			break
+		}
+		bytes += item.Size
+		files += item.Files
+	}
+	s.recordAudit(r, "trash.empty", "", bytes, err)
+	s.trimTrash()
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not empty trash")
+		return
+	}
+
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Trash emptied", Bytes: bytes, Files: files})
+}
+
+// loadTrashMeta reads the metadata of item id, writing an error response if
+// it is unknown.
+func (s *Server) loadTrashMeta(w http.ResponseWriter, r *http.Request, id string) (trashMeta, bool) {
+	if !validTrashID(id) {
+		s.writeError(w, r, http.StatusNotFound, apitypes.CodeNotFound, "Trash item not found", nil)
+		return trashMeta{}, false
+	}
+	meta, err := s.readTrashMeta(id)
+	if err == nil {
+		_, err = s.root.Lstat(trashItemName(id))
+	}
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			s.writeError(w, r, http.StatusNotFound, apitypes.CodeNotFound, "Trash item not found", nil)
+			return trashMeta{}, false
+		}
+		s.writeFSError(w, r, err, "Could not read trash item")
+		return trashMeta{}, false
+	}
+	return meta, true
+}
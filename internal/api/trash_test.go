@@ -0,0 +1,58 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestRestoreTrashOverwrite checks that restoring over an existing entry
+// moves that entry into the trash instead of deleting it.
+func TestRestoreTrashOverwrite(t *testing.T) {
+	s, base := newTestServer(t)
+	h := testHandler(s)
+	post := func(target, body string) apitypes.Result {
+		t.Helper()
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, httptest.NewRequest("POST", target, strings.NewReader(body)))
+		if rr.Code != http.StatusOK {
+			t.Fatalf("POST %s: got status %d: %s", target, rr.Code, rr.Body)
+		}
+		var result apitypes.Result
+		if err := json.Unmarshal(rr.Body.Bytes(), &result); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	deleted := post("/api/files/delete", `{"path":"sub/ok.txt"}`)
+	okFile := filepath.Join(base, "data", "sub", "ok.txt")
+	if err := os.WriteFile(okFile, []byte("new"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, httptest.NewRequest("POST", "/api/trash/"+deleted.TrashID+"/restore", nil))
+	if rr.Code != http.StatusConflict {
+		t.Fatalf("restore without overwrite: got status %d, want %d", rr.Code, http.StatusConflict)
+	}
+
+	restored := post("/api/trash/"+deleted.TrashID+"/restore", `{"overwrite":true}`)
+	if data, _ := os.ReadFile(okFile); string(data) != "ok" {
+		t.Errorf("got content %q after the restore, want %q", data, "ok")
+	}
+	if restored.TrashID == "" {
+		t.Fatal("restore did not report the trash item of the replaced file")
+	}
+
+	post("/api/trash/"+restored.TrashID+"/restore", `{"overwrite":true}`)
+	if data, _ := os.ReadFile(okFile); string(data) != "new" {
+		t.Errorf("got content %q after restoring the replaced file, want %q", data, "new")
+	}
+}
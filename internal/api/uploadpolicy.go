@@ -0,0 +1,56 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"io"
+	"path/filepath"
+
+	"github.com/pegnia/sidecar/internal/uploadpolicy"
+)
+
+// checkUploadName checks the extension of a file about to be uploaded.
+func (s *Server) checkUploadName(name string) error {
+	return s.uploadPolicy.CheckName(filepath.ToSlash(name))
+}
+
+// checkUploadSize checks a declared upload size against the size limits.
+func (s *Server) checkUploadSize(name string, size int64) error {
+	return s.uploadPolicy.CheckSize(filepath.ToSlash(name), size)
+}
+
+// checkStagedUpload runs the content checks on a fully received upload before
+// it is moved to name: type sniffing and, if configured, the scanner.
+func (s *Server) checkStagedUpload(ctx context.Context, name, staged string) error {
+	if !s.uploadPolicy.ChecksContent() {
+		return nil
+	}
+	f, err := s.root.Open(staged)
+	if err != nil {
+		return err
+	}
+	head := make([]byte, uploadpolicy.SniffLen)
+	n, err := io.ReadFull(f, head)
+	f.Close()
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
+		return err
+	}
+	host, err := s.root.HostPath(staged)
+	if err != nil {
+		return err
+	}
+	return s.uploadPolicy.CheckContent(ctx, filepath.ToSlash(name), host, head[:n])
+}
+
+// checkExtractedFile runs the checks of an upload to name on a file an
+// archive extraction has staged: the size limits, then the content checks.
+func (s *Server) checkExtractedFile(ctx context.Context, name, staged string) error {
+	info, err := s.root.Lstat(staged)
+	if err != nil {
+		return err
+	}
+	if err := s.checkUploadSize(name, info.Size()); err != nil {
+		return err
+	}
+	return s.checkStagedUpload(ctx, name, staged)
+}
@@ -0,0 +1,426 @@
+package api
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/policy"
+	"github.com/pegnia/sidecar/internal/uploadpolicy"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// uploadsDir holds the staging files of resumable uploads. It lives inside
+// the data root so completed uploads can be renamed into place atomically.
+var uploadsDir = filepath.Join(internalDir, "uploads")
+
+// uploadCleanupInterval is how often abandoned upload sessions are looked for.
+const uploadCleanupInterval = 10 * time.Minute
+
+// uploadMeta is the part of an upload session persisted next to its staged
+// data. The offset is not stored: it is the size of the staged file, so it
+// survives restarts and crashes mid-chunk without bookkeeping.
+type uploadMeta struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+func uploadPartName(id string) string { return filepath.Join(uploadsDir, id+".part") }
+func uploadMetaName(id string) string { return filepath.Join(uploadsDir, id+".json") }
+
+// validUploadID reports whether id has the shape generated by createUploadHandler.
+func validUploadID(id string) bool {
+	if len(id) != 32 {
+		return false
+	}
+	_, err := hex.DecodeString(id)
+	return err == nil
+}
+
+// lockUpload marks an upload as busy so concurrent chunks cannot interleave.
+// It returns false if another request already holds it.
+func (s *Server) lockUpload(id string) bool {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	if s.activeUploads[id] {
+		return false
+	}
+	s.activeUploads[id] = true
+	return true
+}
+
+func (s *Server) unlockUpload(id string) {
+	s.uploadsMu.Lock()
+	defer s.uploadsMu.Unlock()
+	delete(s.activeUploads, id)
+}
+
+// loadUpload reads the session id, writing an error response if it is unknown.
+func (s *Server) loadUpload(w http.ResponseWriter, r *http.Request, id string) (*apitypes.UploadSession, bool) {
+	if !validUploadID(id) {
+		s.writeError(w, r, http.StatusNotFound, apitypes.CodeNotFound, "Upload session not found", nil)
+		return nil, false
+	}
+	data, err := fs.ReadFile(s.root.FS(), filepath.ToSlash(uploadMetaName(id)))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			s.writeError(w, r, http.StatusNotFound, apitypes.CodeNotFound, "Upload session not found", nil)
+			return nil, false
+		}
+		s.writeFSError(w, r, err, "Could not read upload session")
+		return nil, false
+	}
+	var meta uploadMeta
+	if err := json.Unmarshal(data, &meta); err != nil {
+		s.writeError(w, r, http.StatusInternalServerError, apitypes.CodeInternal, "Upload session is corrupt", nil)
+		return nil, false
+	}
+	info, err := s.root.Stat(uploadPartName(id))
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not read upload session")
+		return nil, false
+	}
+	return &apitypes.UploadSession{
+		ID:        id,
+		Path:      meta.Path,
+		Size:      meta.Size,
+		Offset:    info.Size(),
+		SHA256:    meta.SHA256,
+		Overwrite: meta.Overwrite,
+		ExpiresAt: info.ModTime().Add(s.uploadTTL),
+	}, true
+}
+
+// writeUpload replies with the session and mirrors its offset in the Upload-Offset header.
+func (s *Server) writeUpload(w http.ResponseWriter, r *http.Request, status int, session *apitypes.UploadSession) {
+	w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+	s.writeJSON(w, r, status, session)
+}
+
+// removeUpload deletes the staged data and metadata of a session, and takes
+// the staged bytes counted by patchUploadHandler back out of the usage total.
+func (s *Server) removeUpload(id string) {
+	if info, err := s.root.Lstat(uploadPartName(id)); err == nil {
+		if s.root.Remove(uploadPartName(id)) == nil {
+			s.usage.Add(-info.Size())
+		}
+	}
+	s.root.Remove(uploadMetaName(id))
+}
+
+// createUploadHandler starts a resumable upload. The destination is validated
+// up front so a client does not send gigabytes only to be refused at the end.
+func (s *Server) createUploadHandler(w http.ResponseWriter, r *http.Request) {
+	var payload apitypes.UploadRequest
+	if err := json.NewDecoder(r.Body).Decode(&payload); err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid request body", nil)
+		return
+	}
+	name, err := s.sanitizePath(payload.Path)
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	if name == "." {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeInvalidPath, "Path must name a file", nil)
+		return
+	}
+	if !s.authorize(w, r, name, policy.Write) {
+		return
+	}
+	if payload.Size < 0 {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Size must not be negative", nil)
+		return
+	}
+	var ok bool
+	if payload.SHA256, ok = parseHexDigest(payload.SHA256, sha256.Size); !ok {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "sha256 must be a hex-encoded SHA-256 digest", nil)
+		return
+	}
+	if err := s.checkUploadName(name); err != nil {
+		s.log(r).Warn("Rejected upload by content policy", "path", name, "error", err)
+		s.writeFSError(w, r, err, "File type not allowed for security reasons")
+		return
+	}
+	if err := s.checkUploadSize(name, payload.Size); err != nil {
+		s.log(r).Warn("Rejected upload by content policy", "path", name, "error", err)
+		s.writeFSError(w, r, err, "File exceeds the size limit for its path")
+		return
+	}
+	if dirInfo, err := s.root.Stat(filepath.Dir(name)); err != nil {
+		s.writeFSError(w, r, err, "Could not access destination directory")
+		return
+	} else if !dirInfo.IsDir() {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeNotDirectory, "Destination parent is not a directory", nil)
+		return
+	}
+	if _, err := s.root.Lstat(name); err == nil && !payload.Overwrite {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeAlreadyExists, "File already exists. Use overwrite=true to replace it.", nil)
+		return
+	}
+
+	if err := s.reserve(r.Context(), payload.Size); err != nil {
+		s.writeFSError(w, r, err, "Could not create upload session")
+		return
+	}
+
+	if err := s.root.MkdirAll(uploadsDir, 0700); err != nil {
+		s.writeFSError(w, r, err, "Could not create upload session")
+		return
+	}
+	id := newRequestID()
+	meta, _ := json.Marshal(uploadMeta{Path: name, Size: payload.Size, SHA256: payload.SHA256, Overwrite: payload.Overwrite})
+	err = s.createFile(uploadPartName(id), nil, 0600)
+	if err == nil {
+		err = s.createFile(uploadMetaName(id), meta, 0600)
+	}
+	if err != nil {
+		s.removeUpload(id)
+		s.writeFSError(w, r, err, "Could not create upload session")
+		return
+	}
+
+	session, ok := s.loadUpload(w, r, id)
+	if !ok {
+		return
+	}
+	s.log(r).Info("Resumable upload started", "upload_id", id, "path", name, "size", payload.Size)
+	w.Header().Set("Location", "/api/uploads/"+id)
+	s.writeUpload(w, r, http.StatusCreated, session)
+}
+
+// getUploadHandler reports the state of an upload, most importantly the
+// offset a client should resume from.
+func (s *Server) getUploadHandler(w http.ResponseWriter, r *http.Request) {
+	if session, ok := s.loadUpload(w, r, r.PathValue("id")); ok {
+		s.writeUpload(w, r, http.StatusOK, session)
+	}
+}
+
+// patchUploadHandler appends a chunk to an upload. The Upload-Offset header
+// must match the current offset, which makes retries of a chunk that was
+// partially received safe: the client asks for the offset and continues there.
+func (s *Server) patchUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.lockUpload(id) {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer s.unlockUpload(id)
+	session, ok := s.loadUpload(w, r, id)
+	if !ok {
+		return
+	}
+
+	offset, err := strconv.ParseInt(r.Header.Get("Upload-Offset"), 10, 64)
+	if err != nil {
+		s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Missing or invalid Upload-Offset header", nil)
+		return
+	}
+	if offset != session.Offset {
+		w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "Upload-Offset does not match the upload",
+			map[string]any{"offset": session.Offset})
+		return
+	}
+
+	part, err := s.root.OpenFile(uploadPartName(id), os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not open upload")
+		return
+	}
+	// Whatever arrives before an error is kept; the client resumes after it.
+	written, err := io.Copy(part, http.MaxBytesReader(w, r.Body, session.Size-session.Offset))
+	if cerr := part.Close(); err == nil {
+		err = cerr
+	}
+	session.Offset += written
+	metrics.BytesUploaded.Add(float64(written))
+	s.usage.Add(written)
+	if err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			w.Header().Set("Upload-Offset", strconv.FormatInt(session.Offset, 10))
+			s.writeError(w, r, http.StatusRequestEntityTooLarge, apitypes.CodeTooLarge, "Chunk extends past the declared upload size",
+				map[string]any{"size": session.Size, "offset": session.Offset})
+			return
+		}
+		s.log(r).Warn("Upload chunk interrupted", "upload_id", id, "offset", session.Offset, "error", err)
+		s.writeFSError(w, r, err, "Could not write upload chunk")
+		return
+	}
+	s.writeUpload(w, r, http.StatusOK, session)
+}
+
+// completeUploadHandler verifies a fully received upload and moves it into place.
+func (s *Server) completeUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.lockUpload(id) {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer s.unlockUpload(id)
+	session, ok := s.loadUpload(w, r, id)
+	if !ok {
+		return
+	}
+
+	if session.Offset != session.Size {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "Upload is incomplete",
+			map[string]any{"offset": session.Offset, "size": session.Size})
+		return
+	}
+	sums, err := s.syncAndSum(uploadPartName(id))
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not verify upload")
+		return
+	}
+	if err := (expectedChecksums{SHA256: session.SHA256}).verify(sums); err != nil {
+		// The data is unusable; drop the session so the client starts over.
+		s.removeUpload(id)
+		s.recordAudit(r, "file.upload", session.Path, session.Size, err)
+		s.writeFSError(w, r, err, "Uploaded data does not match the declared checksum")
+		return
+	}
+	if _, err := s.root.Lstat(session.Path); err == nil && !session.Overwrite {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeAlreadyExists, "File already exists. Use overwrite=true to replace it.", nil)
+		return
+	}
+	// The policy may have changed since the upload started, for example
+	// because the game server came up.
+	if !s.authorize(w, r, session.Path, policy.Write) {
+		return
+	}
+	if err := s.checkStagedUpload(r.Context(), session.Path, uploadPartName(id)); err != nil {
+		// Like a checksum mismatch, a rejected file cannot be fixed by resuming.
+		var rejection *uploadpolicy.Rejection
+		if errors.As(err, &rejection) {
+			s.removeUpload(id)
+			s.log(r).Warn("Rejected upload by content policy", "upload_id", id, "path", session.Path, "error", err)
+		}
+		s.recordAudit(r, "file.upload", session.Path, session.Size, err)
+		s.writeFSError(w, r, err, "Upload rejected by content policy")
+		return
+	}
+
+	err = s.setStagedMode(uploadPartName(id), session.Path)
+	if err == nil {
+		err = s.root.Rename(uploadPartName(id), session.Path)
+	}
+	s.recordAudit(r, "file.upload", session.Path, session.Size, err)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not save file")
+		return
+	}
+	s.syncDir(filepath.Dir(session.Path))
+	s.root.Remove(uploadMetaName(id))
+
+	s.writeResult(w, r, http.StatusCreated, apitypes.Result{
+		Message: "File uploaded successfully", Path: session.Path, Bytes: session.Size, SHA256: sums.SHA256, MD5: sums.MD5,
+	})
+	s.log(r).Info("Resumable upload completed successfully", "upload_id", id, "path", session.Path, "size", session.Size)
+}
+
+// cancelUploadHandler abandons an upload and discards the staged data.
+func (s *Server) cancelUploadHandler(w http.ResponseWriter, r *http.Request) {
+	id := r.PathValue("id")
+	if !s.lockUpload(id) {
+		s.writeError(w, r, http.StatusConflict, apitypes.CodeConflict, "Another request is writing to this upload", nil)
+		return
+	}
+	defer s.unlockUpload(id)
+	session, ok := s.loadUpload(w, r, id)
+	if !ok {
+		return
+	}
+
+	s.removeUpload(id)
+	s.writeResult(w, r, http.StatusOK, apitypes.Result{Message: "Upload cancelled", Path: session.Path, Bytes: session.Offset})
+}
+
+// cleanupUploads periodically removes upload sessions that have seen no data
+// for longer than the session TTL.
+func (s *Server) cleanupUploads(ctx context.Context) {
+	ticker := time.NewTicker(uploadCleanupInterval)
+	defer ticker.Stop()
+	for {
+		s.removeExpiredUploads()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (s *Server) removeExpiredUploads() {
+	entries, err := s.root.ReadDir(uploadsDir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		// A session is aged by its staged data, which is touched by every
+		// chunk; an orphaned half of a session is aged by itself.
+		id := strings.TrimSuffix(strings.TrimSuffix(entry.Name(), ".json"), ".part")
+		if !validUploadID(id) {
+			continue
+		}
+		info, err := s.root.Stat(uploadPartName(id))
+		if err != nil {
+			info, err = entry.Info()
+		}
+		if err != nil || time.Since(info.ModTime()) < s.uploadTTL {
+			continue
+		}
+		if !s.lockUpload(id) {
+			continue
+		}
+		s.removeUpload(id)
+		s.unlockUpload(id)
+		s.logger.Info("Removed abandoned upload session", "upload_id", id)
+	}
+}
+
+// createFile writes data to a new file, failing if name already exists.
+func (s *Server) createFile(name string, data []byte, perm os.FileMode) error {
+	f, err := s.root.OpenFile(name, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// syncAndSum flushes a staged file to disk and returns its digests.
+func (s *Server) syncAndSum(name string) (checksums, error) {
+	f, err := s.root.OpenFile(name, os.O_RDWR, 0)
+	if err != nil {
+		return checksums{}, err
+	}
+	defer f.Close()
+	if err := f.Sync(); err != nil {
+		return checksums{}, err
+	}
+	sha, md := sha256.New(), md5.New()
+	if _, err := io.Copy(io.MultiWriter(sha, md), f); err != nil {
+		return checksums{}, err
+	}
+	return checksums{SHA256: hexSum(sha), MD5: hexSum(md)}, nil
+}
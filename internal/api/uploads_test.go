@@ -0,0 +1,70 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// TestUploadUsage checks that the bytes of an upload that is given up on are
+// taken back out of the disk usage they were added to.
+func TestUploadUsage(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		sha256 string
+		finish func(id string) *http.Request
+		status int
+	}{
+		{"cancelled", "", func(id string) *http.Request {
+			return httptest.NewRequest("DELETE", "/api/uploads/"+id, nil)
+		}, http.StatusOK},
+		{"checksum mismatch", strings.Repeat("0", 64), func(id string) *http.Request {
+			return httptest.NewRequest("POST", "/api/uploads/"+id+"/complete", nil)
+		}, http.StatusUnprocessableEntity},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, _ := newTestServer(t)
+			h := testHandler(s)
+			before, err := s.usage.Used(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			rr := httptest.NewRecorder()
+			h.ServeHTTP(rr, httptest.NewRequest("POST", "/api/uploads",
+				strings.NewReader(`{"path":"sub/new.bin","size":5,"sha256":"`+tt.sha256+`"}`)))
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("create: got status %d: %s", rr.Code, rr.Body)
+			}
+			var session apitypes.UploadSession
+			if err := json.Unmarshal(rr.Body.Bytes(), &session); err != nil {
+				t.Fatal(err)
+			}
+
+			rr = httptest.NewRecorder()
+			req := httptest.NewRequest("PATCH", "/api/uploads/"+session.ID, strings.NewReader("hello"))
+			req.Header.Set("Upload-Offset", "0")
+			h.ServeHTTP(rr, req)
+			if rr.Code != http.StatusOK {
+				t.Fatalf("chunk: got status %d: %s", rr.Code, rr.Body)
+			}
+			if used, _ := s.usage.Used(context.Background()); used != before+5 {
+				t.Fatalf("got usage %d after the chunk, want %d", used, before+5)
+			}
+
+			rr = httptest.NewRecorder()
+			h.ServeHTTP(rr, tt.finish(session.ID))
+			if rr.Code != tt.status {
+				t.Fatalf("got status %d, want %d: %s", rr.Code, tt.status, rr.Body)
+			}
+			if used, _ := s.usage.Used(context.Background()); used != before {
+				t.Errorf("got usage %d after the upload was dropped, want %d", used, before)
+			}
+		})
+	}
+}
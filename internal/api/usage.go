@@ -0,0 +1,159 @@
+package api
+
+import (
+	"cmp"
+	"context"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"syscall"
+
+	"github.com/pegnia/sidecar/internal/usage"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+const (
+	defaultUsageChildren = 100
+	maxUsageChildren     = 10000
+)
+
+// quotaError is returned when a write would take the data root over its
+// configured quota. It unwraps to EDQUOT so it maps to 507 no_space like a
+// real filesystem quota would.
+type quotaError struct {
+	Limit     int64
+	Used      int64
+	Requested int64
+}
+
+func (e *quotaError) Error() string { return "data root quota exceeded" }
+
+func (e *quotaError) Unwrap() error { return syscall.EDQUOT }
+
+// quotaRemaining returns how many bytes may still be written, or ok false if
+// no quota is configured.
+func (s *Server) quotaRemaining(ctx context.Context) (remaining int64, ok bool, err error) {
+	if s.quotaBytes <= 0 {
+		return 0, false, nil
+	}
+	used, err := s.usage.Used(ctx)
+	return max(s.quotaBytes-used, 0), true, err
+}
+
+// reserve checks that n more bytes fit within the quota. Usage is only
+// updated once the bytes are written, so concurrent writes may together
+// overshoot the quota by up to one request each.
+func (s *Server) reserve(ctx context.Context, n int64) error {
+	if s.quotaBytes <= 0 {
+		return nil
+	}
+	used, err := s.usage.Used(ctx)
+	if err != nil {
+		return err
+	}
+	if used+n > s.quotaBytes {
+		return &quotaError{Limit: s.quotaBytes, Used: used, Requested: n}
+	}
+	return nil
+}
+
+// usageHandler reports the recursive size of a directory and its largest
+// entries from the latest background scan, along with the capacity of the
+// filesystem and the quota.
+func (s *Server) usageHandler(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	name, err := s.sanitizePath(query.Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	limit := defaultUsageChildren
+	if v := query.Get("limit"); v != "" {
+		if limit, err = strconv.Atoi(v); err != nil || limit <= 0 || limit > maxUsageChildren {
+			s.writeError(w, r, http.StatusBadRequest, apitypes.CodeBadRequest, "Invalid 'limit', expected 1 to "+strconv.Itoa(maxUsageChildren), nil)
+			return
+		}
+	}
+	info, err := s.root.Stat(name)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not access directory")
+		return
+	}
+	if !info.IsDir() {
+		s.writeFSError(w, r, &fs.PathError{Op: "usage", Path: name, Err: syscall.ENOTDIR}, "Could not access directory")
+		return
+	}
+
+	var snap *usage.Snapshot
+	if query.Get("refresh") == "true" {
+		snap, err = s.usage.Rescan(r.Context())
+	} else {
+		snap, err = s.usage.Current(r.Context())
+	}
+	if err != nil {
+		if r.Context().Err() == nil {
+			s.writeFSError(w, r, err, "Could not measure disk usage")
+		}
+		return
+	}
+
+	base := filepath.ToSlash(name)
+	dir, ok := snap.Dirs[base]
+	if !ok {
+		// Created after the last scan: measure just this directory now.
+		sub, err := usage.Scan(r.Context(), s.root.FS(), base)
+		if err != nil {
+			if r.Context().Err() == nil {
+				s.writeFSError(w, r, err, "Could not measure disk usage")
+			}
+			return
+		}
+		dir, snap = sub.Dirs[base], sub
+	}
+	result := apitypes.DiskUsage{
+		Path:      base,
+		Size:      dir.Size,
+		Files:     dir.Files,
+		ScannedAt: snap.ScannedAt,
+		Children:  []apitypes.UsageEntry{},
+	}
+
+	entries, err := s.root.ReadDir(name)
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not read directory")
+		return
+	}
+	for _, e := range entries {
+		p := path.Join(base, e.Name())
+		if s.hiddenPath(filepath.FromSlash(p)) {
+			continue
+		}
+		child := apitypes.UsageEntry{Name: e.Name(), Path: p, IsDir: e.IsDir()}
+		if e.IsDir() {
+			d := snap.Dirs[p]
+			child.Size, child.Files = d.Size, d.Files
+		} else if info, err := e.Info(); err == nil && info.Mode().IsRegular() {
+			child.Size = info.Size()
+		}
+		result.Children = append(result.Children, child)
+	}
+	slices.SortFunc(result.Children, func(a, b apitypes.UsageEntry) int {
+		return cmp.Or(cmp.Compare(b.Size, a.Size), cmp.Compare(a.Name, b.Name))
+	})
+	if len(result.Children) > limit {
+		result.Children, result.Truncated = result.Children[:limit], true
+	}
+
+	if fsStats, ok := usage.Filesystem(s.dataRoot); ok {
+		result.Filesystem = &apitypes.FilesystemUsage{Total: fsStats.Total, Free: fsStats.Free, Available: fsStats.Available}
+	}
+	if s.quotaBytes > 0 {
+		if used, err := s.usage.Used(r.Context()); err == nil {
+			result.Quota = &apitypes.QuotaUsage{Limit: s.quotaBytes, Used: used, Available: max(s.quotaBytes-used, 0)}
+		}
+	}
+	s.writeJSON(w, r, http.StatusOK, result)
+}
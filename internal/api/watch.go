@@ -0,0 +1,121 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"path"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/metrics"
+	"github.com/pegnia/sidecar/internal/watch"
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// watchKeepAlive is how often an idle watch stream sends a comment, so
+// proxies do not time the connection out.
+const watchKeepAlive = 30 * time.Second
+
+// watchFilesHandler streams changes below a directory as server-sent events,
+// one JSON apitypes.FileEvent per message. The stream starts once the whole
+// tree is watched, and ends if the directory itself is deleted or moved.
+func (s *Server) watchFilesHandler(w http.ResponseWriter, r *http.Request) {
+	name, err := s.sanitizePath(r.URL.Query().Get("path"))
+	if err != nil {
+		s.writeFSError(w, r, err, "Invalid path")
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		s.writeError(w, r, http.StatusInternalServerError, apitypes.CodeInternal, "Streaming unsupported", nil)
+		return
+	}
+	info, err := s.root.Stat(name)
+	if err == nil && !info.IsDir() {
+		err = &fs.PathError{Op: "watch", Path: name, Err: syscall.ENOTDIR}
+	}
+	var host string
+	if err == nil {
+		host, err = s.root.HostPath(name)
+	}
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not watch directory")
+		return
+	}
+	select {
+	case s.watchStreams <- struct{}{}:
+		defer func() { <-s.watchStreams }()
+	default:
+		s.writeError(w, r, http.StatusTooManyRequests, apitypes.CodeRateLimited, "Too many open watch streams", map[string]any{"limit": cap(s.watchStreams)})
+		return
+	}
+	watcher, err := watch.New(host, watch.Options{
+		Skip:     func(rel string) bool { return s.hiddenPath(filepath.Join(name, filepath.FromSlash(rel))) },
+		Debounce: s.watchDebounce,
+	})
+	if errors.Is(err, watch.ErrWatchLimit) {
+		s.writeError(w, r, http.StatusServiceUnavailable, apitypes.CodeInternal, "Could not watch directory", map[string]any{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		s.writeFSError(w, r, err, "Could not watch directory")
+		return
+	}
+	defer watcher.Close()
+
+	log := s.log(r).With("path", name)
+	log.Info("Watch stream opened")
+	metrics.WatchStreams.Add(1)
+	defer metrics.WatchStreams.Add(-1)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	keepAlive := time.NewTicker(watchKeepAlive)
+	defer keepAlive.Stop()
+	base := filepath.ToSlash(name)
+	for {
+		select {
+		case batch, ok := <-watcher.Events:
+			if !ok {
+				if err := watcher.Err(); err != nil {
+					log.Warn("Watch stream failed", "error", err)
+					_, code := fsErrorStatus(err)
+					b, _ := json.Marshal(apitypes.ErrorResponse{
+						Code:      code,
+						Message:   "Watch stream failed",
+						Details:   errorDetails(err),
+						RequestID: requestID(r),
+					})
+					fmt.Fprintf(w, "event: error\ndata: %s\n\n", b)
+				} else {
+					log.Info("Watched directory is gone, closing stream")
+				}
+				flusher.Flush()
+				return
+			}
+			for _, e := range batch {
+				ev := apitypes.FileEvent{Op: string(e.Op), IsDir: e.IsDir, Time: e.Time}
+				if e.Op != watch.Overflow {
+					ev.Path = path.Join(base, e.Path)
+				}
+				b, _ := json.Marshal(ev)
+				fmt.Fprintf(w, "data: %s\n\n", b)
+			}
+			flusher.Flush()
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+			flusher.Flush()
+		case <-r.Context().Done():
+			log.Info("Client disconnected from watch stream")
+			return
+		}
+	}
+}
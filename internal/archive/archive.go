@@ -0,0 +1,197 @@
+// Package archive streams directory trees from a data root as zip or tar archives.
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pegnia/sidecar/internal/fsroot"
+)
+
+// Format is a supported archive format.
+type Format string
+
+const (
+	Zip    Format = "zip"
+	Tar    Format = "tar"
+	TarGz  Format = "tar.gz"
+	TarZst Format = "tar.zst"
+)
+
+// ParseFormat validates a user-supplied format name. "tgz" is accepted as an alias for tar.gz.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case Zip, Tar, TarGz, TarZst:
+		return f, nil
+	case "tgz":
+		return TarGz, nil
+	}
+	return "", fmt.Errorf("unsupported archive format %q", s)
+}
+
+// Extension returns the file name extension for the format, including the leading dot.
+func (f Format) Extension() string {
+	return "." + string(f)
+}
+
+// ContentType returns the MIME type for the format.
+func (f Format) ContentType() string {
+	switch f {
+	case Zip:
+		return "application/zip"
+	case TarGz:
+		return "application/gzip"
+	case TarZst:
+		return "application/zstd"
+	}
+	return "application/x-tar"
+}
+
+// Filter decides whether an entry is written to the archive. rel is the
+// slash-separated path relative to the archived directory. Returning false for
+// a directory skips its entire subtree.
+type Filter func(rel string, d fs.DirEntry) bool
+
+// Write streams the tree rooted at dir inside root to w in the given format.
+// Entry names are relative to dir. Symlinks are stored as links in tar
+// archives and skipped in zip archives, which have no portable representation.
+func Write(w io.Writer, root *fsroot.Root, dir string, format Format, filter Filter) error {
+	switch format {
+	case Zip:
+		zw := zip.NewWriter(w)
+		if err := walk(root, dir, filter, zipEntry(root, zw)); err != nil {
+			return err
+		}
+		return zw.Close()
+	case Tar:
+		tw := tar.NewWriter(w)
+		if err := walk(root, dir, filter, tarEntry(root, tw)); err != nil {
+			return err
+		}
+		return tw.Close()
+	case TarGz:
+		gw := gzip.NewWriter(w)
+		if err := Write(gw, root, dir, Tar, filter); err != nil {
+			return err
+		}
+		return gw.Close()
+	case TarZst:
+		zw, err := zstd.NewWriter(w)
+		if err != nil {
+			return err
+		}
+		if err := Write(zw, root, dir, Tar, filter); err != nil {
+			zw.Close()
+			return err
+		}
+		return zw.Close()
+	}
+	return fmt.Errorf("unsupported archive format %q", format)
+}
+
+// entryFunc writes one entry. name is the root-relative path, rel the archive name.
+type entryFunc func(name, rel string, info fs.FileInfo) error
+
+func walk(root *fsroot.Root, dir string, filter Filter, add entryFunc) error {
+	base := filepath.ToSlash(dir)
+	return fs.WalkDir(root.FS(), base, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if p == base {
+			return nil
+		}
+		rel := strings.TrimPrefix(p, base+"/")
+		if base == "." {
+			rel = p
+		}
+		if filter != nil && !filter(rel, d) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		return add(filepath.FromSlash(p), rel, info)
+	})
+}
+
+func tarEntry(root *fsroot.Root, tw *tar.Writer) entryFunc {
+	return func(name, rel string, info fs.FileInfo) error {
+		var link string
+		if info.Mode()&fs.ModeSymlink != 0 {
+			target, err := root.Readlink(name)
+			if err != nil {
+				return err
+			}
+			link = target
+		} else if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+
+		hdr, err := tar.FileInfoHeader(info, link)
+		if err != nil {
+			return err
+		}
+		hdr.Name = rel
+		if info.IsDir() {
+			hdr.Name += "/"
+		}
+		// Drop host-specific ownership; the archive is meant to be portable.
+		hdr.Uid, hdr.Gid, hdr.Uname, hdr.Gname = 0, 0, "", ""
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return nil
+		}
+		return copyFile(root, name, tw)
+	}
+}
+
+func zipEntry(root *fsroot.Root, zw *zip.Writer) entryFunc {
+	return func(name, rel string, info fs.FileInfo) error {
+		if !info.Mode().IsRegular() && !info.IsDir() {
+			return nil
+		}
+		hdr, err := zip.FileInfoHeader(info)
+		if err != nil {
+			return err
+		}
+		hdr.Name = path.Clean(rel)
+		if info.IsDir() {
+			hdr.Name += "/"
+		} else {
+			hdr.Method = zip.Deflate
+		}
+		fw, err := zw.CreateHeader(hdr)
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return nil
+		}
+		return copyFile(root, name, fw)
+	}
+}
+
+func copyFile(root *fsroot.Root, name string, w io.Writer) error {
+	f, err := root.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	return err
+}
@@ -0,0 +1,510 @@
+package archive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"cmp"
+	"compress/gzip"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pegnia/sidecar/internal/fsroot"
+)
+
+// Errors returned by Extract, wrapped in an *EntryError naming the offending entry.
+var (
+	ErrUnsafePath   = errors.New("entry path escapes the destination directory")
+	ErrForbidden    = errors.New("file type not allowed")
+	ErrTooLarge     = errors.New("archive exceeds the maximum uncompressed size")
+	ErrTooManyFiles = errors.New("archive exceeds the maximum number of entries")
+)
+
+// ErrCorrupt is returned when the archive itself cannot be decoded.
+var ErrCorrupt = errors.New("archive is corrupt or truncated")
+
+// EntryError reports which archive entry caused extraction to stop.
+type EntryError struct {
+	Name string
+	Err  error
+}
+
+func (e *EntryError) Error() string {
+	return fmt.Sprintf("archive entry %q: %v", e.Name, e.Err)
+}
+
+func (e *EntryError) Unwrap() error {
+	return e.Err
+}
+
+// ExtractOptions bounds what an archive may write.
+type ExtractOptions struct {
+	// MaxBytes caps the total uncompressed size of regular files. Zero means no limit.
+	MaxBytes int64
+	// MaxFiles caps the number of entries, directories included. Zero means no limit.
+	MaxFiles int
+	// Allow, if set, is consulted for every regular file name; false rejects the archive.
+	Allow func(name string) bool
+	// Overwrite replaces existing files instead of failing with fs.ErrExist.
+	Overwrite bool
+	// StagingDir, if set, is a root-relative directory, on the same
+	// filesystem as the destination, into which files replaced under
+	// Overwrite are moved so a failed extraction can put them back, and in
+	// which a zip stream is spooled. It is created when needed and removed
+	// once extraction finishes. Without it, replaced files are removed right
+	// away and lost if extraction fails, and zips are spooled to the
+	// system's temporary directory.
+	StagingDir string
+	// Check, if set, is consulted with the root-relative destination of every
+	// entry before it is written, and with the path every symlink entry points
+	// to; an error rejects the archive.
+	Check func(dst string) error
+	// FileMode and DirMode are the permissions of zip entries, which keep
+	// only their executable bits, and of parent directories the archive does
+	// not list; 0644 and 0755 if zero. Tar entries keep their recorded modes.
+	// Modes are applied exactly, regardless of the umask.
+	FileMode fs.FileMode
+	DirMode  fs.FileMode
+	// Created, if set, is called with the root-relative path of every file,
+	// directory and link the extraction creates, for example to change its
+	// owner; an error rejects the archive.
+	Created func(dst string) error
+	// Verify, if set, is called for every regular file once its content has
+	// been written, with its root-relative destination and the hidden file
+	// next to it that holds the content. The file is only moved into place if
+	// Verify returns nil; an error rejects the archive.
+	Verify func(dst, staged string) error
+}
+
+// Stats summarises a completed extraction.
+type Stats struct {
+	Files int
+	Bytes int64
+}
+
+// FormatFromName guesses the archive format from a file name's extension.
+func FormatFromName(name string) (Format, bool) {
+	name = strings.ToLower(name)
+	for _, f := range []Format{TarGz, TarZst, Tar, Zip} {
+		if strings.HasSuffix(name, f.Extension()) {
+			return f, true
+		}
+	}
+	if strings.HasSuffix(name, ".tgz") {
+		return TarGz, true
+	}
+	return "", false
+}
+
+// Extract unpacks the archive read from src into the existing directory dir
+// inside root. Entry names are checked before anything is written, and every
+// path is resolved through root so neither "../" names nor symlinks planted by
+// earlier entries can reach outside it. If extraction fails part way, the
+// files and directories it created are removed again, and files it replaced
+// under Overwrite are moved back from the StagingDir.
+func Extract(src io.Reader, root *fsroot.Root, dir string, format Format, opts ExtractOptions) (Stats, error) {
+	x := &extractor{root: root, dir: dir, opts: opts}
+	var err error
+	switch format {
+	case Zip:
+		err = x.zip(src)
+	case Tar:
+		err = x.tar(src)
+	case TarGz:
+		var gr *gzip.Reader
+		if gr, err = gzip.NewReader(src); err == nil {
+			err = x.tar(gr)
+			gr.Close()
+		}
+	case TarZst:
+		var zr *zstd.Decoder
+		if zr, err = zstd.NewReader(src); err == nil {
+			err = x.tar(zr)
+			zr.Close()
+		}
+	default:
+		err = fmt.Errorf("unsupported archive format %q", format)
+	}
+	if err != nil {
+		x.rollback()
+		return Stats{}, corrupt(err)
+	}
+	x.discardReplaced()
+	return x.stats, nil
+}
+
+// corrupt tags decoding errors with ErrCorrupt so callers can tell a bad
+// archive apart from a failure writing its contents.
+func corrupt(err error) error {
+	for _, target := range []error{zip.ErrFormat, zip.ErrChecksum, zip.ErrAlgorithm, gzip.ErrHeader, gzip.ErrChecksum,
+		tar.ErrHeader, zstd.ErrMagicMismatch, io.ErrUnexpectedEOF} {
+		if errors.Is(err, target) {
+			return fmt.Errorf("%w: %v", ErrCorrupt, err)
+		}
+	}
+	return err
+}
+
+type extractor struct {
+	root    *fsroot.Root
+	dir     string
+	opts    ExtractOptions
+	stats   Stats
+	entries int
+	created []string
+	// replaced lists the files moved aside under Overwrite, oldest first.
+	replaced []replacement
+}
+
+// replacement is an existing file moved aside to make room for an entry.
+type replacement struct {
+	dst, staged string
+}
+
+// target validates an entry name and returns its root-relative destination.
+// Absolute names and names that climb out with ".." are rejected outright.
+func (x *extractor) target(name string) (string, error) {
+	rel := filepath.FromSlash(strings.TrimSuffix(strings.ReplaceAll(name, "\\", "/"), "/"))
+	if !filepath.IsLocal(rel) {
+		return "", &EntryError{Name: name, Err: ErrUnsafePath}
+	}
+	dst := filepath.Join(x.dir, rel)
+	if x.opts.Check != nil {
+		if err := x.opts.Check(dst); err != nil {
+			return "", &EntryError{Name: name, Err: err}
+		}
+	}
+	return dst, nil
+}
+
+// count enforces MaxFiles.
+func (x *extractor) count(name string) error {
+	x.entries++
+	if x.opts.MaxFiles > 0 && x.entries > x.opts.MaxFiles {
+		return &EntryError{Name: name, Err: ErrTooManyFiles}
+	}
+	return nil
+}
+
+func (x *extractor) allow(name string) error {
+	if x.opts.Allow != nil && !x.opts.Allow(path.Base(name)) {
+		return &EntryError{Name: name, Err: ErrForbidden}
+	}
+	return nil
+}
+
+// mkdirAll creates dst and any missing parents, remembering what it created.
+func (x *extractor) mkdirAll(dst string, perm fs.FileMode) error {
+	info, err := x.root.Lstat(dst)
+	if err == nil {
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: dst, Err: fs.ErrExist}
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	if parent := filepath.Dir(dst); parent != dst {
+		if err := x.mkdirAll(parent, x.dirMode()); err != nil {
+			return err
+		}
+	}
+	if err := x.root.Mkdir(dst, perm|0700); err != nil {
+		return err
+	}
+	x.created = append(x.created, dst)
+	if err := x.root.Chmod(dst, perm.Perm()|0700); err != nil {
+		return err
+	}
+	return x.onCreate(dst)
+}
+
+func (x *extractor) fileMode() fs.FileMode { return cmp.Or(x.opts.FileMode, 0644) }
+func (x *extractor) dirMode() fs.FileMode  { return cmp.Or(x.opts.DirMode, 0755) }
+
+// onCreate reports a newly created entry to the Created hook.
+func (x *extractor) onCreate(dst string) error {
+	if x.opts.Created == nil {
+		return nil
+	}
+	return x.opts.Created(dst)
+}
+
+// prepare makes room for a new file or link at dst.
+func (x *extractor) prepare(dst string) error {
+	if err := x.mkdirAll(filepath.Dir(dst), x.dirMode()); err != nil {
+		return err
+	}
+	info, err := x.root.Lstat(dst)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if !x.opts.Overwrite || info.IsDir() {
+		return &fs.PathError{Op: "extract", Path: dst, Err: fs.ErrExist}
+	}
+	// An entry the archive itself wrote earlier needs no keeping.
+	if x.opts.StagingDir == "" || slices.Contains(x.created, dst) {
+		return x.root.Remove(dst)
+	}
+	if err := x.root.MkdirAll(x.opts.StagingDir, 0700); err != nil {
+		return err
+	}
+	staged := filepath.Join(x.opts.StagingDir, strconv.Itoa(len(x.replaced)))
+	if err := x.root.Rename(dst, staged); err != nil {
+		return err
+	}
+	x.replaced = append(x.replaced, replacement{dst: dst, staged: staged})
+	return nil
+}
+
+// writeFile copies one regular file, counting its bytes against MaxBytes.
+// With a Verify hook, the content is written to a hidden file next to dst
+// first, so a file Verify rejects never appears under its name.
+func (x *extractor) writeFile(name, dst string, perm fs.FileMode, r io.Reader) error {
+	if err := x.prepare(dst); err != nil {
+		return err
+	}
+	staged := dst
+	if x.opts.Verify != nil {
+		var suffix [4]byte
+		rand.Read(suffix[:])
+		staged = filepath.Join(filepath.Dir(dst), "."+filepath.Base(dst)+".extract-"+hex.EncodeToString(suffix[:]))
+	}
+	f, err := x.root.OpenFile(staged, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm.Perm()|0600)
+	if err != nil {
+		return err
+	}
+	x.created = append(x.created, staged)
+	if err := f.Chmod(perm.Perm() | 0600); err != nil {
+		f.Close()
+		return err
+	}
+	if err := x.onCreate(staged); err != nil {
+		f.Close()
+		return err
+	}
+
+	limit := int64(-1)
+	if x.opts.MaxBytes > 0 {
+		limit = x.opts.MaxBytes - x.stats.Bytes
+		r = io.LimitReader(r, limit+1)
+	}
+	n, err := io.Copy(f, r)
+	x.stats.Bytes += n
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return err
+	}
+	if limit >= 0 && n > limit {
+		return &EntryError{Name: name, Err: ErrTooLarge}
+	}
+	if staged != dst {
+		if err := x.opts.Verify(dst, staged); err != nil {
+			return &EntryError{Name: name, Err: err}
+		}
+		if err := x.root.Rename(staged, dst); err != nil {
+			return err
+		}
+		x.created[len(x.created)-1] = dst
+	}
+	x.stats.Files++
+	return nil
+}
+
+// symlink recreates a link, refusing targets that would resolve outside the
+// extraction directory. The target is vetted by Check like a destination, as
+// later entries can be written through the link.
+func (x *extractor) symlink(name, dst, target string) error {
+	rel, err := filepath.Rel(x.dir, dst)
+	if err != nil || filepath.IsAbs(target) || !filepath.IsLocal(filepath.Join(filepath.Dir(rel), filepath.FromSlash(target))) {
+		return &EntryError{Name: name, Err: ErrUnsafePath}
+	}
+	if x.opts.Check != nil {
+		if err := x.opts.Check(filepath.Join(filepath.Dir(dst), filepath.FromSlash(target))); err != nil {
+			return &EntryError{Name: name, Err: err}
+		}
+	}
+	if err := x.prepare(dst); err != nil {
+		return err
+	}
+	if err := x.root.Symlink(target, dst); err != nil {
+		return err
+	}
+	x.created = append(x.created, dst)
+	if err := x.onCreate(dst); err != nil {
+		return err
+	}
+	x.stats.Files++
+	return nil
+}
+
+func (x *extractor) tar(r io.Reader) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		dst, err := x.target(hdr.Name)
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := x.count(hdr.Name); err != nil {
+				return err
+			}
+			if err := x.mkdirAll(dst, fs.FileMode(hdr.Mode).Perm()); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			if err := x.count(hdr.Name); err != nil {
+				return err
+			}
+			if err := x.allow(hdr.Name); err != nil {
+				return err
+			}
+			if x.opts.MaxBytes > 0 && x.stats.Bytes+hdr.Size > x.opts.MaxBytes {
+				return &EntryError{Name: hdr.Name, Err: ErrTooLarge}
+			}
+			if err := x.writeFile(hdr.Name, dst, fs.FileMode(hdr.Mode), tr); err != nil {
+				return err
+			}
+		case tar.TypeSymlink:
+			if err := x.count(hdr.Name); err != nil {
+				return err
+			}
+			if err := x.symlink(hdr.Name, dst, hdr.Linkname); err != nil {
+				return err
+			}
+		default:
+			// Hard links, devices and other special entries are skipped.
+		}
+	}
+}
+
+// zip extracts a zip archive. The format keeps its index at the end, so the
+// stream is spooled to a temporary file first; that also lets every entry be
+// validated before anything is written.
+func (x *extractor) zip(src io.Reader) error {
+	tmp, done, err := x.spool()
+	if err != nil {
+		return err
+	}
+	defer done()
+	size, err := io.Copy(tmp, src)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(tmp, size)
+	if err != nil {
+		return err
+	}
+
+	var total uint64
+	for i, f := range zr.File {
+		if _, err := x.target(f.Name); err != nil {
+			return err
+		}
+		if x.opts.MaxFiles > 0 && i >= x.opts.MaxFiles {
+			return &EntryError{Name: f.Name, Err: ErrTooManyFiles}
+		}
+		if f.Mode().IsRegular() {
+			if err := x.allow(f.Name); err != nil {
+				return err
+			}
+			total += f.UncompressedSize64
+			if x.opts.MaxBytes > 0 && total > uint64(x.opts.MaxBytes) {
+				return &EntryError{Name: f.Name, Err: ErrTooLarge}
+			}
+		}
+	}
+
+	// Zip tools rarely record meaningful Unix modes, so only the executable
+	// bits are carried over.
+	for _, f := range zr.File {
+		dst, _ := x.target(f.Name)
+		x.entries++
+		switch mode := f.Mode(); {
+		case mode.IsDir():
+			if err := x.mkdirAll(dst, x.dirMode()); err != nil {
+				return err
+			}
+		case mode.IsRegular():
+			// The declared size is not trusted: writeFile enforces MaxBytes on
+			// the bytes actually decompressed.
+			rc, err := f.Open()
+			if err != nil {
+				return err
+			}
+			err = x.writeFile(f.Name, dst, x.fileMode()|mode&0111, rc)
+			rc.Close()
+			if err != nil {
+				return err
+			}
+		default:
+			// Zip symlinks and special files have no portable meaning and are skipped.
+		}
+	}
+	return nil
+}
+
+// spool creates the file a zip stream is buffered in, and returns a function
+// that closes it and removes it. In the StagingDir it takes space on the data
+// volume, which the archive is headed for anyway, instead of in a temporary
+// directory that may be small or in memory; it is removed with the StagingDir.
+func (x *extractor) spool() (*os.File, func(), error) {
+	if x.opts.StagingDir == "" {
+		tmp, err := os.CreateTemp("", "sidecar-extract-*.zip")
+		if err != nil {
+			return nil, nil, err
+		}
+		return tmp, func() { tmp.Close(); os.Remove(tmp.Name()) }, nil
+	}
+	if err := x.root.MkdirAll(x.opts.StagingDir, 0700); err != nil {
+		return nil, nil, err
+	}
+	f, err := x.root.OpenFile(filepath.Join(x.opts.StagingDir, "archive.zip"), os.O_RDWR|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return nil, nil, err
+	}
+	return f, func() { f.Close() }, nil
+}
+
+// rollback removes everything the extraction created, newest first, then
+// moves the files it replaced back into place.
+func (x *extractor) rollback() {
+	for i := len(x.created) - 1; i >= 0; i-- {
+		x.root.Remove(x.created[i])
+	}
+	for i := len(x.replaced) - 1; i >= 0; i-- {
+		x.root.Rename(x.replaced[i].staged, x.replaced[i].dst)
+	}
+	x.discardReplaced()
+}
+
+// discardReplaced removes the StagingDir along with whatever is left in it.
+func (x *extractor) discardReplaced() {
+	if x.opts.StagingDir != "" {
+		x.root.RemoveAll(x.opts.StagingDir)
+	}
+}
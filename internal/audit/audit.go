@@ -0,0 +1,160 @@
+// Package audit records mutating operations performed through the sidecar.
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/config"
+)
+
+// ErrNoFile is returned by Query when no audit file has been configured.
+var ErrNoFile = errors.New("audit log file not configured")
+
+// Result values recorded on an Event.
+const (
+	ResultSuccess = "success"
+	ResultFailure = "failure"
+)
+
+// Event is a single audited operation, stored as one JSON line.
+type Event struct {
+	Time       time.Time `json:"time"`
+	Action     string    `json:"action"`
+	Actor      string    `json:"actor"`
+	RemoteAddr string    `json:"remote_addr,omitempty"`
+	Path       string    `json:"path,omitempty"`
+	Target     string    `json:"target,omitempty"`
+	Bytes      int64     `json:"bytes,omitempty"`
+	Result     string    `json:"result"`
+	Error      string    `json:"error,omitempty"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// Filter selects events returned by Query. Zero values match everything.
+type Filter struct {
+	Since  time.Time
+	Until  time.Time
+	Actor  string
+	Action string
+	// Limit caps the number of events returned, keeping the most recent ones.
+	Limit int
+}
+
+func (f Filter) match(e Event) bool {
+	if !f.Since.IsZero() && e.Time.Before(f.Since) {
+		return false
+	}
+	if !f.Until.IsZero() && e.Time.After(f.Until) {
+		return false
+	}
+	if f.Actor != "" && e.Actor != f.Actor {
+		return false
+	}
+	if f.Action != "" && e.Action != f.Action {
+		return false
+	}
+	return true
+}
+
+// Logger appends audit events to a JSON lines file and, optionally, stdout.
+// A Logger with neither destination configured discards events.
+type Logger struct {
+	mu     sync.Mutex
+	path   string
+	file   *os.File
+	stdout io.Writer
+	logger *slog.Logger
+}
+
+// New creates a Logger from configuration, opening the audit file for appending.
+func New(cfg config.AuditConfig) (*Logger, error) {
+	l := &Logger{
+		path:   cfg.File,
+		logger: slog.With("component", "audit"),
+	}
+	if cfg.Stdout {
+		l.stdout = os.Stdout
+	}
+	if cfg.File != "" {
+		file, err := os.OpenFile(cfg.File, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("opening audit log: %w", err)
+		}
+		l.file = file
+	}
+	return l, nil
+}
+
+// Record writes an event. The timestamp is filled in if unset. Failures to
+// write are logged rather than returned so auditing never blocks an operation.
+func (l *Logger) Record(e Event) {
+	if l == nil || (l.file == nil && l.stdout == nil) {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now().UTC()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		l.logger.Error("Failed to encode audit event", "error", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		if _, err := l.file.Write(line); err != nil {
+			l.logger.Error("Failed to write audit event", "path", l.path, "error", err)
+		}
+	}
+	if l.stdout != nil {
+		l.stdout.Write(line)
+	}
+}
+
+// Query reads the audit file and returns the events matching f, oldest first.
+func (l *Logger) Query(f Filter) ([]Event, error) {
+	if l == nil || l.path == "" {
+		return nil, ErrNoFile
+	}
+	file, err := os.Open(l.path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	events := []Event{}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var e Event
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			continue
+		}
+		if !f.match(e) {
+			continue
+		}
+		events = append(events, e)
+		if f.Limit > 0 && len(events) > f.Limit {
+			events = events[1:]
+		}
+	}
+	return events, scanner.Err()
+}
+
+// Close closes the audit file.
+func (l *Logger) Close() error {
+	if l == nil || l.file == nil {
+		return nil
+	}
+	return l.file.Close()
+}
@@ -0,0 +1,309 @@
+// Package backup keeps snapshots of the data root as compressed archives in a
+// local directory. Each snapshot is an archive named after its ID plus a
+// JSON metadata file; a snapshot exists once its metadata has been written.
+package backup
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/archive"
+)
+
+// idTimeLayout is the time part of snapshot IDs, which therefore sort by age.
+const idTimeLayout = "20060102T150405Z"
+
+// partialSuffix marks an archive that is still being written.
+const partialSuffix = ".partial"
+
+// ErrChecksum is returned when an imported archive does not match the size
+// and checksum recorded for it.
+var ErrChecksum = errors.New("archive does not match its checksum")
+
+// Snapshot describes a stored snapshot. Size and SHA256 are those of the
+// archive; Files is the number of files in it.
+type Snapshot struct {
+	ID        string         `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	CreatedBy string         `json:"created_by"`
+	Label     string         `json:"label,omitempty"`
+	Format    archive.Format `json:"format"`
+	Paths     []string       `json:"paths,omitempty"`
+	Size      int64          `json:"size"`
+	Files     int            `json:"files"`
+	SHA256    string         `json:"sha256"`
+	// RemoteKey is the object key of the archive in remote storage, once
+	// it has been uploaded there.
+	RemoteKey string `json:"remote_key,omitempty"`
+}
+
+// NewID returns a new snapshot ID for a snapshot taken at t.
+func NewID(t time.Time) string {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return t.UTC().Format(idTimeLayout) + "-" + hex.EncodeToString(b)
+}
+
+// ValidID reports whether id has the shape generated by NewID.
+func ValidID(id string) bool {
+	ts, suffix, ok := strings.Cut(id, "-")
+	if !ok || len(suffix) != 8 {
+		return false
+	}
+	if _, err := hex.DecodeString(suffix); err != nil || strings.ToLower(suffix) != suffix {
+		return false
+	}
+	_, err := time.Parse(idTimeLayout, ts)
+	return err == nil
+}
+
+// Dir is a directory of snapshots on the host.
+type Dir struct {
+	dir string
+}
+
+// OpenDir opens the snapshot directory dir, creating it if needed. Archives
+// left behind by snapshots that were interrupted are removed.
+func OpenDir(dir string) (*Dir, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	d := &Dir{dir: dir}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, e := range entries {
+		id, _, _ := strings.Cut(e.Name(), ".")
+		if strings.HasSuffix(e.Name(), partialSuffix) || ValidID(id) && !d.exists(id+".json") {
+			os.Remove(filepath.Join(dir, e.Name()))
+		}
+	}
+	return d, nil
+}
+
+func (d *Dir) exists(name string) bool {
+	_, err := os.Lstat(filepath.Join(d.dir, name))
+	return err == nil
+}
+
+func (d *Dir) metaName(id string) string {
+	return filepath.Join(d.dir, id+".json")
+}
+
+func (d *Dir) archiveName(snap *Snapshot) string {
+	return filepath.Join(d.dir, snap.ID+snap.Format.Extension())
+}
+
+// Create stores a new snapshot whose archive is produced by write. The
+// metadata in snap is saved after write returns, so write may still fill in
+// fields such as Files; Size and SHA256 are set by Create.
+func (d *Dir) Create(snap *Snapshot, write func(io.Writer) error) error {
+	return d.create(snap, write, nil)
+}
+
+// Import stores a snapshot taken elsewhere, such as one fetched from remote
+// storage, under its own ID. The archive read from r must match the Size and
+// SHA256 in snap, or Import fails with ErrChecksum.
+func (d *Dir) Import(snap Snapshot, r io.Reader) error {
+	want := snap
+	return d.create(&snap, func(w io.Writer) error {
+		_, err := io.Copy(w, r)
+		return err
+	}, func() error {
+		if snap.Size != want.Size || snap.SHA256 != want.SHA256 {
+			return fmt.Errorf("snapshot %q: %w", snap.ID, ErrChecksum)
+		}
+		return nil
+	})
+}
+
+// create is Create, with check called once Size and SHA256 are known and
+// before the snapshot is stored.
+func (d *Dir) create(snap *Snapshot, write func(io.Writer) error, check func() error) (err error) {
+	name := d.archiveName(snap)
+	f, err := os.OpenFile(name+partialSuffix, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			f.Close()
+			os.Remove(name + partialSuffix)
+			os.Remove(name)
+		}
+	}()
+
+	sum := sha256.New()
+	counter := &countingWriter{w: io.MultiWriter(f, sum)}
+	if err := write(counter); err != nil {
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	snap.Size, snap.SHA256 = counter.n, hex.EncodeToString(sum.Sum(nil))
+	if check != nil {
+		if err := check(); err != nil {
+			return err
+		}
+	}
+	if err := os.Rename(name+partialSuffix, name); err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(d.metaName(snap.ID), data); err != nil {
+		return err
+	}
+	d.syncDir()
+	return nil
+}
+
+// Update rewrites the metadata of the existing snapshot snap.ID.
+func (d *Dir) Update(snap *Snapshot) error {
+	if _, err := d.Get(snap.ID); err != nil {
+		return err
+	}
+	data, err := json.Marshal(snap)
+	if err != nil {
+		return err
+	}
+	if err := writeFileSync(d.metaName(snap.ID), data); err != nil {
+		return err
+	}
+	d.syncDir()
+	return nil
+}
+
+// List returns every snapshot, newest first.
+func (d *Dir) List() ([]Snapshot, error) {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		return nil, err
+	}
+	snaps := []Snapshot{}
+	for _, e := range entries {
+		id, ok := strings.CutSuffix(e.Name(), ".json")
+		if !ok || !ValidID(id) {
+			continue
+		}
+		snap, err := d.Get(id)
+		if err != nil {
+			continue
+		}
+		snaps = append(snaps, snap)
+	}
+	slices.SortFunc(snaps, func(a, b Snapshot) int {
+		return b.CreatedAt.Compare(a.CreatedAt)
+	})
+	return snaps, nil
+}
+
+// Get returns the snapshot id. It fails with fs.ErrNotExist if there is none.
+func (d *Dir) Get(id string) (Snapshot, error) {
+	var snap Snapshot
+	if !ValidID(id) {
+		return snap, fmt.Errorf("snapshot %q: %w", id, fs.ErrNotExist)
+	}
+	data, err := os.ReadFile(d.metaName(id))
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return snap, fmt.Errorf("snapshot %q: %w", id, fs.ErrNotExist)
+		}
+		return snap, err
+	}
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return snap, fmt.Errorf("reading snapshot %q: %w", id, err)
+	}
+	if _, err := os.Lstat(d.archiveName(&snap)); err != nil {
+		return snap, fmt.Errorf("snapshot %q: %w", id, fs.ErrNotExist)
+	}
+	return snap, nil
+}
+
+// Open opens the archive of snapshot id for reading.
+func (d *Dir) Open(id string) (*os.File, Snapshot, error) {
+	snap, err := d.Get(id)
+	if err != nil {
+		return nil, snap, err
+	}
+	f, err := os.Open(d.archiveName(&snap))
+	return f, snap, err
+}
+
+// Delete removes snapshot id. The metadata goes first, so a snapshot that
+// could only be partly removed is no longer listed, and its archive is
+// cleaned up when the directory is next opened.
+func (d *Dir) Delete(id string) (Snapshot, error) {
+	snap, err := d.Get(id)
+	if err != nil {
+		return snap, err
+	}
+	if err := os.Remove(d.metaName(id)); err != nil {
+		return snap, err
+	}
+	err = os.Remove(d.archiveName(&snap))
+	d.syncDir()
+	return snap, err
+}
+
+// syncDir flushes the directory so renames and removals survive a crash.
+func (d *Dir) syncDir() {
+	if f, err := os.Open(d.dir); err == nil {
+		f.Sync()
+		f.Close()
+	}
+}
+
+// writeFileSync writes data to name through a temporary file, so a crash
+// leaves either no file or the complete one.
+func writeFileSync(name string, data []byte) error {
+	tmp := name + partialSuffix
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Rename(tmp, name)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(b []byte) (int, error) {
+	n, err := c.w.Write(b)
+	c.n += int64(n)
+	return n, err
+}
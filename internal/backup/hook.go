@@ -0,0 +1,54 @@
+package backup
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// maxHookOutput limits how much of a failed hook's output is kept.
+const maxHookOutput = 4096
+
+// HookError is returned when a consistency hook fails or times out. Output
+// holds the start of what the command wrote to stdout and stderr.
+type HookError struct {
+	Hook   string
+	Output string
+	Err    error
+}
+
+func (e *HookError) Error() string {
+	return fmt.Sprintf("%s command failed: %v", e.Hook, e.Err)
+}
+
+func (e *HookError) Unwrap() error {
+	return e.Err
+}
+
+// RunHook runs the hook command argv, if any, with the snapshot ID in
+// SIDECAR_BACKUP_ID. hook names it in errors, e.g. "pre-snapshot".
+func RunHook(ctx context.Context, hook string, argv []string, timeout time.Duration, id string) error {
+	if len(argv) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Env = append(os.Environ(), "SIDECAR_BACKUP_ID="+id)
+	out, err := cmd.CombinedOutput()
+	if err == nil {
+		return nil
+	}
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		err = fmt.Errorf("did not finish within %s", timeout)
+	}
+	out = bytes.TrimSpace(out)
+	if len(out) > maxHookOutput {
+		out = out[:maxHookOutput]
+	}
+	return &HookError{Hook: hook, Output: string(out), Err: err}
+}
@@ -0,0 +1,90 @@
+package backup
+
+import "time"
+
+// Retention is a grandfather-father-son retention policy: it keeps the newest
+// snapshot of each of the last Hourly hours, Daily days and Weekly ISO weeks
+// that have one, then drops the oldest of those until all snapshots together
+// take up at most MaxBytes. Periods are calendar periods in the local time
+// zone. A zero count keeps no snapshots for that period; with all counts zero
+// every snapshot is kept, and a MaxBytes of 0 means no size limit.
+type Retention struct {
+	Hourly, Daily, Weekly int
+	MaxBytes              int64
+}
+
+// Expired returns the snapshots of snaps, which must be sorted newest first,
+// that the policy does not keep. Only snapshots for which prunable reports
+// true are subject to it; the others count towards MaxBytes but are never
+// returned. The newest prunable snapshot is always kept.
+func (r Retention) Expired(snaps []Snapshot, prunable func(Snapshot) bool) []Snapshot {
+	var candidates []Snapshot
+	var total int64
+	for _, snap := range snaps {
+		total += snap.Size
+		if prunable(snap) {
+			candidates = append(candidates, snap)
+		}
+	}
+	if len(candidates) == 0 {
+		return nil
+	}
+
+	keep := make([]bool, len(candidates))
+	if r.Hourly == 0 && r.Daily == 0 && r.Weekly == 0 {
+		for i := range keep {
+			keep[i] = true
+		}
+	} else {
+		keep[0] = true
+		for _, p := range []struct {
+			n      int
+			period func(time.Time) time.Time
+		}{
+			{r.Hourly, func(t time.Time) time.Time {
+				return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), 0, 0, 0, t.Location())
+			}},
+			{r.Daily, startOfDay},
+			{r.Weekly, func(t time.Time) time.Time {
+				return startOfDay(t).AddDate(0, 0, -(int(t.Weekday())+6)%7)
+			}},
+		} {
+			var last time.Time
+			for i, snap := range candidates {
+				if p.n == 0 {
+					break
+				}
+				if period := p.period(snap.CreatedAt.Local()); !period.Equal(last) {
+					last = period
+					keep[i] = true
+					p.n--
+				}
+			}
+		}
+	}
+
+	for i, snap := range candidates {
+		if !keep[i] {
+			total -= snap.Size
+		}
+	}
+	for i := len(candidates) - 1; i > 0 && r.MaxBytes > 0 && total > r.MaxBytes; i-- {
+		if keep[i] {
+			keep[i] = false
+			total -= candidates[i].Size
+		}
+	}
+
+	var expired []Snapshot
+	for i, snap := range candidates {
+		if !keep[i] {
+			expired = append(expired, snap)
+		}
+	}
+	return expired
+}
+
+// startOfDay returns midnight at the start of t's day.
+func startOfDay(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
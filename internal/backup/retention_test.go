@@ -0,0 +1,124 @@
+package backup
+
+import (
+	"slices"
+	"testing"
+	"time"
+)
+
+// snapshotsAt returns snapshots of size 10 taken at the given times, which
+// name them, in the order given.
+func snapshotsAt(t *testing.T, times ...string) []Snapshot {
+	t.Helper()
+	var snaps []Snapshot
+	for _, s := range times {
+		created, err := time.Parse("2006-01-02 15:04", s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		snaps = append(snaps, Snapshot{ID: s, CreatedAt: created, Size: 10})
+	}
+	return snaps
+}
+
+func TestRetentionExpired(t *testing.T) {
+	// Periods are taken in the local time zone.
+	local := time.Local
+	time.Local = time.UTC
+	t.Cleanup(func() { time.Local = local })
+
+	for _, tt := range []struct {
+		name      string
+		retention Retention
+		snaps     []string
+		want      []string
+	}{
+		{
+			name:      "no limits",
+			retention: Retention{},
+			snaps:     []string{"2026-03-09 10:00", "2026-03-09 09:00", "2026-03-01 00:00"},
+		},
+		{
+			name:      "hourly",
+			retention: Retention{Hourly: 2},
+			snaps:     []string{"2026-03-09 10:50", "2026-03-09 10:10", "2026-03-09 09:30", "2026-03-09 08:00"},
+			want:      []string{"2026-03-09 10:10", "2026-03-09 08:00"},
+		},
+		{
+			name:      "hours without snapshots are not counted",
+			retention: Retention{Hourly: 2},
+			snaps:     []string{"2026-03-09 10:00", "2026-03-09 03:00", "2026-03-08 23:00"},
+			want:      []string{"2026-03-08 23:00"},
+		},
+		{
+			name:      "daily",
+			retention: Retention{Daily: 2},
+			snaps:     []string{"2026-03-09 12:00", "2026-03-09 08:00", "2026-03-08 23:59", "2026-03-08 00:00", "2026-03-07 12:00"},
+			want:      []string{"2026-03-09 08:00", "2026-03-08 00:00", "2026-03-07 12:00"},
+		},
+		{
+			// March 9, 2026 is a Monday, so the 8th ends the week before.
+			name:      "weekly",
+			retention: Retention{Weekly: 2},
+			snaps:     []string{"2026-03-09 00:00", "2026-03-08 23:00", "2026-03-07 12:00", "2026-03-02 00:00", "2026-03-01 12:00"},
+			want:      []string{"2026-03-07 12:00", "2026-03-02 00:00", "2026-03-01 12:00"},
+		},
+		{
+			name:      "periods combined",
+			retention: Retention{Hourly: 2, Daily: 2, Weekly: 3},
+			snaps: []string{
+				"2026-03-09 10:30", "2026-03-09 10:00", "2026-03-09 09:00", "2026-03-09 08:00",
+				"2026-03-08 12:00", "2026-03-08 06:00", "2026-03-07 12:00", "2026-03-01 12:00", "2026-02-22 12:00",
+			},
+			want: []string{
+				"2026-03-09 10:00", "2026-03-09 08:00",
+				"2026-03-08 06:00", "2026-03-07 12:00", "2026-02-22 12:00",
+			},
+		},
+		{
+			name:      "size limit drops the oldest kept",
+			retention: Retention{MaxBytes: 25},
+			snaps:     []string{"2026-03-09 10:00", "2026-03-09 09:00", "2026-03-09 08:00", "2026-03-09 07:00"},
+			want:      []string{"2026-03-09 08:00", "2026-03-09 07:00"},
+		},
+		{
+			name:      "size limit with periods",
+			retention: Retention{Daily: 3, MaxBytes: 20},
+			snaps:     []string{"2026-03-09 10:00", "2026-03-09 09:00", "2026-03-08 10:00", "2026-03-07 10:00"},
+			want:      []string{"2026-03-09 09:00", "2026-03-07 10:00"},
+		},
+		{
+			name:      "newest is always kept",
+			retention: Retention{MaxBytes: 5},
+			snaps:     []string{"2026-03-09 10:00", "2026-03-09 09:00"},
+			want:      []string{"2026-03-09 09:00"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []string
+			for _, snap := range tt.retention.Expired(snapshotsAt(t, tt.snaps...), func(Snapshot) bool { return true }) {
+				got = append(got, snap.ID)
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got expired %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestRetentionUnprunable checks that snapshots the policy does not apply to
+// count towards the size limit but are never expired.
+func TestRetentionUnprunable(t *testing.T) {
+	snaps := snapshotsAt(t, "2026-03-09 10:00", "2026-03-09 09:00", "2026-03-09 08:00", "2026-03-09 07:00")
+	manual := map[string]bool{"2026-03-09 10:00": true, "2026-03-09 07:00": true}
+	var got []string
+	for _, snap := range (Retention{MaxBytes: 25}).Expired(snaps, func(s Snapshot) bool { return !manual[s.ID] }) {
+		got = append(got, snap.ID)
+	}
+	if want := []string{"2026-03-09 08:00"}; !slices.Equal(got, want) {
+		t.Errorf("got expired %q, want %q", got, want)
+	}
+	if got := (Retention{Hourly: 1}).Expired(snaps, func(Snapshot) bool { return false }); got != nil {
+		t.Errorf("got expired %v with nothing prunable", got)
+	}
+}
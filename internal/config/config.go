@@ -2,6 +2,7 @@ package config
 
 import (
 	"os"
+	"strconv"
 	"time"
 )
 
@@ -10,6 +11,7 @@ type Config struct {
 	Agones AgonesConfig
 	API    APIConfig
 	Data   DataConfig
+	Audit  AuditConfig
 }
 
 // AgonesConfig holds settings for the Agones SDK interaction.
@@ -33,6 +35,12 @@ type DataConfig struct {
 	StdoutFile string
 }
 
+// AuditConfig controls where audit events for mutating operations are written.
+type AuditConfig struct {
+	File   string
+	Stdout bool
+}
+
 // LoadFromEnv loads configuration from environment variables.
 func LoadFromEnv() *Config {
 	return &Config{
@@ -51,6 +59,10 @@ func LoadFromEnv() *Config {
 			Root:       getEnv("SIDECAR_DATA_ROOT", "/data"),
 			StdoutFile: getEnv("SIDECAR_STDOUT_FILE", "logs/stdout.log"),
 		},
+		Audit: AuditConfig{
+			File:   getEnv("SIDECAR_AUDIT_FILE", ""),
+			Stdout: getEnvBool("SIDECAR_AUDIT_STDOUT", false),
+		},
 	}
 }
 
@@ -70,3 +82,12 @@ func getEnvDuration(key string, fallback time.Duration) time.Duration {
 	}
 	return fallback
 }
+
+func getEnvBool(key string, fallback bool) bool {
+	if value, ok := os.LookupEnv(key); ok {
+		if b, err := strconv.ParseBool(value); err == nil {
+			return b
+		}
+	}
+	return fallback
+}
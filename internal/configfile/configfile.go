@@ -0,0 +1,302 @@
+// Package configfile reads and edits game server configuration files in
+// place. Settings are exposed as JSON-compatible values and changed with JSON
+// merge patches (RFC 7386); edits touch only the affected lines or nodes, so
+// comments, ordering and formatting elsewhere in the file are preserved.
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Format is a supported configuration file format.
+type Format string
+
+const (
+	Properties Format = "properties"
+	INI        Format = "ini"
+	JSON       Format = "json"
+	YAML       Format = "yaml"
+	TOML       Format = "toml"
+)
+
+// Formats lists every supported format.
+var Formats = []Format{Properties, INI, JSON, YAML, TOML}
+
+// ParseFormat validates a user-supplied format name.
+func ParseFormat(s string) (Format, error) {
+	switch f := Format(strings.ToLower(s)); f {
+	case Properties, INI, JSON, YAML, TOML:
+		return f, nil
+	case "yml":
+		return YAML, nil
+	}
+	return "", fmt.Errorf("unsupported config format %q", s)
+}
+
+// FormatFromName guesses the format from a file name's extension.
+func FormatFromName(name string) (Format, bool) {
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".properties":
+		return Properties, true
+	case ".ini", ".cfg":
+		return INI, true
+	case ".json":
+		return JSON, true
+	case ".yaml", ".yml":
+		return YAML, true
+	case ".toml":
+		return TOML, true
+	}
+	return "", false
+}
+
+// ErrSyntax wraps errors for files that cannot be parsed in their format.
+var ErrSyntax = errors.New("invalid config file")
+
+// ErrPatch wraps errors for patches that do not fit the file's structure,
+// such as nested objects for a flat .properties file.
+var ErrPatch = errors.New("invalid config patch")
+
+// Get parses text and returns its settings. Objects are returned as *Object
+// so key order follows the file.
+func Get(format Format, text string) (*Object, error) {
+	switch format {
+	case Properties:
+		return getProperties(text), nil
+	case INI:
+		return getINI(text), nil
+	case JSON:
+		return getJSON(text)
+	case YAML:
+		return getYAML(text)
+	case TOML:
+		return getTOML(text)
+	}
+	return nil, fmt.Errorf("unsupported config format %q", format)
+}
+
+// Patch applies a JSON merge patch to the settings in text and returns the
+// edited file. A null value removes a key; an object merges into the
+// existing object; anything else replaces the value.
+func Patch(format Format, text string, patch *Object) (string, error) {
+	switch format {
+	case Properties:
+		return patchProperties(text, patch)
+	case INI:
+		return patchINI(text, patch)
+	case JSON:
+		return patchJSON(text, patch)
+	case YAML:
+		return patchYAML(text, patch)
+	case TOML:
+		return patchTOML(text, patch)
+	}
+	return "", fmt.Errorf("unsupported config format %q", format)
+}
+
+// Member is a single key of an Object.
+type Member struct {
+	Key   string
+	Value any
+}
+
+// Object is a JSON object that remembers key order.
+type Object struct {
+	Members []Member
+}
+
+// Get returns the value of key.
+func (o *Object) Get(key string) (any, bool) {
+	for _, m := range o.Members {
+		if m.Key == key {
+			return m.Value, true
+		}
+	}
+	return nil, false
+}
+
+// Set replaces the value of key, appending it if absent.
+func (o *Object) Set(key string, value any) {
+	for i, m := range o.Members {
+		if m.Key == key {
+			o.Members[i].Value = value
+			return
+		}
+	}
+	o.Members = append(o.Members, Member{Key: key, Value: value})
+}
+
+// Delete removes key.
+func (o *Object) Delete(key string) {
+	for i, m := range o.Members {
+		if m.Key == key {
+			o.Members = append(o.Members[:i], o.Members[i+1:]...)
+			return
+		}
+	}
+}
+
+// MarshalJSON encodes the object with its keys in order.
+func (o *Object) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteByte('{')
+	for i, m := range o.Members {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		key, _ := marshal(m.Key)
+		buf.Write(key)
+		buf.WriteByte(':')
+		value, err := marshal(m.Value)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(value)
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
+
+// marshal is json.Marshal without HTML escaping, so values like "<none>"
+// written back to a file stay readable.
+func marshal(v any) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimSuffix(buf.Bytes(), []byte("\n")), nil
+}
+
+// merge applies a JSON merge patch to target in place.
+func merge(target, patch *Object) {
+	for _, m := range patch.Members {
+		switch v := m.Value.(type) {
+		case nil:
+			target.Delete(m.Key)
+		case *Object:
+			existing, ok := valueOf(target, m.Key).(*Object)
+			if !ok {
+				existing = &Object{}
+			}
+			merge(existing, v)
+			target.Set(m.Key, existing)
+		default:
+			target.Set(m.Key, v)
+		}
+	}
+}
+
+func valueOf(obj *Object, key string) any {
+	v, _ := obj.Get(key)
+	return v
+}
+
+// DecodeObject reads a JSON object, keeping key order and number text. Nested
+// objects are *Object, arrays []any and numbers json.Number.
+func DecodeObject(r io.Reader) (*Object, error) {
+	dec := json.NewDecoder(r)
+	dec.UseNumber()
+	v, err := decodeValue(dec)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := dec.Token(); err != io.EOF {
+		return nil, errors.New("unexpected data after JSON value")
+	}
+	obj, ok := v.(*Object)
+	if !ok {
+		return nil, errors.New("expected a JSON object")
+	}
+	return obj, nil
+}
+
+func decodeValue(dec *json.Decoder) (any, error) {
+	tok, err := dec.Token()
+	if err != nil {
+		return nil, err
+	}
+	switch tok {
+	case json.Delim('{'):
+		obj := &Object{}
+		for dec.More() {
+			keyTok, err := dec.Token()
+			if err != nil {
+				return nil, err
+			}
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(keyTok.(string), value)
+		}
+		_, err := dec.Token()
+		return obj, err
+	case json.Delim('['):
+		arr := []any{}
+		for dec.More() {
+			value, err := decodeValue(dec)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, value)
+		}
+		_, err := dec.Token()
+		return arr, err
+	}
+	return tok, nil
+}
+
+// scalarString formats a patch value for formats that store every value as
+// text. Booleans follow the capitalisation of the value they replace, so an
+// INI file using True/False keeps doing so.
+func scalarString(v any, old string) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		s := strconv.FormatBool(v)
+		if strings.EqualFold(old, "true") || strings.EqualFold(old, "false") {
+			switch {
+			case old == strings.ToUpper(old):
+				s = strings.ToUpper(s)
+			case old[:1] == strings.ToUpper(old[:1]):
+				s = strings.ToUpper(s[:1]) + s[1:]
+			}
+		}
+		return s, nil
+	}
+	return "", fmt.Errorf("%w: expected a string, number or boolean, got %T", ErrPatch, v)
+}
+
+// splitLines splits text into lines without their terminators and reports
+// the line ending in use, so edited files keep it.
+func splitLines(text string) ([]string, string) {
+	eol := "\n"
+	if strings.Contains(text, "\r\n") {
+		eol = "\r\n"
+	}
+	text = strings.TrimSuffix(strings.ReplaceAll(text, "\r\n", "\n"), "\n")
+	if text == "" {
+		return nil, eol
+	}
+	return strings.Split(text, "\n"), eol
+}
+
+// joinLines is the inverse of splitLines; the result ends with a line ending.
+func joinLines(lines []string, eol string) string {
+	if len(lines) == 0 {
+		return ""
+	}
+	return strings.Join(lines, eol) + eol
+}
@@ -0,0 +1,147 @@
+package configfile
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// file joins its arguments into the text of a file.
+func file(l ...string) string {
+	return strings.Join(l, "\n") + "\n"
+}
+
+func TestPatch(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		format Format
+		text   string
+		patch  string
+		want   string
+		get    string // the settings of want
+	}{
+		{
+			name:   "properties",
+			format: Properties,
+			text:   file("# Server settings", "motd=Hello", "! spawn", "spawn-protection = 16", "level-name:world"),
+			patch:  `{"motd":"Hi there","spawn-protection":0,"pvp":true,"level-name":null}`,
+			want:   file("# Server settings", "motd=Hi there", "! spawn", "spawn-protection = 0", "pvp=true"),
+			get:    `{"motd":"Hi there","spawn-protection":"0","pvp":"true"}`,
+		},
+		{
+			name:   "properties, CRLF",
+			format: Properties,
+			text:   "a=1\r\nb=2\r\n",
+			patch:  `{"a":"x"}`,
+			want:   "a=x\r\nb=2\r\n",
+			get:    `{"a":"x","b":"2"}`,
+		},
+		{
+			name:   "ini",
+			format: INI,
+			text: file(
+				"; global", "Name=Test ; the name", "",
+				"[Server]", "# port", "Port = 7777   # default", "Url=http://x/#a", `Motd="a ; b"`,
+				"[Engrams]", "Override=A", "Override=B",
+			),
+			patch: `{"Name":"New","Server":{"Port":7778,"Url":null,"Pvp":"True"},"Engrams":{"Override":["C"]},"Extra":{"K":"v"}}`,
+			want: file(
+				"; global", "Name=New ; the name", "",
+				"[Server]", "# port", "Port = 7778   # default", `Motd="a ; b"`, "Pvp=True",
+				"[Engrams]", "Override=C", "",
+				"[Extra]", "K=v",
+			),
+			get: `{"Name":"New","Server":{"Port":"7778","Motd":"\"a ; b\"","Pvp":"True"},"Engrams":{"Override":"C"},"Extra":{"K":"v"}}`,
+		},
+		{
+			name:   "ini, remove section",
+			format: INI,
+			text:   file("[A]", "x=1", "[B]", "y=2"),
+			patch:  `{"A":null}`,
+			want:   file("[B]", "y=2"),
+			get:    `{"B":{"y":"2"}}`,
+		},
+		{
+			name:   "yaml",
+			format: YAML,
+			text:   file("# top", "server:", "  # the port", "  port: 25565 # default", `  motd: "hi"`, "list:", "  - a", "  - b"),
+			patch:  `{"server":{"port":25566,"motd":null,"pvp":true},"list":["c"],"new":{"a":1}}`,
+			want:   file("# top", "server:", "  # the port", "  port: 25566 # default", "  pvp: true", "list:", "  - c", "new:", "  a: 1"),
+			get:    `{"server":{"port":25566,"pvp":true},"list":["c"],"new":{"a":1}}`,
+		},
+		{
+			name:   "toml",
+			format: TOML,
+			text:   file("# top", `title = "x" # name`, "", "[server]", "# port", "port = 25565 # default", `motd = "hi"`, "", "[other]", "k = 1"),
+			patch:  `{"title":"y","server":{"port":25566,"motd":null,"pvp":true},"other":null,"new":{"a":1}}`,
+			want:   file("# top", `title = "y" # name`, "", "[server]", "# port", "port = 25566 # default", "pvp = true", "", "[new]", "a = 1"),
+			get:    `{"title":"y","server":{"port":25566,"pvp":true},"new":{"a":1}}`,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			// An empty patch leaves the file as it is.
+			got, err := Patch(tt.format, tt.text, &Object{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.text {
+				t.Errorf("empty patch changed the file to\n%s", got)
+			}
+
+			patch, err := DecodeObject(strings.NewReader(tt.patch))
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err = Patch(tt.format, tt.text, patch)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("got file\n%s\nwant\n%s", got, tt.want)
+			}
+			settings, err := Get(tt.format, got)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if data, _ := json.Marshal(settings); string(data) != tt.get {
+				t.Errorf("got settings %s, want %s", data, tt.get)
+			}
+		})
+	}
+}
+
+func TestGetINI(t *testing.T) {
+	text := file(
+		"Top=1 ; a comment", "Hash=2	# a comment", "Color=#ff0000", "Url=http://host/a;b",
+		`Quoted="a ; b" ; a comment`, "Empty= ; a comment",
+		"[Section]", "Key = value   ;", "Key=again",
+	)
+	want := `{"Top":"1","Hash":"2","Color":"#ff0000","Url":"http://host/a;b","Quoted":"\"a ; b\"","Empty":"","Section":{"Key":["value","again"]}}`
+	settings, err := Get(INI, text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := json.Marshal(settings); string(data) != want {
+		t.Errorf("got settings %s, want %s", data, want)
+	}
+}
+
+func TestPatchErrors(t *testing.T) {
+	for _, tt := range []struct {
+		format Format
+		text   string
+		patch  string
+	}{
+		{Properties, "a=1\n", `{"a":{"b":"c"}}`},
+		{INI, "[A]\nx=1\n", `{"A":{"x":{"y":"z"}}}`},
+		{INI, "x=1\n", `{"x":[{"y":"z"}]}`},
+	} {
+		patch, err := DecodeObject(strings.NewReader(tt.patch))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := Patch(tt.format, tt.text, patch); err == nil {
+			t.Errorf("%s: patch %s succeeded, want an error", tt.format, tt.patch)
+		}
+	}
+}
@@ -0,0 +1,223 @@
+package configfile
+
+import (
+	"fmt"
+	"strings"
+)
+
+// iniKey is one "key=value" line.
+type iniKey struct {
+	key     string
+	value   string
+	comment string // trailing comment with the whitespace before it
+	line    int
+}
+
+// iniSection is a "[name]" header and the keys below it. The keys before the
+// first header belong to a section with an empty name and no header line.
+type iniSection struct {
+	name   string
+	header int // -1 for the unnamed leading section
+	end    int // index one past the section's last line
+	keys   []iniKey
+}
+
+// parseINI splits lines into sections. Lines starting with ';' or '#' are
+// comments, as is the rest of a line from a ';' or '#' that follows
+// whitespace; lines without '=' are kept but carry no setting.
+func parseINI(lines []string) []*iniSection {
+	sections := []*iniSection{{header: -1}}
+	for i, line := range lines {
+		trimmed := strings.TrimSpace(line)
+		switch {
+		case trimmed == "" || trimmed[0] == ';' || trimmed[0] == '#':
+		case trimmed[0] == '[' && strings.HasSuffix(trimmed, "]"):
+			sections[len(sections)-1].end = i
+			sections = append(sections, &iniSection{name: strings.TrimSpace(trimmed[1 : len(trimmed)-1]), header: i})
+		default:
+			if key, value, ok := strings.Cut(trimmed, "="); ok {
+				value, comment := cutINIComment(value)
+				s := sections[len(sections)-1]
+				s.keys = append(s.keys, iniKey{key: strings.TrimSpace(key), value: strings.TrimSpace(value), comment: comment, line: i})
+			}
+		}
+	}
+	sections[len(sections)-1].end = len(lines)
+	return sections
+}
+
+// cutINIComment splits a trailing comment off a value. Inside double quotes
+// ';' and '#' are part of the value, as they are when not preceded by
+// whitespace, so URLs and colors survive.
+func cutINIComment(value string) (string, string) {
+	quoted := false
+	for i := 0; i < len(value); i++ {
+		switch c := value[i]; {
+		case c == '"':
+			quoted = !quoted
+		case (c == ';' || c == '#') && !quoted && i > 0 && (value[i-1] == ' ' || value[i-1] == '\t'):
+			rest := strings.TrimRight(value[:i], " \t")
+			return rest, value[len(rest):]
+		}
+	}
+	return value, ""
+}
+
+// iniValues returns the settings of a section. Keys that repeat, as ARK's
+// engram overrides do, become arrays in file order.
+func iniValues(s *iniSection) *Object {
+	obj := &Object{}
+	for _, k := range s.keys {
+		switch prev := valueOf(obj, k.key).(type) {
+		case nil:
+			obj.Set(k.key, k.value)
+		case string:
+			obj.Set(k.key, []any{prev, k.value})
+		case []any:
+			obj.Set(k.key, append(prev, k.value))
+		}
+	}
+	return obj
+}
+
+// getINI returns keys before the first section at the top level and every
+// section as a nested object. Sections that appear more than once are merged.
+func getINI(text string) *Object {
+	lines, _ := splitLines(text)
+	obj := &Object{}
+	for _, s := range parseINI(lines) {
+		values := iniValues(s)
+		if s.header < 0 {
+			obj.Members = append(obj.Members, values.Members...)
+			continue
+		}
+		if existing, ok := valueOf(obj, s.name).(*Object); ok {
+			existing.Members = append(existing.Members, values.Members...)
+			continue
+		}
+		obj.Set(s.name, values)
+	}
+	return obj
+}
+
+// findSection returns the last section named name; the unnamed section is "".
+func findSection(sections []*iniSection, name string, global bool) *iniSection {
+	if global {
+		return sections[0]
+	}
+	var found *iniSection
+	for _, s := range sections[1:] {
+		if s.name == name {
+			found = s
+		}
+	}
+	return found
+}
+
+func patchINI(text string, patch *Object) (string, error) {
+	lines, eol := splitLines(text)
+	for _, m := range patch.Members {
+		var err error
+		switch v := m.Value.(type) {
+		case *Object:
+			for _, km := range v.Members {
+				if lines, err = setINIKey(lines, m.Key, false, km.Key, km.Value); err != nil {
+					return "", err
+				}
+			}
+		case nil:
+			// null removes a whole section, or a top-level key of the same name.
+			lines = removeINISection(lines, m.Key)
+			lines, err = setINIKey(lines, "", true, m.Key, nil)
+		default:
+			lines, err = setINIKey(lines, "", true, m.Key, m.Value)
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return joinLines(lines, eol), nil
+}
+
+func removeINISection(lines []string, name string) []string {
+	sections := parseINI(lines)
+	for i := len(sections) - 1; i >= 1; i-- {
+		if s := sections[i]; s.name == name {
+			lines = append(lines[:s.header], lines[s.end:]...)
+		}
+	}
+	return lines
+}
+
+// setINIKey sets, replaces or (with a nil value) removes a key. An array
+// value writes one line per element, replacing every existing occurrence.
+func setINIKey(lines []string, section string, global bool, key string, value any) ([]string, error) {
+	var values []any
+	switch v := value.(type) {
+	case nil:
+	case []any:
+		values = v
+	default:
+		values = []any{v}
+	}
+
+	sections := parseINI(lines)
+	s := findSection(sections, section, global)
+	var existing []iniKey
+	if s != nil {
+		for _, k := range s.keys {
+			if k.key == key {
+				existing = append(existing, k)
+			}
+		}
+	}
+
+	old, comment := "", ""
+	if len(existing) > 0 {
+		old, comment = existing[0].value, existing[0].comment
+	}
+	newLines := make([]string, len(values))
+	for i, v := range values {
+		str, err := scalarString(v, old)
+		if err != nil {
+			return nil, fmt.Errorf("%w (key %q)", err, key)
+		}
+		newLines[i] = key + "=" + str
+		if len(existing) > 0 {
+			// Keep the spacing around '=' and the indentation of the original line.
+			orig := lines[existing[0].line]
+			eq := strings.Index(orig, "=")
+			after := orig[eq+1:]
+			newLines[i] = orig[:eq+1] + after[:len(after)-len(strings.TrimLeft(after, " \t"))] + str
+		}
+	}
+	if len(newLines) > 0 {
+		// The comment stays with the first line, which replaces the original.
+		newLines[0] += comment
+	}
+
+	if len(existing) > 0 {
+		// Replace the first occurrence and drop the others.
+		at := existing[0].line
+		for i := len(existing) - 1; i >= 1; i-- {
+			lines = append(lines[:existing[i].line], lines[existing[i].line+1:]...)
+		}
+		return append(lines[:at], append(newLines, lines[at+1:]...)...), nil
+	}
+	if len(newLines) == 0 {
+		return lines, nil
+	}
+	if s == nil {
+		// New section at the end of the file, separated by a blank line.
+		if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+			lines = append(lines, "")
+		}
+		return append(append(lines, "["+section+"]"), newLines...), nil
+	}
+	// Insert after the section's last key, or right after its header.
+	at := s.header + 1
+	if len(s.keys) > 0 {
+		at = s.keys[len(s.keys)-1].line + 1
+	}
+	return append(lines[:at], append(newLines, lines[at:]...)...), nil
+}
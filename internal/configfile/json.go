@@ -0,0 +1,60 @@
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+func getJSON(text string) (*Object, error) {
+	obj, err := DecodeObject(strings.NewReader(text))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+	return obj, nil
+}
+
+// patchJSON merges the patch and re-encodes the document. JSON has no
+// comments, so keeping key order and the file's indentation is enough;
+// single-line documents stay on one line.
+func patchJSON(text string, patch *Object) (string, error) {
+	obj, err := getJSON(text)
+	if err != nil {
+		return "", err
+	}
+	merge(obj, patch)
+	compact, err := marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPatch, err)
+	}
+
+	lines, eol := splitLines(text)
+	out := compact
+	if indent, ok := jsonIndent(lines); ok {
+		var buf bytes.Buffer
+		if err := json.Indent(&buf, compact, "", indent); err != nil {
+			return "", err
+		}
+		out = buf.Bytes()
+	}
+	result := strings.ReplaceAll(string(out), "\n", eol)
+	if strings.HasSuffix(text, "\n") {
+		result += eol
+	}
+	return result, nil
+}
+
+// jsonIndent returns the indentation of the first indented line, reporting
+// false for documents written on a single line.
+func jsonIndent(lines []string) (string, bool) {
+	if len(lines) < 2 {
+		return "", false
+	}
+	for _, line := range lines[1:] {
+		if trimmed := strings.TrimLeft(line, " \t"); trimmed != "" && len(trimmed) < len(line) {
+			return line[:len(line)-len(trimmed)], true
+		}
+	}
+	return "  ", true
+}
@@ -0,0 +1,201 @@
+package configfile
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// propEntry is one key/value pair of a .properties file. A logical line may
+// span several physical lines joined by trailing backslashes.
+type propEntry struct {
+	key    string
+	value  string
+	first  int    // index of the first physical line
+	last   int    // index of the last physical line
+	prefix string // raw key and separator, kept verbatim when the value changes
+}
+
+// parseProperties parses Java .properties syntax: '#' and '!' comments,
+// '=', ':' or whitespace separators, backslash continuations and escapes.
+func parseProperties(lines []string) []propEntry {
+	var entries []propEntry
+	for i := 0; i < len(lines); i++ {
+		first := i
+		trimmed := strings.TrimLeft(lines[i], " \t\f")
+		if trimmed == "" || trimmed[0] == '#' || trimmed[0] == '!' {
+			continue
+		}
+		logical := trimmed
+		for continues(logical) && i+1 < len(lines) {
+			i++
+			logical = logical[:len(logical)-1] + strings.TrimLeft(lines[i], " \t\f")
+		}
+
+		// The key ends at the first unescaped separator or whitespace.
+		end := 0
+		for end < len(logical) {
+			c := logical[end]
+			if c == '\\' {
+				end += 2
+				continue
+			}
+			if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+				break
+			}
+			end++
+		}
+		end = min(end, len(logical))
+		sep := end
+		for sep < len(logical) && strings.IndexByte(" \t\f", logical[sep]) >= 0 {
+			sep++
+		}
+		if sep < len(logical) && (logical[sep] == '=' || logical[sep] == ':') {
+			sep++
+			for sep < len(logical) && strings.IndexByte(" \t\f", logical[sep]) >= 0 {
+				sep++
+			}
+		}
+
+		prefix := lines[first][:len(lines[first])-len(trimmed)] + logical[:sep]
+		if sep == end {
+			// A bare key with no value; give it a separator for when one is set.
+			prefix += "="
+		}
+		entries = append(entries, propEntry{
+			key:    unescapeProperty(logical[:end]),
+			value:  unescapeProperty(logical[sep:]),
+			first:  first,
+			last:   i,
+			prefix: prefix,
+		})
+	}
+	return entries
+}
+
+// continues reports whether a line ends in an odd number of backslashes.
+func continues(line string) bool {
+	n := 0
+	for i := len(line) - 1; i >= 0 && line[i] == '\\'; i-- {
+		n++
+	}
+	return n%2 == 1
+}
+
+func unescapeProperty(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '\\' || i+1 == len(s) {
+			b.WriteByte(c)
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+4 < len(s) {
+				if r, err := strconv.ParseUint(s[i+1:i+5], 16, 16); err == nil {
+					b.WriteRune(rune(r))
+					i += 4
+					continue
+				}
+			}
+			b.WriteByte('u')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String()
+}
+
+// escapeProperty escapes s for use as a key or, with key false, a value.
+func escapeProperty(s string, key bool) string {
+	var b strings.Builder
+	for i, r := range s {
+		switch r {
+		case '\\':
+			b.WriteString(`\\`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '=', ':', '#', '!':
+			if key {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		case ' ':
+			// Leading spaces of a value would otherwise be taken as separator.
+			if key || i == 0 {
+				b.WriteByte('\\')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+func getProperties(text string) *Object {
+	lines, _ := splitLines(text)
+	obj := &Object{}
+	for _, e := range parseProperties(lines) {
+		obj.Set(e.key, e.value)
+	}
+	return obj
+}
+
+func patchProperties(text string, patch *Object) (string, error) {
+	lines, eol := splitLines(text)
+	for _, m := range patch.Members {
+		entries := parseProperties(lines)
+		var matches []propEntry
+		for _, e := range entries {
+			if e.key == m.Key {
+				matches = append(matches, e)
+			}
+		}
+
+		if m.Value == nil {
+			// Remove every definition, last first so indices stay valid.
+			for i := len(matches) - 1; i >= 0; i-- {
+				lines = append(lines[:matches[i].first], lines[matches[i].last+1:]...)
+			}
+			continue
+		}
+
+		old := ""
+		if len(matches) > 0 {
+			old = matches[len(matches)-1].value
+		}
+		value, err := scalarString(m.Value, old)
+		if err != nil {
+			return "", fmt.Errorf("%w (key %q)", err, m.Key)
+		}
+		if len(matches) == 0 {
+			lines = append(lines, escapeProperty(m.Key, true)+"="+escapeProperty(value, false))
+			continue
+		}
+		// Java keeps the last definition of a key; update that one in place.
+		e := matches[len(matches)-1]
+		replaced := e.prefix + escapeProperty(value, false)
+		lines = append(lines[:e.first], append([]string{replaced}, lines[e.last+1:]...)...)
+	}
+	return joinLines(lines, eol), nil
+}
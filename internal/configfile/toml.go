@@ -0,0 +1,545 @@
+package configfile
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/BurntSushi/toml"
+)
+
+func getTOML(text string) (*Object, error) {
+	var doc map[string]any
+	md, err := toml.Decode(text, &doc)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+
+	// The decoded map has no order, so rebuild it following the key order
+	// the decoder saw. Dotted keys imply tables that are not listed
+	// themselves; keys inside arrays of tables are picked up with the array.
+	obj := &Object{}
+	for _, key := range md.Keys() {
+		parent, node := obj, any(doc)
+		for i, part := range key {
+			m, ok := node.(map[string]any)
+			if !ok {
+				break
+			}
+			node = m[part]
+			if i == len(key)-1 {
+				if _, isTable := node.(map[string]any); isTable {
+					if _, exists := parent.Get(part); !exists {
+						parent.Set(part, &Object{})
+					}
+				} else {
+					parent.Set(part, tomlValue(node))
+				}
+				break
+			}
+			if _, isTable := node.(map[string]any); !isTable {
+				break
+			}
+			child, ok := valueOf(parent, part).(*Object)
+			if !ok {
+				child = &Object{}
+				parent.Set(part, child)
+			}
+			parent = child
+		}
+	}
+	return obj, nil
+}
+
+// tomlValue converts a decoded value to the JSON-compatible values Get
+// returns. Tables nested in arrays have their keys sorted.
+func tomlValue(v any) any {
+	switch v := v.(type) {
+	case int64:
+		return json.Number(strconv.FormatInt(v, 10))
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+		return json.Number(strconv.FormatFloat(v, 'g', -1, 64))
+	case time.Time:
+		// Local dates and times are decoded into zones named after their type.
+		switch v.Location().String() {
+		case "date-local":
+			return v.Format("2006-01-02")
+		case "time-local":
+			return v.Format("15:04:05.999999999")
+		case "datetime-local":
+			return v.Format("2006-01-02T15:04:05.999999999")
+		}
+		return v.Format(time.RFC3339Nano)
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		obj := &Object{}
+		for _, k := range keys {
+			obj.Set(k, tomlValue(v[k]))
+		}
+		return obj
+	case []map[string]any:
+		arr := make([]any, len(v))
+		for i, e := range v {
+			arr[i] = tomlValue(e)
+		}
+		return arr
+	case []any:
+		arr := make([]any, len(v))
+		for i, e := range v {
+			arr[i] = tomlValue(e)
+		}
+		return arr
+	}
+	return v
+}
+
+// tomlKV is a key/value statement. The value runs from column valStart of
+// line first to column valEnd of line last; a trailing comment follows it.
+type tomlKV struct {
+	path     []string
+	first    int
+	last     int
+	valStart int
+	valEnd   int
+}
+
+// tomlTable is a [table] or [[array]] header; the root table has header -1.
+type tomlTable struct {
+	path   []string
+	array  bool
+	header int
+	end    int // index one past the table's last line
+	kvs    []tomlKV
+}
+
+// scanTOML finds the headers and key/value statements of a document that
+// has already been validated by the decoder.
+func scanTOML(lines []string) []*tomlTable {
+	tables := []*tomlTable{{header: -1}}
+	for i := 0; i < len(lines); i++ {
+		line := lines[i]
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" || trimmed[0] == '#' {
+			continue
+		}
+		if trimmed[0] == '[' {
+			array := strings.HasPrefix(trimmed, "[[")
+			rest := strings.TrimLeft(trimmed[1:], "[")
+			path, _ := parseTOMLKey(rest)
+			tables[len(tables)-1].end = i
+			tables = append(tables, &tomlTable{path: path, array: array, header: i})
+			continue
+		}
+		path, n := parseTOMLKey(trimmed)
+		col := len(line) - len(trimmed) + n
+		col += len(line[col:]) - len(strings.TrimLeft(line[col:], " \t"))
+		if col >= len(line) || line[col] != '=' {
+			continue
+		}
+		col++
+		col += len(line[col:]) - len(strings.TrimLeft(line[col:], " \t"))
+		t := tables[len(tables)-1]
+		last, end := scanTOMLValue(lines, i, col)
+		t.kvs = append(t.kvs, tomlKV{
+			path:     append(slices.Clone(t.path), path...),
+			first:    i,
+			last:     last,
+			valStart: col,
+			valEnd:   end,
+		})
+		i = last
+	}
+	tables[len(tables)-1].end = len(lines)
+	return tables
+}
+
+// parseTOMLKey parses a possibly dotted and quoted key and returns its parts
+// and the number of bytes consumed.
+func parseTOMLKey(s string) ([]string, int) {
+	var parts []string
+	i := 0
+	for {
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i >= len(s) {
+			return parts, i
+		}
+		switch s[i] {
+		case '"':
+			j := i + 1
+			for j < len(s) && s[j] != '"' {
+				if s[j] == '\\' {
+					j++
+				}
+				j++
+			}
+			part, err := strconv.Unquote(s[i:min(j+1, len(s))])
+			if err != nil {
+				part = s[i+1 : min(j, len(s))]
+			}
+			parts = append(parts, part)
+			i = min(j+1, len(s))
+		case '\'':
+			j := strings.IndexByte(s[i+1:], '\'')
+			if j < 0 {
+				j = len(s) - i - 1
+			}
+			parts = append(parts, s[i+1:i+1+j])
+			i = min(i+j+2, len(s))
+		default:
+			j := i
+			for j < len(s) && isBareKeyChar(s[j]) {
+				j++
+			}
+			parts = append(parts, s[i:j])
+			i = j
+		}
+		for i < len(s) && (s[i] == ' ' || s[i] == '\t') {
+			i++
+		}
+		if i >= len(s) || s[i] != '.' {
+			return parts, i
+		}
+		i++
+	}
+}
+
+func isBareKeyChar(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' || c == '-'
+}
+
+// scanTOMLValue finds the end of the value starting at lines[line][col]. It
+// returns the line the value ends on and the column just past it.
+func scanTOMLValue(lines []string, line, col int) (int, int) {
+	s := lines[line]
+	switch {
+	case strings.HasPrefix(s[col:], `"""`), strings.HasPrefix(s[col:], `'''`):
+		delim := s[col : col+3]
+		pos := col + 3
+		for l := line; l < len(lines); l, pos = l+1, 0 {
+			s := lines[l]
+			for pos < len(s) {
+				if delim == `"""` && s[pos] == '\\' {
+					pos += 2
+					continue
+				}
+				if strings.HasPrefix(s[pos:], delim) {
+					// Up to two quotes may directly precede the delimiter.
+					end := pos + 3
+					for end < len(s) && s[end] == delim[0] && end < pos+5 {
+						end++
+					}
+					return l, end
+				}
+				pos++
+			}
+		}
+		return len(lines) - 1, len(lines[len(lines)-1])
+	case s[col] == '"' || s[col] == '\'':
+		pos := col + 1
+		for pos < len(s) && s[pos] != s[col] {
+			if s[col] == '"' && s[pos] == '\\' {
+				pos++
+			}
+			pos++
+		}
+		return line, min(pos+1, len(s))
+	case s[col] == '[' || s[col] == '{':
+		depth := 0
+		pos := col
+		for l := line; l < len(lines); l, pos = l+1, 0 {
+			s := lines[l]
+			for pos < len(s) {
+				switch c := s[pos]; c {
+				case '#':
+					pos = len(s)
+					continue
+				case '"', '\'':
+					if strings.HasPrefix(s[pos:], strings.Repeat(string(c), 3)) {
+						l2, end := scanTOMLValue(lines, l, pos)
+						if l2 != l {
+							l, s = l2, lines[l2]
+						}
+						pos = end
+						continue
+					}
+					_, pos = scanTOMLValue(lines, l, pos)
+					continue
+				case '[', '{':
+					depth++
+				case ']', '}':
+					depth--
+					if depth == 0 {
+						return l, pos + 1
+					}
+				}
+				pos++
+			}
+		}
+		return len(lines) - 1, len(lines[len(lines)-1])
+	}
+	end := len(s)
+	if i := strings.IndexByte(s[col:], '#'); i >= 0 {
+		end = col + i
+	}
+	return line, col + len(strings.TrimRight(s[col:end], " \t"))
+}
+
+// patchTOML applies the patch one key at a time, rescanning the document
+// after each edit. Values are replaced in place so their trailing comments
+// survive; new keys go after the last key of their table, and new tables
+// are appended to the end of the file.
+func patchTOML(text string, patch *Object) (string, error) {
+	if _, err := getTOML(text); err != nil {
+		return "", err
+	}
+	lines, eol := splitLines(text)
+	lines, err := patchTOMLTable(lines, nil, patch)
+	if err != nil {
+		return "", err
+	}
+	out := joinLines(lines, eol)
+	if _, err := toml.Decode(out, new(map[string]any)); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPatch, err)
+	}
+	return out, nil
+}
+
+func patchTOMLTable(lines []string, prefix []string, patch *Object) ([]string, error) {
+	for _, m := range patch.Members {
+		path := append(slices.Clone(prefix), m.Key)
+		tables := scanTOML(lines)
+		kv, _ := findTOMLKV(tables, path)
+		var err error
+		switch v := m.Value.(type) {
+		case nil:
+			lines = deleteTOML(lines, tables, path)
+		case *Object:
+			if kv == nil {
+				if isTOMLArrayTable(tables, path) {
+					return nil, fmt.Errorf("%w: arrays of tables cannot be merged (key %q)", ErrPatch, strings.Join(path, "."))
+				}
+				lines, err = patchTOMLTable(lines, path, v)
+				break
+			}
+			// An inline table or a scalar: merge with the current value and
+			// write the result back as an inline table.
+			current, _ := getTOML(joinLines(lines, "\n"))
+			merged, ok := lookup(current, path).(*Object)
+			if !ok {
+				merged = &Object{}
+			}
+			merge(merged, v)
+			lines, err = setTOML(lines, tables, path, merged)
+		default:
+			lines, err = setTOML(lines, tables, path, v)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return lines, nil
+}
+
+func lookup(obj *Object, path []string) any {
+	var v any = obj
+	for _, part := range path {
+		o, ok := v.(*Object)
+		if !ok {
+			return nil
+		}
+		v = valueOf(o, part)
+	}
+	return v
+}
+
+func findTOMLKV(tables []*tomlTable, path []string) (*tomlKV, *tomlTable) {
+	for _, t := range tables {
+		if t.array {
+			continue
+		}
+		for i := range t.kvs {
+			if slices.Equal(t.kvs[i].path, path) {
+				return &t.kvs[i], t
+			}
+		}
+	}
+	return nil, nil
+}
+
+func isTOMLArrayTable(tables []*tomlTable, path []string) bool {
+	for _, t := range tables {
+		if t.array && hasPrefix(t.path, path) {
+			return true
+		}
+	}
+	return false
+}
+
+func hasPrefix(path, prefix []string) bool {
+	return len(path) >= len(prefix) && slices.Equal(path[:len(prefix)], prefix)
+}
+
+// setTOML replaces the value at path or inserts a new key.
+func setTOML(lines []string, tables []*tomlTable, path []string, value any) ([]string, error) {
+	encoded, err := encodeTOML(value)
+	if err != nil {
+		return nil, fmt.Errorf("%w (key %q)", err, strings.Join(path, "."))
+	}
+	if kv, _ := findTOMLKV(tables, path); kv != nil {
+		replaced := lines[kv.first][:kv.valStart] + encoded + lines[kv.last][kv.valEnd:]
+		return slices.Concat(lines[:kv.first], []string{replaced}, lines[kv.last+1:]), nil
+	}
+	if isTOMLArrayTable(tables, path) {
+		return nil, fmt.Errorf("%w: arrays of tables cannot be replaced (key %q)", ErrPatch, strings.Join(path, "."))
+	}
+	// A table being replaced by a plain value goes away first.
+	for _, t := range tables {
+		if t.header >= 0 && hasPrefix(t.path, path) {
+			lines = deleteTOML(lines, tables, path)
+			tables = scanTOML(lines)
+			break
+		}
+	}
+
+	parent := path[:len(path)-1]
+	var (
+		owner *tomlTable
+		after = -1
+	)
+	for _, t := range tables {
+		switch {
+		case t.array:
+		case slices.Equal(t.path, parent):
+			// The parent's own table; put the key after its last statement.
+			owner, after = t, t.header
+			if len(t.kvs) > 0 {
+				after = t.kvs[len(t.kvs)-1].last
+			}
+		case owner == nil && hasPrefix(parent, t.path):
+			// Otherwise join dotted keys that already share the parent, as
+			// in "server.port = 1" in an enclosing table.
+			for _, kv := range t.kvs {
+				if len(kv.path) > len(parent) && hasPrefix(kv.path, parent) {
+					owner, after = t, kv.last
+				}
+			}
+		}
+		if owner != nil && slices.Equal(owner.path, parent) {
+			break
+		}
+	}
+
+	if owner == nil {
+		if len(parent) > 0 {
+			// No such table yet: add one at the end of the file.
+			if len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) != "" {
+				lines = append(lines, "")
+			}
+			return append(lines, "["+formatTOMLKey(parent)+"]", formatTOMLKey(path[len(path)-1:])+" = "+encoded), nil
+		}
+		owner = tables[0]
+	}
+
+	stmt := formatTOMLKey(path[len(owner.path):]) + " = " + encoded
+	if owner.header < 0 && after < 0 {
+		// A root key in a file without root keys goes above the first
+		// table, and above any comment block attached to it.
+		at := 0
+		if len(tables) > 1 {
+			at = tables[1].header
+			for at > 0 && strings.HasPrefix(strings.TrimSpace(lines[at-1]), "#") {
+				at--
+			}
+			return slices.Concat(lines[:at], []string{stmt, ""}, lines[at:]), nil
+		}
+		return append(lines, stmt), nil
+	}
+	return slices.Concat(lines[:after+1], []string{stmt}, lines[after+1:]), nil
+}
+
+// deleteTOML removes the key at path, every key below it and every table
+// whose header is at or below it.
+func deleteTOML(lines []string, tables []*tomlTable, path []string) []string {
+	var remove [][2]int
+	for _, t := range tables {
+		if t.header >= 0 && hasPrefix(t.path, path) {
+			remove = append(remove, [2]int{t.header, t.end - 1})
+			continue
+		}
+		for _, kv := range t.kvs {
+			if !t.array && hasPrefix(kv.path, path) {
+				remove = append(remove, [2]int{kv.first, kv.last})
+			}
+		}
+	}
+	for i := len(remove) - 1; i >= 0; i-- {
+		lines = append(lines[:remove[i][0]], lines[remove[i][1]+1:]...)
+	}
+	return lines
+}
+
+// encodeTOML formats a patch value as a TOML value; objects become inline tables.
+func encodeTOML(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return strconv.Quote(v), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case json.Number:
+		return v.String(), nil
+	case []any:
+		parts := make([]string, len(v))
+		for i, e := range v {
+			s, err := encodeTOML(e)
+			if err != nil {
+				return "", err
+			}
+			parts[i] = s
+		}
+		return "[" + strings.Join(parts, ", ") + "]", nil
+	case *Object:
+		if len(v.Members) == 0 {
+			return "{}", nil
+		}
+		parts := make([]string, 0, len(v.Members))
+		for _, m := range v.Members {
+			if m.Value == nil {
+				continue
+			}
+			s, err := encodeTOML(m.Value)
+			if err != nil {
+				return "", err
+			}
+			parts = append(parts, formatTOMLKey([]string{m.Key})+" = "+s)
+		}
+		return "{ " + strings.Join(parts, ", ") + " }", nil
+	case nil:
+		return "", fmt.Errorf("%w: TOML has no null value", ErrPatch)
+	}
+	return "", fmt.Errorf("%w: unsupported value %T", ErrPatch, v)
+}
+
+func formatTOMLKey(path []string) string {
+	parts := make([]string, len(path))
+	for i, p := range path {
+		parts[i] = p
+		if p == "" || strings.IndexFunc(p, func(r rune) bool { return r > 0x7f || !isBareKeyChar(byte(r)) }) >= 0 {
+			parts[i] = strconv.Quote(p)
+		}
+	}
+	return strings.Join(parts, ".")
+}
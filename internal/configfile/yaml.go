@@ -0,0 +1,220 @@
+package configfile
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// parseYAML returns the document node and its top-level mapping. An empty
+// file yields a new, empty mapping.
+func parseYAML(text string) (*yaml.Node, *yaml.Node, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal([]byte(text), &doc); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+	}
+	if doc.Kind == 0 {
+		doc = yaml.Node{Kind: yaml.DocumentNode, Content: []*yaml.Node{{Kind: yaml.MappingNode, Tag: "!!map"}}}
+	}
+	root := doc.Content[0]
+	if root.Kind != yaml.MappingNode {
+		return nil, nil, fmt.Errorf("%w: top level is not a mapping", ErrSyntax)
+	}
+	return &doc, root, nil
+}
+
+func getYAML(text string) (*Object, error) {
+	_, root, err := parseYAML(text)
+	if err != nil {
+		return nil, err
+	}
+	v, err := yamlValue(root)
+	if err != nil {
+		return nil, err
+	}
+	return v.(*Object), nil
+}
+
+// yamlValue converts a node to the JSON-compatible values Get returns.
+func yamlValue(n *yaml.Node) (any, error) {
+	switch n.Kind {
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.MappingNode:
+		obj := &Object{}
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			obj.Set(n.Content[i].Value, v)
+		}
+		return obj, nil
+	case yaml.SequenceNode:
+		arr := make([]any, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			arr = append(arr, v)
+		}
+		return arr, nil
+	}
+	switch n.ShortTag() {
+	case "!!null":
+		return nil, nil
+	case "!!bool":
+		var b bool
+		if err := n.Decode(&b); err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrSyntax, err)
+		}
+		return b, nil
+	case "!!int":
+		var i int64
+		if err := n.Decode(&i); err == nil {
+			return json.Number(strconv.FormatInt(i, 10)), nil
+		}
+	case "!!float":
+		var f float64
+		if err := n.Decode(&f); err == nil && !math.IsInf(f, 0) && !math.IsNaN(f) {
+			return json.Number(strconv.FormatFloat(f, 'g', -1, 64)), nil
+		}
+	}
+	// Strings, timestamps and numbers JSON cannot represent keep their text.
+	return n.Value, nil
+}
+
+// patchYAML merges the patch into the node tree and re-encodes it. Comments
+// hang off the nodes, so they survive as long as the nodes do; replaced
+// scalars keep the comments and quoting style of the value they replace.
+func patchYAML(text string, patch *Object) (string, error) {
+	doc, root, err := parseYAML(text)
+	if err != nil {
+		return "", err
+	}
+	if err := mergeYAML(root, patch); err != nil {
+		return "", err
+	}
+
+	lines, eol := splitLines(text)
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(yamlIndent(lines))
+	if err := enc.Encode(doc); err != nil {
+		return "", fmt.Errorf("%w: %v", ErrPatch, err)
+	}
+	if err := enc.Close(); err != nil {
+		return "", err
+	}
+	out := buf.String()
+	if len(lines) > 0 && strings.TrimSpace(lines[0]) == "---" && !strings.HasPrefix(out, "---") {
+		out = "---\n" + out
+	}
+	return strings.ReplaceAll(out, "\n", eol), nil
+}
+
+func mergeYAML(mapping *yaml.Node, patch *Object) error {
+	for _, m := range patch.Members {
+		idx := -1
+		for i := 0; i+1 < len(mapping.Content); i += 2 {
+			if mapping.Content[i].Value == m.Key {
+				idx = i
+			}
+		}
+
+		if m.Value == nil {
+			if idx >= 0 {
+				mapping.Content = append(mapping.Content[:idx], mapping.Content[idx+2:]...)
+			}
+			continue
+		}
+		if obj, ok := m.Value.(*Object); ok && idx >= 0 && mapping.Content[idx+1].Kind == yaml.MappingNode {
+			if err := mergeYAML(mapping.Content[idx+1], obj); err != nil {
+				return err
+			}
+			continue
+		}
+
+		node, err := yamlNode(m.Value)
+		if err != nil {
+			return fmt.Errorf("%w (key %q)", err, m.Key)
+		}
+		if idx < 0 {
+			key := &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: m.Key}
+			mapping.Content = append(mapping.Content, key, node)
+			continue
+		}
+		old := mapping.Content[idx+1]
+		node.HeadComment, node.LineComment, node.FootComment = old.HeadComment, old.LineComment, old.FootComment
+		if old.Kind == yaml.ScalarNode && node.Kind == yaml.ScalarNode && old.ShortTag() == node.ShortTag() && node.Style == 0 {
+			node.Style = old.Style
+		}
+		mapping.Content[idx+1] = node
+	}
+	return nil
+}
+
+// yamlNode builds a node for a patch value. Objects keep the patch's key
+// order, and nulls inside them are dropped as a merge patch requires.
+func yamlNode(v any) (*yaml.Node, error) {
+	switch v := v.(type) {
+	case *Object:
+		mapping := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+		return mapping, mergeYAML(mapping, v)
+	case []any:
+		seq := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq"}
+		for _, e := range v {
+			if e == nil {
+				seq.Content = append(seq.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!null", Value: "null"})
+				continue
+			}
+			n, err := yamlNode(e)
+			if err != nil {
+				return nil, err
+			}
+			seq.Content = append(seq.Content, n)
+		}
+		return seq, nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return yamlScalar(i)
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid number %s", ErrPatch, v)
+		}
+		return yamlScalar(f)
+	case string, bool:
+		return yamlScalar(v)
+	}
+	return nil, errors.New("unsupported value type")
+}
+
+func yamlScalar(v any) (*yaml.Node, error) {
+	var n yaml.Node
+	if err := n.Encode(v); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrPatch, err)
+	}
+	return &n, nil
+}
+
+// yamlIndent returns the indentation width of the first nested line, or 2.
+func yamlIndent(lines []string) int {
+	for i, line := range lines {
+		trimmed := strings.TrimLeft(line, " ")
+		if i == 0 || trimmed == "" || trimmed[0] == '#' || strings.HasPrefix(trimmed, "- ") {
+			continue
+		}
+		if indent := len(line) - len(trimmed); indent > 0 {
+			return indent
+		}
+	}
+	return 2
+}
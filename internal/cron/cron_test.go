@@ -0,0 +1,138 @@
+package cron
+
+import (
+	"testing"
+	"time"
+	_ "time/tzdata"
+)
+
+func bits(values ...int) uint64 {
+	var set uint64
+	for _, v := range values {
+		set |= 1 << v
+	}
+	return set
+}
+
+func span(lo, hi, step int) uint64 {
+	var set uint64
+	for v := lo; v <= hi; v += step {
+		set |= 1 << v
+	}
+	return set
+}
+
+func TestParse(t *testing.T) {
+	for _, tt := range []struct {
+		spec                          string
+		minute, hour, dom, month, dow uint64
+	}{
+		{"* * * * *", span(0, 59, 1), span(0, 23, 1), span(1, 31, 1), span(1, 12, 1), span(0, 6, 1)},
+		{"*/15 0-6/2 1,15 * *", bits(0, 15, 30, 45), bits(0, 2, 4, 6), bits(1, 15), span(1, 12, 1), span(0, 6, 1)},
+		{"5/20 9-17 * * *", bits(5, 25, 45), span(9, 17, 1), span(1, 31, 1), span(1, 12, 1), span(0, 6, 1)},
+		{"0 0 * jan-mar,DEC mon-fri", bits(0), bits(0), span(1, 31, 1), bits(1, 2, 3, 12), span(1, 5, 1)},
+		{"0 0 * * 7", bits(0), bits(0), span(1, 31, 1), span(1, 12, 1), bits(0)},
+		{"0 0 * * 5-7", bits(0), bits(0), span(1, 31, 1), span(1, 12, 1), bits(0, 5, 6)},
+		{"@weekly", bits(0), bits(0), span(1, 31, 1), span(1, 12, 1), bits(0)},
+		{"@HOURLY", bits(0), span(0, 23, 1), span(1, 31, 1), span(1, 12, 1), span(0, 6, 1)},
+	} {
+		s, err := Parse(tt.spec)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.spec, err)
+			continue
+		}
+		got := [5]uint64{s.minute, s.hour, s.dom, s.month, s.dow}
+		want := [5]uint64{tt.minute, tt.hour, tt.dom, tt.month, tt.dow}
+		if got != want {
+			t.Errorf("Parse(%q) = %b, want %b", tt.spec, got, want)
+		}
+		if s.String() != tt.spec {
+			t.Errorf("Parse(%q).String() = %q", tt.spec, s.String())
+		}
+	}
+
+	for _, spec := range []string{
+		"",
+		"* * * *",
+		"* * * * * *",
+		"60 * * * *",
+		"* 24 * * *",
+		"* * 0 * *",
+		"* * * 13 *",
+		"* * * * 8",
+		"5-1 * * * *",
+		"*/0 * * * *",
+		"*/x * * * *",
+		"* * * foo *",
+		"* * * * sunday",
+		"1-2-3 * * * *",
+		"@fortnightly",
+	} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded, want an error", spec)
+		}
+	}
+}
+
+func TestNext(t *testing.T) {
+	newYork, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		spec string
+		loc  *time.Location
+		from string
+		want string // empty if the schedule never fires
+	}{
+		{"next minute", "* * * * *", time.UTC, "2026-03-01T10:00:30Z", "2026-03-01T10:01:00Z"},
+		{"step", "*/15 * * * *", time.UTC, "2026-03-01T10:15:00Z", "2026-03-01T10:30:00Z"},
+		{"next day", "30 1 * * *", time.UTC, "2026-03-01T02:00:00Z", "2026-03-02T01:30:00Z"},
+		{"next year", "0 0 1 1 *", time.UTC, "2026-03-01T00:00:00Z", "2027-01-01T00:00:00Z"},
+		{"weekday", "0 9 * * mon-fri", time.UTC, "2026-03-06T10:00:00Z", "2026-03-09T09:00:00Z"},
+		{"day of month or week", "0 0 13 * fri", time.UTC, "2026-03-07T00:00:00Z", "2026-03-13T00:00:00Z"},
+		{"day of month or week, week first", "0 0 31 * fri", time.UTC, "2026-03-14T00:00:00Z", "2026-03-20T00:00:00Z"},
+		{"leap day", "0 0 29 2 *", time.UTC, "2026-03-01T00:00:00Z", "2028-02-29T00:00:00Z"},
+		{"never", "0 0 31 2 *", time.UTC, "2026-03-01T00:00:00Z", ""},
+
+		// The clocks go forward from 02:00 EST to 03:00 EDT on March 8, 2026.
+		{"spring forward, skipped time", "30 2 * * *", newYork, "2026-03-07T12:00:00-05:00", "2026-03-09T02:30:00-04:00"},
+		{"spring forward, after the gap", "0 3 * * *", newYork, "2026-03-08T00:00:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"spring forward, hourly", "0 * * * *", newYork, "2026-03-08T01:00:00-05:00", "2026-03-08T03:00:00-04:00"},
+		{"spring forward, minutes", "*/20 * * * *", newYork, "2026-03-08T01:40:00-05:00", "2026-03-08T03:00:00-04:00"},
+
+		// The clocks go back from 02:00 EDT to 01:00 EST on November 1, 2026.
+		{"fall back, first pass", "30 1 * * *", newYork, "2026-10-31T12:00:00-04:00", "2026-11-01T01:30:00-04:00"},
+		{"fall back, repeated time", "30 1 * * *", newYork, "2026-11-01T01:30:00-04:00", "2026-11-02T01:30:00-05:00"},
+		{"fall back, inside the repeated hour", "30 1 * * *", newYork, "2026-11-01T01:10:00-05:00", "2026-11-02T01:30:00-05:00"},
+		{"fall back, hourly", "0 * * * *", newYork, "2026-11-01T01:00:00-04:00", "2026-11-01T02:00:00-05:00"},
+		{"fall back, minutes", "*/20 * * * *", newYork, "2026-11-01T01:40:00-04:00", "2026-11-01T02:00:00-05:00"},
+		{"fall back, after the change", "30 2 * * *", newYork, "2026-11-01T00:00:00-04:00", "2026-11-01T02:30:00-05:00"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			s, err := Parse(tt.spec)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := time.Parse(time.RFC3339, tt.from)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got := s.Next(from.In(tt.loc))
+			if tt.want == "" {
+				if !got.IsZero() {
+					t.Errorf("Next(%s) = %s, want the zero time", tt.from, got)
+				}
+				return
+			}
+			want, err := time.Parse(time.RFC3339, tt.want)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !got.Equal(want) || got.Location() != tt.loc {
+				t.Errorf("Next(%s) = %s, want %s", tt.from, got, want.In(tt.loc))
+			}
+		})
+	}
+}
@@ -0,0 +1,120 @@
+// Package filemode parses permission changes written the way chmod(1) takes
+// them: an octal mode such as "0664", or symbolic clauses such as
+// "u+rw,g+rwX,o-rwx". Only the nine permission bits can be changed.
+package filemode
+
+import (
+	"fmt"
+	"io/fs"
+	"strconv"
+	"strings"
+)
+
+// Change is a parsed mode specification.
+type Change struct {
+	octal   bool
+	mode    fs.FileMode
+	clauses []clause
+}
+
+type clause struct {
+	who   fs.FileMode // mask of the affected bits for u, g and o
+	op    byte
+	perm  fs.FileMode // rwx, replicated into every class
+	condX bool        // X: execute only for directories or files already executable
+}
+
+// ParseOctal parses an octal permission mode such as "644" or "0o2775"
+// and rejects bits outside 0777.
+func ParseOctal(s string) (fs.FileMode, error) {
+	v, err := strconv.ParseUint(strings.TrimPrefix(strings.TrimPrefix(s, "0o"), "0O"), 8, 32)
+	if err != nil || s == "" {
+		return 0, fmt.Errorf("invalid mode %q, expected octal such as 0644", s)
+	}
+	if v&^0777 != 0 {
+		return 0, fmt.Errorf("invalid mode %q, only permission bits (0777) can be set", s)
+	}
+	return fs.FileMode(v), nil
+}
+
+// Parse parses an octal or symbolic mode specification. Symbolic clauses
+// without u, g, o or a apply to everyone; no umask is consulted.
+func Parse(s string) (*Change, error) {
+	if s != "" && s[0] >= '0' && s[0] <= '9' {
+		mode, err := ParseOctal(s)
+		if err != nil {
+			return nil, err
+		}
+		return &Change{octal: true, mode: mode}, nil
+	}
+	c := &Change{}
+	for _, part := range strings.Split(s, ",") {
+		var who fs.FileMode
+		i := 0
+		for ; i < len(part) && strings.IndexByte("ugoa", part[i]) >= 0; i++ {
+			switch part[i] {
+			case 'u':
+				who |= 0700
+			case 'g':
+				who |= 0070
+			case 'o':
+				who |= 0007
+			default:
+				who |= 0777
+			}
+		}
+		if who == 0 {
+			who = 0777
+		}
+		if i == len(part) {
+			return nil, fmt.Errorf("invalid mode %q, expected octal or symbolic such as g+rw", s)
+		}
+		for i < len(part) {
+			cl := clause{who: who, op: part[i]}
+			if cl.op != '+' && cl.op != '-' && cl.op != '=' {
+				return nil, fmt.Errorf("invalid mode %q, expected +, - or = in %q", s, part)
+			}
+			for i++; i < len(part) && strings.IndexByte("+-=", part[i]) < 0; i++ {
+				switch part[i] {
+				case 'r':
+					cl.perm |= 0444
+				case 'w':
+					cl.perm |= 0222
+				case 'x':
+					cl.perm |= 0111
+				case 'X':
+					cl.condX = true
+				default:
+					return nil, fmt.Errorf("invalid mode %q, only r, w, x and X are supported", s)
+				}
+			}
+			c.clauses = append(c.clauses, cl)
+		}
+	}
+	return c, nil
+}
+
+// Apply returns the permission bits that result from applying the change to
+// an entry whose current mode is old.
+func (c *Change) Apply(old fs.FileMode, isDir bool) fs.FileMode {
+	if c.octal {
+		return c.mode
+	}
+	mode := old.Perm()
+	for _, cl := range c.clauses {
+		perm := cl.perm
+		if cl.condX && (isDir || mode&0111 != 0) {
+			perm |= 0111
+		}
+		perm &= cl.who
+		switch cl.op {
+		case '+':
+			mode |= perm
+		case '-':
+			mode &^= perm
+		default:
+			mode = mode&^cl.who | perm
+		}
+	}
+	return mode
+}
@@ -0,0 +1,357 @@
+// Package fsroot confines file operations to a single directory tree.
+//
+// Every path handed to a Root is interpreted relative to the root directory.
+// Lexical escapes ("..", absolute paths) are rejected up front, and symlinks
+// are resolved by os.Root so a link pointing outside the tree cannot be
+// followed, no matter who created it.
+package fsroot
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+// ErrEscape is returned when a path would resolve outside of the root.
+var ErrEscape = errors.New("invalid path: access denied")
+
+// Root is a directory tree that file operations cannot escape.
+type Root struct {
+	dir  string
+	real string
+	root *os.Root
+}
+
+// Open opens dir as a Root. The directory must already exist.
+func Open(dir string) (*Root, error) {
+	abs, err := filepath.Abs(dir)
+	if err != nil {
+		return nil, err
+	}
+	real, err := filepath.EvalSymlinks(abs)
+	if err != nil {
+		return nil, err
+	}
+	root, err := os.OpenRoot(real)
+	if err != nil {
+		return nil, err
+	}
+	return &Root{dir: abs, real: real, root: root}, nil
+}
+
+// Close releases the underlying directory handle.
+func (r *Root) Close() error {
+	return r.root.Close()
+}
+
+// Dir returns the absolute path of the root directory as it was configured.
+func (r *Root) Dir() string {
+	return r.dir
+}
+
+// FS returns a read-only fs.FS view of the root.
+func (r *Root) FS() fs.FS {
+	return r.root.FS()
+}
+
+// Rel converts a user-supplied path into a clean path relative to the root.
+// Leading slashes are ignored, so "/world" and "world" name the same entry, and
+// the empty path names the root itself ("."). Paths that climb out of the root
+// with ".." are rejected with ErrEscape.
+func (r *Root) Rel(userPath string) (string, error) {
+	p := filepath.FromSlash(userPath)
+	p = strings.TrimLeft(p, string(filepath.Separator))
+	if p == "" {
+		return ".", nil
+	}
+	p = filepath.Clean(p)
+	if !filepath.IsLocal(p) {
+		return "", ErrEscape
+	}
+	return p, nil
+}
+
+// Join returns the host path of a root-relative name. The result is meant for
+// logging and for handing to code that cannot work with a Root; it is not
+// protected against symlink escapes.
+func (r *Root) Join(name string) string {
+	return filepath.Join(r.dir, name)
+}
+
+// Open opens the named file for reading.
+func (r *Root) Open(name string) (*os.File, error) {
+	f, err := r.root.Open(name)
+	return f, wrap(err)
+}
+
+// OpenFile opens the named file with the given flags and permissions.
+func (r *Root) OpenFile(name string, flag int, perm os.FileMode) (*os.File, error) {
+	f, err := r.root.OpenFile(name, flag, perm)
+	return f, wrap(err)
+}
+
+// Create creates or truncates the named file.
+func (r *Root) Create(name string) (*os.File, error) {
+	f, err := r.root.Create(name)
+	return f, wrap(err)
+}
+
+// Stat returns file info for the named file, following symlinks inside the root.
+func (r *Root) Stat(name string) (os.FileInfo, error) {
+	info, err := r.root.Stat(name)
+	return info, wrap(err)
+}
+
+// Lstat returns file info for the named file without following a final symlink.
+func (r *Root) Lstat(name string) (os.FileInfo, error) {
+	info, err := r.root.Lstat(name)
+	return info, wrap(err)
+}
+
+// ReadDir reads the named directory and returns its entries sorted by name.
+func (r *Root) ReadDir(name string) ([]os.DirEntry, error) {
+	entries, err := fs.ReadDir(r.root.FS(), filepath.ToSlash(name))
+	return entries, wrap(err)
+}
+
+// Mkdir creates a single directory.
+func (r *Root) Mkdir(name string, perm os.FileMode) error {
+	return wrap(r.root.Mkdir(name, perm))
+}
+
+// MkdirAll creates a directory along with any missing parents.
+func (r *Root) MkdirAll(name string, perm os.FileMode) error {
+	if name == "." {
+		return nil
+	}
+	var current string
+	for _, part := range strings.Split(name, string(filepath.Separator)) {
+		current = filepath.Join(current, part)
+		err := r.root.Mkdir(current, perm)
+		if err == nil {
+			continue
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return wrap(err)
+		}
+		info, statErr := r.root.Stat(current)
+		if statErr != nil {
+			return wrap(statErr)
+		}
+		if !info.IsDir() {
+			return &fs.PathError{Op: "mkdir", Path: current, Err: fs.ErrExist}
+		}
+	}
+	return nil
+}
+
+// Remove removes a single file or empty directory. A final symlink is removed
+// rather than followed.
+func (r *Root) Remove(name string) error {
+	return wrap(r.root.Remove(name))
+}
+
+// RemoveAll removes name and everything it contains. It is not an error for
+// name not to exist.
+func (r *Root) RemoveAll(name string) error {
+	if name == "." {
+		return fmt.Errorf("fsroot: refusing to remove the root directory")
+	}
+	info, err := r.root.Lstat(name)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return nil
+		}
+		return wrap(err)
+	}
+	if info.IsDir() {
+		entries, err := r.ReadDir(name)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			if err := r.RemoveAll(filepath.Join(name, entry.Name())); err != nil {
+				return err
+			}
+		}
+	}
+	return wrap(r.root.Remove(name))
+}
+
+// Rename moves oldname to newname. The parent directories of both names are
+// resolved and checked against the root before the rename is issued; the
+// entries themselves are renamed as-is, so renaming a symlink moves the link.
+func (r *Root) Rename(oldname, newname string) error {
+	oldPath, err := r.HostPath(oldname)
+	if err != nil {
+		return err
+	}
+	newPath, err := r.HostPath(newname)
+	if err != nil {
+		return err
+	}
+	return os.Rename(oldPath, newPath)
+}
+
+// Readlink returns the target of the named symlink. The target is returned
+// verbatim and may point outside of the root; following it through the Root
+// is still refused.
+func (r *Root) Readlink(name string) (string, error) {
+	p, err := r.HostPath(name)
+	if err != nil {
+		return "", err
+	}
+	return os.Readlink(p)
+}
+
+// Symlink creates newname as a symlink to oldname. The link is created inside
+// the root, but its target is not checked because the Root will not follow a
+// link that escapes.
+func (r *Root) Symlink(oldname, newname string) error {
+	p, err := r.HostPath(newname)
+	if err != nil {
+		return err
+	}
+	return os.Symlink(oldname, p)
+}
+
+// Chmod changes the mode of name. Like os.Chmod it follows a final
+// symlink, but only to a target inside the root.
+func (r *Root) Chmod(name string, mode os.FileMode) error {
+	p, err := r.HostPath(name)
+	if err != nil {
+		return err
+	}
+	target, err := filepath.EvalSymlinks(p)
+	if err != nil {
+		return err
+	}
+	if !r.contains(target) {
+		return ErrEscape
+	}
+	return os.Chmod(target, mode)
+}
+
+// Lchown changes the numeric owner and group of name; -1 leaves either
+// unchanged. A final symlink is changed itself rather than followed.
+func (r *Root) Lchown(name string, uid, gid int) error {
+	p, err := r.HostPath(name)
+	if err != nil {
+		return err
+	}
+	return os.Lchown(p, uid, gid)
+}
+
+// maxLinks bounds the symlinks Resolve follows, like the kernel's limit.
+const maxLinks = 40
+
+// Resolve returns the root-relative path name refers to once every symlink
+// along it, the final element included, has been followed. Elements that do
+// not exist are taken as they are, so the result also names where a new
+// entry would be created. It fails with ErrEscape if a link leads outside of
+// the root. Like HostPath, it is subject to the check-then-use race if the
+// tree is being modified concurrently.
+func (r *Root) Resolve(name string) (string, error) {
+	if name != "." && !filepath.IsLocal(name) {
+		return "", ErrEscape
+	}
+	resolved, missing, links := ".", false, 0
+	parts := strings.Split(name, string(filepath.Separator))
+	for len(parts) > 0 {
+		part := parts[0]
+		parts = parts[1:]
+		switch part {
+		case "", ".":
+			continue
+		case "..":
+			if resolved == "." {
+				return "", ErrEscape
+			}
+			resolved = filepath.Dir(resolved)
+			continue
+		}
+		next := filepath.Join(resolved, part)
+		if missing {
+			resolved = next
+			continue
+		}
+		info, err := r.root.Lstat(next)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, syscall.ENOTDIR) {
+			resolved, missing = next, true
+			continue
+		}
+		if err != nil {
+			return "", wrap(err)
+		}
+		if info.Mode()&fs.ModeSymlink == 0 {
+			resolved = next
+			continue
+		}
+		if links++; links > maxLinks {
+			return "", &fs.PathError{Op: "resolve", Path: name, Err: syscall.ELOOP}
+		}
+		target, err := os.Readlink(filepath.Join(r.real, next))
+		if err != nil {
+			return "", err
+		}
+		if filepath.IsAbs(target) {
+			if !r.contains(target) {
+				return "", ErrEscape
+			}
+			target, _ = filepath.Rel(r.real, target)
+			resolved = "."
+		}
+		parts = append(strings.Split(target, string(filepath.Separator)), parts...)
+	}
+	return resolved, nil
+}
+
+// HostPath resolves the parent directory of name through any symlinks and
+// returns the host path of name inside it. It fails with ErrEscape if the
+// parent resolves outside of the root. It exists for the few operations
+// os.Root cannot perform itself (rename, chmod, chown, readlink), and is
+// subject to the usual check-then-use race if the tree is being modified
+// concurrently.
+func (r *Root) HostPath(name string) (string, error) {
+	if name == "." {
+		return r.real, nil
+	}
+	if !filepath.IsLocal(name) {
+		return "", ErrEscape
+	}
+	parent, err := filepath.EvalSymlinks(filepath.Join(r.real, filepath.Dir(name)))
+	if err != nil {
+		return "", err
+	}
+	if !r.contains(parent) {
+		return "", ErrEscape
+	}
+	return filepath.Join(parent, filepath.Base(name)), nil
+}
+
+// contains reports whether the absolute host path p lies inside the root.
+func (r *Root) contains(p string) bool {
+	rel, err := filepath.Rel(r.real, p)
+	if err != nil {
+		return false
+	}
+	return rel == "." || filepath.IsLocal(rel)
+}
+
+// wrap rewrites the error os.Root reports when a path escapes the root so
+// callers can test for it with errors.Is(err, ErrEscape). os.Root does not
+// export its sentinel, so the message is the only thing to match on.
+func wrap(err error) error {
+	if err == nil {
+		return nil
+	}
+	var pathErr *fs.PathError
+	if errors.As(err, &pathErr) && pathErr.Err.Error() == "path escapes from parent" {
+		return &fs.PathError{Op: pathErr.Op, Path: pathErr.Path, Err: ErrEscape}
+	}
+	return err
+}
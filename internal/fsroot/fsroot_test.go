@@ -0,0 +1,285 @@
+package fsroot
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// newTestRoot lays out a data root next to a sibling whose name shares its
+// prefix, with links that lead out of the root and links that stay inside:
+//
+//	base/outside
+//	base/data-other/secret
+//	base/data/sub/ok.txt
+//	base/data/in     -> sub/ok.txt
+//	base/data/indir  -> sub
+//	base/data/out    -> base/outside
+//	base/data/outdir -> base
+//	base/data/other  -> ../data-other
+func newTestRoot(t *testing.T) (*Root, string) {
+	t.Helper()
+	base := t.TempDir()
+	data := filepath.Join(base, "data")
+	for _, dir := range []string{filepath.Join(data, "sub"), filepath.Join(base, "data-other")} {
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, content := range map[string]string{
+		filepath.Join(base, "outside"):              "outside",
+		filepath.Join(base, "data-other", "secret"): "secret",
+		filepath.Join(data, "sub", "ok.txt"):        "ok",
+	} {
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for link, target := range map[string]string{
+		"in":     "sub/ok.txt",
+		"indir":  "sub",
+		"out":    filepath.Join(base, "outside"),
+		"outdir": base,
+		"other":  "../data-other",
+	} {
+		if err := os.Symlink(target, filepath.Join(data, link)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	root, err := Open(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { root.Close() })
+	return root, base
+}
+
+func checkEscape(t *testing.T, err error, escape bool) {
+	t.Helper()
+	switch {
+	case escape && !errors.Is(err, ErrEscape):
+		t.Errorf("got error %v, want ErrEscape", err)
+	case !escape && err != nil:
+		t.Errorf("got error %v, want none", err)
+	}
+}
+
+func TestRel(t *testing.T) {
+	root, _ := newTestRoot(t)
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"", "."},
+		{"/", "."},
+		{"world", "world"},
+		{"/world", "world"},
+		{"//world/./region/", "world/region"},
+		{"a/../b", "b"},
+		{"a/..", "."},
+		{"..", ""},
+		{"../data-other/secret", ""},
+		{"/../outside", ""},
+		{"a/../../outside", ""},
+		{"sub/../../data/sub", ""},
+	} {
+		got, err := root.Rel(tt.path)
+		checkEscape(t, err, tt.want == "")
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("Rel(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestHostPath(t *testing.T) {
+	root, base := newTestRoot(t)
+	data := filepath.Join(base, "data")
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{".", data},
+		{"sub/ok.txt", filepath.Join(data, "sub", "ok.txt")},
+		{"indir/ok.txt", filepath.Join(data, "sub", "ok.txt")},
+		// The final element is not resolved, so a link that escapes is
+		// named by its own path.
+		{"out", filepath.Join(data, "out")},
+		{"..", ""},
+		{"../data-other/secret", ""},
+		{filepath.Join(base, "outside"), ""},
+		{"outdir/outside", ""},
+		{"other/secret", ""},
+	} {
+		got, err := root.HostPath(filepath.FromSlash(tt.name))
+		checkEscape(t, err, tt.want == "")
+		if got != tt.want {
+			t.Errorf("HostPath(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	root, base := newTestRoot(t)
+	if err := root.Symlink(filepath.Join(base, "data", "sub"), "abs"); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Symlink("loop", "loop"); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		name string
+		want string
+	}{
+		{".", "."},
+		{"sub/ok.txt", "sub/ok.txt"},
+		{"in", "sub/ok.txt"},
+		{"indir/ok.txt", "sub/ok.txt"},
+		{"indir/new/file", "sub/new/file"},
+		{"indir/../in", "sub/ok.txt"},
+		{"abs/ok.txt", "sub/ok.txt"},
+		{"missing/../sub", "sub"},
+		{"..", ""},
+		{"out", ""},
+		{"outdir/outside", ""},
+		{"other/secret", ""},
+		{"other/new", ""},
+		{"indir/../../outside", ""},
+	} {
+		got, err := root.Resolve(filepath.FromSlash(tt.name))
+		checkEscape(t, err, tt.want == "")
+		if got != filepath.FromSlash(tt.want) {
+			t.Errorf("Resolve(%q) = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+	if _, err := root.Resolve("loop"); err == nil || errors.Is(err, ErrEscape) {
+		t.Errorf("Resolve(loop): got error %v, want a loop error", err)
+	}
+}
+
+func TestChmod(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		escape bool
+	}{
+		{"sub/ok.txt", false},
+		{"in", false},
+		{"indir/ok.txt", false},
+		{"out", true},
+		{"outdir/outside", true},
+		{"other/secret", true},
+		{"../outside", true},
+		{"/outside", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, base := newTestRoot(t)
+			name := filepath.FromSlash(tt.name)
+			if filepath.IsAbs(name) {
+				name = filepath.Join(base, name)
+			}
+			checkEscape(t, root.Chmod(name, 0o600), tt.escape)
+			for _, p := range []string{
+				filepath.Join(base, "outside"),
+				filepath.Join(base, "data-other", "secret"),
+			} {
+				if info, err := os.Stat(p); err != nil || info.Mode().Perm() != 0o644 {
+					t.Errorf("mode of %s changed", p)
+				}
+			}
+		})
+	}
+}
+
+func TestRename(t *testing.T) {
+	for _, tt := range []struct {
+		oldname, newname string
+		escape           bool
+	}{
+		{"sub/ok.txt", "moved", false},
+		{"sub/ok.txt", "indir/moved", false},
+		// Renaming a link that escapes moves the link, not its target.
+		{"out", "moved", false},
+		{"sub/ok.txt", "../moved", true},
+		{"sub/ok.txt", "outdir/moved", true},
+		{"sub/ok.txt", "other/moved", true},
+		{"other/secret", "moved", true},
+		{"outdir/outside", "moved", true},
+		{"../data-other/secret", "moved", true},
+	} {
+		t.Run(tt.oldname+"->"+tt.newname, func(t *testing.T) {
+			root, base := newTestRoot(t)
+			err := root.Rename(filepath.FromSlash(tt.oldname), filepath.FromSlash(tt.newname))
+			checkEscape(t, err, tt.escape)
+			for _, p := range []string{
+				filepath.Join(base, "outside"),
+				filepath.Join(base, "data-other", "secret"),
+			} {
+				if _, err := os.Stat(p); err != nil {
+					t.Errorf("%s was moved: %v", p, err)
+				}
+			}
+			for _, p := range []string{
+				filepath.Join(base, "moved"),
+				filepath.Join(base, "data-other", "moved"),
+			} {
+				if _, err := os.Lstat(p); err == nil {
+					t.Errorf("%s was created outside of the root", p)
+				}
+			}
+		})
+	}
+}
+
+func TestSymlink(t *testing.T) {
+	for _, tt := range []struct {
+		target, name string
+		escape       bool
+	}{
+		{"sub/ok.txt", "link", false},
+		{"ok.txt", "indir/link", false},
+		{"../link", "..", true},
+		{"x", "../link", true},
+		{"x", "outdir/link", true},
+		{"x", "other/link", true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			root, base := newTestRoot(t)
+			err := root.Symlink(tt.target, filepath.FromSlash(tt.name))
+			checkEscape(t, err, tt.escape)
+			for _, p := range []string{
+				filepath.Join(base, "link"),
+				filepath.Join(base, "data-other", "link"),
+			} {
+				if _, err := os.Lstat(p); err == nil {
+					t.Errorf("%s was created outside of the root", p)
+				}
+			}
+		})
+	}
+}
+
+// TestFollowEscapingLink checks that links whose targets are outside of the
+// root can be created but not followed.
+func TestFollowEscapingLink(t *testing.T) {
+	root, base := newTestRoot(t)
+	if err := root.Symlink("../data-other/secret", "sibling"); err != nil {
+		t.Fatal(err)
+	}
+	if err := root.Symlink(filepath.Join(base, "outside"), "absolute"); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"out", "outdir/outside", "other/secret", "sibling", "absolute"} {
+		f, err := root.Open(filepath.FromSlash(name))
+		if err == nil {
+			f.Close()
+		}
+		if !errors.Is(err, ErrEscape) {
+			t.Errorf("Open(%q): got error %v, want ErrEscape", name, err)
+		}
+	}
+	f, err := root.Open("in")
+	if err != nil {
+		t.Fatalf("Open(in): %v", err)
+	}
+	f.Close()
+}
@@ -0,0 +1,75 @@
+package metrics
+
+// HTTP API metrics.
+var (
+	HTTPRequests = NewCounterVec("sidecar_http_requests_total",
+		"HTTP requests handled by the API server.", "route", "method", "status")
+	HTTPRequestDuration = NewHistogramVec("sidecar_http_request_duration_seconds",
+		"Latency of HTTP requests handled by the API server.", nil, "route", "method")
+	RateLimitRejections = NewCounterVec("sidecar_rate_limit_rejections_total",
+		"Requests rejected by the per-client rate limiter.")
+	BytesUploaded = NewCounterVec("sidecar_bytes_uploaded_total",
+		"Bytes written to the data root through the file API.")
+	BytesDownloaded = NewCounterVec("sidecar_bytes_downloaded_total",
+		"Bytes served from the data root through the file API.")
+	WatchStreams = NewGaugeVec("sidecar_watch_streams",
+		"Open file change event streams.")
+)
+
+// Storage metrics.
+var (
+	DataRootBytes = NewGaugeVec("sidecar_data_root_bytes",
+		"Size of the data root as of the last usage scan.")
+	DataRootQuotaBytes = NewGaugeVec("sidecar_data_root_quota_bytes",
+		"Configured quota of the data root; 0 if none.")
+	TrashBytes = NewGaugeVec("sidecar_trash_bytes",
+		"Total size of the items in the trash.")
+	TrashItems = NewGaugeVec("sidecar_trash_items",
+		"Number of items in the trash.")
+)
+
+// Backup metrics.
+var (
+	Backups = NewCounterVec("sidecar_backups_total",
+		"Snapshots taken, by result.", "result")
+	Restores = NewCounterVec("sidecar_restores_total",
+		"Snapshot restores, by result.", "result")
+	BackupDuration = NewGaugeVec("sidecar_backup_duration_seconds",
+		"Time taken by the last successful snapshot.")
+	BackupLastTimestamp = NewGaugeVec("sidecar_backup_last_timestamp_seconds",
+		"Unix time of the newest stored snapshot.")
+	BackupSnapshots = NewGaugeVec("sidecar_backup_snapshots",
+		"Number of stored snapshots.")
+	BackupBytes = NewGaugeVec("sidecar_backup_bytes",
+		"Total size of the stored snapshots.")
+	BackupUploads = NewCounterVec("sidecar_backup_uploads_total",
+		"Snapshot uploads to remote storage, by result.", "result")
+	BackupUploadDuration = NewGaugeVec("sidecar_backup_upload_duration_seconds",
+		"Time taken by the last successful upload to remote storage.")
+	ScheduledBackups = NewCounterVec("sidecar_backup_scheduled_runs_total",
+		"Scheduled snapshot runs, by result.", "result")
+	BackupsPruned = NewCounterVec("sidecar_backups_pruned_total",
+		"Scheduled snapshots removed by the retention policy.")
+	BackupScheduleNextTimestamp = NewGaugeVec("sidecar_backup_schedule_next_timestamp_seconds",
+		"Unix time of the next scheduled snapshot.")
+	BackupScheduleLastTimestamp = NewGaugeVec("sidecar_backup_schedule_last_run_timestamp_seconds",
+		"Unix time of the last scheduled snapshot run.")
+)
+
+// Agones lifecycle metrics.
+var (
+	ProbeAttempts = NewCounterVec("sidecar_probe_attempts_total",
+		"Readiness probe attempts against the game server, by result.", "result")
+	ProbeDuration = NewHistogramVec("sidecar_probe_duration_seconds",
+		"Latency of individual readiness probe attempts.", nil, "result")
+	TimeToReady = NewGaugeVec("sidecar_time_to_ready_seconds",
+		"Seconds from sidecar start until the game server was marked Ready.")
+	HealthPings = NewCounterVec("sidecar_health_pings_total",
+		"Agones health pings sent, by result.", "result")
+	GameServerState = NewGaugeVec("sidecar_gameserver_state",
+		"Current Agones GameServer state; the active state has value 1.", "state")
+	GameServerPlayers = NewGaugeVec("sidecar_gameserver_players",
+		"Connected players reported by Agones player tracking.")
+	GameServerPlayerCapacity = NewGaugeVec("sidecar_gameserver_player_capacity",
+		"Player capacity reported by Agones player tracking.")
+)
@@ -0,0 +1,188 @@
+// Package policy decides which paths of the data root the file API may read,
+// change or delete. Rules match slash-separated, root-relative paths with the
+// glob package, and a rule matching a directory applies to everything below it.
+package policy
+
+import (
+	"fmt"
+	"io/fs"
+	"path"
+	"strings"
+	"sync/atomic"
+
+	"github.com/pegnia/sidecar/internal/glob"
+)
+
+// Effect is what a rule does to the paths it matches.
+type Effect string
+
+const (
+	// Hidden paths cannot be read, listed, changed or deleted.
+	Hidden Effect = "hidden"
+	// ReadOnly paths can be read but not changed or deleted.
+	ReadOnly Effect = "read_only"
+	// Protected paths can be changed but not deleted, moved away or replaced.
+	// Unlike the other effects it does not extend to the contents of a
+	// matching directory; use "dir/**" for that.
+	Protected Effect = "protected"
+)
+
+// Op is the kind of access being checked.
+type Op int
+
+const (
+	Read Op = iota
+	Write
+	Delete
+)
+
+func (op Op) String() string {
+	switch op {
+	case Read:
+		return "read"
+	case Write:
+		return "write"
+	}
+	return "delete"
+}
+
+// Rule applies an effect to the paths matching Pattern. A WhileRunning rule
+// only applies while the game server is running.
+type Rule struct {
+	Pattern      string
+	Effect       Effect
+	WhileRunning bool
+}
+
+// Violation is returned for an access the policy forbids. Rule is nil if the
+// whole file API is in read-only mode. It unwraps to fs.ErrPermission.
+type Violation struct {
+	Path string
+	Op   Op
+	Rule *Rule
+}
+
+func (v *Violation) Error() string {
+	if v.Rule == nil {
+		return "the file API is in read-only mode"
+	}
+	switch v.Rule.Effect {
+	case Hidden:
+		return fmt.Sprintf("path is hidden by rule %q", v.Rule.Pattern)
+	case ReadOnly:
+		return fmt.Sprintf("path is read-only by rule %q", v.Rule.Pattern)
+	}
+	return fmt.Sprintf("path is protected from deletion by rule %q", v.Rule.Pattern)
+}
+
+func (v *Violation) Unwrap() error { return fs.ErrPermission }
+
+// Policy is a set of rules plus a global read-only switch. The zero value
+// allows everything.
+type Policy struct {
+	readOnly bool
+	rules    []Rule
+	running  atomic.Bool
+}
+
+// New returns a policy with the given rules, rejecting malformed patterns.
+func New(readOnly bool, rules []Rule) (*Policy, error) {
+	for _, rule := range rules {
+		switch rule.Effect {
+		case Hidden, ReadOnly, Protected:
+		default:
+			return nil, fmt.Errorf("unknown policy effect %q", rule.Effect)
+		}
+		if !glob.Valid(rule.Pattern) || strings.Trim(rule.Pattern, "/") == "" {
+			return nil, fmt.Errorf("invalid %s pattern %q", rule.Effect, rule.Pattern)
+		}
+	}
+	return &Policy{readOnly: readOnly, rules: rules}, nil
+}
+
+// Rules builds rules with one effect from a comma-separated list of patterns.
+func Rules(list string, effect Effect, whileRunning bool) []Rule {
+	var rules []Rule
+	for _, pattern := range strings.Split(list, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			rules = append(rules, Rule{Pattern: pattern, Effect: effect, WhileRunning: whileRunning})
+		}
+	}
+	return rules
+}
+
+// ReadOnly reports whether the whole file API is read-only.
+func (p *Policy) ReadOnly() bool { return p.readOnly }
+
+// AllRules returns every configured rule, including inactive WhileRunning ones.
+func (p *Policy) AllRules() []Rule { return p.rules }
+
+// SetRunning records whether the game server is running, which switches
+// WhileRunning rules on or off.
+func (p *Policy) SetRunning(running bool) { p.running.Store(running) }
+
+// Running reports the state last passed to SetRunning.
+func (p *Policy) Running() bool { return p.running.Load() }
+
+func (p *Policy) active(rule *Rule) bool {
+	return !rule.WhileRunning || p.running.Load()
+}
+
+// Hidden reports whether name or one of its parents is hidden.
+func (p *Policy) Hidden(name string) bool {
+	for n := name; n != "." && n != "/" && n != ""; n = path.Dir(n) {
+		for i := range p.rules {
+			if rule := &p.rules[i]; rule.Effect == Hidden && p.active(rule) && glob.Match(rule.Pattern, n) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Check returns a *Violation if op on name is forbidden, either by a rule
+// matching name or by one matching a parent directory.
+func (p *Policy) Check(name string, op Op) error {
+	if op != Read && p.readOnly {
+		return &Violation{Path: name, Op: op}
+	}
+	for n := name; n != "." && n != "/" && n != ""; n = path.Dir(n) {
+		if err := p.match(name, n, op, n == name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CheckTree is Check for name and every entry below it in fsys, for
+// operations such as a recursive delete that reach the whole tree. Entries
+// that cannot be read are skipped.
+func (p *Policy) CheckTree(fsys fs.FS, name string, op Op) error {
+	if err := p.Check(name, op); err != nil || len(p.rules) == 0 {
+		return err
+	}
+	return fs.WalkDir(fsys, name, func(n string, d fs.DirEntry, err error) error {
+		if err != nil || n == name {
+			return nil
+		}
+		return p.match(n, n, op, true)
+	})
+}
+
+// match checks the rules matching n, which is name itself or one of its
+// parents, for op on name.
+func (p *Policy) match(name, n string, op Op, self bool) error {
+	for i := range p.rules {
+		rule := &p.rules[i]
+		if !p.active(rule) || !glob.Match(rule.Pattern, n) {
+			continue
+		}
+		switch {
+		case rule.Effect == Hidden,
+			rule.Effect == ReadOnly && op != Read,
+			rule.Effect == Protected && op == Delete && self:
+			return &Violation{Path: name, Op: op, Rule: rule}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,439 @@
+// Package s3 is a small client for S3-compatible object storage, covering
+// what the sidecar needs to ship snapshots: uploading objects, in parts when
+// they are large, and listing, fetching and deleting them. Requests are
+// signed with AWS Signature Version 4, so it works with AWS S3 as well as
+// MinIO, Ceph, Cloudflare R2 and the like.
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Server-side encryption modes. SSECustomer encrypts with a key the client
+// sends along with every request for the object.
+const (
+	SSES3       = "AES256"
+	SSEKMS      = "aws:kms"
+	SSECustomer = "SSE-C"
+)
+
+// Config describes a bucket and how to reach it.
+type Config struct {
+	// Endpoint is the base URL of the service, such as http://minio:9000.
+	// It defaults to the AWS endpoint of Region.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// PathStyle addresses the bucket as the first path segment rather than
+	// as a subdomain of the endpoint, as MinIO and most other services need.
+	PathStyle bool
+
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+
+	// SSE selects server-side encryption of uploaded objects: SSES3, SSEKMS
+	// with KMSKeyID, or SSECustomer with the base64-encoded 256-bit
+	// CustomerKey. Empty leaves it to the bucket's default.
+	SSE         string
+	KMSKeyID    string
+	CustomerKey string
+
+	// PartSize is the size of the parts large objects are uploaded in, and
+	// thus the memory an upload uses. It defaults to DefaultPartSize.
+	PartSize int64
+
+	HTTPClient *http.Client
+}
+
+const (
+	// DefaultPartSize is used if Config.PartSize is not set.
+	DefaultPartSize = 16 << 20
+	// MinPartSize is the smallest part S3 accepts, except for the last one.
+	MinPartSize = 5 << 20
+	// maxParts is the most parts a multipart upload may have.
+	maxParts = 10000
+	// maxAttempts bounds how often a request failing with a server error or
+	// a network error is sent.
+	maxAttempts = 3
+)
+
+// Client talks to one bucket.
+type Client struct {
+	cfg      Config
+	endpoint *url.URL
+	http     *http.Client
+	// sseC holds the headers identifying the customer key, if any.
+	sseC http.Header
+}
+
+// New returns a client for the bucket described by cfg.
+func New(cfg Config) (*Client, error) {
+	if cfg.Bucket == "" {
+		return nil, errors.New("no bucket given")
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+	if cfg.Endpoint == "" {
+		cfg.Endpoint = "https://s3." + cfg.Region + ".amazonaws.com"
+	}
+	endpoint, err := url.Parse(cfg.Endpoint)
+	if err != nil || (endpoint.Scheme != "http" && endpoint.Scheme != "https") || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid endpoint %q", cfg.Endpoint)
+	}
+	endpoint.Path = strings.TrimSuffix(endpoint.Path, "/")
+	if cfg.AccessKeyID == "" || cfg.SecretAccessKey == "" {
+		return nil, errors.New("no credentials given")
+	}
+	if cfg.PartSize == 0 {
+		cfg.PartSize = DefaultPartSize
+	}
+	if cfg.PartSize < MinPartSize {
+		return nil, fmt.Errorf("part size must be at least %d bytes", MinPartSize)
+	}
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = http.DefaultClient
+	}
+
+	c := &Client{cfg: cfg, endpoint: endpoint, http: cfg.HTTPClient}
+	switch cfg.SSE {
+	case "", SSES3, SSEKMS:
+	case SSECustomer:
+		key, err := base64.StdEncoding.DecodeString(cfg.CustomerKey)
+		if err != nil || len(key) != 32 {
+			return nil, errors.New("the customer key must be 32 bytes, base64-encoded")
+		}
+		sum := md5.Sum(key)
+		c.sseC = http.Header{}
+		c.sseC.Set("X-Amz-Server-Side-Encryption-Customer-Algorithm", "AES256")
+		c.sseC.Set("X-Amz-Server-Side-Encryption-Customer-Key", cfg.CustomerKey)
+		c.sseC.Set("X-Amz-Server-Side-Encryption-Customer-Key-Md5", base64.StdEncoding.EncodeToString(sum[:]))
+	default:
+		return nil, fmt.Errorf("unknown server-side encryption %q, expected %s, %s or %s", cfg.SSE, SSES3, SSEKMS, SSECustomer)
+	}
+	return c, nil
+}
+
+// String describes the bucket for logs.
+func (c *Client) String() string {
+	return c.endpoint.String() + "/" + c.cfg.Bucket
+}
+
+// Error is returned for requests that failed, either with an S3 error
+// response, in which case StatusCode is set, or without reaching the
+// service. It matches fs.ErrNotExist for missing objects.
+type Error struct {
+	Op         string
+	Key        string
+	StatusCode int
+	Code       string
+	Message    string
+	RequestID  string
+	Err        error
+}
+
+func (e *Error) Error() string {
+	msg := e.Op + " " + e.Key + ": "
+	switch {
+	case e.Err != nil:
+		return msg + e.Err.Error()
+	case e.Code != "":
+		return msg + e.Code + ": " + e.Message
+	default:
+		return msg + http.StatusText(e.StatusCode)
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+func (e *Error) Is(target error) bool {
+	return target == fs.ErrNotExist && e.StatusCode == http.StatusNotFound
+}
+
+// Object is an entry of a bucket listing.
+type Object struct {
+	Key          string    `xml:"Key"`
+	Size         int64     `xml:"Size"`
+	LastModified time.Time `xml:"LastModified"`
+}
+
+// request is an S3 call. Its body is held in memory so the call can be retried.
+type request struct {
+	op     string
+	method string
+	key    string
+	query  url.Values
+	header http.Header
+	body   []byte
+}
+
+// do sends req, retrying server errors and network errors, and returns the
+// response if its status is 2xx. The caller must close its body.
+func (c *Client) do(ctx context.Context, req request) (*http.Response, error) {
+	u := *c.endpoint
+	path := "/" + req.key
+	if c.cfg.PathStyle {
+		path = strings.TrimSuffix("/"+c.cfg.Bucket+path, "/")
+	} else {
+		u.Host = c.cfg.Bucket + "." + u.Host
+	}
+	u.Path = c.endpoint.Path + path
+	u.RawPath = uriEncode(u.Path, false)
+	u.RawQuery = canonicalQuery(req.query)
+
+	payloadHash := emptyHash
+	if req.body != nil {
+		payloadHash = hashHex(req.body)
+	}
+	var lastErr error
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		if attempt > 1 {
+			select {
+			case <-ctx.Done():
+				return nil, &Error{Op: req.op, Key: req.key, Err: ctx.Err()}
+			case <-time.After(time.Duration(attempt*attempt) * 250 * time.Millisecond):
+			}
+		}
+		httpReq, err := http.NewRequestWithContext(ctx, req.method, u.String(), bytes.NewReader(req.body))
+		if err != nil {
+			return nil, &Error{Op: req.op, Key: req.key, Err: err}
+		}
+		httpReq.ContentLength = int64(len(req.body))
+		for name, values := range req.header {
+			httpReq.Header[name] = values
+		}
+		c.sign(httpReq, payloadHash, time.Now())
+
+		resp, err := c.http.Do(httpReq)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, &Error{Op: req.op, Key: req.key, Err: err}
+			}
+			lastErr = &Error{Op: req.op, Key: req.key, Err: err}
+			continue
+		}
+		if resp.StatusCode/100 == 2 {
+			return resp, nil
+		}
+		lastErr = responseError(req, resp)
+		if resp.StatusCode < 500 {
+			return nil, lastErr
+		}
+	}
+	return nil, lastErr
+}
+
+// responseError reads the S3 error document of a failed response.
+func responseError(req request, resp *http.Response) error {
+	defer resp.Body.Close()
+	e := &Error{Op: req.op, Key: req.key, StatusCode: resp.StatusCode, RequestID: resp.Header.Get("X-Amz-Request-Id")}
+	var doc struct {
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&doc) == nil {
+		e.Code, e.Message = doc.Code, doc.Message
+	}
+	return e
+}
+
+// call sends req and discards the response body.
+func (c *Client) call(ctx context.Context, req request) (http.Header, error) {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.Header, nil
+}
+
+// callXML sends req and decodes the XML response into v. S3 may report a
+// failure in the body of a 200 response, which is returned as an *Error.
+func (c *Client) callXML(ctx context.Context, req request, v any) error {
+	resp, err := c.do(ctx, req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return &Error{Op: req.op, Key: req.key, Err: err}
+	}
+	var failure struct {
+		XMLName xml.Name
+		Code    string `xml:"Code"`
+		Message string `xml:"Message"`
+	}
+	if xml.Unmarshal(data, &failure) == nil && failure.XMLName.Local == "Error" {
+		return &Error{Op: req.op, Key: req.key, StatusCode: resp.StatusCode, Code: failure.Code, Message: failure.Message}
+	}
+	if err := xml.Unmarshal(data, v); err != nil {
+		return &Error{Op: req.op, Key: req.key, Err: fmt.Errorf("decoding response: %w", err)}
+	}
+	return nil
+}
+
+// encryptionHeaders returns the headers requesting server-side encryption of
+// a new object.
+func (c *Client) encryptionHeaders() http.Header {
+	h := http.Header{}
+	switch c.cfg.SSE {
+	case SSES3:
+		h.Set("X-Amz-Server-Side-Encryption", SSES3)
+	case SSEKMS:
+		h.Set("X-Amz-Server-Side-Encryption", SSEKMS)
+		if c.cfg.KMSKeyID != "" {
+			h.Set("X-Amz-Server-Side-Encryption-Aws-Kms-Key-Id", c.cfg.KMSKeyID)
+		}
+	case SSECustomer:
+		for name, values := range c.sseC {
+			h[name] = values
+		}
+	}
+	return h
+}
+
+// PutObject uploads data as key in a single request.
+func (c *Client) PutObject(ctx context.Context, key string, data []byte, contentType string) error {
+	header := c.encryptionHeaders()
+	header.Set("Content-Type", contentType)
+	_, err := c.call(ctx, request{op: "PutObject", method: http.MethodPut, key: key, header: header, body: data})
+	return err
+}
+
+// GetObject returns the content of key and its size. The caller must close it.
+func (c *Client) GetObject(ctx context.Context, key string) (io.ReadCloser, int64, error) {
+	header := http.Header{}
+	for name, values := range c.sseC {
+		header[name] = values
+	}
+	resp, err := c.do(ctx, request{op: "GetObject", method: http.MethodGet, key: key, header: header})
+	if err != nil {
+		return nil, 0, err
+	}
+	return resp.Body, resp.ContentLength, nil
+}
+
+// DeleteObject deletes key. Deleting a missing key succeeds.
+func (c *Client) DeleteObject(ctx context.Context, key string) error {
+	_, err := c.call(ctx, request{op: "DeleteObject", method: http.MethodDelete, key: key})
+	return err
+}
+
+// List returns the objects whose keys start with prefix, in key order.
+func (c *Client) List(ctx context.Context, prefix string) ([]Object, error) {
+	var objects []Object
+	token := ""
+	for {
+		query := url.Values{"list-type": {"2"}, "prefix": {prefix}}
+		if token != "" {
+			query.Set("continuation-token", token)
+		}
+		var page struct {
+			Contents              []Object `xml:"Contents"`
+			IsTruncated           bool     `xml:"IsTruncated"`
+			NextContinuationToken string   `xml:"NextContinuationToken"`
+		}
+		if err := c.callXML(ctx, request{op: "ListObjectsV2", method: http.MethodGet, query: query}, &page); err != nil {
+			return nil, err
+		}
+		objects = append(objects, page.Contents...)
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			return objects, nil
+		}
+		token = page.NextContinuationToken
+	}
+}
+
+// Upload streams r to key. Content up to the part size is sent in a single
+// request; anything larger is sent as a multipart upload, one part at a time,
+// so memory use stays at one part. A failed multipart upload is aborted.
+func (c *Client) Upload(ctx context.Context, key string, r io.Reader, contentType string) error {
+	buf := make([]byte, c.cfg.PartSize)
+	n, err := io.ReadFull(r, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return c.PutObject(ctx, key, buf[:n], contentType)
+	}
+	if err != nil {
+		return err
+	}
+
+	header := c.encryptionHeaders()
+	header.Set("Content-Type", contentType)
+	var created struct {
+		UploadID string `xml:"UploadId"`
+	}
+	if err := c.callXML(ctx, request{op: "CreateMultipartUpload", method: http.MethodPost, key: key, query: url.Values{"uploads": {""}}, header: header}, &created); err != nil {
+		return err
+	}
+	if err := c.uploadParts(ctx, key, created.UploadID, r, buf); err != nil {
+		abort := request{op: "AbortMultipartUpload", method: http.MethodDelete, key: key, query: url.Values{"uploadId": {created.UploadID}}}
+		c.call(context.WithoutCancel(ctx), abort)
+		return err
+	}
+	return nil
+}
+
+// completedPart is an element of a CompleteMultipartUpload request.
+type completedPart struct {
+	PartNumber int    `xml:"PartNumber"`
+	ETag       string `xml:"ETag"`
+}
+
+// uploadParts sends the first part, already read into buf, and the rest of
+// r, then completes the upload.
+func (c *Client) uploadParts(ctx context.Context, key, uploadID string, r io.Reader, buf []byte) error {
+	var parts []completedPart
+	n := len(buf)
+	for {
+		number := len(parts) + 1
+		if number > maxParts {
+			return &Error{Op: "UploadPart", Key: key, Err: fmt.Errorf("more than %d parts of %d bytes", maxParts, len(buf))}
+		}
+		header := http.Header{}
+		for name, values := range c.sseC {
+			header[name] = values
+		}
+		query := url.Values{"partNumber": {strconv.Itoa(number)}, "uploadId": {uploadID}}
+		respHeader, err := c.call(ctx, request{op: "UploadPart", method: http.MethodPut, key: key, query: query, header: header, body: buf[:n]})
+		if err != nil {
+			return err
+		}
+		parts = append(parts, completedPart{PartNumber: number, ETag: respHeader.Get("ETag")})
+
+		n, err = io.ReadFull(r, buf)
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return err
+		}
+	}
+
+	body, err := xml.Marshal(struct {
+		XMLName xml.Name        `xml:"CompleteMultipartUpload"`
+		Parts   []completedPart `xml:"Part"`
+	}{Parts: parts})
+	if err != nil {
+		return err
+	}
+	var done struct{}
+	return c.callXML(ctx, request{op: "CompleteMultipartUpload", method: http.MethodPost, key: key, query: url.Values{"uploadId": {uploadID}}, body: body}, &done)
+}
@@ -0,0 +1,216 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeS3 is an in-memory bucket named "bucket", addressed path-style. It
+// serves just the calls the client makes and records them by operation.
+type fakeS3 struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+	uploads map[string]map[int][]byte
+	calls   []string
+	// failPart makes uploads of this part number fail.
+	failPart int
+}
+
+func newFakeS3(t *testing.T) (*fakeS3, *Client) {
+	t.Helper()
+	f := &fakeS3{objects: map[string][]byte{}, uploads: map[string]map[int][]byte{}}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	c, err := New(Config{
+		Endpoint:        srv.URL,
+		Bucket:          "bucket",
+		PathStyle:       true,
+		AccessKeyID:     "key",
+		SecretAccessKey: "secret",
+		PartSize:        MinPartSize,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f, c
+}
+
+func (f *fakeS3) fail(w http.ResponseWriter, status int, code string) {
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "<Error><Code>%s</Code><Message>%s</Message></Error>", code, code)
+}
+
+func (f *fakeS3) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	if r.Header.Get("X-Amz-Content-Sha256") != hashHex(body) ||
+		!strings.HasPrefix(r.Header.Get("Authorization"), sigAlgorithm+" Credential=key/") {
+		f.fail(w, http.StatusForbidden, "SignatureDoesNotMatch")
+		return
+	}
+	key, ok := strings.CutPrefix(r.URL.Path, "/bucket/")
+	if !ok && r.URL.Path != "/bucket" {
+		f.fail(w, http.StatusNotFound, "NoSuchBucket")
+		return
+	}
+	query := r.URL.Query()
+	switch {
+	case r.Method == http.MethodPost && query.Has("uploads"):
+		f.calls = append(f.calls, "CreateMultipartUpload")
+		id := strconv.Itoa(len(f.uploads) + 1)
+		f.uploads[id] = map[int][]byte{}
+		fmt.Fprintf(w, "<InitiateMultipartUploadResult><UploadId>%s</UploadId></InitiateMultipartUploadResult>", id)
+	case r.Method == http.MethodPut && query.Has("partNumber"):
+		f.calls = append(f.calls, "UploadPart")
+		n, _ := strconv.Atoi(query.Get("partNumber"))
+		parts, ok := f.uploads[query.Get("uploadId")]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchUpload")
+			return
+		}
+		if n == f.failPart {
+			f.fail(w, http.StatusBadRequest, "InvalidArgument")
+			return
+		}
+		parts[n] = body
+		w.Header().Set("ETag", fmt.Sprintf(`"part%d"`, n))
+	case r.Method == http.MethodPost && query.Has("uploadId"):
+		f.calls = append(f.calls, "CompleteMultipartUpload")
+		var doc struct {
+			Parts []completedPart `xml:"Part"`
+		}
+		xml.Unmarshal(body, &doc)
+		parts := f.uploads[query.Get("uploadId")]
+		var data []byte
+		for i, p := range doc.Parts {
+			if p.PartNumber != i+1 || p.ETag != fmt.Sprintf(`"part%d"`, p.PartNumber) {
+				f.fail(w, http.StatusBadRequest, "InvalidPart")
+				return
+			}
+			data = append(data, parts[p.PartNumber]...)
+		}
+		f.objects[key] = data
+		delete(f.uploads, query.Get("uploadId"))
+		fmt.Fprint(w, "<CompleteMultipartUploadResult></CompleteMultipartUploadResult>")
+	case r.Method == http.MethodDelete && query.Has("uploadId"):
+		f.calls = append(f.calls, "AbortMultipartUpload")
+		delete(f.uploads, query.Get("uploadId"))
+		w.WriteHeader(http.StatusNoContent)
+	case r.Method == http.MethodPut:
+		f.calls = append(f.calls, "PutObject")
+		f.objects[key] = body
+	case r.Method == http.MethodGet:
+		f.calls = append(f.calls, "GetObject")
+		data, ok := f.objects[key]
+		if !ok {
+			f.fail(w, http.StatusNotFound, "NoSuchKey")
+			return
+		}
+		w.Write(data)
+	default:
+		f.fail(w, http.StatusMethodNotAllowed, "MethodNotAllowed")
+	}
+}
+
+func randomData(t *testing.T, n int) []byte {
+	t.Helper()
+	data := make([]byte, n)
+	if _, err := rand.Read(data); err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestUpload(t *testing.T) {
+	for _, tt := range []struct {
+		name  string
+		size  int
+		calls string
+	}{
+		{"single request", MinPartSize - 1, "PutObject"},
+		{"multipart", 2*MinPartSize + 1,
+			"CreateMultipartUpload UploadPart UploadPart UploadPart CompleteMultipartUpload"},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			f, c := newFakeS3(t)
+			data := randomData(t, tt.size)
+			if err := c.Upload(context.Background(), "dir/obj", bytes.NewReader(data), "application/octet-stream"); err != nil {
+				t.Fatal(err)
+			}
+			if got := strings.Join(f.calls, " "); got != tt.calls {
+				t.Errorf("got calls %s, want %s", got, tt.calls)
+			}
+			if !bytes.Equal(f.objects["dir/obj"], data) {
+				t.Errorf("stored object differs from the uploaded data")
+			}
+			if len(f.uploads) != 0 {
+				t.Errorf("%d multipart uploads left open", len(f.uploads))
+			}
+		})
+	}
+}
+
+func TestUploadAbortsOnFailure(t *testing.T) {
+	f, c := newFakeS3(t)
+	f.failPart = 2
+	data := randomData(t, 3*MinPartSize)
+	err := c.Upload(context.Background(), "obj", bytes.NewReader(data), "application/octet-stream")
+	var s3Err *Error
+	if !errors.As(err, &s3Err) || s3Err.Op != "UploadPart" || s3Err.Code != "InvalidArgument" {
+		t.Fatalf("got error %v, want the failed UploadPart", err)
+	}
+	want := "CreateMultipartUpload UploadPart UploadPart AbortMultipartUpload"
+	if got := strings.Join(f.calls, " "); got != want {
+		t.Errorf("got calls %s, want %s", got, want)
+	}
+	if len(f.uploads) != 0 {
+		t.Errorf("the failed multipart upload was not aborted")
+	}
+	if _, ok := f.objects["obj"]; ok {
+		t.Errorf("a failed upload created the object")
+	}
+}
+
+func TestGetObject(t *testing.T) {
+	f, c := newFakeS3(t)
+	f.objects["obj"] = []byte("content")
+	body, size, err := c.GetObject(context.Background(), "obj")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := io.ReadAll(body)
+	body.Close()
+	if err != nil || string(data) != "content" || size != int64(len(data)) {
+		t.Errorf("got %q of size %d, %v, want %q", data, size, err, "content")
+	}
+
+	_, _, err = c.GetObject(context.Background(), "missing")
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for a missing object, want fs.ErrNotExist", err)
+	}
+	var s3Err *Error
+	if !errors.As(err, &s3Err) || s3Err.Code != "NoSuchKey" {
+		t.Errorf("got error %v, want NoSuchKey", err)
+	}
+
+	bad, err := New(Config{Endpoint: c.cfg.Endpoint, Bucket: "bucket", PathStyle: true, AccessKeyID: "other", SecretAccessKey: "secret"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := bad.GetObject(context.Background(), "obj"); err == nil || errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("got error %v for a refused request, want one that is not fs.ErrNotExist", err)
+	}
+}
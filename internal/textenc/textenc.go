@@ -0,0 +1,127 @@
+// Package textenc detects the character encoding of text files and converts
+// them to and from UTF-8, so files can be edited as strings and written back
+// in the encoding they came in.
+package textenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// Encoding names a supported text encoding.
+type Encoding string
+
+const (
+	UTF8    Encoding = "utf-8"
+	UTF8BOM Encoding = "utf-8-bom"
+	UTF16LE Encoding = "utf-16le"
+	UTF16BE Encoding = "utf-16be"
+	// Latin1 is assumed for anything that is not valid UTF-8 but looks like
+	// text. Java .properties files are traditionally ISO-8859-1.
+	Latin1 Encoding = "iso-8859-1"
+)
+
+// ErrBinary is returned by Decode for data that does not look like text.
+var ErrBinary = errors.New("file does not look like text")
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Parse validates an encoding name.
+func Parse(s string) (Encoding, error) {
+	switch e := Encoding(strings.ToLower(s)); e {
+	case UTF8, UTF8BOM, UTF16LE, UTF16BE, Latin1:
+		return e, nil
+	case "latin1", "latin-1":
+		return Latin1, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q", s)
+}
+
+// Decode detects the encoding of b and returns its content as UTF-8. A byte
+// order mark selects UTF-8 or UTF-16; otherwise valid UTF-8 is taken as is,
+// and anything else is read as ISO-8859-1 unless it contains NUL bytes.
+func Decode(b []byte) (string, Encoding, error) {
+	switch {
+	case bytes.HasPrefix(b, bomUTF8):
+		rest := b[len(bomUTF8):]
+		if !utf8.Valid(rest) {
+			return "", "", ErrBinary
+		}
+		return string(rest), UTF8BOM, nil
+	case bytes.HasPrefix(b, bomUTF16LE):
+		s, err := decodeUTF16(b[2:], binary.LittleEndian)
+		return s, UTF16LE, err
+	case bytes.HasPrefix(b, bomUTF16BE):
+		s, err := decodeUTF16(b[2:], binary.BigEndian)
+		return s, UTF16BE, err
+	}
+	if bytes.IndexByte(b, 0) >= 0 {
+		return "", "", ErrBinary
+	}
+	if utf8.Valid(b) {
+		return string(b), UTF8, nil
+	}
+	runes := make([]rune, len(b))
+	for i, c := range b {
+		runes[i] = rune(c)
+	}
+	return string(runes), Latin1, nil
+}
+
+// Encode converts UTF-8 text to enc. UTF-16 and UTF-8 with BOM output starts
+// with a byte order mark. Characters that enc cannot represent are an error.
+func Encode(s string, enc Encoding) ([]byte, error) {
+	if !utf8.ValidString(s) {
+		return nil, errors.New("text is not valid UTF-8")
+	}
+	switch enc {
+	case UTF8:
+		return []byte(s), nil
+	case UTF8BOM:
+		return append(append([]byte{}, bomUTF8...), s...), nil
+	case UTF16LE:
+		return encodeUTF16(s, bomUTF16LE, binary.LittleEndian), nil
+	case UTF16BE:
+		return encodeUTF16(s, bomUTF16BE, binary.BigEndian), nil
+	case Latin1:
+		out := make([]byte, 0, len(s))
+		for _, r := range s {
+			if r > 0xFF {
+				return nil, fmt.Errorf("character %q cannot be represented in %s", r, enc)
+			}
+			out = append(out, byte(r))
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("unsupported encoding %q", enc)
+}
+
+func decodeUTF16(b []byte, order binary.ByteOrder) (string, error) {
+	if len(b)%2 != 0 {
+		return "", ErrBinary
+	}
+	units := make([]uint16, len(b)/2)
+	for i := range units {
+		units[i] = order.Uint16(b[2*i:])
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func encodeUTF16(s string, bom []byte, order binary.AppendByteOrder) []byte {
+	units := utf16.Encode([]rune(s))
+	out := make([]byte, len(bom), len(bom)+2*len(units))
+	copy(out, bom)
+	for _, u := range units {
+		out = order.AppendUint16(out, u)
+	}
+	return out
+}
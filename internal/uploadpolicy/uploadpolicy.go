@@ -0,0 +1,258 @@
+// Package uploadpolicy decides which files may be written through the upload
+// endpoints: by extension, by the content type sniffed from their first bytes,
+// by size per path pattern, and optionally by an external scanner that is run
+// on the staged file before it replaces the destination.
+package uploadpolicy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pegnia/sidecar/internal/glob"
+)
+
+// DefaultDenyExts are refused unless the deny list is configured explicitly.
+var DefaultDenyExts = []string{".exe", ".dll", ".sh", ".bat", ".cmd", ".php", ".phtml", ".js", ".jsp", ".asp"}
+
+// SniffLen is how many leading bytes CheckContent sniffs the type from.
+const SniffLen = 512
+
+// maxScanOutput caps the scanner output kept in a Rejection.
+const maxScanOutput = 1024
+
+// Reasons a file is rejected.
+const (
+	ReasonExtension = "extension"
+	ReasonType      = "type"
+	ReasonSize      = "size"
+	ReasonScan      = "scan"
+)
+
+// Rejection reports a file refused by the policy. Only the fields relevant
+// to Reason are set.
+type Rejection struct {
+	Reason    string
+	Extension string
+	Type      string
+	Pattern   string
+	Limit     int64
+	Size      int64
+	Output    string
+}
+
+func (e *Rejection) Error() string {
+	switch e.Reason {
+	case ReasonExtension:
+		return fmt.Sprintf("file extension %q is not allowed", e.Extension)
+	case ReasonType:
+		return fmt.Sprintf("file content type %s is not allowed", e.Type)
+	case ReasonSize:
+		return fmt.Sprintf("file size %d exceeds the limit of %d for %q", e.Size, e.Limit, e.Pattern)
+	}
+	return "file was rejected by the upload scanner"
+}
+
+// SizeLimit caps the size of files whose path matches Pattern.
+type SizeLimit struct {
+	Pattern string
+	Max     int64
+}
+
+// Config describes a Policy. Empty allow lists allow everything not denied.
+type Config struct {
+	// AllowExts and DenyExts hold extensions such as ".jar". An allow list
+	// only admits files without an extension if it contains ".".
+	AllowExts []string
+	DenyExts  []string
+	// AllowTypes and DenyTypes hold MIME types such as "application/zip" or
+	// wildcards such as "image/*".
+	AllowTypes []string
+	DenyTypes  []string
+	// MaxSizes are tried in order; the first matching pattern applies.
+	MaxSizes []SizeLimit
+	// ScanCommand, if set, is run with the staged file's path appended. A
+	// non-zero exit status rejects the file.
+	ScanCommand []string
+	ScanTimeout time.Duration
+}
+
+// Policy checks uploaded files against a Config.
+type Policy struct {
+	cfg Config
+}
+
+// New validates cfg and returns a Policy for it.
+func New(cfg Config) (*Policy, error) {
+	cfg.AllowExts = normalizeExts(cfg.AllowExts)
+	cfg.DenyExts = normalizeExts(cfg.DenyExts)
+	for _, l := range cfg.MaxSizes {
+		if !glob.Valid(l.Pattern) || l.Max <= 0 {
+			return nil, fmt.Errorf("invalid upload size limit %q=%d", l.Pattern, l.Max)
+		}
+	}
+	if cfg.ScanTimeout <= 0 {
+		cfg.ScanTimeout = time.Minute
+	}
+	return &Policy{cfg: cfg}, nil
+}
+
+func normalizeExts(exts []string) []string {
+	out := make([]string, 0, len(exts))
+	for _, ext := range exts {
+		ext = strings.ToLower(strings.TrimSpace(ext))
+		if ext == "" {
+			continue
+		}
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		out = append(out, ext)
+	}
+	return out
+}
+
+// List splits a comma-separated setting, dropping empty entries.
+func List(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// ParseSizes parses "pattern=bytes" pairs separated by commas.
+func ParseSizes(s string) ([]SizeLimit, error) {
+	var limits []SizeLimit
+	for _, entry := range List(s) {
+		pattern, size, ok := strings.Cut(entry, "=")
+		max, err := strconv.ParseInt(strings.TrimSpace(size), 10, 64)
+		if !ok || err != nil {
+			return nil, fmt.Errorf("invalid upload size limit %q, expected pattern=bytes", entry)
+		}
+		limits = append(limits, SizeLimit{Pattern: strings.TrimSpace(pattern), Max: max})
+	}
+	return limits, nil
+}
+
+// CheckName checks the extension of name.
+func (p *Policy) CheckName(name string) error {
+	ext := strings.ToLower(path.Ext(name))
+	lookup := ext
+	if lookup == "" {
+		lookup = "."
+	}
+	if len(p.cfg.AllowExts) > 0 && !slices.Contains(p.cfg.AllowExts, lookup) || ext != "" && slices.Contains(p.cfg.DenyExts, ext) {
+		return &Rejection{Reason: ReasonExtension, Extension: ext}
+	}
+	return nil
+}
+
+// CheckSize checks size against the first limit whose pattern matches the
+// slash-separated, root-relative name.
+func (p *Policy) CheckSize(name string, size int64) error {
+	for _, l := range p.cfg.MaxSizes {
+		if glob.Match(l.Pattern, name) {
+			if size > l.Max {
+				return &Rejection{Reason: ReasonSize, Pattern: l.Pattern, Limit: l.Max, Size: size}
+			}
+			return nil
+		}
+	}
+	return nil
+}
+
+// ChecksContent reports whether CheckContent has anything to check, so
+// callers can skip reading the file back.
+func (p *Policy) ChecksContent() bool {
+	return len(p.cfg.AllowTypes) > 0 || len(p.cfg.DenyTypes) > 0 || len(p.cfg.ScanCommand) > 0
+}
+
+// CheckContent checks a staged file: its sniffed content type, then the
+// scanner. head holds the file's first bytes, up to SniffLen; file is its
+// path on the host, and name the root-relative destination, which the
+// scanner receives in SIDECAR_UPLOAD_PATH.
+func (p *Policy) CheckContent(ctx context.Context, name, file string, head []byte) error {
+	if err := p.checkType(head); err != nil {
+		return err
+	}
+	return p.scan(ctx, name, file)
+}
+
+func (p *Policy) checkType(head []byte) error {
+	if len(p.cfg.AllowTypes) == 0 && len(p.cfg.DenyTypes) == 0 {
+		return nil
+	}
+	typ := Sniff(head)
+	if len(p.cfg.AllowTypes) > 0 && !matchType(p.cfg.AllowTypes, typ) || matchType(p.cfg.DenyTypes, typ) {
+		return &Rejection{Reason: ReasonType, Type: typ}
+	}
+	return nil
+}
+
+func (p *Policy) scan(ctx context.Context, name, file string) error {
+	if len(p.cfg.ScanCommand) == 0 {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, p.cfg.ScanTimeout)
+	defer cancel()
+	args := append(append([]string{}, p.cfg.ScanCommand[1:]...), file)
+	cmd := exec.CommandContext(ctx, p.cfg.ScanCommand[0], args...)
+	cmd.Env = append(os.Environ(), "SIDECAR_UPLOAD_PATH="+name)
+	out, err := cmd.CombinedOutput()
+	if ctx.Err() != nil {
+		return fmt.Errorf("upload scanner did not finish within %s", p.cfg.ScanTimeout)
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		out = bytes.TrimSpace(out)
+		if len(out) > maxScanOutput {
+			out = out[:maxScanOutput]
+		}
+		return &Rejection{Reason: ReasonScan, Output: string(out)}
+	}
+	if err != nil {
+		return fmt.Errorf("running upload scanner: %v", err)
+	}
+	return nil
+}
+
+// Sniff returns the MIME type of content starting with head, without
+// parameters. Executables and scripts, which net/http does not recognise,
+// are detected first.
+func Sniff(head []byte) string {
+	switch {
+	case bytes.HasPrefix(head, []byte("\x7fELF")):
+		return "application/x-elf"
+	case bytes.HasPrefix(head, []byte("MZ")):
+		return "application/vnd.microsoft.portable-executable"
+	case bytes.HasPrefix(head, []byte("\xfe\xed\xfa\xce")), bytes.HasPrefix(head, []byte("\xfe\xed\xfa\xcf")),
+		bytes.HasPrefix(head, []byte("\xce\xfa\xed\xfe")), bytes.HasPrefix(head, []byte("\xcf\xfa\xed\xfe")):
+		return "application/x-mach-binary"
+	case bytes.HasPrefix(head, []byte("#!")):
+		return "text/x-shellscript"
+	}
+	typ, _, _ := strings.Cut(http.DetectContentType(head), ";")
+	return typ
+}
+
+func matchType(patterns []string, typ string) bool {
+	for _, p := range patterns {
+		p = strings.ToLower(strings.TrimSpace(p))
+		if p == typ || strings.HasSuffix(p, "/*") && strings.HasPrefix(typ, p[:len(p)-1]) {
+			return true
+		}
+	}
+	return false
+}
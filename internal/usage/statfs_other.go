@@ -0,0 +1,8 @@
+//go:build !unix
+
+package usage
+
+// Filesystem reports no capacity on platforms without statfs.
+func Filesystem(string) (FilesystemStats, bool) {
+	return FilesystemStats{}, false
+}
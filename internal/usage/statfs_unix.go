@@ -0,0 +1,19 @@
+//go:build unix
+
+package usage
+
+import "syscall"
+
+// Filesystem reports the capacity of the filesystem holding dir.
+func Filesystem(dir string) (FilesystemStats, bool) {
+	var st syscall.Statfs_t
+	if err := syscall.Statfs(dir, &st); err != nil {
+		return FilesystemStats{}, false
+	}
+	bsize := int64(st.Bsize)
+	return FilesystemStats{
+		Total:     int64(st.Blocks) * bsize,
+		Free:      int64(st.Bfree) * bsize,
+		Available: int64(st.Bavail) * bsize,
+	}, true
+}
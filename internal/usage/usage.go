@@ -0,0 +1,187 @@
+// Package usage measures how much space a directory tree takes. A Tracker
+// rescans the tree in the background and keeps the latest result, together
+// with the bytes written since, so quota checks do not have to walk the tree.
+package usage
+
+import (
+	"context"
+	"io/fs"
+	"path"
+	"sync"
+	"time"
+)
+
+// Dir is the recursive size of a directory and the number of regular files below it.
+type Dir struct {
+	Size  int64
+	Files int
+}
+
+// Snapshot is the result of one scan. Dirs is keyed by slash-separated path
+// relative to the scanned filesystem, with "." for its root.
+type Snapshot struct {
+	Dirs      map[string]Dir
+	ScannedAt time.Time
+	Duration  time.Duration
+}
+
+// Scan walks dir in fsys and adds the size of every regular file to each of
+// its ancestors up to dir. Symlinks are not followed, and entries that cannot
+// be read are skipped; only cancellation stops the scan.
+func Scan(ctx context.Context, fsys fs.FS, dir string) (*Snapshot, error) {
+	start := time.Now()
+	snap := &Snapshot{Dirs: map[string]Dir{}, ScannedAt: start}
+	err := fs.WalkDir(fsys, dir, func(p string, d fs.DirEntry, err error) error {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			snap.Dirs[p] = snap.Dirs[p]
+			return nil
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		for parent := path.Dir(p); ; parent = path.Dir(parent) {
+			e := snap.Dirs[parent]
+			e.Size += info.Size()
+			e.Files++
+			snap.Dirs[parent] = e
+			if parent == dir || parent == "." {
+				break
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	snap.Duration = time.Since(start)
+	return snap, nil
+}
+
+// Tracker keeps the latest Snapshot of a filesystem.
+type Tracker struct {
+	fsys fs.FS
+
+	scanMu sync.Mutex // held while scanning, so scans do not overlap
+	mu     sync.Mutex
+	snap   *Snapshot
+	// added counts bytes reported through Add since the snapshot's scan
+	// started. Writes that land while a scan runs may be counted twice,
+	// which errs on the side of a full quota.
+	added     int64
+	addedScan int64 // value of added when the running scan started
+
+	refresh chan struct{}
+	// OnScan, if set, is called with every new snapshot.
+	OnScan func(*Snapshot)
+}
+
+// NewTracker creates a tracker for fsys. No scan happens until Run, Current
+// or Rescan is called.
+func NewTracker(fsys fs.FS) *Tracker {
+	return &Tracker{fsys: fsys, refresh: make(chan struct{}, 1)}
+}
+
+// Run rescans every interval, and soon after Refresh is called, until ctx is done.
+func (t *Tracker) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		t.Rescan(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-t.refresh:
+		}
+	}
+}
+
+// Refresh asks Run for an early rescan, for example after files were deleted.
+func (t *Tracker) Refresh() {
+	select {
+	case t.refresh <- struct{}{}:
+	default:
+	}
+}
+
+// Rescan scans the filesystem now and returns the new snapshot.
+func (t *Tracker) Rescan(ctx context.Context) (*Snapshot, error) {
+	t.scanMu.Lock()
+	defer t.scanMu.Unlock()
+	return t.scan(ctx)
+}
+
+func (t *Tracker) scan(ctx context.Context) (*Snapshot, error) {
+	t.mu.Lock()
+	t.addedScan = t.added
+	t.mu.Unlock()
+
+	snap, err := Scan(ctx, t.fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+	t.mu.Lock()
+	t.snap = snap
+	t.added -= t.addedScan
+	t.mu.Unlock()
+	if t.OnScan != nil {
+		t.OnScan(snap)
+	}
+	return snap, nil
+}
+
+// Current returns the latest snapshot, scanning first if there is none yet.
+func (t *Tracker) Current(ctx context.Context) (*Snapshot, error) {
+	if snap := t.latest(); snap != nil {
+		return snap, nil
+	}
+	t.scanMu.Lock()
+	defer t.scanMu.Unlock()
+	if snap := t.latest(); snap != nil {
+		return snap, nil
+	}
+	return t.scan(ctx)
+}
+
+func (t *Tracker) latest() *Snapshot {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.snap
+}
+
+// Add records n bytes written since the last scan.
+func (t *Tracker) Add(n int64) {
+	t.mu.Lock()
+	t.added += n
+	t.mu.Unlock()
+}
+
+// Used returns the size of the whole filesystem: the latest snapshot plus the
+// bytes added since, scanning first if there is no snapshot yet.
+func (t *Tracker) Used(ctx context.Context) (int64, error) {
+	snap, err := t.Current(ctx)
+	if err != nil {
+		return 0, err
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return snap.Dirs["."].Size + t.added, nil
+}
+
+// FilesystemStats is the capacity of a filesystem in bytes. Available is what
+// unprivileged processes may still use; Free includes reserved blocks.
+type FilesystemStats struct {
+	Total     int64
+	Free      int64
+	Available int64
+}
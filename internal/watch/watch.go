@@ -0,0 +1,335 @@
+// Package watch reports changes below a directory tree. It uses fsnotify,
+// which is inotify on Linux, adds watches for directories as they appear,
+// and coalesces bursts of events on the same path into one.
+package watch
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// Op is the kind of change an Event reports.
+type Op string
+
+const (
+	Create Op = "create"
+	Modify Op = "modify"
+	Delete Op = "delete"
+	// Rename is reported for the old path of a moved entry. The new path, if
+	// it is inside the watched tree, gets a Create.
+	Rename Op = "rename"
+	// Overflow means events were lost, and the tree should be read again.
+	Overflow Op = "overflow"
+)
+
+// maxPending is how many distinct paths may wait for delivery before they
+// are replaced by a single Overflow event.
+const maxPending = 10000
+
+// ErrWatchLimit is returned when the kernel refuses more watches.
+var ErrWatchLimit = errors.New("inotify watch limit reached, raise fs.inotify.max_user_watches")
+
+// Event is a change to the entry at Path, which is slash-separated and
+// relative to the watched directory. Path is empty for Overflow.
+type Event struct {
+	Op    Op
+	Path  string
+	IsDir bool
+	Time  time.Time
+}
+
+// Options configures a Watcher.
+type Options struct {
+	// Skip, if set, reports whether the entry at a path relative to the
+	// watched directory, and everything below it, should be ignored.
+	Skip func(rel string) bool
+	// Debounce is how long a path must stay quiet before its events are
+	// delivered; 250ms if zero. Paths that keep changing are delivered
+	// after ten times that.
+	Debounce time.Duration
+}
+
+// Watcher watches a directory tree. Batches of events are delivered on
+// Events, which is closed when the Watcher stops, including after the
+// watched directory itself was deleted or moved.
+type Watcher struct {
+	Events <-chan []Event
+
+	events chan []Event
+	fsw    *fsnotify.Watcher
+	dir    string
+	opts   Options
+	dirs   map[string]bool
+	err    error
+	done   chan struct{}
+	closed chan struct{}
+
+	pending map[string]*Event
+	order   []string
+}
+
+// New starts watching the tree rooted at the host directory dir. It fails if
+// the tree cannot be watched completely.
+func New(dir string, opts Options) (*Watcher, error) {
+	if opts.Debounce <= 0 {
+		opts.Debounce = 250 * time.Millisecond
+	}
+	fsw, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &Watcher{
+		events:  make(chan []Event),
+		fsw:     fsw,
+		dir:     dir,
+		opts:    opts,
+		dirs:    map[string]bool{},
+		done:    make(chan struct{}),
+		closed:  make(chan struct{}),
+		pending: map[string]*Event{},
+	}
+	w.Events = w.events
+	if _, err := w.addTree(".", false); err != nil {
+		fsw.Close()
+		return nil, err
+	}
+	go w.run()
+	return w, nil
+}
+
+// Close stops the watcher and waits for Events to be closed.
+func (w *Watcher) Close() error {
+	select {
+	case <-w.done:
+	default:
+		close(w.done)
+	}
+	<-w.closed
+	return nil
+}
+
+// Err returns the error that stopped the watcher, once Events is closed. It
+// is nil if the watcher was closed.
+func (w *Watcher) Err() error {
+	return w.err
+}
+
+// addTree watches the directory rel and every directory below it. If report
+// is set, it returns Create events for the entries it finds, which may have
+// been created before their directory was watched.
+func (w *Watcher) addTree(rel string, report bool) ([]Event, error) {
+	var found []Event
+	now := time.Now()
+	err := filepath.WalkDir(filepath.Join(w.dir, rel), func(p string, d fs.DirEntry, err error) error {
+		r, relErr := filepath.Rel(w.dir, p)
+		if relErr != nil {
+			return relErr
+		}
+		r = filepath.ToSlash(r)
+		if err != nil {
+			// Entries may vanish while the tree is walked.
+			if r == rel || errors.Is(err, fs.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+		if r != "." && w.opts.Skip != nil && w.opts.Skip(r) {
+			if d.IsDir() {
+				return fs.SkipDir
+			}
+			return nil
+		}
+		if report && r != rel {
+			found = append(found, Event{Op: Create, Path: r, IsDir: d.IsDir(), Time: now})
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if err := w.fsw.Add(p); err != nil {
+			if errors.Is(err, syscall.ENOSPC) {
+				return ErrWatchLimit
+			}
+			if errors.Is(err, fs.ErrNotExist) {
+				return fs.SkipDir
+			}
+			return fmt.Errorf("watching %s: %w", r, err)
+		}
+		w.dirs[r] = true
+		return nil
+	})
+	return found, err
+}
+
+// forget drops the watches of rel and every directory below it.
+func (w *Watcher) forget(rel string) {
+	for d := range w.dirs {
+		if d == rel || strings.HasPrefix(d, rel+"/") || rel == "." {
+			w.fsw.Remove(filepath.Join(w.dir, filepath.FromSlash(d)))
+			delete(w.dirs, d)
+		}
+	}
+}
+
+func (w *Watcher) run() {
+	defer close(w.closed)
+	defer close(w.events)
+	defer w.fsw.Close()
+
+	var timer *time.Timer
+	var timerC <-chan time.Time
+	var first time.Time
+	for {
+		select {
+		case ev, ok := <-w.fsw.Events:
+			if !ok {
+				return
+			}
+			if err := w.handle(ev); err != nil {
+				w.err = err
+				return
+			}
+			if len(w.pending) == 0 {
+				continue
+			}
+			now := time.Now()
+			if timer == nil {
+				first = now
+				timer = time.NewTimer(w.opts.Debounce)
+				timerC = timer.C
+				continue
+			}
+			// Wait for the path to settle, but no longer than ten debounce periods in total.
+			if wait := min(w.opts.Debounce, first.Add(10*w.opts.Debounce).Sub(now)); wait > 0 {
+				timer.Reset(wait)
+			}
+		case err, ok := <-w.fsw.Errors:
+			if !ok {
+				return
+			}
+			if !errors.Is(err, fsnotify.ErrEventOverflow) {
+				w.err = err
+				return
+			}
+			w.overflow()
+		case <-timerC:
+			timer, timerC = nil, nil
+			// Once the watched directory itself is gone there is nothing left to watch.
+			if !w.flush() || len(w.dirs) == 0 {
+				return
+			}
+		case <-w.done:
+			if timer != nil {
+				timer.Stop()
+			}
+			return
+		}
+	}
+}
+
+// handle records the fsnotify event ev as pending.
+func (w *Watcher) handle(ev fsnotify.Event) error {
+	r, err := filepath.Rel(w.dir, ev.Name)
+	if err != nil {
+		return nil
+	}
+	rel := filepath.ToSlash(r)
+	if rel != "." && w.opts.Skip != nil && w.opts.Skip(rel) {
+		return nil
+	}
+	now := time.Now()
+	switch {
+	case ev.Has(fsnotify.Create):
+		info, err := os.Lstat(ev.Name)
+		if err != nil {
+			// Already gone again. The Remove or Rename that follows cancels this.
+			w.add(Event{Op: Create, Path: rel, Time: now})
+			return nil
+		}
+		w.add(Event{Op: Create, Path: rel, IsDir: info.IsDir(), Time: now})
+		if info.IsDir() {
+			found, err := w.addTree(rel, true)
+			for _, e := range found {
+				w.add(e)
+			}
+			return err
+		}
+	case ev.Has(fsnotify.Remove), ev.Has(fsnotify.Rename):
+		op := Delete
+		if ev.Has(fsnotify.Rename) {
+			op = Rename
+		}
+		isDir := w.dirs[rel]
+		if isDir {
+			w.forget(rel)
+		}
+		w.add(Event{Op: op, Path: rel, IsDir: isDir, Time: now})
+	case ev.Has(fsnotify.Write):
+		w.add(Event{Op: Modify, Path: rel, IsDir: w.dirs[rel], Time: now})
+	}
+	return nil
+}
+
+// add merges e into the pending event for its path: a file created and
+// then written is still created, and one created and removed again before
+// delivery is not reported at all. A removed directory is reported both by
+// itself and by its parent, and only the former knows it was a directory.
+func (w *Watcher) add(e Event) {
+	if len(w.pending) >= maxPending {
+		w.overflow()
+		return
+	}
+	prev, ok := w.pending[e.Path]
+	if !ok {
+		w.pending[e.Path] = &e
+		w.order = append(w.order, e.Path)
+		return
+	}
+	switch {
+	case prev.Op == Overflow:
+	case prev.Op == Create && e.Op == Modify:
+		prev.Time = e.Time
+	case prev.Op == Create && (e.Op == Delete || e.Op == Rename):
+		delete(w.pending, e.Path)
+	case prev.Op == e.Op:
+		prev.IsDir = prev.IsDir || e.IsDir
+		prev.Time = e.Time
+	default:
+		*prev = e
+	}
+}
+
+// overflow replaces the pending events with a single Overflow event.
+func (w *Watcher) overflow() {
+	w.pending = map[string]*Event{"": {Op: Overflow, Time: time.Now()}}
+	w.order = []string{""}
+}
+
+// flush delivers the pending events, in the order their paths first
+// changed. It returns false if the watcher was closed meanwhile.
+func (w *Watcher) flush() bool {
+	batch := make([]Event, 0, len(w.pending))
+	for _, p := range w.order {
+		if e, ok := w.pending[p]; ok {
+			batch = append(batch, *e)
+			delete(w.pending, p)
+		}
+	}
+	w.order = w.order[:0]
+	if len(batch) == 0 {
+		return true
+	}
+	select {
+	case w.events <- batch:
+		return true
+	case <-w.done:
+		return false
+	}
+}
@@ -0,0 +1,172 @@
+package watch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDebounce = 50 * time.Millisecond
+
+func newWatcher(t *testing.T, dir string, opts Options) *Watcher {
+	t.Helper()
+	opts.Debounce = testDebounce
+	w, err := New(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { w.Close() })
+	return w
+}
+
+// collect returns the events delivered until none arrive for a while, as
+// "op path" strings with a trailing slash for directories.
+func collect(t *testing.T, w *Watcher) []string {
+	t.Helper()
+	var got []string
+	for {
+		select {
+		case batch, ok := <-w.Events:
+			if !ok {
+				return got
+			}
+			for _, e := range batch {
+				s := string(e.Op) + " " + e.Path
+				if e.IsDir {
+					s += "/"
+				}
+				got = append(got, s)
+			}
+		case <-time.After(10 * testDebounce):
+			return got
+		}
+	}
+}
+
+func write(t *testing.T, name, content string) {
+	t.Helper()
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func checkEvents(t *testing.T, got []string, want ...string) {
+	t.Helper()
+	if strings.Join(got, ", ") != strings.Join(want, ", ") {
+		t.Errorf("got events %q, want %q", got, want)
+	}
+}
+
+func TestDebounce(t *testing.T) {
+	dir := t.TempDir()
+	w := newWatcher(t, dir, Options{})
+
+	// A file created and written in several steps is reported once.
+	f, err := os.Create(filepath.Join(dir, "a.txt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 5 {
+		f.WriteString("chunk")
+	}
+	f.Close()
+	checkEvents(t, collect(t, w), "create a.txt")
+
+	write(t, filepath.Join(dir, "a.txt"), "changed")
+	write(t, filepath.Join(dir, "a.txt"), "changed again")
+	checkEvents(t, collect(t, w), "modify a.txt")
+
+	// A file created and removed again before delivery is not reported.
+	write(t, filepath.Join(dir, "b.txt"), "b")
+	os.Remove(filepath.Join(dir, "b.txt"))
+	os.Remove(filepath.Join(dir, "a.txt"))
+	checkEvents(t, collect(t, w), "delete a.txt")
+}
+
+func TestNewDirectories(t *testing.T) {
+	dir := t.TempDir()
+	w := newWatcher(t, dir, Options{})
+
+	if err := os.MkdirAll(filepath.Join(dir, "x", "y"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(dir, "x", "y", "f"), "f")
+	checkEvents(t, collect(t, w), "create x/", "create x/y/", "create x/y/f")
+
+	// The new directories are watched too.
+	write(t, filepath.Join(dir, "x", "y", "f"), "changed")
+	checkEvents(t, collect(t, w), "modify x/y/f")
+
+	if err := os.Rename(filepath.Join(dir, "x"), filepath.Join(dir, "z")); err != nil {
+		t.Fatal(err)
+	}
+	// The move's target, z, is reported as created after the rename.
+	if got := collect(t, w); len(got) == 0 || got[0] != "rename x/" {
+		t.Errorf("got events %q, want the rename of x first", got)
+	}
+}
+
+func TestSkip(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, ".hidden"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(t, dir, Options{
+		Skip: func(rel string) bool { return strings.HasPrefix(filepath.Base(rel), ".") },
+	})
+
+	write(t, filepath.Join(dir, ".hidden", "f"), "f")
+	write(t, filepath.Join(dir, ".dotfile"), "f")
+	if err := os.MkdirAll(filepath.Join(dir, "new", ".cache"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	write(t, filepath.Join(dir, "new", ".cache", "f"), "f")
+	write(t, filepath.Join(dir, "visible"), "f")
+	checkEvents(t, collect(t, w), "create new/", "create visible")
+}
+
+func TestOverflow(t *testing.T) {
+	w := &Watcher{pending: map[string]*Event{}}
+	for i := range maxPending + 1 {
+		w.add(Event{Op: Create, Path: fmt.Sprintf("f%d", i)})
+	}
+	if len(w.pending) != 1 || w.pending[""] == nil || w.pending[""].Op != Overflow {
+		t.Fatalf("got %d pending events, want a single overflow", len(w.pending))
+	}
+	// Changes after the overflow are delivered after it, and do not replace it.
+	w.add(Event{Op: Delete, Path: ""})
+	w.add(Event{Op: Modify, Path: "f0"})
+	var got []string
+	for _, p := range w.order {
+		got = append(got, string(w.pending[p].Op)+" "+p)
+	}
+	checkEvents(t, got, "overflow ", "modify f0")
+}
+
+func TestWatchedDirectoryRemoved(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "watched")
+	if err := os.Mkdir(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	w := newWatcher(t, dir, Options{})
+	if err := os.Remove(dir); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-w.Events:
+			if !ok {
+				if err := w.Err(); err != nil {
+					t.Errorf("got error %v, want none", err)
+				}
+				return
+			}
+		case <-deadline:
+			t.Fatal("Events was not closed after the watched directory was removed")
+		}
+	}
+}
@@ -4,10 +4,12 @@ import (
 	"context"
 	"github.com/pegnia/sidecar/internal/agones"
 	"github.com/pegnia/sidecar/internal/api"
+	"github.com/pegnia/sidecar/internal/audit"
 	"github.com/pegnia/sidecar/internal/config"
 	"log/slog"
 	"os"
 	"os/signal"
+	"strconv"
 	"syscall"
 
 	"agones.dev/agones/sdks/go"
@@ -30,9 +32,32 @@ func main() {
 	}
 	slog.Info("Successfully connected to Agones SDK")
 
-	apiServer := api.NewServer(cfg.API.ListenAddress, cfg.Data.Root, cfg.Data.StdoutFile)
+	auditLog, err := audit.New(cfg.Audit)
+	if err != nil {
+		slog.Error("Could not open audit log", "error", err)
+		os.Exit(1)
+	}
+	defer auditLog.Close()
+
+	apiServer, err := api.NewServer(cfg.API.ListenAddress, cfg.Data.Root, cfg.Data.StdoutFile, auditLog)
+	if err != nil {
+		slog.Error("Could not create API server", "error", err)
+		os.Exit(1)
+	}
+
+	// Remote snapshots are kept under the GameServer's name and fleet.
+	if gs, err := agonesSDK.GameServer(); err == nil {
+		meta := gs.GetObjectMeta()
+		apiServer.SetGameServer(meta.GetName(), meta.GetLabels()["agones.dev/fleet"])
+	} else {
+		slog.Warn("Could not get GameServer, using the host name for remote backups", "error", err)
+	}
+	// The SDK prefixes the label, so it shows up as agones.dev/sdk-draining.
+	apiServer.SetDrainer(func(draining bool) error {
+		return agonesSDK.SetLabel("draining", strconv.FormatBool(draining))
+	})
 
-	go agones.RunManager(ctx, cfg.Agones, agonesSDK)
+	go agones.RunManager(ctx, cfg.Agones, agonesSDK, auditLog, apiServer.SetGameServerState)
 	go apiServer.Run(ctx)
 
 	<-ctx.Done()
@@ -0,0 +1,325 @@
+// Package apitypes defines the JSON types exchanged with the sidecar API.
+// They are shared by the server and by the Go client so the two cannot drift.
+package apitypes
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// FileInfo represents a single file or directory, used for JSON responses.
+// Path is relative to the data root. Mode is in ls notation, e.g.
+// "-rw-r--r--"; UID and GID are absent where the platform has no owners.
+type FileInfo struct {
+	Name          string    `json:"name"`
+	Path          string    `json:"path,omitempty"`
+	Size          int64     `json:"size"`
+	IsDir         bool      `json:"is_dir"`
+	Modified      time.Time `json:"modified"`
+	Mode          string    `json:"mode,omitempty"`
+	SymlinkTarget string    `json:"symlink_target,omitempty"`
+	UID           *uint32   `json:"uid,omitempty"`
+	GID           *uint32   `json:"gid,omitempty"`
+	MIMEType      string    `json:"mime_type,omitempty"`
+}
+
+// Error codes returned in ErrorResponse.Code. They are stable and safe to branch on.
+const (
+	CodeBadRequest         = "bad_request"
+	CodeInvalidPath        = "invalid_path"
+	CodeNotFound           = "not_found"
+	CodeAlreadyExists      = "already_exists"
+	CodePermissionDenied   = "permission_denied"
+	CodeNoSpace            = "no_space"
+	CodeNotDirectory       = "not_a_directory"
+	CodeIsDirectory        = "is_a_directory"
+	CodeTooLarge           = "too_large"
+	CodeRateLimited        = "rate_limited"
+	CodeConflict           = "conflict"
+	CodeChecksumMismatch   = "checksum_mismatch"
+	CodePreconditionFailed = "precondition_failed"
+	CodeNotText            = "not_text"
+	CodeInvalidConfig      = "invalid_config"
+	CodePolicyDenied       = "policy_denied"
+	CodeContentRejected    = "content_rejected"
+	CodeNotConfigured      = "not_configured"
+	CodeHookFailed         = "hook_failed"
+	CodeRemoteFailed       = "remote_failed"
+	CodeInternal           = "internal"
+)
+
+// ErrorResponse is the JSON body of every error returned by the API.
+type ErrorResponse struct {
+	Code      string         `json:"code"`
+	Message   string         `json:"message"`
+	Details   map[string]any `json:"details,omitempty"`
+	RequestID string         `json:"request_id,omitempty"`
+}
+
+// Result is the JSON body returned by successful mutating operations.
+type Result struct {
+	Message   string `json:"message"`
+	Path      string `json:"path,omitempty"`
+	Bytes     int64  `json:"bytes,omitempty"`
+	Files     int    `json:"files,omitempty"`
+	SHA256    string `json:"sha256,omitempty"`
+	MD5       string `json:"md5,omitempty"`
+	ETag      string `json:"etag,omitempty"`
+	TrashID   string `json:"trash_id,omitempty"`
+	BackupID  string `json:"backup_id,omitempty"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// DeleteRequest is the body accepted by the delete endpoint. Items go to the
+// trash unless Permanent is set or the trash is disabled.
+type DeleteRequest struct {
+	Path      string `json:"path"`
+	Permanent bool   `json:"permanent,omitempty"`
+}
+
+// TransferRequest is the body accepted by the move and copy endpoints.
+type TransferRequest struct {
+	Source      string `json:"source"`
+	Destination string `json:"destination"`
+	Overwrite   bool   `json:"overwrite,omitempty"`
+}
+
+// TransferProgress reports how far a copy has progressed. It is streamed as
+// "progress" server-sent events when a client requests text/event-stream.
+// Operations with several steps, such as restores, name the current one in
+// Phase; the counts start over with each.
+type TransferProgress struct {
+	Phase      string `json:"phase,omitempty"`
+	BytesDone  int64  `json:"bytes_done"`
+	BytesTotal int64  `json:"bytes_total"`
+	FilesDone  int    `json:"files_done"`
+	FilesTotal int    `json:"files_total"`
+}
+
+// UploadRequest starts a resumable upload of Size bytes to Path.
+type UploadRequest struct {
+	Path      string `json:"path"`
+	Size      int64  `json:"size"`
+	SHA256    string `json:"sha256,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// UploadSession is the state of a resumable upload. Offset is the number of
+// bytes received so far; the next chunk must start there.
+type UploadSession struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	Size      int64     `json:"size"`
+	Offset    int64     `json:"offset"`
+	SHA256    string    `json:"sha256,omitempty"`
+	Overwrite bool      `json:"overwrite,omitempty"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// TrashItem is a deleted file or directory kept in the trash. ExpiresAt is
+// when it will be purged by age; the trash's size limit may purge it sooner.
+type TrashItem struct {
+	ID        string    `json:"id"`
+	Path      string    `json:"path"`
+	IsDir     bool      `json:"is_dir"`
+	Size      int64     `json:"size"`
+	Files     int       `json:"files,omitempty"`
+	DeletedBy string    `json:"deleted_by"`
+	DeletedAt time.Time `json:"deleted_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RestoreRequest is the optional body of the trash restore endpoint. An empty
+// Path restores the item where it was deleted from.
+type RestoreRequest struct {
+	Path      string `json:"path,omitempty"`
+	Overwrite bool   `json:"overwrite,omitempty"`
+}
+
+// ChmodRequest is the body of the chmod endpoint. Mode is octal, such as
+// "0664", or symbolic as for chmod(1), such as "g+rwX,o-rwx".
+type ChmodRequest struct {
+	Path      string `json:"path"`
+	Mode      string `json:"mode"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+// ChownRequest is the body of the chown endpoint. UID and GID are numeric;
+// an omitted one is left unchanged.
+type ChownRequest struct {
+	Path      string `json:"path"`
+	UID       *int   `json:"uid,omitempty"`
+	GID       *int   `json:"gid,omitempty"`
+	Recursive bool   `json:"recursive,omitempty"`
+}
+
+// FileEvent is a change streamed by GET /api/files/watch. Op is "create",
+// "modify", "delete" or "rename", the last for the old path of a moved entry,
+// whose new path gets a "create". Op "overflow" means events were lost and
+// the directory should be listed again; Path is empty then.
+type FileEvent struct {
+	Op    string    `json:"op"`
+	Path  string    `json:"path,omitempty"`
+	IsDir bool      `json:"is_dir"`
+	Time  time.Time `json:"time"`
+}
+
+// Backup is a snapshot of the data root, or of the configured paths in it.
+// Size and SHA256 are those of the archive, which is in Format. RemoteKey is
+// the object key of the archive once it has been uploaded to remote storage.
+type Backup struct {
+	ID        string    `json:"id"`
+	CreatedAt time.Time `json:"created_at"`
+	CreatedBy string    `json:"created_by"`
+	Label     string    `json:"label,omitempty"`
+	Format    string    `json:"format"`
+	Paths     []string  `json:"paths,omitempty"`
+	Size      int64     `json:"size"`
+	Files     int       `json:"files"`
+	SHA256    string    `json:"sha256"`
+	RemoteKey string    `json:"remote_key,omitempty"`
+}
+
+// BackupRequest is the optional body of POST /api/backups.
+type BackupRequest struct {
+	Label string `json:"label,omitempty"`
+}
+
+// BackupSchedule is returned by GET /api/backups/schedule. Schedule and
+// NextRun are absent if no schedule is configured, and the Last fields until
+// the first scheduled run. LastResult is success, failure or skipped, the
+// latter when another backup operation was running at the time.
+type BackupSchedule struct {
+	Schedule     string          `json:"schedule,omitempty"`
+	Retention    BackupRetention `json:"retention"`
+	NextRun      *time.Time      `json:"next_run,omitempty"`
+	LastRun      *time.Time      `json:"last_run,omitempty"`
+	LastResult   string          `json:"last_result,omitempty"`
+	LastBackupID string          `json:"last_backup_id,omitempty"`
+	LastError    string          `json:"last_error,omitempty"`
+	LastPruned   int             `json:"last_pruned,omitempty"`
+}
+
+// BackupRetention is the retention policy applied to scheduled snapshots.
+// Zero counts and a zero MaxBytes mean no limit.
+type BackupRetention struct {
+	KeepHourly int   `json:"keep_hourly"`
+	KeepDaily  int   `json:"keep_daily"`
+	KeepWeekly int   `json:"keep_weekly"`
+	MaxBytes   int64 `json:"max_bytes"`
+}
+
+// RestoreBackupRequest is the optional body of POST /api/backups/{id}/restore.
+// Drain marks the game server as not accepting players while the restore
+// runs. A snapshot of the current state is taken first unless
+// SkipSafetySnapshot is set.
+type RestoreBackupRequest struct {
+	Drain              bool `json:"drain,omitempty"`
+	SkipSafetySnapshot bool `json:"skip_safety_snapshot,omitempty"`
+}
+
+// PathPolicy is returned by GET /api/policy. Rules with WhileRunning set are
+// only Active while the game server is running.
+type PathPolicy struct {
+	ReadOnly          bool         `json:"read_only"`
+	GameServerRunning bool         `json:"game_server_running"`
+	Rules             []PolicyRule `json:"rules"`
+}
+
+// PolicyRule is a single path rule. Effect is hidden, read_only or protected.
+type PolicyRule struct {
+	Pattern      string `json:"pattern"`
+	Effect       string `json:"effect"`
+	WhileRunning bool   `json:"while_running,omitempty"`
+	Active       bool   `json:"active"`
+}
+
+// SearchResult is an entry found by GET /api/files/search. Matches is only
+// set for content searches.
+type SearchResult struct {
+	FileInfo
+	Matches []SearchMatch `json:"matches,omitempty"`
+}
+
+// SearchMatch is a line matching a content search, with its line number
+// counted from 1 and the requested number of context lines around it.
+type SearchMatch struct {
+	Line   int      `json:"line"`
+	Text   string   `json:"text"`
+	Before []string `json:"before,omitempty"`
+	After  []string `json:"after,omitempty"`
+}
+
+// SearchResponse is the body returned by GET /api/files/search. Truncated is
+// set when more entries matched than max_results allowed.
+type SearchResponse struct {
+	Results   []SearchResult `json:"results"`
+	Truncated bool           `json:"truncated"`
+}
+
+// DiskUsage is returned by GET /api/files/usage. Sizes are recursive and
+// come from the latest background scan taken at ScannedAt. Children holds the
+// directory's largest entries; Truncated is set if some were left out.
+type DiskUsage struct {
+	Path       string           `json:"path"`
+	Size       int64            `json:"size"`
+	Files      int              `json:"files"`
+	ScannedAt  time.Time        `json:"scanned_at"`
+	Children   []UsageEntry     `json:"children"`
+	Truncated  bool             `json:"truncated"`
+	Filesystem *FilesystemUsage `json:"filesystem,omitempty"`
+	Quota      *QuotaUsage      `json:"quota,omitempty"`
+}
+
+// UsageEntry is a directory entry in DiskUsage. Files counts the regular
+// files below a directory.
+type UsageEntry struct {
+	Name  string `json:"name"`
+	Path  string `json:"path"`
+	Size  int64  `json:"size"`
+	Files int    `json:"files,omitempty"`
+	IsDir bool   `json:"is_dir"`
+}
+
+// FilesystemUsage is the capacity of the volume holding the data root.
+// Available is what the sidecar may still write; Free includes reserved blocks.
+type FilesystemUsage struct {
+	Total     int64 `json:"total"`
+	Free      int64 `json:"free"`
+	Available int64 `json:"available"`
+}
+
+// QuotaUsage is the configured quota of the data root and how much of it is used.
+type QuotaUsage struct {
+	Limit     int64 `json:"limit"`
+	Used      int64 `json:"used"`
+	Available int64 `json:"available"`
+}
+
+// FileContent is a text file as returned by GET /api/files/content. Content is
+// always UTF-8; Encoding is the file's encoding on disk.
+type FileContent struct {
+	Path     string    `json:"path"`
+	Content  string    `json:"content"`
+	Encoding string    `json:"encoding"`
+	Size     int64     `json:"size"`
+	Modified time.Time `json:"modified"`
+	ETag     string    `json:"etag"`
+}
+
+// ContentRequest is the body of PUT /api/files/content. Encoding defaults to
+// the existing file's encoding, or UTF-8 for new files.
+type ContentRequest struct {
+	Content  string `json:"content"`
+	Encoding string `json:"encoding,omitempty"`
+}
+
+// ConfigFile is a parsed configuration file as returned by the config
+// endpoints. Settings is a JSON object with keys in file order; tables and
+// sections are nested objects.
+type ConfigFile struct {
+	Path     string          `json:"path"`
+	Format   string          `json:"format"`
+	Settings json.RawMessage `json:"settings"`
+	ETag     string          `json:"etag"`
+}
@@ -0,0 +1,106 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// CreateBackup takes a snapshot of the data root, or of the paths the
+// sidecar is configured to back up, and waits for it to be written.
+func (c *Client) CreateBackup(ctx context.Context, label string) (*apitypes.Backup, error) {
+	var b apitypes.Backup
+	if err := c.sendJSON(ctx, http.MethodPost, "/api/backups", apitypes.BackupRequest{Label: label}, &b); err != nil {
+		return nil, err
+	}
+	return &b, nil
+}
+
+// ListBackups returns the stored snapshots, newest first.
+func (c *Client) ListBackups(ctx context.Context) ([]apitypes.Backup, error) {
+	var backups []apitypes.Backup
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/backups", nil, &backups); err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// BackupSchedule returns the snapshot schedule, the retention policy and the
+// outcome of the last scheduled run.
+func (c *Client) BackupSchedule(ctx context.Context) (*apitypes.BackupSchedule, error) {
+	var sched apitypes.BackupSchedule
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/backups/schedule", nil, &sched); err != nil {
+		return nil, err
+	}
+	return &sched, nil
+}
+
+// DownloadBackup returns the archive of a snapshot. The caller must close it.
+func (c *Client) DownloadBackup(ctx context.Context, id string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/backups/"+id+"/download", nil, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// RestoreBackup restores a snapshot over the current data and waits for it
+// to finish. The result's BackupID names the safety snapshot taken first.
+func (c *Client) RestoreBackup(ctx context.Context, id string, opts apitypes.RestoreBackupRequest) (*apitypes.Result, error) {
+	return c.postJSON(ctx, "/api/backups/"+id+"/restore", opts)
+}
+
+// ListRemoteBackups returns the snapshots in remote storage, newest first.
+// A non-empty gameServer lists those of another GameServer, such as the one
+// this one replaced.
+func (c *Client) ListRemoteBackups(ctx context.Context, gameServer string) ([]apitypes.Backup, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/backups/remote", gameServerQuery(gameServer), "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var backups []apitypes.Backup
+	if err := decode(resp, &backups); err != nil {
+		return nil, err
+	}
+	return backups, nil
+}
+
+// RestoreRemoteBackup downloads a snapshot from remote storage, unless it is
+// stored locally already, and restores it like RestoreBackup.
+func (c *Client) RestoreRemoteBackup(ctx context.Context, id, gameServer string, opts apitypes.RestoreBackupRequest) (*apitypes.Result, error) {
+	data, err := json.Marshal(opts)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := c.do(ctx, http.MethodPost, "/api/backups/remote/"+id+"/restore", gameServerQuery(gameServer), "application/json", bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result apitypes.Result
+	if err := decode(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// gameServerQuery selects the remote snapshots of another GameServer.
+func gameServerQuery(name string) url.Values {
+	if name == "" {
+		return nil
+	}
+	return url.Values{"gameserver": {name}}
+}
+
+// DeleteBackup deletes a snapshot.
+func (c *Client) DeleteBackup(ctx context.Context, id string) error {
+	return c.sendJSON(ctx, http.MethodDelete, "/api/backups/"+id, nil, &apitypes.Result{})
+}
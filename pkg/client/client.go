@@ -0,0 +1,526 @@
+// Package client is a typed Go client for the sidecar file management API.
+package client
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// FileInfo represents a single file or directory returned by the API.
+type FileInfo = apitypes.FileInfo
+
+// Error is returned when the API responds with a non-success status code,
+// or ends an event stream with an error after a 200. Code holds one of the
+// apitypes.Code* constants when the server supplied one.
+type Error struct {
+	StatusCode int
+	apitypes.ErrorResponse
+}
+
+func (e *Error) Error() string {
+	if e.Code != "" {
+		return fmt.Sprintf("sidecar API error %d (%s): %s", e.StatusCode, e.Code, e.Message)
+	}
+	return fmt.Sprintf("sidecar API error %d: %s", e.StatusCode, e.Message)
+}
+
+// Client talks to a single sidecar API server.
+type Client struct {
+	// BaseURL is the address of the sidecar, e.g. "http://10.0.0.5:9999".
+	BaseURL string
+	// HTTPClient is used for all requests; http.DefaultClient if nil.
+	HTTPClient *http.Client
+}
+
+// New returns a Client for the sidecar at baseURL.
+func New(baseURL string) *Client {
+	return &Client{BaseURL: strings.TrimRight(baseURL, "/")}
+}
+
+func (c *Client) httpClient() *http.Client {
+	if c.HTTPClient != nil {
+		return c.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+// newRequest builds a request to endpoint on the sidecar.
+func (c *Client) newRequest(ctx context.Context, method, endpoint string, query url.Values, contentType string, body io.Reader) (*http.Request, error) {
+	u := c.BaseURL + endpoint
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req, nil
+}
+
+// do sends a request and returns the response if its status is 2xx.
+// Otherwise the body is read into an *Error and the response is closed.
+func (c *Client) do(ctx context.Context, method, endpoint string, query url.Values, contentType string, body io.Reader) (*http.Response, error) {
+	req, err := c.newRequest(ctx, method, endpoint, query, contentType, body)
+	if err != nil {
+		return nil, err
+	}
+	return c.send(req)
+}
+
+// send performs req, handling errors as described for do.
+func (c *Client) send(req *http.Request) (*http.Response, error) {
+	resp, err := c.httpClient().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+		apiErr := &Error{StatusCode: resp.StatusCode}
+		if err := json.Unmarshal(body, &apiErr.ErrorResponse); err != nil || apiErr.Code == "" {
+			apiErr.ErrorResponse = apitypes.ErrorResponse{Message: strings.TrimSpace(string(body))}
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// postJSON sends v as a JSON body to a mutating endpoint and decodes the result.
+func (c *Client) postJSON(ctx context.Context, endpoint string, v any) (*apitypes.Result, error) {
+	var result apitypes.Result
+	if err := c.sendJSON(ctx, http.MethodPost, endpoint, v, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// sendJSON sends in as a JSON body, if non-nil, and decodes the response into out.
+func (c *Client) sendJSON(ctx context.Context, method, endpoint string, in, out any) error {
+	var body io.Reader
+	contentType := ""
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body, contentType = bytes.NewReader(data), "application/json"
+	}
+	resp, err := c.do(ctx, method, endpoint, nil, contentType, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	return decode(resp, out)
+}
+
+// decode reads a JSON response body into out.
+func decode(resp *http.Response, out any) error {
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("decoding response: %w", err)
+	}
+	return nil
+}
+
+// postPath sends a {"path": ...} JSON body to a mutating file endpoint.
+func (c *Client) postPath(ctx context.Context, endpoint, path string) error {
+	_, err := c.postJSON(ctx, endpoint, map[string]string{"path": path})
+	return err
+}
+
+// ListFiles lists the directory at path, relative to the data root. It
+// follows pagination cursors and returns every entry.
+func (c *Client) ListFiles(ctx context.Context, path string) ([]FileInfo, error) {
+	var all []FileInfo
+	opts := ListOptions{Limit: 10000}
+	for {
+		page, err := c.ListFilesPage(ctx, path, opts)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, page.Files...)
+		if page.NextCursor == "" {
+			return all, nil
+		}
+		opts.Cursor = page.NextCursor
+	}
+}
+
+// ListOptions controls a directory listing. Zero values select the server
+// defaults: one level, sorted by name, hidden entries included, 1000 per page.
+type ListOptions struct {
+	// Depth is the number of levels to descend; -1 lists the whole tree.
+	Depth int
+	// Sort is "name", "size" or "modified"; Desc reverses the order.
+	Sort       string
+	Desc       bool
+	HideHidden bool
+	Limit      int
+	// Cursor is the NextCursor of the previous page.
+	Cursor string
+}
+
+// FilePage is one page of a directory listing.
+type FilePage struct {
+	Files []FileInfo
+	// Total is the number of entries across all pages.
+	Total int
+	// NextCursor is empty on the last page.
+	NextCursor string
+}
+
+// ListFilesPage returns one page of the listing of the directory at path.
+func (c *Client) ListFilesPage(ctx context.Context, path string, opts ListOptions) (*FilePage, error) {
+	query := url.Values{"path": {path}}
+	switch {
+	case opts.Depth < 0:
+		query.Set("depth", "0")
+	case opts.Depth > 0:
+		query.Set("depth", strconv.Itoa(opts.Depth))
+	}
+	if opts.Sort != "" {
+		query.Set("sort", opts.Sort)
+	}
+	if opts.Desc {
+		query.Set("order", "desc")
+	}
+	if opts.HideHidden {
+		query.Set("hidden", "false")
+	}
+	if opts.Limit > 0 {
+		query.Set("limit", strconv.Itoa(opts.Limit))
+	}
+	if opts.Cursor != "" {
+		query.Set("cursor", opts.Cursor)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/api/files", query, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	page := &FilePage{NextCursor: resp.Header.Get("X-Next-Cursor")}
+	page.Total, _ = strconv.Atoi(resp.Header.Get("X-Total-Count"))
+	if err := json.NewDecoder(resp.Body).Decode(&page.Files); err != nil {
+		return nil, fmt.Errorf("decoding file list: %w", err)
+	}
+	return page, nil
+}
+
+// Policy returns the server's path policy: whether the file API is
+// read-only, and which paths are hidden, read-only or protected from deletion.
+func (c *Client) Policy(ctx context.Context) (*apitypes.PathPolicy, error) {
+	var policy apitypes.PathPolicy
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/policy", nil, &policy); err != nil {
+		return nil, err
+	}
+	return &policy, nil
+}
+
+// Usage returns the disk usage of the directory at path. With refresh the
+// server rescans the data root instead of answering from its last scan.
+func (c *Client) Usage(ctx context.Context, path string, refresh bool) (*apitypes.DiskUsage, error) {
+	query := url.Values{"path": {path}}
+	if refresh {
+		query.Set("refresh", "true")
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/api/files/usage", query, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var usage apitypes.DiskUsage
+	if err := decode(resp, &usage); err != nil {
+		return nil, err
+	}
+	return &usage, nil
+}
+
+// Download opens the file at path for reading. The caller must close the returned reader.
+func (c *Client) Download(ctx context.Context, path string) (io.ReadCloser, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/files/download", url.Values{"path": {path}}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// PartialDownload is a file download that may start part-way through the file.
+type PartialDownload struct {
+	io.ReadCloser
+	// Offset is where the body starts in the file. It is 0 if the file
+	// changed since the ETag passed to DownloadFrom and is sent whole.
+	Offset int64
+	// Size is the size of the whole file.
+	Size int64
+	// ETag identifies this version of the file, for resuming again.
+	ETag string
+}
+
+// DownloadFrom downloads the file at path starting at offset, to resume an
+// interrupted download. If etag is set and the file no longer has it, the
+// server sends the whole file, which the returned Offset reflects.
+func (c *Client) DownloadFrom(ctx context.Context, path string, offset int64, etag string) (*PartialDownload, error) {
+	req, err := c.newRequest(ctx, http.MethodGet, "/api/files/download", url.Values{"path": {path}}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+		if etag != "" {
+			req.Header.Set("If-Range", etag)
+		}
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	d := &PartialDownload{ReadCloser: resp.Body, Size: resp.ContentLength, ETag: resp.Header.Get("ETag")}
+	if resp.StatusCode == http.StatusPartialContent {
+		var end int64
+		if _, err := fmt.Sscanf(resp.Header.Get("Content-Range"), "bytes %d-%d/%d", &d.Offset, &end, &d.Size); err != nil {
+			resp.Body.Close()
+			return nil, fmt.Errorf("parsing Content-Range: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// postFile streams content as the multipart form field "file" to endpoint.
+func (c *Client) postFile(ctx context.Context, endpoint string, query url.Values, filename string, content io.Reader) (*http.Response, error) {
+	pr, pw := io.Pipe()
+	mw := multipart.NewWriter(pw)
+	go func() {
+		part, err := mw.CreateFormFile("file", filename)
+		if err == nil {
+			_, err = io.Copy(part, content)
+		}
+		if err == nil {
+			err = mw.Close()
+		}
+		pw.CloseWithError(err)
+	}()
+
+	resp, err := c.do(ctx, http.MethodPost, endpoint, query, mw.FormDataContentType(), pr)
+	pr.Close()
+	return resp, err
+}
+
+// Upload streams content to a file named filename inside the existing directory
+// dir. The SHA-256 of content is computed while it is sent and compared with
+// the digest the server reports for the written file.
+func (c *Client) Upload(ctx context.Context, dir, filename string, content io.Reader, overwrite bool) (*apitypes.Result, error) {
+	query := url.Values{"path": {dir}, "overwrite": {strconv.FormatBool(overwrite)}}
+	h := sha256.New()
+	resp, err := c.postFile(ctx, "/api/files/upload", query, filename, io.TeeReader(content, h))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result apitypes.Result
+	if err := decode(resp, &result); err != nil {
+		return nil, err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); result.SHA256 != "" && result.SHA256 != sum {
+		return &result, fmt.Errorf("upload checksum mismatch: sent %s, server wrote %s", sum, result.SHA256)
+	}
+	return &result, nil
+}
+
+// Extract uploads a zip or tar archive and unpacks it into the existing
+// directory dir. The format is taken from filename's extension.
+func (c *Client) Extract(ctx context.Context, dir, filename string, content io.Reader, overwrite bool) (*apitypes.Result, error) {
+	query := url.Values{"path": {dir}, "overwrite": {strconv.FormatBool(overwrite)}}
+	resp, err := c.postFile(ctx, "/api/files/extract", query, filename, content)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result apitypes.Result
+	if err := decode(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// Delete removes the file or directory at path recursively. Unless the
+// server has its trash disabled, the item is moved there and can be restored.
+func (c *Client) Delete(ctx context.Context, path string) error {
+	return c.postPath(ctx, "/api/files/delete", path)
+}
+
+// CreateDir creates the directory at path along with any missing parents.
+func (c *Client) CreateDir(ctx context.Context, path string) error {
+	return c.postPath(ctx, "/api/files/create-dir", path)
+}
+
+// Move moves or renames src to dst. Existing destinations are only replaced if overwrite is set.
+func (c *Client) Move(ctx context.Context, src, dst string, overwrite bool) (*apitypes.Result, error) {
+	return c.postJSON(ctx, "/api/files/move", apitypes.TransferRequest{Source: src, Destination: dst, Overwrite: overwrite})
+}
+
+// Copy copies src to dst recursively. Existing destinations are only replaced if overwrite is set.
+func (c *Client) Copy(ctx context.Context, src, dst string, overwrite bool) (*apitypes.Result, error) {
+	return c.postJSON(ctx, "/api/files/copy", apitypes.TransferRequest{Source: src, Destination: dst, Overwrite: overwrite})
+}
+
+// Chmod changes the permissions of path, and of everything below it if
+// recursive is set. mode is octal, such as "0664", or symbolic as for
+// chmod(1), such as "g+rwX".
+func (c *Client) Chmod(ctx context.Context, path, mode string, recursive bool) (*apitypes.Result, error) {
+	return c.postJSON(ctx, "/api/files/chmod", apitypes.ChmodRequest{Path: path, Mode: mode, Recursive: recursive})
+}
+
+// Chown changes the numeric owner and group of path, and of everything below
+// it if recursive is set. As with os.Chown, -1 leaves either unchanged.
+func (c *Client) Chown(ctx context.Context, path string, uid, gid int, recursive bool) (*apitypes.Result, error) {
+	req := apitypes.ChownRequest{Path: path, Recursive: recursive}
+	if uid >= 0 {
+		req.UID = &uid
+	}
+	if gid >= 0 {
+		req.GID = &gid
+	}
+	return c.postJSON(ctx, "/api/files/chown", req)
+}
+
+// StreamLogs follows the game server stdout log, calling fn for every line
+// until ctx is cancelled, the server closes the stream, or fn returns an error.
+func (c *Client) StreamLogs(ctx context.Context, fn func(line string) error) error {
+	resp, err := c.do(ctx, http.MethodGet, "/api/logs/stream", nil, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	scanner := bufio.NewScanner(resp.Body)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		data, ok := strings.CutPrefix(scanner.Text(), "data: ")
+		if !ok {
+			continue
+		}
+		if err := fn(data); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}
+
+// GetContent reads a text file for editing. The returned ETag can be passed to
+// PutContent to make sure nobody changed the file in the meantime.
+func (c *Client) GetContent(ctx context.Context, path string) (*apitypes.FileContent, error) {
+	resp, err := c.do(ctx, http.MethodGet, "/api/files/content", url.Values{"path": {path}}, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var content apitypes.FileContent
+	if err := decode(resp, &content); err != nil {
+		return nil, err
+	}
+	return &content, nil
+}
+
+// PutContent atomically replaces or creates the text file at path. If etag is
+// non-empty the write only succeeds while the file still has that ETag;
+// otherwise the API answers with an *Error whose Code is
+// apitypes.CodePreconditionFailed.
+func (c *Client) PutContent(ctx context.Context, path, content, etag string) (*apitypes.Result, error) {
+	body, err := json.Marshal(apitypes.ContentRequest{Content: content})
+	if err != nil {
+		return nil, err
+	}
+	req, err := c.newRequest(ctx, http.MethodPut, "/api/files/content", url.Values{"path": {path}}, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result apitypes.Result
+	if err := decode(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// GetConfig parses the config file at path and returns its settings. format
+// may be empty to let the server pick it from the file extension.
+func (c *Client) GetConfig(ctx context.Context, path, format string) (*apitypes.ConfigFile, error) {
+	query := url.Values{"path": {path}}
+	if format != "" {
+		query.Set("format", format)
+	}
+	resp, err := c.do(ctx, http.MethodGet, "/api/config", query, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var cfg apitypes.ConfigFile
+	if err := decode(resp, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// PatchConfig applies patch, which must marshal to a JSON object, to the
+// config file at path as a JSON merge patch: keys set to nil are removed and
+// nested maps merge into sections. etag works as for PutContent.
+func (c *Client) PatchConfig(ctx context.Context, path, format string, patch any, etag string) (*apitypes.ConfigFile, error) {
+	body, err := json.Marshal(patch)
+	if err != nil {
+		return nil, err
+	}
+	query := url.Values{"path": {path}}
+	if format != "" {
+		query.Set("format", format)
+	}
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/config", query, "application/merge-patch+json", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	if etag != "" {
+		req.Header.Set("If-Match", etag)
+	}
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var cfg apitypes.ConfigFile
+	if err := decode(resp, &cfg); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
@@ -0,0 +1,64 @@
+package client
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// SearchOptions filters a search. Zero values leave a filter unset.
+type SearchOptions struct {
+	// Names are globs matched against the path below the searched directory.
+	Names []string
+	// Regex is a regular expression matched against the same path.
+	Regex string
+	// Content is a regular expression searched for in text files.
+	Content    string
+	IgnoreCase bool
+	// Context is the number of lines returned around each content match.
+	Context int
+	// Type is "file" or "dir".
+	Type           string
+	MinSize        int64
+	MaxSize        int64
+	ModifiedAfter  time.Time
+	ModifiedBefore time.Time
+	// MaxResults defaults to 1000 on the server.
+	MaxResults int
+}
+
+// Search walks the directory at dir and returns the entries matching opts.
+func (c *Client) Search(ctx context.Context, dir string, opts SearchOptions) (*apitypes.SearchResponse, error) {
+	query := url.Values{"path": {dir}, "name": opts.Names}
+	set := func(key, value string, ok bool) {
+		if ok {
+			query.Set(key, value)
+		}
+	}
+	set("regex", opts.Regex, opts.Regex != "")
+	set("content", opts.Content, opts.Content != "")
+	set("ignore_case", "true", opts.IgnoreCase)
+	set("context", strconv.Itoa(opts.Context), opts.Context > 0)
+	set("type", opts.Type, opts.Type != "")
+	set("min_size", strconv.FormatInt(opts.MinSize, 10), opts.MinSize > 0)
+	set("max_size", strconv.FormatInt(opts.MaxSize, 10), opts.MaxSize > 0)
+	set("modified_after", opts.ModifiedAfter.Format(time.RFC3339), !opts.ModifiedAfter.IsZero())
+	set("modified_before", opts.ModifiedBefore.Format(time.RFC3339), !opts.ModifiedBefore.IsZero())
+	set("max_results", strconv.Itoa(opts.MaxResults), opts.MaxResults > 0)
+
+	resp, err := c.do(ctx, http.MethodGet, "/api/files/search", query, "", nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result apitypes.SearchResponse
+	if err := decode(resp, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
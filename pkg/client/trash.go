@@ -0,0 +1,43 @@
+package client
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// DeletePermanently removes the file or directory at path without moving it to the trash.
+func (c *Client) DeletePermanently(ctx context.Context, path string) error {
+	_, err := c.postJSON(ctx, "/api/files/delete", apitypes.DeleteRequest{Path: path, Permanent: true})
+	return err
+}
+
+// ListTrash returns the items in the trash, newest first.
+func (c *Client) ListTrash(ctx context.Context) ([]apitypes.TrashItem, error) {
+	var items []apitypes.TrashItem
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/trash", nil, &items); err != nil {
+		return nil, err
+	}
+	return items, nil
+}
+
+// RestoreTrash moves a trashed item back to path, or to where it was deleted
+// from if path is empty. An existing item there is only replaced if overwrite is set.
+func (c *Client) RestoreTrash(ctx context.Context, id, path string, overwrite bool) (*apitypes.Result, error) {
+	return c.postJSON(ctx, "/api/trash/"+id+"/restore", apitypes.RestoreRequest{Path: path, Overwrite: overwrite})
+}
+
+// PurgeTrash permanently deletes a single item from the trash.
+func (c *Client) PurgeTrash(ctx context.Context, id string) error {
+	return c.sendJSON(ctx, http.MethodDelete, "/api/trash/"+id, nil, &apitypes.Result{})
+}
+
+// EmptyTrash permanently deletes everything in the trash.
+func (c *Client) EmptyTrash(ctx context.Context) (*apitypes.Result, error) {
+	var result apitypes.Result
+	if err := c.sendJSON(ctx, http.MethodDelete, "/api/trash", nil, &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
@@ -0,0 +1,99 @@
+package client
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// DefaultChunkSize is the chunk size used by UploadResumable when none is given.
+const DefaultChunkSize = 16 * 1024 * 1024
+
+// CreateUpload starts a resumable upload described by req.
+func (c *Client) CreateUpload(ctx context.Context, req apitypes.UploadRequest) (*apitypes.UploadSession, error) {
+	var session apitypes.UploadSession
+	if err := c.sendJSON(ctx, http.MethodPost, "/api/uploads", req, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// GetUpload returns the current state of an upload, including the offset to resume from.
+func (c *Client) GetUpload(ctx context.Context, id string) (*apitypes.UploadSession, error) {
+	var session apitypes.UploadSession
+	if err := c.sendJSON(ctx, http.MethodGet, "/api/uploads/"+id, nil, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// UploadChunk appends chunk to the upload at offset, which must equal the
+// upload's current offset.
+func (c *Client) UploadChunk(ctx context.Context, id string, offset int64, chunk io.Reader) (*apitypes.UploadSession, error) {
+	req, err := c.newRequest(ctx, http.MethodPatch, "/api/uploads/"+id, nil, "application/offset+octet-stream", chunk)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	resp, err := c.send(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var session apitypes.UploadSession
+	if err := decode(resp, &session); err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+// CompleteUpload verifies a fully sent upload and moves it into place.
+func (c *Client) CompleteUpload(ctx context.Context, id string) (*apitypes.Result, error) {
+	return c.postJSON(ctx, "/api/uploads/"+id+"/complete", nil)
+}
+
+// CancelUpload abandons an upload and discards what was sent.
+func (c *Client) CancelUpload(ctx context.Context, id string) error {
+	return c.sendJSON(ctx, http.MethodDelete, "/api/uploads/"+id, nil, &apitypes.Result{})
+}
+
+// UploadResumable sends content in chunks of chunkSize bytes (DefaultChunkSize
+// if zero) and completes the upload. It resumes from the server's offset, so
+// calling it again with the same session after a failure continues where the
+// previous attempt stopped.
+func (c *Client) UploadResumable(ctx context.Context, session *apitypes.UploadSession, content io.ReaderAt, chunkSize int64) (*apitypes.Result, error) {
+	if chunkSize <= 0 {
+		chunkSize = DefaultChunkSize
+	}
+	current, err := c.GetUpload(ctx, session.ID)
+	if err != nil {
+		return nil, err
+	}
+	for offset := current.Offset; offset < current.Size; {
+		n := min(chunkSize, current.Size-offset)
+		next, err := c.UploadChunk(ctx, session.ID, offset, io.NewSectionReader(content, offset, n))
+		if err != nil {
+			var apiErr *Error
+			if !errors.As(err, &apiErr) || apiErr.Code != apitypes.CodeConflict {
+				return nil, err
+			}
+			// The server holds a different offset, e.g. after a chunk that was
+			// only partly received; pick up from there.
+			state, getErr := c.GetUpload(ctx, session.ID)
+			if getErr != nil {
+				return nil, getErr
+			}
+			if state.Offset == offset {
+				return nil, err
+			}
+			next = state
+		}
+		offset = next.Offset
+	}
+	return c.CompleteUpload(ctx, session.ID)
+}
@@ -0,0 +1,59 @@
+package client
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/pegnia/sidecar/pkg/apitypes"
+)
+
+// Watch streams changes below the directory at path, calling fn for every
+// event until ctx is cancelled, fn returns an error, or the server ends the
+// stream, which it does when the directory itself is deleted or moved. If the
+// server ends the stream with an error, Watch returns it as an *Error. An
+// event with Op "overflow" means changes were missed and the directory
+// should be listed again.
+func (c *Client) Watch(ctx context.Context, path string, fn func(apitypes.FileEvent) error) error {
+	resp, err := c.do(ctx, http.MethodGet, "/api/files/watch", url.Values{"path": {path}}, "", nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var event string
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if name, ok := strings.CutPrefix(line, "event: "); ok {
+			event = name
+			continue
+		}
+		data, ok := strings.CutPrefix(line, "data: ")
+		if !ok {
+			continue
+		}
+		if event == "error" {
+			apiErr := &Error{StatusCode: resp.StatusCode}
+			if err := json.Unmarshal([]byte(data), &apiErr.ErrorResponse); err != nil {
+				return fmt.Errorf("decoding stream error: %w", err)
+			}
+			return apiErr
+		}
+		var ev apitypes.FileEvent
+		if err := json.Unmarshal([]byte(data), &ev); err != nil {
+			return fmt.Errorf("decoding file event: %w", err)
+		}
+		if err := fn(ev); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil && ctx.Err() == nil {
+		return err
+	}
+	return ctx.Err()
+}